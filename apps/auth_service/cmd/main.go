@@ -2,6 +2,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/phuslu/log"
 
 	"github.com/carlosealves2/short-stream/authservice/internal/bootstrap"
@@ -10,14 +12,37 @@ import (
 )
 
 func main() {
-	// Initialize global logger first
-	appLogger := logger.NewGlobal(log.InfoLevel)
+	// Initialize a bootstrap logger first so configuration errors can be reported
+	bootLogger := logger.NewGlobal(log.InfoLevel)
+
+	builder := config.NewBuilder().
+		WithFile(os.Getenv("CONFIG_FILE")).
+		WithEnv().
+		WithFlags()
+
+	// Optional external secret sources, consulted for secrets not set directly or via *_FILE
+	if dir := os.Getenv("SECRETS_DIR"); dir != "" {
+		builder.WithSecretSource(config.NewFileSecretSource(dir))
+	}
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		builder.WithSecretSource(config.NewVaultSecretSource(config.VaultConfig{
+			Addr:  addr,
+			Token: os.Getenv("VAULT_TOKEN"),
+			Path:  os.Getenv("VAULT_SECRET_PATH"),
+		}))
+	}
 
-	cfg, err := config.NewBuilder().WithEnv().Build()
+	cfg, err := builder.Build()
 	if err != nil {
-		appLogger.Fatal().Err(err).Msg("Failed to load configuration")
+		bootLogger.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 
+	levels, err := newLevels(cfg.Log)
+	if err != nil {
+		bootLogger.Fatal().Err(err).Msg("Failed to configure log levels")
+	}
+	appLogger := logger.NewGlobalWithLevels(levels, logger.Format(cfg.Log.Format))
+
 	app, err := bootstrap.New(cfg, appLogger)
 	if err != nil {
 		appLogger.Fatal().Err(err).Msg("Failed to initialize application")
@@ -27,3 +52,22 @@ func main() {
 		appLogger.Fatal().Err(err).Msg("Failed to start server")
 	}
 }
+
+// newLevels builds the runtime level registry from the log configuration
+func newLevels(cfg *config.LogConfig) (*logger.Levels, error) {
+	defaultLevel, err := logger.ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	components, err := logger.ParseComponentLevels(cfg.ComponentLevels)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := logger.NewLevels(defaultLevel)
+	for name, level := range components {
+		levels.SetComponent(name, level)
+	}
+	return levels, nil
+}
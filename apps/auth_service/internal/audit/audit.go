@@ -0,0 +1,224 @@
+// Package audit keeps a tamper-evident record of security-relevant actions
+// (logins, refreshes, logouts, admin revocations). Entries are append-only and
+// hash-chained: each entry's hash covers its content and the previous entry's
+// hash, so editing or removing an entry breaks the chain from that point on.
+// The chain is not anchored outside its store: removing the latest entries
+// leaves a shorter chain that still verifies.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin              = "login"
+	ActionRefresh            = "refresh"
+	ActionLogout             = "logout"
+	ActionSessionRevoke      = "session.revoke"
+	ActionUserSessionsRevoke = "user_sessions.revoke"
+	ActionImpersonationStart = "impersonation.start"
+	ActionImpersonationEnd   = "impersonation.end"
+)
+
+// Outcomes of an action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// ActorAdmin is the actor of actions taken through the admin API
+const ActorAdmin = "admin"
+
+// Record is an action as reported by the caller
+type Record struct {
+	Action string `json:"action"`
+	// Actor is who performed the action: the user's subject for their own
+	// logins and logouts, ActorAdmin for the admin API, empty when unknown
+	Actor string `json:"actor,omitempty"`
+	// Subject is the user the action applies to
+	Subject string `json:"subject,omitempty"`
+	// SessionID identifies the session by a keyed hash of its ID, never the
+	// ID itself, which would let readers of the log take the session over
+	SessionID string `json:"session_id,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Outcome   string `json:"outcome"`
+	// Reason explains a failure, why a session was ended or why an
+	// impersonation was started
+	Reason string `json:"reason,omitempty"`
+	// Impersonator is the support engineer behind an action taken in an
+	// impersonation session, or starting or ending one
+	Impersonator string `json:"impersonator,omitempty"`
+}
+
+// Entry is a Record as stored, chained to the entry before it
+type Entry struct {
+	// Seq numbers entries from 1 without gaps
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	Record
+	// PrevHash is the hash of entry Seq-1, empty for the first entry
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// Filter selects entries in Query. Empty fields match everything.
+type Filter struct {
+	Actor        string
+	Impersonator string
+	Subject      string
+	Action       string
+	Outcome      string
+	Since        time.Time
+	Until        time.Time
+	// Limit bounds the entries returned; 0 means DefaultLimit
+	Limit int
+}
+
+// DefaultLimit is the number of entries Query returns when Filter.Limit is 0
+const DefaultLimit = 100
+
+// Verification is the result of checking the hash chain
+type Verification struct {
+	Valid bool `json:"valid"`
+	// Entries is the number of entries checked; checking stops at the first
+	// broken entry
+	Entries int64 `json:"entries"`
+	// BrokenAt is the sequence number of the first entry that does not
+	// match the chain; only set when Valid is false
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Sink is an append-only audit log
+type Sink interface {
+	// Append stores record as the next entry of the chain
+	Append(ctx context.Context, record Record) (Entry, error)
+	// Query returns the entries matching filter, newest first
+	Query(ctx context.Context, filter Filter) ([]Entry, error)
+	// Verify walks the whole chain and reports the first broken entry
+	Verify(ctx context.Context) (Verification, error)
+}
+
+// Option configures a Sink
+type Option func(*options)
+
+type options struct {
+	now func() time.Time
+}
+
+// WithClock sets the clock entries are timestamped with (time.Now by default)
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{now: time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// chainEntry returns the entry following prev (nil for the first entry)
+func chainEntry(prev *Entry, record Record, now time.Time) Entry {
+	entry := Entry{
+		Seq: 1,
+		// Stored timestamps keep microseconds; truncate so the hash survives storage
+		Time:   now.UTC().Truncate(time.Microsecond),
+		Record: record,
+	}
+	if prev != nil {
+		entry.Seq = prev.Seq + 1
+		entry.PrevHash = prev.Hash
+	}
+	entry.Hash = entryHash(entry)
+	return entry
+}
+
+// entryHash is the SHA-256 of the entry's content, including PrevHash
+func entryHash(entry Entry) string {
+	content, err := json.Marshal(struct {
+		Seq  int64     `json:"seq"`
+		Time time.Time `json:"time"`
+		Record
+		PrevHash string `json:"prev_hash"`
+	}{entry.Seq, entry.Time.UTC(), entry.Record, entry.PrevHash})
+	if err != nil {
+		// Only strings, numbers and a time are marshaled
+		panic(fmt.Sprintf("audit: failed to marshal entry: %v", err))
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifier checks entries one by one, in sequence order
+type verifier struct {
+	result Verification
+	prev   *Entry
+}
+
+func newVerifier() *verifier {
+	return &verifier{result: Verification{Valid: true}}
+}
+
+// check verifies entry against the previous one. It returns false once the
+// chain is broken; later entries are not checked.
+func (v *verifier) check(entry Entry) bool {
+	if !v.result.Valid {
+		return false
+	}
+	v.result.Entries++
+
+	expectedSeq, expectedPrev := int64(1), ""
+	if v.prev != nil {
+		expectedSeq, expectedPrev = v.prev.Seq+1, v.prev.Hash
+	}
+	switch {
+	case entry.Seq != expectedSeq:
+		v.fail(expectedSeq, fmt.Sprintf("entry %d is missing", expectedSeq))
+	case entry.PrevHash != expectedPrev:
+		v.fail(entry.Seq, "previous hash does not match")
+	case entry.Hash != entryHash(entry):
+		v.fail(entry.Seq, "content does not match its hash")
+	}
+
+	v.prev = &entry
+	return v.result.Valid
+}
+
+func (v *verifier) fail(seq int64, reason string) {
+	v.result.Valid = false
+	v.result.BrokenAt = seq
+	v.result.Reason = reason
+}
+
+// matches reports whether entry passes filter
+func (f Filter) matches(entry Entry) bool {
+	switch {
+	case f.Actor != "" && entry.Actor != f.Actor,
+		f.Impersonator != "" && entry.Impersonator != f.Impersonator,
+		f.Subject != "" && entry.Subject != f.Subject,
+		f.Action != "" && entry.Action != f.Action,
+		f.Outcome != "" && entry.Outcome != f.Outcome,
+		!f.Since.IsZero() && entry.Time.Before(f.Since),
+		!f.Until.IsZero() && !entry.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+func (f Filter) limit() int {
+	if f.Limit <= 0 {
+		return DefaultLimit
+	}
+	return f.Limit
+}
@@ -0,0 +1,106 @@
+package audit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/audit"
+)
+
+// newSinkFunc returns an empty sink using now as its clock
+type newSinkFunc func(t *testing.T, now func() time.Time) audit.Sink
+
+// testSink runs the behavior every Sink implementation must have
+func testSink(t *testing.T, newSink newSinkFunc) {
+	t.Run("append chains entries", func(t *testing.T) {
+		sink := newSink(t, time.Now)
+		ctx := context.Background()
+
+		first, err := sink.Append(ctx, audit.Record{Action: audit.ActionLogin, Actor: "user-1", Subject: "user-1", Outcome: audit.OutcomeSuccess})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), first.Seq)
+		assert.Empty(t, first.PrevHash)
+		assert.Len(t, first.Hash, 64)
+
+		second, err := sink.Append(ctx, audit.Record{Action: audit.ActionLogout, Actor: "user-1", Subject: "user-1", Outcome: audit.OutcomeSuccess})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), second.Seq)
+		assert.Equal(t, first.Hash, second.PrevHash)
+		assert.NotEqual(t, first.Hash, second.Hash)
+
+		result, err := sink.Verify(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, audit.Verification{Valid: true, Entries: 2}, result)
+	})
+
+	t.Run("empty log verifies", func(t *testing.T) {
+		sink := newSink(t, time.Now)
+		result, err := sink.Verify(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, audit.Verification{Valid: true}, result)
+	})
+
+	t.Run("query filters newest first", func(t *testing.T) {
+		base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		now := base
+		sink := newSink(t, func() time.Time { return now })
+		ctx := context.Background()
+
+		records := []audit.Record{
+			{Action: audit.ActionLogin, Actor: "user-1", Subject: "user-1", Outcome: audit.OutcomeSuccess},
+			{Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Reason: "invalid_state", IP: "10.0.0.1"},
+			{Action: audit.ActionRefresh, Actor: "user-1", Subject: "user-1", Outcome: audit.OutcomeFailure, Reason: "device_mismatch"},
+			{Action: audit.ActionSessionRevoke, Actor: audit.ActorAdmin, Subject: "user-1", SessionID: "session-1", Outcome: audit.OutcomeSuccess},
+			{Action: audit.ActionRefresh, Actor: "support-1", Subject: "user-2", Impersonator: "support-1", Outcome: audit.OutcomeSuccess},
+		}
+		for i, record := range records {
+			now = base.Add(time.Duration(i) * time.Minute)
+			_, err := sink.Append(ctx, record)
+			require.NoError(t, err)
+		}
+
+		entries, err := sink.Query(ctx, audit.Filter{Subject: "user-1"})
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		assert.Equal(t, []int64{4, 3, 1}, seqs(entries))
+		assert.Equal(t, records[3], entries[0].Record)
+		assert.True(t, base.Add(3*time.Minute).Equal(entries[0].Time))
+
+		entries, err = sink.Query(ctx, audit.Filter{Outcome: audit.OutcomeFailure, Action: audit.ActionLogin})
+		require.NoError(t, err)
+		assert.Equal(t, []int64{2}, seqs(entries))
+
+		entries, err = sink.Query(ctx, audit.Filter{Actor: audit.ActorAdmin})
+		require.NoError(t, err)
+		assert.Equal(t, []int64{4}, seqs(entries))
+
+		entries, err = sink.Query(ctx, audit.Filter{Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute)})
+		require.NoError(t, err)
+		assert.Equal(t, []int64{3, 2}, seqs(entries))
+
+		entries, err = sink.Query(ctx, audit.Filter{Impersonator: "support-1"})
+		require.NoError(t, err)
+		require.Equal(t, []int64{5}, seqs(entries))
+		assert.Equal(t, records[4], entries[0].Record)
+
+		entries, err = sink.Query(ctx, audit.Filter{Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []int64{5, 4}, seqs(entries))
+
+		entries, err = sink.Query(ctx, audit.Filter{Actor: "nobody"})
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
+
+func seqs(entries []audit.Entry) []int64 {
+	out := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		out = append(out, entry.Seq)
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxLineSize bounds one JSON line; entries are far smaller
+const maxLineSize = 1 << 20
+
+// FileSink writes entries as JSON lines to a file opened for append only. Only
+// one process may write to the file; run it next to a single replica or use
+// PostgresSink.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	last *Entry
+	opts options
+}
+
+// NewFileSink opens the audit log at path, creating it if needed, and resumes
+// the chain from its last entry
+func NewFileSink(path string, opts ...Option) (*FileSink, error) {
+	s := &FileSink{path: path, opts: newOptions(opts)}
+
+	err := s.scan(func(entry Entry) bool {
+		s.last = &entry
+		return true
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600) //nolint:gosec // path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	s.file = file
+	return s, nil
+}
+
+// Append writes record as a new line and syncs it to disk
+func (s *FileSink) Append(_ context.Context, record Record) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := chainEntry(s.last, record, s.opts.now())
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return Entry{}, fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return Entry{}, fmt.Errorf("failed to sync audit log: %w", err)
+	}
+
+	s.last = &entry
+	return entry, nil
+}
+
+// Query reads the file and returns the newest entries matching filter
+func (s *FileSink) Query(_ context.Context, filter Filter) ([]Entry, error) {
+	limit := filter.limit()
+	var matched []Entry
+	err := s.scan(func(entry Entry) bool {
+		if filter.matches(entry) {
+			matched = append(matched, entry)
+			// Only the newest limit entries are kept
+			if len(matched) > limit {
+				matched = matched[1:]
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	newestFirst := make([]Entry, 0, len(matched))
+	for i := len(matched) - 1; i >= 0; i-- {
+		newestFirst = append(newestFirst, matched[i])
+	}
+	return newestFirst, nil
+}
+
+// Verify checks the chain of every entry in the file
+func (s *FileSink) Verify(_ context.Context) (Verification, error) {
+	v := newVerifier()
+	if err := s.scan(v.check); err != nil {
+		return Verification{}, err
+	}
+	return v.result, nil
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// scan calls fn for every entry in file order until fn returns false
+func (s *FileSink) scan(fn func(Entry) bool) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	return scanEntries(file, fn)
+}
+
+func scanEntries(r io.Reader, fn func(Entry) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("corrupt audit log at line %d: %w", line, err)
+		}
+		if !fn(entry) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return nil
+}
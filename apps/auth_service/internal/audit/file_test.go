@@ -0,0 +1,106 @@
+package audit_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/audit"
+)
+
+func newFileSink(t *testing.T, path string, opts ...audit.Option) *audit.FileSink {
+	t.Helper()
+	sink, err := audit.NewFileSink(path, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sink.Close() })
+	return sink
+}
+
+func TestFileSink_Conformance(t *testing.T) {
+	testSink(t, func(t *testing.T, now func() time.Time) audit.Sink {
+		return newFileSink(t, filepath.Join(t.TempDir(), "audit.log"), audit.WithClock(now))
+	})
+}
+
+func TestFileSink_ResumesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	ctx := context.Background()
+
+	first, err := newFileSink(t, path).Append(ctx, audit.Record{Action: audit.ActionLogin, Outcome: audit.OutcomeSuccess})
+	require.NoError(t, err)
+
+	// A restarted service continues the same chain
+	second, err := newFileSink(t, path).Append(ctx, audit.Record{Action: audit.ActionLogout, Outcome: audit.OutcomeSuccess})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), second.Seq)
+	assert.Equal(t, first.Hash, second.PrevHash)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestFileSink_DetectsTampering(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		tamper   func(lines []string) []string
+		brokenAt int64
+		reason   string
+	}{
+		{
+			name: "edited entry",
+			tamper: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"outcome":"failure"`, `"outcome":"success"`, 1)
+				return lines
+			},
+			brokenAt: 2,
+			reason:   "content does not match its hash",
+		},
+		{
+			name: "removed entry",
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			brokenAt: 2,
+			reason:   "entry 2 is missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			sink := newFileSink(t, path)
+			for _, outcome := range []string{audit.OutcomeSuccess, audit.OutcomeFailure, audit.OutcomeSuccess} {
+				_, err := sink.Append(ctx, audit.Record{Action: audit.ActionLogin, Subject: "user-1", Outcome: outcome})
+				require.NoError(t, err)
+			}
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			lines := tt.tamper(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+			require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+
+			result, err := sink.Verify(ctx)
+			require.NoError(t, err)
+			assert.False(t, result.Valid)
+			assert.Equal(t, tt.brokenAt, result.BrokenAt)
+			assert.Equal(t, tt.reason, result.Reason)
+		})
+	}
+}
+
+func TestFileSink_CorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("not json\n"), 0o600))
+
+	_, err := audit.NewFileSink(path)
+	assert.ErrorContains(t, err, "corrupt audit log at line 1")
+}
@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// appendLockID is the advisory lock key serializing appends across replicas
+const appendLockID = 7_214_392_047
+
+// entryColumns are the auth_audit_log columns scanned by scanEntry
+const entryColumns = "seq, occurred_at, action, actor, subject, session_id, ip, user_agent, outcome, reason, impersonator, prev_hash, hash"
+
+// PostgresSink stores entries in the auth_audit_log table, created by
+// storage.MigratePostgres. Replicas share one chain; appends are serialized
+// with an advisory lock.
+type PostgresSink struct {
+	pool *pgxpool.Pool
+	opts options
+}
+
+// NewPostgresSink creates a Sink on pool
+func NewPostgresSink(pool *pgxpool.Pool, opts ...Option) *PostgresSink {
+	return &PostgresSink{pool: pool, opts: newOptions(opts)}
+}
+
+// Append stores record after the current last entry
+func (p *PostgresSink) Append(ctx context.Context, record Record) (Entry, error) {
+	var entry Entry
+	err := pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", appendLockID); err != nil {
+			return err
+		}
+
+		var prev *Entry
+		last, err := scanEntry(tx.QueryRow(ctx, "SELECT "+entryColumns+" FROM auth_audit_log ORDER BY seq DESC LIMIT 1"))
+		switch {
+		case err == nil:
+			prev = &last
+		case !errors.Is(err, pgx.ErrNoRows):
+			return err
+		}
+
+		entry = chainEntry(prev, record, p.opts.now())
+		_, err = tx.Exec(ctx,
+			"INSERT INTO auth_audit_log ("+entryColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)",
+			entry.Seq, entry.Time, entry.Action, entry.Actor, entry.Subject, entry.SessionID,
+			entry.IP, entry.UserAgent, entry.Outcome, entry.Reason, entry.Impersonator, entry.PrevHash, entry.Hash,
+		)
+		return err
+	})
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to append audit entry: %w", err)
+	}
+	return entry, nil
+}
+
+// Query returns the newest entries matching filter
+func (p *PostgresSink) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if filter.Actor != "" {
+		where("actor = ?", filter.Actor)
+	}
+	if filter.Impersonator != "" {
+		where("impersonator = ?", filter.Impersonator)
+	}
+	if filter.Subject != "" {
+		where("subject = ?", filter.Subject)
+	}
+	if filter.Action != "" {
+		where("action = ?", filter.Action)
+	}
+	if filter.Outcome != "" {
+		where("outcome = ?", filter.Outcome)
+	}
+	if !filter.Since.IsZero() {
+		where("occurred_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where("occurred_at < ?", filter.Until)
+	}
+
+	query := "SELECT " + entryColumns + " FROM auth_audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.limit())
+	query += " ORDER BY seq DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// Verify streams the whole table in sequence order and checks the chain
+func (p *PostgresSink) Verify(ctx context.Context) (Verification, error) {
+	rows, err := p.pool.Query(ctx, "SELECT "+entryColumns+" FROM auth_audit_log ORDER BY seq")
+	if err != nil {
+		return Verification{}, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	v := newVerifier()
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return Verification{}, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if !v.check(entry) {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return Verification{}, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return v.result, nil
+}
+
+func scanEntry(row pgx.Row) (Entry, error) {
+	var entry Entry
+	err := row.Scan(
+		&entry.Seq, &entry.Time, &entry.Action, &entry.Actor, &entry.Subject, &entry.SessionID,
+		&entry.IP, &entry.UserAgent, &entry.Outcome, &entry.Reason, &entry.Impersonator, &entry.PrevHash, &entry.Hash,
+	)
+	entry.Time = entry.Time.UTC()
+	return entry, err
+}
@@ -0,0 +1,113 @@
+package audit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	testpostgres "github.com/testcontainers/testcontainers-go/modules/postgres"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/audit"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+func setupPostgresContainer(t *testing.T) *pgxpool.Pool {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	postgresContainer, err := testpostgres.Run(ctx,
+		"docker.io/postgres:16-alpine",
+		testpostgres.WithDatabase("auth"),
+		testpostgres.WithUsername("auth"),
+		testpostgres.WithPassword("auth"),
+		testpostgres.BasicWaitStrategies(),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		if err := postgresContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate container: %s", err)
+		}
+	})
+
+	connectionString, err := postgresContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	pool, err := pgxpool.New(ctx, connectionString)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	require.NoError(t, storage.MigratePostgres(ctx, pool))
+
+	return pool
+}
+
+func TestPostgresSink_Conformance(t *testing.T) {
+	pool := setupPostgresContainer(t)
+
+	testSink(t, func(t *testing.T, now func() time.Time) audit.Sink {
+		// The append-only triggers refuse DELETE and TRUNCATE, so the owner
+		// disables them to reset the table
+		_, err := pool.Exec(context.Background(), `
+			ALTER TABLE auth_audit_log DISABLE TRIGGER USER;
+			TRUNCATE auth_audit_log;
+			ALTER TABLE auth_audit_log ENABLE TRIGGER USER;`)
+		require.NoError(t, err)
+		return audit.NewPostgresSink(pool, audit.WithClock(now))
+	})
+}
+
+func TestPostgresSink_AppendOnly(t *testing.T) {
+	pool := setupPostgresContainer(t)
+	ctx := context.Background()
+	sink := audit.NewPostgresSink(pool)
+
+	for range 3 {
+		_, err := sink.Append(ctx, audit.Record{Action: audit.ActionLogin, Subject: "user-1", Outcome: audit.OutcomeSuccess})
+		require.NoError(t, err)
+	}
+
+	_, err := pool.Exec(ctx, "UPDATE auth_audit_log SET outcome = 'failure' WHERE seq = 2")
+	assert.ErrorContains(t, err, "append-only")
+	_, err = pool.Exec(ctx, "DELETE FROM auth_audit_log WHERE seq = 2")
+	assert.ErrorContains(t, err, "append-only")
+	_, err = pool.Exec(ctx, "TRUNCATE auth_audit_log")
+	assert.ErrorContains(t, err, "append-only")
+
+	// Someone able to drop the trigger is still caught by the chain
+	_, err = pool.Exec(ctx, "ALTER TABLE auth_audit_log DISABLE TRIGGER auth_audit_log_append_only")
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, "UPDATE auth_audit_log SET outcome = 'failure' WHERE seq = 2")
+	require.NoError(t, err)
+
+	result, err := sink.Verify(ctx)
+	require.NoError(t, err)
+	assert.False(t, result.Valid)
+	assert.Equal(t, int64(2), result.BrokenAt)
+}
+
+func TestPostgresSink_ConcurrentAppends(t *testing.T) {
+	pool := setupPostgresContainer(t)
+	ctx := context.Background()
+
+	// Two sinks stand in for two replicas sharing the table
+	sinks := []audit.Sink{audit.NewPostgresSink(pool), audit.NewPostgresSink(pool)}
+	errs := make(chan error, 20)
+	for i := range 20 {
+		go func() {
+			_, err := sinks[i%2].Append(ctx, audit.Record{Action: audit.ActionRefresh, Outcome: audit.OutcomeSuccess})
+			errs <- err
+		}()
+	}
+	for range 20 {
+		require.NoError(t, <-errs)
+	}
+
+	result, err := sinks[0].Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, audit.Verification{Valid: true, Entries: 20}, result)
+}
@@ -0,0 +1,32 @@
+package bootstrap
+
+import (
+	"github.com/carlosealves2/short-stream/authservice/internal/audit"
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+)
+
+// initAudit opens the configured audit log. It returns nil when auditing is
+// disabled.
+func (a *App) initAudit() (audit.Sink, error) {
+	cfg := a.config.Audit
+	if cfg == nil || !cfg.Enabled() {
+		return nil, nil
+	}
+
+	switch cfg.Sink {
+	case config.AuditPostgres:
+		pool, err := a.initPostgres()
+		if err != nil {
+			return nil, err
+		}
+		a.logger.Info().Str("sink", cfg.Sink).Msg("Audit log initialized")
+		return audit.NewPostgresSink(pool), nil
+	default:
+		sink, err := audit.NewFileSink(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		a.logger.Info().Str("sink", cfg.Sink).Str("file", cfg.File).Msg("Audit log initialized")
+		return sink, nil
+	}
+}
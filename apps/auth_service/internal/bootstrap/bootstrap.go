@@ -3,10 +3,17 @@ package bootstrap
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 
 	"github.com/carlosealves2/short-stream/authservice/internal/config"
@@ -17,36 +24,104 @@ import (
 	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
 )
 
+// shutdownTimeout bounds how long in-flight requests may take to finish on shutdown
+const shutdownTimeout = 15 * time.Second
+
 // App represents the authentication service application
 type App struct {
-	router *gin.Engine
-	config *config.Config
-	logger logger.Logger
+	router      *gin.Engine
+	config      *config.Config
+	logger      logger.Logger
+	redisClient redis.UniversalClient
+	pgPool      *pgxpool.Pool
+	oidcClient  *oidc.Client
+
+	// ctx is cancelled by Close to stop the background workers
+	ctx     context.Context
+	stop    context.CancelFunc
+	workers sync.WaitGroup
 }
 
 // New creates a new App instance with the given configuration and logger
 func New(cfg *config.Config, log logger.Logger) (*App, error) {
-	app := &App{
-		config: cfg,
-		logger: log,
-	}
-
+	app := newApp(cfg, log)
 	if err := app.initialize(); err != nil {
+		app.Close()
 		return nil, err
 	}
 
 	return app, nil
 }
 
+// newApp creates an App with nothing initialized yet
+func newApp(cfg *config.Config, log logger.Logger) *App {
+	ctx, stop := context.WithCancel(context.Background())
+	return &App{
+		config: cfg,
+		logger: log,
+		ctx:    ctx,
+		stop:   stop,
+	}
+}
+
+// goBackground runs worker until Close, which waits for it to return
+func (a *App) goBackground(worker func(ctx context.Context)) {
+	a.workers.Add(1)
+	go func() {
+		defer a.workers.Done()
+		worker(a.ctx)
+	}()
+}
+
+// Close stops the background workers, waits for them and then releases the
+// connections they and the handlers use
+func (a *App) Close() {
+	a.stop()
+	a.workers.Wait()
+
+	if a.pgPool != nil {
+		a.pgPool.Close()
+	}
+	if a.redisClient != nil {
+		if err := a.redisClient.Close(); err != nil {
+			a.logger.Warn().Err(err).Msg("Failed to close Redis client")
+		}
+	}
+}
+
 func (a *App) initialize() error {
-	// Initialize Redis
-	redisClient, err := a.initRedis()
-	if err != nil {
-		return fmt.Errorf("failed to initialize Redis: %w", err)
+	// Initialize Redis (session store and/or shared rate limits)
+	if a.needsRedis() {
+		redisClient, err := a.initRedis()
+		if err != nil {
+			return fmt.Errorf("failed to initialize Redis: %w", err)
+		}
+		a.redisClient = redisClient
 	}
 
 	// Initialize storage
-	store := storage.NewRedisStore(redisClient, a.config.App.SessionMaxAge)
+	store, err := a.initStore()
+	if err != nil {
+		return fmt.Errorf("failed to initialize session store: %w", err)
+	}
+
+	// Initialize user records
+	users, err := a.initUserStore()
+	if err != nil {
+		return fmt.Errorf("failed to initialize user store: %w", err)
+	}
+
+	// Initialize auth events
+	publisher, err := a.initEvents()
+	if err != nil {
+		return fmt.Errorf("failed to initialize events: %w", err)
+	}
+
+	// Initialize the audit log
+	auditLog, err := a.initAudit()
+	if err != nil {
+		return fmt.Errorf("failed to initialize audit log: %w", err)
+	}
 
 	// Initialize OIDC client
 	oidcClient, err := a.initOIDC()
@@ -54,72 +129,332 @@ func (a *App) initialize() error {
 		return fmt.Errorf("failed to initialize OIDC: %w", err)
 	}
 
-	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(oidcClient, store, a.config.App, a.logger)
+	// Revoke refresh tokens at the provider, retrying failures in the background
+	revoker := oidc.NewAsyncRevoker(oidcClient,
+		oidc.WithRetry(a.config.OIDC.RevocationAttempts, a.config.OIDC.RevocationBackoff),
+		oidc.WithRevokerLogger(a.logger.Component("oidc")),
+		oidc.WithRevokerContext(a.ctx),
+	)
+	a.goBackground(func(ctx context.Context) {
+		<-ctx.Done()
+		revoker.Wait()
+	})
+	authOpts := []handlers.AuthHandlerOption{
+		handlers.WithPostLogoutRedirectURL(a.config.OIDC.PostLogoutRedirectURL),
+		handlers.WithNativeClients(a.config.Native),
+		handlers.WithUserStore(users),
+	}
+	if a.config.OIDC.RevokeOnLogout {
+		authOpts = append(authOpts, handlers.WithTokenRevoker(revoker))
+	}
+	if a.config.Impersonation != nil && a.config.Impersonation.Enabled() {
+		authOpts = append(authOpts, handlers.WithImpersonation(a.config.Impersonation))
+	}
+	adminLogger := a.logger.Component("admin")
+	adminOpts := []handlers.SessionAdminOption{
+		handlers.WithAdminSessionHashKey(a.config.App.SessionHashKey),
+		handlers.WithAdminUserStore(users),
+	}
+	if publisher != nil {
+		authOpts = append(authOpts, handlers.WithEventPublisher(publisher))
+		adminOpts = append(adminOpts, handlers.WithAdminEventPublisher(publisher))
+	}
+	var auditHandler *handlers.AuditAdminHandler
+	if auditLog != nil {
+		authOpts = append(authOpts, handlers.WithAuditSink(auditLog))
+		adminOpts = append(adminOpts, handlers.WithAdminAuditSink(auditLog))
+		auditHandler = handlers.NewAuditAdminHandler(auditLog, adminLogger)
+	}
 
-	// Setup router
-	a.router = a.setupRouter(authHandler)
+	// Initialize handlers
+	a.router = a.setupRouter(routeHandlers{
+		auth:     handlers.NewAuthHandler(oidcClient, store, a.config.App, a.logger.Component("auth"), authOpts...),
+		logLevel: handlers.NewLogLevelHandler(a.logger.Levels(), adminLogger),
+		sessions: handlers.NewSessionAdminHandler(store, revoker, adminLogger, adminOpts...),
+		tokens:   handlers.NewServiceTokenHandler(oidcClient, oidc.NewServiceTokenCache(oidcClient), a.config.Internal, a.logger.Component("tokens")),
+		audit:    auditHandler,
+	})
 
 	return nil
 }
 
+// needsRedis reports whether any component is backed by Redis
+func (a *App) needsRedis() bool {
+	return a.storageBackend() == config.StoreRedis ||
+		(a.config.RateLimit != nil && a.config.RateLimit.Enabled)
+}
+
+func (a *App) storageBackend() string {
+	if a.config.Storage == nil {
+		return config.StoreRedis
+	}
+	return a.config.Storage.Backend
+}
+
+func (a *App) initStore() (storage.Store, error) {
+	switch a.storageBackend() {
+	case config.StorePostgres:
+		return a.initPostgresStore()
+	case config.StoreMemory:
+		a.logger.Warn().
+			Str("backend", config.StoreMemory).
+			Msg("Session store initialized in memory; sessions are lost on restart and not shared between replicas")
+		return storage.NewMemoryStore(a.sessionLifetime()), nil
+	default:
+		a.logger.Info().Str("backend", config.StoreRedis).Msg("Session store initialized")
+		return storage.NewRedisStore(a.redisClient, a.sessionLifetime()), nil
+	}
+}
+
+func (a *App) sessionLifetime() storage.Lifetime {
+	return storage.Lifetime{
+		Idle:     a.config.App.SessionIdleTimeout,
+		Absolute: a.config.App.SessionAbsoluteTimeout,
+	}
+}
+
 func (a *App) initRedis() (redis.UniversalClient, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     a.config.Redis.Addr,
-		Password: a.config.Redis.Password,
-		DB:       a.config.Redis.DB,
-	})
+	opts, err := redisOptions(a.config.Redis)
+	if err != nil {
+		return nil, err
+	}
+
+	// Re-read a mounted password file on every new connection so rotations apply
+	if a.config.Redis.PasswordFile != "" {
+		password := a.secret("REDIS_PASSWORD", opts.Password, a.config.Redis.PasswordFile)
+		username := opts.Username
+		opts.CredentialsProvider = func() (string, string) {
+			return username, password()
+		}
+	}
+
+	client := redis.NewUniversalClient(opts)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
 		return nil, err
 	}
 
-	a.logger.Info().Str("addr", a.config.Redis.Addr).Msg("Connected to Redis successfully")
+	a.logger.Info().
+		Str("mode", a.config.Redis.Mode).
+		Strs("addrs", opts.Addrs).
+		Bool("tls", opts.TLSConfig != nil).
+		Msg("Connected to Redis successfully")
 	return client, nil
 }
 
 func (a *App) initOIDC() (*oidc.Client, error) {
-	client, err := oidc.NewClient(context.Background(), a.config.OIDC)
+	client, err := oidc.NewClient(a.ctx, a.config.OIDC, oidc.WithLogger(a.logger.Component("oidc")))
 	if err != nil {
 		return nil, err
 	}
 
-	a.logger.Info().Str("provider", a.config.OIDC.ProviderURL).Msg("OIDC client initialized successfully")
+	// Retry and refresh discovery until the App is closed
+	client.Start(a.ctx)
+	a.oidcClient = client
+
+	a.logger.Info().
+		Str("provider", a.config.OIDC.ProviderURL).
+		Bool("discovered", client.Ready()).
+		Msg("OIDC client initialized successfully")
 	return client, nil
 }
 
-func (a *App) setupRouter(authHandler *handlers.AuthHandler) *gin.Engine {
+// routeHandlers holds the handlers mounted by setupRouter
+type routeHandlers struct {
+	auth     *handlers.AuthHandler
+	logLevel *handlers.LogLevelHandler
+	sessions *handlers.SessionAdminHandler
+	tokens   *handlers.ServiceTokenHandler
+	// audit is nil when no audit sink is configured
+	audit *handlers.AuditAdminHandler
+}
+
+func (a *App) setupRouter(h routeHandlers) *gin.Engine {
 	router := gin.New()
+	// Only X-Forwarded-For from the configured proxies counts, so clients
+	// cannot pick the IP rate limits, events and audit records see
+	if err := router.SetTrustedProxies(a.config.App.TrustedProxies); err != nil {
+		a.logger.Error().Err(err).Msg("Invalid trusted proxies, trusting none")
+		_ = router.SetTrustedProxies(nil)
+	}
 
 	// Apply middleware
 	router.Use(middleware.Recovery(a.logger))
-	router.Use(middleware.Logger(a.logger))
+	router.Use(middleware.Logger(a.logger.Component("http")))
 	router.Use(middleware.CORS([]string{a.config.App.FrontendURL}))
+	if a.config.Security != nil {
+		router.Use(middleware.SecurityHeaders(middleware.SecurityHeadersPolicy{
+			HSTSMaxAge:            a.config.Security.HSTSMaxAge,
+			HSTSIncludeSubdomains: a.config.Security.HSTSIncludeSubdomains,
+			HSTSPreload:           a.config.Security.HSTSPreload,
+			ReferrerPolicy:        a.config.Security.ReferrerPolicy,
+			FrameAncestors:        a.config.Security.FrameAncestors,
+		}))
+	}
 
-	// Health check
+	// Health check; the service stays up while the provider is unreachable,
+	// so its state is reported rather than failing the check
 	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok"})
+		providerStatus := "ok"
+		if a.oidcClient != nil && !a.oidcClient.Healthy() {
+			providerStatus = "degraded"
+		}
+		c.JSON(200, gin.H{"status": "ok", "oidc": providerStatus})
 	})
 
 	// Auth routes
+	limits := a.rateLimits()
 	authGroup := router.Group("/auth")
 	{
-		authGroup.GET("/login", authHandler.Login)
-		authGroup.GET("/callback", authHandler.Callback)
-		authGroup.POST("/refresh", authHandler.Refresh)
-		authGroup.GET("/logout", authHandler.Logout)   // GET para permitir redirect direto
-		authGroup.POST("/logout", authHandler.Logout)  // POST para compatibilidade
+		authGroup.GET("/login", append(limits.login, h.auth.Login)...)
+		authGroup.GET("/callback", append(limits.callback, h.auth.Callback)...)
+		authGroup.POST("/refresh", append(limits.refresh, h.auth.Refresh)...)
+		// Every logout stores a logout state, so it is limited like a login
+		authGroup.GET("/logout", append(limits.logout, h.auth.Logout)...)  // GET para permitir redirect direto
+		authGroup.POST("/logout", append(limits.logout, h.auth.Logout)...) // POST para compatibilidade
+		authGroup.GET("/logout/callback", append(limits.callback, h.auth.LogoutCallback)...)
+		authGroup.GET("/userinfo", h.auth.UserInfo)
+		authGroup.POST("/device/start", append(limits.login, h.auth.DeviceStart)...)
+		authGroup.POST("/device/poll", append(limits.devicePoll, h.auth.DevicePoll)...)
+		authGroup.GET("/native/authorize", append(limits.login, h.auth.NativeAuthorize)...)
+		authGroup.POST("/native/token", append(limits.nativeToken, h.auth.NativeToken)...)
+
+		// Impersonation (only registered when impersonation admins are configured)
+		if a.config.Impersonation != nil && a.config.Impersonation.Enabled() {
+			authGroup.POST("/impersonation", h.auth.StartImpersonation)
+			authGroup.DELETE("/impersonation", h.auth.EndImpersonation)
+		}
+	}
+
+	// Admin routes (only registered when an admin token is configured)
+	if a.config.App.AdminToken != "" {
+		adminToken := a.secret("ADMIN_TOKEN", a.config.App.AdminToken, a.config.App.AdminTokenFile)
+		adminGroup := router.Group("/admin", middleware.BearerAuthFunc(adminToken))
+		{
+			adminGroup.GET("/log-level", h.logLevel.Get)
+			adminGroup.PUT("/log-level", h.logLevel.Update)
+
+			adminGroup.GET("/sessions/stats", h.sessions.Counts)
+			adminGroup.DELETE("/sessions/:session_id", h.sessions.RevokeSession)
+			adminGroup.GET("/users/:user_id/sessions", h.sessions.ListUserSessions)
+			adminGroup.DELETE("/users/:user_id/sessions", h.sessions.RevokeUserSessions)
+
+			if h.audit != nil {
+				adminGroup.GET("/audit", h.audit.Query)
+				adminGroup.GET("/audit/verify", h.audit.Verify)
+			}
+		}
+	}
+
+	// Internal token API for backend services (only registered when internal
+	// services are configured), each authenticating with its own token
+	if a.config.Internal != nil && len(a.config.Internal.Services) > 0 {
+		credentials := make([]middleware.ServiceCredential, 0, len(a.config.Internal.Services))
+		for _, service := range a.config.Internal.Services {
+			credentials = append(credentials, middleware.ServiceCredential{
+				ID:    service.ID,
+				Token: a.secret(service.TokenKey(), service.Token, service.TokenFile),
+			})
+		}
+		internalGroup := router.Group("/internal", middleware.ServiceAuth(credentials))
+		{
+			internalGroup.POST("/token/exchange", h.tokens.Exchange)
+			internalGroup.POST("/token/service", h.tokens.ServiceToken)
+		}
 	}
 
 	return router
 }
 
-// Run starts the HTTP server on the configured port
+// routeLimits holds the rate limit middleware chain for each auth route
+type routeLimits struct {
+	login       []gin.HandlerFunc
+	logout      []gin.HandlerFunc
+	callback    []gin.HandlerFunc
+	refresh     []gin.HandlerFunc
+	devicePoll  []gin.HandlerFunc
+	nativeToken []gin.HandlerFunc
+}
+
+func (a *App) rateLimits() routeLimits {
+	cfg := a.config.RateLimit
+	if cfg == nil || !cfg.Enabled {
+		return routeLimits{}
+	}
+
+	fallback := middleware.NewMemoryRateLimiter()
+	limiter := fallback
+	if a.redisClient != nil {
+		limiter = middleware.NewRedisRateLimiter(a.redisClient,
+			middleware.WithRedisTimeout(cfg.RedisTimeout),
+			middleware.WithRedisCooldown(cfg.RedisCooldown),
+		)
+	}
+	log := a.logger.Component("ratelimit")
+	window := time.Duration(cfg.Window) * time.Second
+
+	limit := func(name string, perWindow int, key middleware.RateLimitKeyFunc) gin.HandlerFunc {
+		return middleware.RateLimit(limiter, fallback, middleware.RateLimitPolicy{
+			Name:   name,
+			Limit:  perWindow,
+			Window: window,
+			Key:    key,
+		}, log)
+	}
+
+	return routeLimits{
+		login: []gin.HandlerFunc{limit("login", cfg.LoginPerIP, middleware.KeyByIP)},
+		// Logouts have their own budget, so they never use up the logins'
+		logout:   []gin.HandlerFunc{limit("logout", cfg.LoginPerIP, middleware.KeyByIP)},
+		callback: []gin.HandlerFunc{limit("callback", cfg.CallbackPerIP, middleware.KeyByIP)},
+		refresh: []gin.HandlerFunc{
+			limit("refresh-ip", cfg.RefreshPerIP, middleware.KeyByIP),
+			limit("refresh-session", cfg.RefreshPerSession, middleware.KeyBySession),
+		},
+		devicePoll:  []gin.HandlerFunc{limit("device-poll", cfg.DevicePollPerIP, middleware.KeyByIP)},
+		nativeToken: []gin.HandlerFunc{limit("native-token", cfg.RefreshPerIP, middleware.KeyByIP)},
+	}
+}
+
+// Run starts the HTTP server on the configured port and serves until SIGINT
+// or SIGTERM, then drains in-flight requests and closes the App
 func (a *App) Run() error {
-	addr := fmt.Sprintf(":%s", a.config.App.Port)
-	a.logger.Info().Str("addr", addr).Msg("Starting auth service")
-	return a.router.Run(addr)
+	defer a.Close()
+
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%s", a.config.App.Port),
+		Handler:      a.router,
+		ReadTimeout:  a.config.App.ReadTimeout,
+		WriteTimeout: a.config.App.WriteTimeout,
+	}
+
+	signals, cancel := signal.NotifyContext(a.ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() {
+		a.logger.Info().Str("addr", server.Addr).Msg("Starting auth service")
+		served <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-signals.Done():
+	}
+
+	a.logger.Info().Msg("Shutting down auth service")
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shut down: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
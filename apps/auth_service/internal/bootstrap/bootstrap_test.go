@@ -0,0 +1,43 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/phuslu/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+func TestApp_CloseStopsBackgroundWorkers(t *testing.T) {
+	app := newApp(&config.Config{}, logger.New(&bytes.Buffer{}, log.ErrorLevel))
+
+	stopped := make(chan struct{})
+	app.goBackground(func(ctx context.Context) {
+		// A sweeper that would otherwise run for the lifetime of the process
+		app.sweepSessions(ctx, nil, time.Hour)
+		close(stopped)
+	})
+
+	closed := make(chan struct{})
+	go func() {
+		app.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("Close returned before the worker stopped")
+	}
+	assert.ErrorIs(t, app.ctx.Err(), context.Canceled)
+}
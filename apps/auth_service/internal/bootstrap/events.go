@@ -0,0 +1,52 @@
+package bootstrap
+
+import (
+	"context"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+	"github.com/carlosealves2/short-stream/authservice/internal/handlers"
+)
+
+// initEvents sets up the event outbox and starts the relay that delivers it to
+// RabbitMQ. It returns nil when events are disabled.
+func (a *App) initEvents() (handlers.EventPublisher, error) {
+	cfg := a.config.Events
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+
+	var outbox events.Outbox
+	if a.config.Storage != nil && a.config.Storage.DatabaseURL != "" {
+		pool, err := a.initPostgres()
+		if err != nil {
+			return nil, err
+		}
+		outbox = events.NewPostgresOutbox(pool)
+		a.logger.Info().Str("backend", config.StorePostgres).Msg("Event outbox initialized")
+	} else {
+		outbox = events.NewMemoryOutbox()
+		a.logger.Warn().Msg("Event outbox initialized in memory; set DATABASE_URL so undelivered events survive restarts")
+	}
+
+	broker := events.NewAMQPBroker(a.secret("RABBITMQ_URL", cfg.RabbitMQURL, cfg.RabbitMQURLFile))
+	relay := events.NewRelay(outbox, broker, events.RelayConfig{
+		Exchange:       cfg.Exchange,
+		Interval:       cfg.RelayInterval,
+		BatchSize:      cfg.BatchSize,
+		Backoff:        cfg.RetryBackoff,
+		MaxBackoff:     cfg.RetryMaxBackoff,
+		PublishTimeout: cfg.PublishTimeout,
+		MaxAttempts:    cfg.MaxAttempts,
+	}, a.logger.Component("events"))
+	// The broker is closed once the relay stopped, before the outbox's pool
+	a.goBackground(func(ctx context.Context) {
+		relay.Run(ctx)
+		if err := broker.Close(); err != nil {
+			a.logger.Warn().Err(err).Msg("Failed to close RabbitMQ connection")
+		}
+	})
+
+	a.logger.Info().Str("exchange", cfg.Exchange).Msg("Event relay started")
+	return events.NewPublisher(outbox), nil
+}
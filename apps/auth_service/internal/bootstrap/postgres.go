@@ -0,0 +1,127 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// initPostgres connects to DATABASE_URL and applies the schema migrations.
+// The pool is shared by the session and user stores.
+func (a *App) initPostgres() (*pgxpool.Pool, error) {
+	if a.pgPool != nil {
+		return a.pgPool, nil
+	}
+	cfg := a.config.Storage
+
+	poolConfig, err := pgxpool.ParseConfig(cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.MaxConns) //nolint:gosec // validated as non-negative
+	}
+	// New connections log in with the credentials of the mounted URL file as
+	// it is now, so rotated database passwords apply without a restart
+	if cfg.DatabaseURLFile != "" {
+		databaseURL := a.secret("DATABASE_URL", cfg.DatabaseURL, cfg.DatabaseURLFile)
+		poolConfig.BeforeConnect = func(_ context.Context, connConfig *pgx.ConnConfig) error {
+			current, err := pgx.ParseConfig(databaseURL())
+			if err != nil {
+				a.logger.Warn().Err(err).Msg("Invalid DATABASE_URL file, using last known credentials")
+				return nil
+			}
+			connConfig.User = current.User
+			connConfig.Password = current.Password
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PostgreSQL pool: %w", err)
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+
+	if err := storage.MigratePostgres(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	a.logger.Info().Str("host", poolConfig.ConnConfig.Host).Msg("Connected to PostgreSQL successfully")
+	a.pgPool = pool
+	return pool, nil
+}
+
+func (a *App) initPostgresStore() (storage.Store, error) {
+	pool, err := a.initPostgres()
+	if err != nil {
+		return nil, err
+	}
+
+	store := storage.NewPostgresStore(pool, a.sessionLifetime())
+	a.goBackground(func(ctx context.Context) {
+		a.sweepSessions(ctx, store, a.config.Storage.SweepInterval)
+	})
+
+	a.logger.Info().Str("backend", config.StorePostgres).Msg("Session store initialized")
+	return store, nil
+}
+
+// initUserStore keeps user records in PostgreSQL whenever DATABASE_URL is set,
+// whatever the session backend. Without DATABASE_URL there is no user store
+// and no internal user IDs are issued, as IDs that change on every restart
+// would be worse than none.
+func (a *App) initUserStore() (storage.UserStore, error) {
+	if a.config.Storage == nil || a.config.Storage.DatabaseURL == "" {
+		a.logger.Info().Msg("User store disabled; set DATABASE_URL to issue internal user IDs")
+		return nil, nil
+	}
+
+	pool, err := a.initPostgres()
+	if err != nil {
+		return nil, err
+	}
+	a.logger.Info().Str("backend", config.StorePostgres).Msg("User store initialized")
+	return storage.NewPostgresUserStore(pool, storage.WithEmailLinking(a.config.OIDC.EmailLinkIssuers...)), nil
+}
+
+// sweepSessions periodically deletes expired states and sessions from
+// PostgreSQL until ctx is cancelled
+func (a *App) sweepSessions(ctx context.Context, store *storage.PostgresStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sweepCtx, cancel := context.WithTimeout(ctx, interval)
+		removed, err := store.Sweep(sweepCtx)
+		cancel()
+
+		if err != nil {
+			a.logger.Error().Err(err).Msg("Failed to sweep expired sessions")
+			continue
+		}
+		if removed > 0 {
+			a.logger.Debug().Int64("removed", removed).Msg("Swept expired sessions")
+		}
+	}
+}
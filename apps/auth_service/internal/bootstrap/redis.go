@@ -0,0 +1,179 @@
+package bootstrap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+)
+
+// redisOptions translates RedisConfig into options for redis.NewUniversalClient,
+// which returns a single-node, Sentinel (failover) or Cluster client by mode
+func redisOptions(cfg *config.RedisConfig) (*redis.UniversalOptions, error) {
+	opts := &redis.UniversalOptions{}
+
+	if cfg.URL != "" {
+		parsed, err := parseRedisURL(cfg.Mode, cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		opts = parsed
+	} else {
+		opts.Addrs = cfg.SeedAddrs()
+		opts.DB = cfg.DB
+	}
+
+	if cfg.Mode == config.RedisModeCluster {
+		opts.IsClusterMode = true
+	}
+
+	// Explicit settings override the URL
+	if cfg.Username != "" {
+		opts.Username = cfg.Username
+	}
+	if cfg.Password != "" {
+		opts.Password = cfg.Password
+	}
+	if cfg.URL != "" && cfg.DB != 0 {
+		opts.DB = cfg.DB
+	}
+	if cfg.MasterName != "" {
+		opts.MasterName = cfg.MasterName
+	}
+	if cfg.SentinelUsername != "" {
+		opts.SentinelUsername = cfg.SentinelUsername
+	}
+	if cfg.SentinelPassword != "" {
+		opts.SentinelPassword = cfg.SentinelPassword
+	}
+	if cfg.PoolSize > 0 {
+		opts.PoolSize = cfg.PoolSize
+	}
+	if cfg.MinIdleConns > 0 {
+		opts.MinIdleConns = cfg.MinIdleConns
+	}
+	if cfg.PoolTimeout > 0 {
+		opts.PoolTimeout = cfg.PoolTimeout
+	}
+	if cfg.DialTimeout > 0 {
+		opts.DialTimeout = cfg.DialTimeout
+	}
+	if cfg.ReadTimeout > 0 {
+		opts.ReadTimeout = cfg.ReadTimeout
+	}
+	if cfg.WriteTimeout > 0 {
+		opts.WriteTimeout = cfg.WriteTimeout
+	}
+
+	// Per-call deadlines, such as the rate limiter's, bound network reads and
+	// writes too rather than only the wait for a pooled connection
+	opts.ContextTimeoutEnabled = true
+
+	tlsConfig, err := redisTLSConfig(cfg.TLS, opts.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	opts.TLSConfig = tlsConfig
+
+	return opts, nil
+}
+
+// parseRedisURL parses a connection string with the go-redis parser for the mode
+func parseRedisURL(mode, rawURL string) (*redis.UniversalOptions, error) {
+	switch mode {
+	case config.RedisModeSentinel:
+		o, err := redis.ParseFailoverURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		return &redis.UniversalOptions{
+			Addrs:            o.SentinelAddrs,
+			MasterName:       o.MasterName,
+			SentinelUsername: o.SentinelUsername,
+			SentinelPassword: o.SentinelPassword,
+			Username:         o.Username,
+			Password:         o.Password,
+			DB:               o.DB,
+			TLSConfig:        o.TLSConfig,
+			PoolSize:         o.PoolSize,
+			MinIdleConns:     o.MinIdleConns,
+			PoolTimeout:      o.PoolTimeout,
+			DialTimeout:      o.DialTimeout,
+			ReadTimeout:      o.ReadTimeout,
+			WriteTimeout:     o.WriteTimeout,
+		}, nil
+	case config.RedisModeCluster:
+		o, err := redis.ParseClusterURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		return &redis.UniversalOptions{
+			Addrs:        o.Addrs,
+			Username:     o.Username,
+			Password:     o.Password,
+			TLSConfig:    o.TLSConfig,
+			PoolSize:     o.PoolSize,
+			MinIdleConns: o.MinIdleConns,
+			PoolTimeout:  o.PoolTimeout,
+			DialTimeout:  o.DialTimeout,
+			ReadTimeout:  o.ReadTimeout,
+			WriteTimeout: o.WriteTimeout,
+		}, nil
+	default:
+		o, err := redis.ParseURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		return &redis.UniversalOptions{
+			Addrs:        []string{o.Addr},
+			Username:     o.Username,
+			Password:     o.Password,
+			DB:           o.DB,
+			TLSConfig:    o.TLSConfig,
+			PoolSize:     o.PoolSize,
+			MinIdleConns: o.MinIdleConns,
+			PoolTimeout:  o.PoolTimeout,
+			DialTimeout:  o.DialTimeout,
+			ReadTimeout:  o.ReadTimeout,
+			WriteTimeout: o.WriteTimeout,
+		}, nil
+	}
+}
+
+// redisTLSConfig builds the TLS configuration, starting from the one implied by
+// a rediss:// URL when present. It returns nil when TLS is not in use.
+func redisTLSConfig(cfg config.RedisTLSConfig, base *tls.Config) (*tls.Config, error) {
+	if base == nil && !cfg.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		tlsConfig = base.Clone()
+	}
+
+	if cfg.ServerName != "" {
+		tlsConfig.ServerName = cfg.ServerName
+	}
+	if cfg.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec // explicit operator opt-in for development
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read REDIS_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("REDIS_TLS_CA_FILE contains no PEM certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,136 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phuslu/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/handlers"
+	"github.com/carlosealves2/short-stream/authservice/internal/oidc"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+// setupTestRouter builds the router of an App with cfg, its auth routes backed
+// by a mock provider and an in-memory session store
+func setupTestRouter(t *testing.T, cfg *config.Config) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	t.Cleanup(mockServer.Close)
+
+	testLogger := logger.New(&bytes.Buffer{}, log.ErrorLevel)
+	oidcClient, err := oidc.NewClient(context.Background(), &config.OIDCConfig{
+		ProviderURL:  mockServer.Issuer,
+		ClientID:     mockServer.ClientID,
+		ClientSecret: "test-secret",
+		RedirectURL:  mockServer.RedirectURL,
+		Scopes:       []string{"openid"},
+	}, oidc.WithLogger(testLogger))
+	require.NoError(t, err)
+
+	app := newApp(cfg, testLogger)
+	app.oidcClient = oidcClient
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	var authOpts []handlers.AuthHandlerOption
+	if cfg.Impersonation != nil {
+		authOpts = append(authOpts, handlers.WithImpersonation(cfg.Impersonation))
+	}
+	return app.setupRouter(routeHandlers{
+		auth:     handlers.NewAuthHandler(oidcClient, store, cfg.App, testLogger, authOpts...),
+		logLevel: handlers.NewLogLevelHandler(testLogger.Levels(), testLogger),
+		sessions: handlers.NewSessionAdminHandler(store, oidc.NewAsyncRevoker(oidcClient), testLogger),
+		tokens:   handlers.NewServiceTokenHandler(oidcClient, oidc.NewServiceTokenCache(oidcClient), cfg.Internal, testLogger),
+	})
+}
+
+func testAppConfig() *config.AppConfig {
+	return &config.AppConfig{
+		FrontendURL:            "http://localhost:3000",
+		CookieHTTPOnly:         true,
+		SessionIdleTimeout:     time.Hour,
+		SessionAbsoluteTimeout: 24 * time.Hour,
+	}
+}
+
+func TestSetupRouter_SecurityHeaders(t *testing.T) {
+	appConfig := testAppConfig()
+	appConfig.AdminToken = "admin-token"
+	router := setupTestRouter(t, &config.Config{
+		App: appConfig,
+		Internal: &config.InternalConfig{Services: []config.InternalService{
+			{ID: "video", Token: "video-token", Audiences: []string{"billing"}},
+		}},
+		Impersonation: &config.ImpersonationConfig{Admins: []string{"admin"}, TTL: 30 * time.Minute},
+		Security: &config.SecurityHeadersConfig{
+			HSTSMaxAge:     365 * 24 * time.Hour,
+			ReferrerPolicy: "no-referrer",
+			FrameAncestors: []string{"'none'"},
+		},
+	})
+
+	authRoutes, redirects := 0, 0
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, "/auth/") {
+			continue
+		}
+		authRoutes++
+
+		t.Run(route.Method+" "+route.Path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(route.Method, route.Path, nil))
+			if w.Code == http.StatusFound {
+				redirects++
+			}
+
+			assert.Equal(t, "max-age=31536000", w.Header().Get("Strict-Transport-Security"))
+			assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+			assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			assert.Equal(t, "frame-ancestors 'none'", w.Header().Get("Content-Security-Policy"))
+			assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+		})
+	}
+	// Every auth route, impersonation included
+	assert.Equal(t, 13, authRoutes)
+	// Redirects to the provider carry the headers too
+	assert.Positive(t, redirects)
+}
+
+func TestSetupRouter_TrustedProxies(t *testing.T) {
+	login := func(router *gin.Engine, forwardedFor string) int {
+		req := httptest.NewRequest("GET", "/auth/login", nil)
+		req.RemoteAddr = "10.0.0.1:12345"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	rateLimit := &config.RateLimitConfig{Enabled: true, Window: 60, LoginPerIP: 1}
+
+	// Without trusted proxies a client cannot dodge the limit by rotating
+	// X-Forwarded-For
+	router := setupTestRouter(t, &config.Config{App: testAppConfig(), RateLimit: rateLimit})
+	assert.Equal(t, http.StatusFound, login(router, "203.0.113.1"))
+	assert.Equal(t, http.StatusTooManyRequests, login(router, "203.0.113.2"))
+
+	// Behind a trusted proxy each forwarded client has its own limit
+	appConfig := testAppConfig()
+	appConfig.TrustedProxies = []string{"10.0.0.0/8"}
+	router = setupTestRouter(t, &config.Config{App: appConfig, RateLimit: rateLimit})
+	assert.Equal(t, http.StatusFound, login(router, "203.0.113.1"))
+	assert.Equal(t, http.StatusFound, login(router, "203.0.113.2"))
+	assert.Equal(t, http.StatusTooManyRequests, login(router, "203.0.113.1"))
+}
@@ -0,0 +1,23 @@
+package bootstrap
+
+import (
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+)
+
+// secret returns a function yielding the current value of a secret. When the
+// secret came from a file the file is re-read whenever it changes, so
+// rotations apply without a restart; otherwise value never changes.
+func (a *App) secret(name, value, file string) func() string {
+	if file == "" {
+		return func() string { return value }
+	}
+
+	secretFile := config.NewSecretFile(file)
+	return func() string {
+		current, err := secretFile.Value()
+		if err != nil {
+			a.logger.Warn().Err(err).Str("secret", name).Msg("Failed to re-read secret file, using last known value")
+		}
+		return current
+	}
+}
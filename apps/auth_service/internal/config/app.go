@@ -1,10 +1,19 @@
 // Package config provides configuration structures and builders for the auth service
 package config
 
+import "time"
+
+// minSessionHashKeyLen is the shortest SESSION_HASH_KEY accepted
+const minSessionHashKeyLen = 32
+
 // AppConfig holds application-specific configuration
 type AppConfig struct {
 	Port string
 
+	// HTTP server timeouts
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
 	// Cookie settings
 	CookieDomain   string
 	CookieSecure   bool
@@ -13,19 +22,71 @@ type AppConfig struct {
 
 	// Frontend URL for redirects after auth
 	FrontendURL string
+	// LogoutRedirectAllowList holds the URLs (origin plus path prefix) a
+	// logout may send the browser to via ?redirect_uri=; FrontendURL is
+	// always allowed
+	LogoutRedirectAllowList []string
+
+	// TrustedProxies are the IPs and CIDRs of the reverse proxies whose
+	// X-Forwarded-For is believed; with none the client IP is the peer address
+	TrustedProxies []string
 
 	// Session settings
-	SessionMaxAge int // in seconds
+	SessionMaxAge int // in seconds; default for SessionIdleTimeout
+	// SessionIdleTimeout ends a session that is not refreshed in time; each refresh extends it
+	SessionIdleTimeout time.Duration
+	// SessionAbsoluteTimeout caps a session's age regardless of activity
+	SessionAbsoluteTimeout time.Duration
+	// Lifetime of remember-me sessions, which are bound to the device they were
+	// created on; remember-me is disabled when SessionRememberIdleTimeout is zero
+	SessionRememberIdleTimeout     time.Duration
+	SessionRememberAbsoluteTimeout time.Duration
+
+	// AdminToken protects the /admin routes; admin routes are disabled when empty
+	AdminToken string
+	// AdminTokenFile is set when the token comes from a file, which is re-read
+	// when it changes
+	AdminTokenFile string
+
+	// SessionHashKey keys the hash that stands in for session IDs in auth
+	// events and audit entries, so they can be correlated without exposing
+	// usable IDs; without it they carry no session reference
+	SessionHashKey string
 }
 
-func newAppConfig() *AppConfig {
+// RememberMeEnabled reports whether logins may request long-lived sessions
+func (c *AppConfig) RememberMeEnabled() bool {
+	return c.SessionRememberIdleTimeout > 0
+}
+
+func newAppConfig(s *sources) *AppConfig {
+	adminToken, adminTokenFile := getSecret(s, "ADMIN_TOKEN")
+	// Read once: a rotated key would break correlation with earlier records
+	sessionHashKey, _ := getSecret(s, "SESSION_HASH_KEY")
+	sessionMaxAge := getValue(s, "SESSION_MAX_AGE", 3600)
+
 	return &AppConfig{
-		Port:           getEnv("PORT", "8080"),
-		CookieDomain:   getEnv("COOKIE_DOMAIN", ""),
-		CookieSecure:   getEnv("COOKIE_SECURE", true),
-		CookieHTTPOnly: getEnv("COOKIE_HTTP_ONLY", true),
-		CookieSameSite: getEnv("COOKIE_SAME_SITE", "Lax"),
-		FrontendURL:    getEnv("FRONTEND_URL", ""),
-		SessionMaxAge:  getEnv("SESSION_MAX_AGE", 3600),
+		Port:           getValue(s, "PORT", "8080"),
+		ReadTimeout:    getValue(s, "SERVER_READ_TIMEOUT", 10*time.Second),
+		WriteTimeout:   getValue(s, "SERVER_WRITE_TIMEOUT", 10*time.Second),
+		CookieDomain:   getValue(s, "COOKIE_DOMAIN", ""),
+		CookieSecure:   getValue(s, "COOKIE_SECURE", true),
+		CookieHTTPOnly: getValue(s, "COOKIE_HTTP_ONLY", true),
+		CookieSameSite: getValue(s, "COOKIE_SAME_SITE", "Lax"),
+		FrontendURL:    getValue(s, "FRONTEND_URL", ""),
+
+		LogoutRedirectAllowList: getValue(s, "LOGOUT_REDIRECT_ALLOWLIST", []string{}),
+		TrustedProxies:          getValue(s, "TRUSTED_PROXIES", []string{}),
+
+		SessionMaxAge:  sessionMaxAge,
+		AdminToken:     adminToken,
+		AdminTokenFile: adminTokenFile,
+		SessionHashKey: sessionHashKey,
+
+		SessionIdleTimeout:     getValue(s, "SESSION_IDLE_TIMEOUT", time.Duration(sessionMaxAge)*time.Second),
+		SessionAbsoluteTimeout: getValue(s, "SESSION_ABSOLUTE_TIMEOUT", 24*time.Hour),
+
+		SessionRememberIdleTimeout:     getValue(s, "SESSION_REMEMBER_IDLE_TIMEOUT", 7*24*time.Hour),
+		SessionRememberAbsoluteTimeout: getValue(s, "SESSION_REMEMBER_ABSOLUTE_TIMEOUT", 30*24*time.Hour),
 	}
 }
@@ -0,0 +1,50 @@
+package config
+
+import "fmt"
+
+// Audit log sinks
+const (
+	AuditNone     = "none"
+	AuditFile     = "file"
+	AuditPostgres = "postgres"
+)
+
+// AuditConfig selects where security-relevant actions are recorded
+type AuditConfig struct {
+	// Sink is none (default), file or postgres
+	Sink string
+	// File is the JSON-lines file written by the file sink
+	File string
+}
+
+// Enabled reports whether an audit sink is configured
+func (c *AuditConfig) Enabled() bool {
+	return c.Sink != AuditNone
+}
+
+func newAuditConfig(s *sources) *AuditConfig {
+	return &AuditConfig{
+		Sink: getValue(s, "AUDIT_SINK", AuditNone),
+		File: getValue(s, "AUDIT_FILE", "audit.log"),
+	}
+}
+
+func validateAuditConfig(cfg *AuditConfig, storage *StorageConfig) []error {
+	var errs []error
+
+	switch cfg.Sink {
+	case AuditNone:
+	case AuditFile:
+		if cfg.File == "" {
+			errs = append(errs, fmt.Errorf("AUDIT_FILE is required when AUDIT_SINK is file"))
+		}
+	case AuditPostgres:
+		if storage == nil || storage.DatabaseURL == "" {
+			errs = append(errs, fmt.Errorf("DATABASE_URL is required when AUDIT_SINK is postgres"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("AUDIT_SINK must be one of none, file or postgres"))
+	}
+
+	return errs
+}
@@ -1,107 +1,331 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
-	"strconv"
+	"slices"
 
 	"github.com/joho/godotenv"
+
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
 )
 
 // Config holds all configuration for the auth service
 type Config struct {
-	App   *AppConfig
-	OIDC  *OIDCConfig
-	Redis *RedisConfig
+	App           *AppConfig
+	OIDC          *OIDCConfig
+	Redis         *RedisConfig
+	Log           *LogConfig
+	RateLimit     *RateLimitConfig
+	Storage       *StorageConfig
+	Native        *NativeConfig
+	Internal      *InternalConfig
+	Events        *EventsConfig
+	Audit         *AuditConfig
+	Impersonation *ImpersonationConfig
+	Security      *SecurityHeadersConfig
 }
 
-// ConfigBuilder builds configuration from various sources
+// ConfigBuilder builds configuration from various sources.
+// Sources can be added in any order; values are resolved with the precedence
+// flags > environment > config file > defaults.
 //
 //nolint:revive // ConfigBuilder is idiomatic for builder pattern
 type ConfigBuilder struct {
-	config *Config
+	config  *Config
+	sources *sources
+	// errs holds source errors (unreadable file, malformed flags)
+	errs []error
 }
 
 // NewBuilder creates a new ConfigBuilder
 func NewBuilder() *ConfigBuilder {
 	return &ConfigBuilder{
-		config: &Config{},
+		config:  &Config{},
+		sources: newSources(),
 	}
 }
 
+// WithFile loads configuration from a YAML file. An empty path is ignored,
+// so the file can be optional (e.g. WithFile(os.Getenv("CONFIG_FILE"))).
+func (b *ConfigBuilder) WithFile(path string) *ConfigBuilder {
+	if path == "" {
+		return b
+	}
+
+	values, err := loadFile(path)
+	if err != nil {
+		b.errs = append(b.errs, err)
+		return b
+	}
+
+	b.sources.file = values
+	b.load()
+	return b
+}
+
 // WithEnv loads configuration from environment variables
 func (b *ConfigBuilder) WithEnv() *ConfigBuilder {
 	// Try to load .env file (optional in production)
 	_ = godotenv.Load()
 
-	b.config.App = newAppConfig()
-	b.config.OIDC = newOIDCConfig()
-	b.config.Redis = newRedisConfig()
+	b.sources.env = true
+	b.load()
+	return b
+}
+
+// WithFlags loads configuration from command line flags (os.Args)
+func (b *ConfigBuilder) WithFlags() *ConfigBuilder {
+	return b.withFlagArgs(os.Args[1:])
+}
+
+func (b *ConfigBuilder) withFlagArgs(args []string) *ConfigBuilder {
+	values, err := parseFlags(args)
+	if err != nil {
+		b.errs = append(b.errs, fmt.Errorf("invalid command line flags: %w", err))
+		return b
+	}
+
+	b.sources.flags = values
+	b.load()
+	return b
+}
 
+// WithSecretSource registers an external source consulted for secrets
+// (OIDC_CLIENT_SECRET, REDIS_PASSWORD, DATABASE_URL, ADMIN_TOKEN, INTERNAL_SERVICE_<ID>_TOKEN, RABBITMQ_URL, SESSION_HASH_KEY) that are not set directly
+// or through a KEY_FILE variable. Sources are consulted in registration order.
+func (b *ConfigBuilder) WithSecretSource(source SecretSource) *ConfigBuilder {
+	b.sources.secrets = append(b.sources.secrets, source)
+	b.load()
 	return b
 }
 
-// Validate checks if the configuration is valid
+// load resolves every configuration section from the current sources
+func (b *ConfigBuilder) load() {
+	b.sources.used = make(map[string]bool)
+	b.sources.errs = nil
+	b.sources.snapshotSecrets()
+
+	b.config.App = newAppConfig(b.sources)
+	b.config.OIDC = newOIDCConfig(b.sources)
+	b.config.Redis = newRedisConfig(b.sources)
+	b.config.Log = newLogConfig(b.sources)
+	b.config.RateLimit = newRateLimitConfig(b.sources)
+	b.config.Storage = newStorageConfig(b.sources)
+	b.config.Native = newNativeConfig(b.sources)
+	b.config.Internal = newInternalConfig(b.sources)
+	b.config.Events = newEventsConfig(b.sources)
+	b.config.Audit = newAuditConfig(b.sources)
+	b.config.Impersonation = newImpersonationConfig(b.sources)
+	b.config.Security = newSecurityHeadersConfig(b.sources)
+}
+
+// Validate checks if the configuration is valid. It returns every problem
+// found, including values that failed to parse, joined into a single error.
 func (b *ConfigBuilder) Validate() error {
+	errs := append([]error{}, b.errs...)
+	errs = append(errs, b.sources.errs...)
+	errs = append(errs, b.sources.unknownKeys()...)
+
 	// Validate App config
-	if b.config.App.FrontendURL == "" {
-		return fmt.Errorf("FRONTEND_URL is required")
+	if b.config.App != nil {
+		errs = append(errs, validateAppConfig(b.config.App)...)
 	}
 
 	// Validate OIDC config
-	if b.config.OIDC.ProviderURL == "" {
-		return fmt.Errorf("OIDC_PROVIDER_URL is required")
+	if b.config.OIDC != nil {
+		errs = append(errs, validateOIDCConfig(b.config.OIDC)...)
 	}
-	if b.config.OIDC.ClientID == "" {
-		return fmt.Errorf("OIDC_CLIENT_ID is required")
+
+	// Validate Redis config
+	if b.config.Redis != nil {
+		errs = append(errs, validateRedisConfig(b.config.Redis)...)
+	}
+
+	// Validate Log config
+	if b.config.Log != nil {
+		errs = append(errs, validateLogConfig(b.config.Log)...)
 	}
-	if b.config.OIDC.ClientSecret == "" {
-		return fmt.Errorf("OIDC_CLIENT_SECRET is required")
+
+	// Validate RateLimit config
+	if b.config.RateLimit != nil && b.config.RateLimit.Enabled {
+		errs = append(errs, validateRateLimitConfig(b.config.RateLimit)...)
 	}
-	if b.config.OIDC.RedirectURL == "" {
-		return fmt.Errorf("OIDC_REDIRECT_URL is required")
+
+	// Validate Storage config
+	if b.config.Storage != nil {
+		errs = append(errs, validateStorageConfig(b.config.Storage)...)
 	}
 
-	// Validate Redis config
-	if b.config.Redis.Addr == "" {
-		return fmt.Errorf("REDIS_ADDR is required")
+	// Validate Native clients config
+	if b.config.Native != nil {
+		errs = append(errs, validateNativeConfig(b.config.Native)...)
 	}
 
+	// Validate internal services config
+	if b.config.Internal != nil {
+		errs = append(errs, validateInternalConfig(b.config.Internal)...)
+	}
+
+	// Validate Events config
+	if b.config.Events != nil && b.config.Events.Enabled {
+		errs = append(errs, validateEventsConfig(b.config.Events)...)
+	}
+
+	// Validate Audit config
+	if b.config.Audit != nil {
+		errs = append(errs, validateAuditConfig(b.config.Audit, b.config.Storage)...)
+	}
+
+	// Validate Impersonation config
+	if b.config.Impersonation != nil && b.config.Impersonation.Enabled() {
+		errs = append(errs, validateImpersonationConfig(b.config.Impersonation, b.config.Storage)...)
+	}
+
+	// Validate Security headers config
+	if b.config.Security != nil {
+		errs = append(errs, validateSecurityHeadersConfig(b.config.Security)...)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
 	return nil
 }
 
-// Build validates and returns the final configuration
-func (b *ConfigBuilder) Build() (*Config, error) {
-	if err := b.Validate(); err != nil {
-		return nil, err
+func validateAppConfig(cfg *AppConfig) []error {
+	var errs []error
+	if cfg.FrontendURL == "" {
+		errs = append(errs, fmt.Errorf("FRONTEND_URL is required"))
 	}
-	return b.config, nil
+	for _, entry := range cfg.LogoutRedirectAllowList {
+		if u, err := url.Parse(entry); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("LOGOUT_REDIRECT_ALLOWLIST entry %q must be an absolute http(s) URL", entry))
+		}
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				errs = append(errs, fmt.Errorf("TRUSTED_PROXIES entry %q must be an IP address or CIDR", proxy))
+			}
+		}
+	}
+	if cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 {
+		errs = append(errs, fmt.Errorf("SERVER_READ_TIMEOUT and SERVER_WRITE_TIMEOUT must not be negative"))
+	}
+	if cfg.SessionIdleTimeout <= 0 || cfg.SessionAbsoluteTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("SESSION_IDLE_TIMEOUT and SESSION_ABSOLUTE_TIMEOUT must be positive"))
+	} else if cfg.SessionAbsoluteTimeout < cfg.SessionIdleTimeout {
+		errs = append(errs, fmt.Errorf("SESSION_ABSOLUTE_TIMEOUT must not be shorter than SESSION_IDLE_TIMEOUT"))
+	}
+	if cfg.SessionRememberIdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("SESSION_REMEMBER_IDLE_TIMEOUT must not be negative"))
+	} else if cfg.RememberMeEnabled() && cfg.SessionRememberAbsoluteTimeout < cfg.SessionRememberIdleTimeout {
+		errs = append(errs, fmt.Errorf("SESSION_REMEMBER_ABSOLUTE_TIMEOUT must not be shorter than SESSION_REMEMBER_IDLE_TIMEOUT"))
+	}
+	if cfg.SessionHashKey != "" && len(cfg.SessionHashKey) < minSessionHashKeyLen {
+		errs = append(errs, fmt.Errorf("SESSION_HASH_KEY must be at least %d bytes", minSessionHashKeyLen))
+	}
+	return errs
 }
 
-// getEnv is a generic function to get environment variables with type safety
-func getEnv[T string | int | bool](key string, defaultValue T) T {
-	valueStr := os.Getenv(key)
-	if valueStr == "" {
-		return defaultValue
+func validateOIDCConfig(cfg *OIDCConfig) []error {
+	var errs []error
+	if cfg.ProviderURL == "" {
+		errs = append(errs, fmt.Errorf("OIDC_PROVIDER_URL is required"))
 	}
-
-	var result any
-	var err error
-
-	switch any(defaultValue).(type) {
-	case string:
-		result = valueStr
-	case int:
-		result, err = strconv.Atoi(valueStr)
-		if err != nil {
-			return defaultValue
+	if cfg.ClientID == "" {
+		errs = append(errs, fmt.Errorf("OIDC_CLIENT_ID is required"))
+	}
+	switch cfg.ClientAuthMethod {
+	case ClientAuthSecret, "":
+		if cfg.ClientSecret == "" {
+			errs = append(errs, fmt.Errorf("OIDC_CLIENT_SECRET (or OIDC_CLIENT_SECRET_FILE) is required"))
+		}
+	case ClientAuthPrivateKeyJWT:
+		if cfg.PrivateKey == "" {
+			errs = append(errs, fmt.Errorf("OIDC_PRIVATE_KEY (or OIDC_PRIVATE_KEY_FILE) is required for private_key_jwt"))
 		}
-	case bool:
-		result, err = strconv.ParseBool(valueStr)
-		if err != nil {
-			return defaultValue
+	case ClientAuthTLS:
+		if cfg.HTTP.CertFile == "" {
+			errs = append(errs, fmt.Errorf("OIDC_HTTP_CERT_FILE and OIDC_HTTP_KEY_FILE are required for tls_client_auth"))
 		}
+	default:
+		errs = append(errs, fmt.Errorf("OIDC_CLIENT_AUTH_METHOD must be %s, %s or %s", ClientAuthSecret, ClientAuthPrivateKeyJWT, ClientAuthTLS))
+	}
+	if cfg.RedirectURL == "" {
+		errs = append(errs, fmt.Errorf("OIDC_REDIRECT_URL is required"))
+	}
+	if len(cfg.Scopes) > 0 && !slices.Contains(cfg.Scopes, "openid") {
+		errs = append(errs, fmt.Errorf("OIDC_SCOPES must include openid"))
+	}
+	if cfg.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("OIDC_TIMEOUT must not be negative"))
+	}
+	if cfg.HTTP.DialTimeout < 0 || cfg.HTTP.Retries < 0 {
+		errs = append(errs, fmt.Errorf("OIDC_HTTP_DIAL_TIMEOUT and OIDC_HTTP_RETRIES must not be negative"))
+	}
+	if cfg.HTTP.ProxyURL != "" {
+		if u, err := url.Parse(cfg.HTTP.ProxyURL); err != nil || u.Host == "" ||
+			(u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			errs = append(errs, fmt.Errorf("OIDC_HTTP_PROXY must be an http, https or socks5 URL"))
+		}
+	}
+	if (cfg.HTTP.CertFile == "") != (cfg.HTTP.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("OIDC_HTTP_CERT_FILE and OIDC_HTTP_KEY_FILE must be set together"))
+	}
+	if cfg.RevocationAttempts < 0 || cfg.RevocationBackoff < 0 {
+		errs = append(errs, fmt.Errorf("OIDC_REVOCATION_ATTEMPTS and OIDC_REVOCATION_BACKOFF must not be negative"))
+	}
+	if cfg.DiscoveryInterval < 0 {
+		errs = append(errs, fmt.Errorf("OIDC_DISCOVERY_INTERVAL must not be negative"))
+	}
+	if cfg.BreakerThreshold < 0 || (cfg.BreakerThreshold > 0 && cfg.BreakerCooldown <= 0) {
+		errs = append(errs, fmt.Errorf("OIDC_BREAKER_THRESHOLD must not be negative and OIDC_BREAKER_COOLDOWN must be positive when the breaker is enabled"))
+	}
+	return errs
+}
+
+func validateRateLimitConfig(cfg *RateLimitConfig) []error {
+	var errs []error
+	if cfg.Window <= 0 {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_WINDOW must be positive"))
+	}
+	if cfg.LoginPerIP <= 0 || cfg.CallbackPerIP <= 0 || cfg.RefreshPerIP <= 0 || cfg.RefreshPerSession <= 0 || cfg.DevicePollPerIP <= 0 {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_* limits must be positive"))
+	}
+	if cfg.RedisTimeout <= 0 || cfg.RedisCooldown < 0 {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_REDIS_TIMEOUT must be positive and RATE_LIMIT_REDIS_COOLDOWN not negative"))
+	}
+	return errs
+}
+
+func validateLogConfig(cfg *LogConfig) []error {
+	var errs []error
+	if _, err := logger.ParseLevel(cfg.Level); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+
+	switch logger.Format(cfg.Format) {
+	case logger.FormatAuto, logger.FormatJSON, logger.FormatConsole:
+	default:
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be one of auto, json or console"))
 	}
 
-	return result.(T)
+	if _, err := logger.ParseComponentLevels(cfg.ComponentLevels); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_COMPONENT_LEVELS: %w", err))
+	}
+
+	return errs
+}
+
+// Build validates and returns the final configuration
+func (b *ConfigBuilder) Build() (*Config, error) {
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	return b.config, nil
 }
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// EventsConfig configures the auth events published to RabbitMQ
+type EventsConfig struct {
+	Enabled bool
+
+	// RabbitMQURL is the amqp:// or amqps:// URL of the broker
+	RabbitMQURL string
+	// RabbitMQURLFile is set when the URL comes from a file; reconnections
+	// use its current contents
+	RabbitMQURLFile string
+	// Exchange is the topic exchange events are published to, with the event
+	// type as routing key
+	Exchange string
+
+	// RelayInterval is how often undelivered events are sent
+	RelayInterval time.Duration
+	// BatchSize bounds the events sent per relay run
+	BatchSize int
+	// RetryBackoff is the delay after a failed delivery, doubled on every
+	// further failure up to RetryMaxBackoff
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	// PublishTimeout bounds one publish, including the broker's confirm
+	PublishTimeout time.Duration
+	// MaxAttempts is how often an event is published before it is dropped,
+	// so an event the broker keeps refusing does not retry forever
+	MaxAttempts int
+}
+
+func newEventsConfig(s *sources) *EventsConfig {
+	rabbitMQURL, rabbitMQURLFile := getSecret(s, "RABBITMQ_URL")
+
+	return &EventsConfig{
+		Enabled:         getValue(s, "EVENTS_ENABLED", false),
+		RabbitMQURL:     rabbitMQURL,
+		RabbitMQURLFile: rabbitMQURLFile,
+		Exchange:        getValue(s, "EVENTS_EXCHANGE", "auth.events"),
+		RelayInterval:   getValue(s, "EVENTS_RELAY_INTERVAL", time.Second),
+		BatchSize:       getValue(s, "EVENTS_BATCH_SIZE", 100),
+		RetryBackoff:    getValue(s, "EVENTS_RETRY_BACKOFF", time.Second),
+		RetryMaxBackoff: getValue(s, "EVENTS_RETRY_MAX_BACKOFF", 5*time.Minute),
+		PublishTimeout:  getValue(s, "EVENTS_PUBLISH_TIMEOUT", 5*time.Second),
+		MaxAttempts:     getValue(s, "EVENTS_MAX_ATTEMPTS", 50),
+	}
+}
+
+func validateEventsConfig(cfg *EventsConfig) []error {
+	var errs []error
+
+	u, err := url.Parse(cfg.RabbitMQURL)
+	if cfg.RabbitMQURL == "" || err != nil || (u.Scheme != "amqp" && u.Scheme != "amqps") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("RABBITMQ_URL must be an amqp:// or amqps:// URL when EVENTS_ENABLED is true"))
+	}
+	if cfg.Exchange == "" {
+		errs = append(errs, fmt.Errorf("EVENTS_EXCHANGE is required"))
+	}
+	if cfg.RelayInterval <= 0 || cfg.PublishTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("EVENTS_RELAY_INTERVAL and EVENTS_PUBLISH_TIMEOUT must be positive"))
+	}
+	if cfg.BatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("EVENTS_BATCH_SIZE must be positive"))
+	}
+	if cfg.MaxAttempts <= 0 {
+		errs = append(errs, fmt.Errorf("EVENTS_MAX_ATTEMPTS must be positive"))
+	}
+	if cfg.RetryBackoff <= 0 || cfg.RetryMaxBackoff < cfg.RetryBackoff {
+		errs = append(errs, fmt.Errorf("EVENTS_RETRY_BACKOFF must be positive and not above EVENTS_RETRY_MAX_BACKOFF"))
+	}
+
+	return errs
+}
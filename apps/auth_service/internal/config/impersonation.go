@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// maxImpersonationTTL caps how long a support engineer may act as a user
+const maxImpersonationTTL = 8 * time.Hour
+
+// ImpersonationConfig controls "login as" for support staff
+type ImpersonationConfig struct {
+	// Admins are the provider subjects allowed to impersonate users; empty
+	// disables impersonation
+	Admins []string
+	// Protected are users that can never be impersonated, by provider subject
+	// or internal user ID. Admins are always protected as well.
+	Protected []string
+	// TTL is how long an impersonation session lasts; it is never extended
+	TTL time.Duration
+}
+
+// Enabled reports whether anyone may impersonate users
+func (c *ImpersonationConfig) Enabled() bool {
+	return len(c.Admins) > 0
+}
+
+// IsAdmin reports whether subject may impersonate users
+func (c *ImpersonationConfig) IsAdmin(subject string) bool {
+	return slices.Contains(c.Admins, subject)
+}
+
+// IsProtected reports whether the user with subject and internal userID is
+// privileged and may not be impersonated
+func (c *ImpersonationConfig) IsProtected(subject, userID string) bool {
+	return c.IsAdmin(subject) || slices.Contains(c.Protected, subject) || slices.Contains(c.Protected, userID)
+}
+
+func newImpersonationConfig(s *sources) *ImpersonationConfig {
+	return &ImpersonationConfig{
+		Admins:    getValue(s, "IMPERSONATION_ADMINS", []string{}),
+		Protected: getValue(s, "IMPERSONATION_PROTECTED_USERS", []string{}),
+		TTL:       getValue(s, "IMPERSONATION_TTL", 30*time.Minute),
+	}
+}
+
+func validateImpersonationConfig(cfg *ImpersonationConfig, storage *StorageConfig) []error {
+	var errs []error
+	if cfg.Enabled() && (storage == nil || storage.DatabaseURL == "") {
+		errs = append(errs, fmt.Errorf("DATABASE_URL is required when IMPERSONATION_ADMINS is set"))
+	}
+	if cfg.TTL <= 0 || cfg.TTL > maxImpersonationTTL {
+		errs = append(errs, fmt.Errorf("IMPERSONATION_TTL must be positive and at most %s", maxImpersonationTTL))
+	}
+	return errs
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// InternalConfig lists the backend services that may call the internal token
+// API. Each service has its own token and may only ask for the audiences and
+// scopes listed for it.
+type InternalConfig struct {
+	Services []InternalService
+	// legacyToken is set when the retired shared INTERNAL_API_TOKEN is still
+	// configured, so validation can point at its replacement
+	legacyToken bool
+}
+
+// InternalService is a backend service registered for the internal token API
+type InternalService struct {
+	ID    string
+	Token string
+	// TokenFile is set when the token comes from a file, which is re-read
+	// when it changes
+	TokenFile string
+	// Audiences are the audiences the service may request tokens for
+	Audiences []string
+	// Scopes are the scopes the service may request; requests without scope
+	// are always allowed
+	Scopes []string
+}
+
+// Service returns the registered service with id
+func (c *InternalConfig) Service(id string) (InternalService, bool) {
+	for _, service := range c.Services {
+		if service.ID == id {
+			return service, true
+		}
+	}
+	return InternalService{}, false
+}
+
+// TokenKey is the configuration key of the service's token
+func (s InternalService) TokenKey() string {
+	return internalServicePrefix(s.ID) + "_TOKEN"
+}
+
+// AllowsAudience reports whether the service may request tokens for audience
+func (s InternalService) AllowsAudience(audience string) bool {
+	for _, allowed := range s.Audiences {
+		if allowed == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScope reports whether every space-separated scope in scope is
+// registered for the service
+func (s InternalService) AllowsScope(scope string) bool {
+	for _, requested := range strings.Fields(scope) {
+		allowed := false
+		for _, registered := range s.Scopes {
+			if registered == requested {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// newInternalConfig reads INTERNAL_SERVICES, a list of service IDs, and for
+// each ID its INTERNAL_SERVICE_<ID>_TOKEN (a secret), _AUDIENCES and _SCOPES,
+// with the ID upper-cased and dashes turned into underscores
+// (internal.service.<id>.audiences in YAML)
+func newInternalConfig(s *sources) *InternalConfig {
+	cfg := &InternalConfig{}
+	for _, id := range getValue(s, "INTERNAL_SERVICES", []string{}) {
+		prefix := internalServicePrefix(id)
+		token, tokenFile := getSecret(s, InternalService{ID: id}.TokenKey())
+		cfg.Services = append(cfg.Services, InternalService{
+			ID:        id,
+			Token:     token,
+			TokenFile: tokenFile,
+			Audiences: getValue(s, prefix+"_AUDIENCES", []string{}),
+			Scopes:    getValue(s, prefix+"_SCOPES", []string{}),
+		})
+	}
+
+	for _, key := range []string{"INTERNAL_API_TOKEN", "INTERNAL_API_TOKEN_FILE"} {
+		if value, ok := s.lookup(key); ok && value != "" {
+			cfg.legacyToken = true
+		}
+	}
+	return cfg
+}
+
+func internalServicePrefix(id string) string {
+	return "INTERNAL_SERVICE_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_"))
+}
+
+func validateInternalConfig(cfg *InternalConfig) []error {
+	var errs []error
+	if cfg.legacyToken {
+		errs = append(errs, errors.New("INTERNAL_API_TOKEN is no longer supported: register each service in INTERNAL_SERVICES with its own INTERNAL_SERVICE_<ID>_TOKEN"))
+	}
+
+	seen := make(map[string]bool)
+	tokens := make(map[string]string)
+	for _, service := range cfg.Services {
+		if !keyIDPattern.MatchString(service.ID) {
+			errs = append(errs, fmt.Errorf("INTERNAL_SERVICES entry %q may only contain letters, digits, '-' and '_'", service.ID))
+			continue
+		}
+		prefix := internalServicePrefix(service.ID)
+		if seen[prefix] {
+			errs = append(errs, fmt.Errorf("INTERNAL_SERVICES entry %q is duplicated", service.ID))
+		}
+		seen[prefix] = true
+
+		if service.Token == "" {
+			errs = append(errs, fmt.Errorf("%s is required for internal service %q", service.TokenKey(), service.ID))
+		} else if other, ok := tokens[service.Token]; ok && other != service.ID {
+			errs = append(errs, fmt.Errorf("internal services %q and %q share a token; each service needs its own", other, service.ID))
+		} else {
+			tokens[service.Token] = service.ID
+		}
+		if len(service.Audiences) == 0 {
+			errs = append(errs, fmt.Errorf("%s_AUDIENCES is required for internal service %q", prefix, service.ID))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,19 @@
+package config
+
+// LogConfig holds logging configuration
+type LogConfig struct {
+	// Level is the default level (trace, debug, info, warn, error)
+	Level string
+	// Format is one of auto, json or console
+	Format string
+	// ComponentLevels overrides the level per component, e.g. "oidc=debug,http=warn"
+	ComponentLevels string
+}
+
+func newLogConfig(s *sources) *LogConfig {
+	return &LogConfig{
+		Level:           getValue(s, "LOG_LEVEL", "info"),
+		Format:          getValue(s, "LOG_FORMAT", "auto"),
+		ComponentLevels: getValue(s, "LOG_COMPONENT_LEVELS", ""),
+	}
+}
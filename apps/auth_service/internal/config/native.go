@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// keyIDPattern restricts native client and internal service IDs to
+// characters usable in a key name
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// NativeConfig lists the public clients (mobile and desktop apps) that may log
+// in through the token-response mode and receive tokens as JSON
+type NativeConfig struct {
+	Clients []NativeClient
+}
+
+// NativeClient is a registered public client
+type NativeClient struct {
+	ID string
+	// RedirectURIs are the exact redirect URIs the client may use
+	RedirectURIs []string
+}
+
+// Client returns the registered client with id
+func (c *NativeConfig) Client(id string) (NativeClient, bool) {
+	for _, client := range c.Clients {
+		if client.ID == id {
+			return client, true
+		}
+	}
+	return NativeClient{}, false
+}
+
+// AllowsRedirect reports whether redirectURI is registered for the client
+func (c NativeClient) AllowsRedirect(redirectURI string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if allowed == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// newNativeConfig reads NATIVE_CLIENTS, a list of client IDs, and for each ID
+// its NATIVE_CLIENT_<ID>_REDIRECT_URIS, with the ID upper-cased and dashes
+// turned into underscores (native.client.<id>.redirect_uris in YAML)
+func newNativeConfig(s *sources) *NativeConfig {
+	cfg := &NativeConfig{}
+	for _, id := range getValue(s, "NATIVE_CLIENTS", []string{}) {
+		cfg.Clients = append(cfg.Clients, NativeClient{
+			ID:           id,
+			RedirectURIs: getValue(s, nativeRedirectURIsKey(id), []string{}),
+		})
+	}
+	return cfg
+}
+
+func nativeRedirectURIsKey(id string) string {
+	return "NATIVE_CLIENT_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_REDIRECT_URIS"
+}
+
+func validateNativeConfig(cfg *NativeConfig) []error {
+	var errs []error
+	seen := make(map[string]bool)
+
+	for _, client := range cfg.Clients {
+		if !keyIDPattern.MatchString(client.ID) {
+			errs = append(errs, fmt.Errorf("NATIVE_CLIENTS entry %q may only contain letters, digits, '-' and '_'", client.ID))
+			continue
+		}
+		key := nativeRedirectURIsKey(client.ID)
+		if seen[key] {
+			errs = append(errs, fmt.Errorf("NATIVE_CLIENTS entry %q is duplicated", client.ID))
+		}
+		seen[key] = true
+
+		if len(client.RedirectURIs) == 0 {
+			errs = append(errs, fmt.Errorf("%s is required for native client %q", key, client.ID))
+		}
+		for _, redirectURI := range client.RedirectURIs {
+			if !validNativeRedirectURI(redirectURI) {
+				errs = append(errs, fmt.Errorf("%s entry %q must be an absolute URI with a private-use scheme or https, without fragment", key, redirectURI))
+			}
+		}
+	}
+	return errs
+}
+
+// validNativeRedirectURI accepts private-use scheme URIs (com.example.app:/cb)
+// and claimed https URIs, as RFC 8252 recommends for native apps
+func validNativeRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Scheme == "" || u.Fragment != "" || strings.Contains(redirectURI, "#") {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http", "javascript", "data", "file":
+		return false
+	}
+	return true
+}
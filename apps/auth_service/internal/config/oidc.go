@@ -1,20 +1,143 @@
 package config
 
+import (
+	"net/url"
+	"time"
+)
+
+// Client authentication methods at the provider's token endpoint
+const (
+	// ClientAuthSecret sends OIDC_CLIENT_SECRET (client_secret_basic or
+	// client_secret_post, detected on first use)
+	ClientAuthSecret = "client_secret"
+	// ClientAuthPrivateKeyJWT signs a client assertion with OIDC_PRIVATE_KEY
+	ClientAuthPrivateKeyJWT = "private_key_jwt"
+	// ClientAuthTLS authenticates with the mTLS client certificate
+	ClientAuthTLS = "tls_client_auth"
+)
+
 // OIDCConfig holds OpenID Connect provider configuration
 type OIDCConfig struct {
 	ProviderURL  string
 	ClientID     string
 	ClientSecret string
-	RedirectURL  string
-	Scopes       []string
+	// ClientSecretFile is set when the secret comes from a file
+	// (OIDC_CLIENT_SECRET_FILE or SECRETS_DIR); the file is re-read when it
+	// changes so rotations apply without a restart
+	ClientSecretFile string
+	RedirectURL      string
+	Scopes           []string
+
+	// ClientAuthMethod is one of the ClientAuth* constants
+	ClientAuthMethod string
+	// PrivateKey is the PEM encoded RSA or EC key signing private_key_jwt
+	// assertions, registered at the provider under PrivateKeyID
+	PrivateKey   string
+	PrivateKeyID string
+	// PrivateKeyFile is set when the key comes from a file, which is re-read
+	// when it changes; a rotated key must keep PrivateKeyID or be registered
+	// at the provider under the same ID
+	PrivateKeyFile string
+
+	// PostLogoutRedirectURL is where the provider sends the browser after
+	// RP-Initiated Logout; it must be registered at the provider
+	PostLogoutRedirectURL string
+
+	// Timeout bounds each request to the provider
+	Timeout time.Duration
+	// HTTP configures the client used for every request to the provider
+	HTTP OIDCHTTPConfig
+
+	// LazyDiscovery lets the service start while the provider is unreachable;
+	// discovery is then retried in the background
+	LazyDiscovery bool
+	// DiscoveryInterval is how often discovery (and with it the JWKS) is
+	// refreshed; 0 disables rediscovery
+	DiscoveryInterval time.Duration
+	// BreakerThreshold consecutive token endpoint failures open the circuit
+	// breaker for BreakerCooldown; 0 disables the breaker
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// RevokeOnLogout revokes the session's refresh token at the provider
+	// (RFC 7009) on logout and when a session is ended server-side
+	RevokeOnLogout bool
+	// RevocationAttempts and RevocationBackoff control background retries of
+	// failed revocations; the backoff doubles after every retry
+	RevocationAttempts int
+	RevocationBackoff  time.Duration
+
+	// EmailLinkIssuers are the issuers whose verified emails link a new
+	// identity to an existing user; empty gives every identity its own user
+	EmailLinkIssuers []string
+}
+
+// OIDCHTTPConfig holds transport settings for requests to the provider
+type OIDCHTTPConfig struct {
+	// DialTimeout bounds opening a connection, TLS handshake included
+	DialTimeout time.Duration
+	// Retries is how often idempotent requests (discovery, JWKS) are retried
+	// after a network error or a 429/5xx response
+	Retries int
+	// ProxyURL routes provider traffic through a proxy; empty falls back to
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	ProxyURL string
+	// CAFile is a PEM bundle trusted in addition to the system roots
+	CAFile string
+	// CertFile and KeyFile hold a client certificate presented to the
+	// provider (mTLS)
+	CertFile string
+	KeyFile  string
 }
 
-func newOIDCConfig() *OIDCConfig {
+func newOIDCConfig(s *sources) *OIDCConfig {
+	clientSecret, clientSecretFile := getSecret(s, "OIDC_CLIENT_SECRET")
+	privateKey, privateKeyFile := getSecret(s, "OIDC_PRIVATE_KEY")
+	redirectURL := getValue(s, "OIDC_REDIRECT_URL", "")
+
 	return &OIDCConfig{
-		ProviderURL:  getEnv("OIDC_PROVIDER_URL", ""),
-		ClientID:     getEnv("OIDC_CLIENT_ID", ""),
-		ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
-		RedirectURL:  getEnv("OIDC_REDIRECT_URL", ""),
-		Scopes:       []string{"openid", "profile", "email"},
+		ProviderURL:      getValue(s, "OIDC_PROVIDER_URL", ""),
+		ClientID:         getValue(s, "OIDC_CLIENT_ID", ""),
+		ClientSecret:     clientSecret,
+		ClientSecretFile: clientSecretFile,
+		RedirectURL:      redirectURL,
+		Scopes:           getValue(s, "OIDC_SCOPES", []string{"openid", "profile", "email"}),
+
+		ClientAuthMethod: getValue(s, "OIDC_CLIENT_AUTH_METHOD", ClientAuthSecret),
+		PrivateKey:       privateKey,
+		PrivateKeyFile:   privateKeyFile,
+		PrivateKeyID:     getValue(s, "OIDC_PRIVATE_KEY_ID", ""),
+		Timeout:          getValue(s, "OIDC_TIMEOUT", 10*time.Second),
+		HTTP: OIDCHTTPConfig{
+			DialTimeout: getValue(s, "OIDC_HTTP_DIAL_TIMEOUT", 5*time.Second),
+			Retries:     getValue(s, "OIDC_HTTP_RETRIES", 2),
+			ProxyURL:    getValue(s, "OIDC_HTTP_PROXY", ""),
+			CAFile:      getValue(s, "OIDC_HTTP_CA_FILE", ""),
+			CertFile:    getValue(s, "OIDC_HTTP_CERT_FILE", ""),
+			KeyFile:     getValue(s, "OIDC_HTTP_KEY_FILE", ""),
+		},
+
+		LazyDiscovery:     getValue(s, "OIDC_LAZY_DISCOVERY", true),
+		DiscoveryInterval: getValue(s, "OIDC_DISCOVERY_INTERVAL", time.Hour),
+		BreakerThreshold:  getValue(s, "OIDC_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:   getValue(s, "OIDC_BREAKER_COOLDOWN", 30*time.Second),
+
+		PostLogoutRedirectURL: getValue(s, "OIDC_POST_LOGOUT_REDIRECT_URL", logoutCallbackURL(redirectURL)),
+
+		RevokeOnLogout:     getValue(s, "OIDC_REVOKE_ON_LOGOUT", true),
+		RevocationAttempts: getValue(s, "OIDC_REVOCATION_ATTEMPTS", 5),
+		RevocationBackoff:  getValue(s, "OIDC_REVOCATION_BACKOFF", 2*time.Second),
+
+		EmailLinkIssuers: getValue(s, "OIDC_EMAIL_LINK_ISSUERS", []string{}),
+	}
+}
+
+// logoutCallbackURL derives the default post-logout redirect from the login
+// redirect URL: same origin, /auth/logout/callback path
+func logoutCallbackURL(redirectURL string) string {
+	u, err := url.Parse(redirectURL)
+	if err != nil || u.Host == "" {
+		return ""
 	}
+	return u.Scheme + "://" + u.Host + "/auth/logout/callback"
 }
@@ -0,0 +1,35 @@
+package config
+
+import "time"
+
+// RateLimitConfig holds rate limiting configuration for the auth endpoints
+type RateLimitConfig struct {
+	Enabled bool
+	Window  int // in seconds
+
+	// Requests allowed per window
+	LoginPerIP        int
+	CallbackPerIP     int
+	RefreshPerIP      int
+	RefreshPerSession int
+	DevicePollPerIP   int
+
+	// RedisTimeout bounds each Redis call; after a failure Redis is skipped
+	// for RedisCooldown and the per-replica fallback applies
+	RedisTimeout  time.Duration
+	RedisCooldown time.Duration
+}
+
+func newRateLimitConfig(s *sources) *RateLimitConfig {
+	return &RateLimitConfig{
+		Enabled:           getValue(s, "RATE_LIMIT_ENABLED", true),
+		Window:            getValue(s, "RATE_LIMIT_WINDOW", 60),
+		LoginPerIP:        getValue(s, "RATE_LIMIT_LOGIN_PER_IP", 20),
+		CallbackPerIP:     getValue(s, "RATE_LIMIT_CALLBACK_PER_IP", 20),
+		RefreshPerIP:      getValue(s, "RATE_LIMIT_REFRESH_PER_IP", 120),
+		RefreshPerSession: getValue(s, "RATE_LIMIT_REFRESH_PER_SESSION", 30),
+		DevicePollPerIP:   getValue(s, "RATE_LIMIT_DEVICE_POLL_PER_IP", 120),
+		RedisTimeout:      getValue(s, "RATE_LIMIT_REDIS_TIMEOUT", 100*time.Millisecond),
+		RedisCooldown:     getValue(s, "RATE_LIMIT_REDIS_COOLDOWN", 5*time.Second),
+	}
+}
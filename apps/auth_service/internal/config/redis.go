@@ -1,16 +1,146 @@
 package config
 
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Redis deployment modes
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+)
+
 // RedisConfig holds Redis connection configuration
 type RedisConfig struct {
-	Addr     string
+	// Mode is standalone (the default when empty), sentinel or cluster
+	Mode string
+
+	// URL is a redis:// or rediss:// connection string. When set it provides
+	// the addresses, credentials, database and TLS; the fields below override it.
+	// In sentinel and cluster mode extra nodes are given with ?addr=host:port.
+	URL string
+
+	Addr string
+	// Addrs is the cluster seed list or the sentinel addresses; defaults to Addr
+	Addrs []string
+
+	// Username selects a Redis 6+ ACL user
+	Username string
 	Password string
-	DB       int
+	// PasswordFile is set when the password comes from a file
+	// (REDIS_PASSWORD_FILE or SECRETS_DIR); the file is re-read on each new
+	// connection so rotations apply without a restart
+	PasswordFile string
+	DB           int
+
+	// Sentinel settings
+	MasterName       string
+	SentinelUsername string
+	// SentinelPassword is read once; rotating it requires a restart
+	SentinelPassword string
+
+	TLS RedisTLSConfig
+
+	// Pool sizing and timeouts; zero keeps the go-redis defaults
+	PoolSize     int
+	MinIdleConns int
+	PoolTimeout  time.Duration
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
 }
 
-func newRedisConfig() *RedisConfig {
+// RedisTLSConfig holds TLS settings for Redis connections
+type RedisTLSConfig struct {
+	Enabled bool
+	// CAFile is a PEM bundle used instead of the system roots
+	CAFile string
+	// ServerName overrides the name checked against the server certificate
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func newRedisConfig(s *sources) *RedisConfig {
+	password, passwordFile := getSecret(s, "REDIS_PASSWORD")
+	sentinelPassword, _ := getSecret(s, "REDIS_SENTINEL_PASSWORD")
+
 	return &RedisConfig{
-		Addr:     getEnv("REDIS_ADDR", "localhost:6379"),
-		Password: getEnv("REDIS_PASSWORD", ""),
-		DB:       getEnv("REDIS_DB", 0),
+		Mode:             getValue(s, "REDIS_MODE", RedisModeStandalone),
+		URL:              getValue(s, "REDIS_URL", ""),
+		Addr:             getValue(s, "REDIS_ADDR", "localhost:6379"),
+		Addrs:            getValue(s, "REDIS_ADDRS", []string(nil)),
+		Username:         getValue(s, "REDIS_USERNAME", ""),
+		Password:         password,
+		PasswordFile:     passwordFile,
+		DB:               getValue(s, "REDIS_DB", 0),
+		MasterName:       getValue(s, "REDIS_SENTINEL_MASTER", ""),
+		SentinelUsername: getValue(s, "REDIS_SENTINEL_USERNAME", ""),
+		SentinelPassword: sentinelPassword,
+		TLS: RedisTLSConfig{
+			Enabled:            getValue(s, "REDIS_TLS", false),
+			CAFile:             getValue(s, "REDIS_TLS_CA_FILE", ""),
+			ServerName:         getValue(s, "REDIS_TLS_SERVER_NAME", ""),
+			InsecureSkipVerify: getValue(s, "REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+		},
+		PoolSize:     getValue(s, "REDIS_POOL_SIZE", 0),
+		MinIdleConns: getValue(s, "REDIS_MIN_IDLE_CONNS", 0),
+		PoolTimeout:  getValue(s, "REDIS_POOL_TIMEOUT", time.Duration(0)),
+		DialTimeout:  getValue(s, "REDIS_DIAL_TIMEOUT", time.Duration(0)),
+		ReadTimeout:  getValue(s, "REDIS_READ_TIMEOUT", time.Duration(0)),
+		WriteTimeout: getValue(s, "REDIS_WRITE_TIMEOUT", time.Duration(0)),
+	}
+}
+
+// SeedAddrs returns the node addresses to connect to when no URL is set
+func (c *RedisConfig) SeedAddrs() []string {
+	if len(c.Addrs) > 0 {
+		return c.Addrs
+	}
+	if c.Addr == "" {
+		return nil
 	}
+	return []string{c.Addr}
+}
+
+func validateRedisConfig(cfg *RedisConfig) []error {
+	var errs []error
+
+	switch cfg.Mode {
+	case "", RedisModeStandalone, RedisModeSentinel, RedisModeCluster:
+	default:
+		errs = append(errs, fmt.Errorf("REDIS_MODE must be one of standalone, sentinel or cluster"))
+	}
+
+	if cfg.URL != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("REDIS_URL must be a redis:// or rediss:// URL"))
+		}
+	} else if len(cfg.SeedAddrs()) == 0 {
+		errs = append(errs, fmt.Errorf("REDIS_ADDR, REDIS_ADDRS or REDIS_URL is required"))
+	}
+
+	if cfg.Mode == RedisModeSentinel && cfg.MasterName == "" && !urlHasParam(cfg.URL, "master_name") {
+		errs = append(errs, fmt.Errorf("REDIS_SENTINEL_MASTER is required in sentinel mode"))
+	}
+	if cfg.Mode == RedisModeCluster && cfg.DB != 0 {
+		errs = append(errs, fmt.Errorf("REDIS_DB must be 0 in cluster mode"))
+	}
+
+	if cfg.PoolSize < 0 || cfg.MinIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("REDIS_POOL_SIZE and REDIS_MIN_IDLE_CONNS must not be negative"))
+	}
+	if cfg.PoolTimeout < 0 || cfg.DialTimeout < 0 || cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 {
+		errs = append(errs, fmt.Errorf("REDIS_*_TIMEOUT values must not be negative"))
+	}
+
+	return errs
+}
+
+func urlHasParam(rawURL, param string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Query().Get(param) != ""
 }
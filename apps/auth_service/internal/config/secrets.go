@@ -0,0 +1,291 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretSource resolves secrets (client secrets, passwords, tokens) by name
+type SecretSource interface {
+	// Lookup returns the secret stored under name. ok is false when the
+	// source has no value for name; err reports a failure to reach the source.
+	Lookup(ctx context.Context, name string) (value string, ok bool, err error)
+}
+
+// secretLookupTimeout bounds each call to a secret source while loading configuration
+const secretLookupTimeout = 10 * time.Second
+
+// secretCandidates returns the names a secret is looked up under:
+// the key itself (OIDC_CLIENT_SECRET) and its lower-case form (oidc_client_secret)
+func secretCandidates(name string) []string {
+	if lower := strings.ToLower(name); lower != name {
+		return []string{name, lower}
+	}
+	return []string{name}
+}
+
+type envSecretSource struct{}
+
+// NewEnvSecretSource creates a SecretSource backed by environment variables
+func NewEnvSecretSource() SecretSource {
+	return envSecretSource{}
+}
+
+func (envSecretSource) Lookup(_ context.Context, name string) (string, bool, error) {
+	value := os.Getenv(name)
+	return value, value != "", nil
+}
+
+type fileSecretSource struct {
+	dir string
+}
+
+// NewFileSecretSource creates a SecretSource that reads one file per secret
+// from dir, as mounted by Docker (/run/secrets) or Kubernetes secret volumes
+func NewFileSecretSource(dir string) SecretSource {
+	return &fileSecretSource{dir: dir}
+}
+
+func (f *fileSecretSource) Lookup(_ context.Context, name string) (string, bool, error) {
+	path, ok := f.secretPath(name)
+	if !ok {
+		return "", false, nil
+	}
+	value, err := readSecretFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// secretPath returns the file holding the secret name, if there is one
+func (f *fileSecretSource) secretPath(name string) (string, bool) {
+	for _, candidate := range secretCandidates(name) {
+		path := filepath.Join(f.dir, candidate)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		return path, true
+	}
+	return "", false
+}
+
+// snapshotSource is a SecretSource that reads all of its secrets in one
+// request. Each load takes a single snapshot and serves every key from it, so
+// the keys of one load come from the same version of the secrets.
+type snapshotSource interface {
+	snapshot(ctx context.Context) (SecretSource, error)
+}
+
+// secretFields serves the fields of a secret read beforehand
+type secretFields map[string]any
+
+func (s secretFields) Lookup(_ context.Context, name string) (string, bool, error) {
+	for _, candidate := range secretCandidates(name) {
+		if value, ok := s[candidate].(string); ok {
+			return value, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// failedSecretSource reports the error of a snapshot that could not be taken
+// for every key looked up in it
+type failedSecretSource struct {
+	err error
+}
+
+func (f failedSecretSource) Lookup(context.Context, string) (string, bool, error) {
+	return "", false, f.err
+}
+
+// fileBackedSource is a SecretSource keeping each secret in a file, which
+// consumers re-read on rotation like a KEY_FILE
+type fileBackedSource interface {
+	secretPath(name string) (string, bool)
+}
+
+// VaultConfig configures a Vault-compatible KV version 2 secret source
+type VaultConfig struct {
+	// Addr is the server address, e.g. https://vault.internal:8200
+	Addr string
+	// Token is sent as X-Vault-Token
+	Token string
+	// Path is the KV v2 data path, e.g. secret/data/auth-service
+	Path string
+	// HTTPClient defaults to a client with a 10s timeout
+	HTTPClient *http.Client
+}
+
+type vaultSecretSource struct {
+	cfg VaultConfig
+}
+
+// NewVaultSecretSource creates a SecretSource that reads fields of a single
+// Vault KV v2 secret. Field names match the secret names or their lower-case form.
+func NewVaultSecretSource(cfg VaultConfig) SecretSource {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: secretLookupTimeout}
+	}
+	return &vaultSecretSource{cfg: cfg}
+}
+
+func (v *vaultSecretSource) Lookup(ctx context.Context, name string) (string, bool, error) {
+	fields, err := v.snapshot(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	return fields.Lookup(ctx, name)
+}
+
+// snapshot reads every field of the secret; a missing secret has none
+func (v *vaultSecretSource) snapshot(ctx context.Context) (SecretSource, error) {
+	url := strings.TrimRight(v.cfg.Addr, "/") + "/v1/" + strings.TrimLeft(v.cfg.Path, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.cfg.Token)
+
+	resp, err := v.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach vault: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return secretFields{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned status %d for %s", resp.StatusCode, v.cfg.Path)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	return secretFields(body.Data.Data), nil
+}
+
+// SecretFile holds a secret mounted as a file and re-reads it when the file
+// changes, so rotated Kubernetes/Docker secrets are picked up without a restart
+type SecretFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+// NewSecretFile creates a SecretFile for path
+func NewSecretFile(path string) *SecretFile {
+	return &SecretFile{path: path}
+}
+
+// Value returns the current secret, re-reading the file if it changed since the last read
+func (f *SecretFile) Value() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return f.value, fmt.Errorf("failed to stat secret file: %w", err)
+	}
+
+	if f.value != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, nil
+	}
+
+	value, err := readSecretFile(f.path)
+	if err != nil {
+		return f.value, err
+	}
+
+	f.value = value
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return value, nil
+}
+
+// snapshotSecrets takes one snapshot of every snapshotSource for the current
+// load; the other sources are consulted key by key
+func (s *sources) snapshotSecrets() {
+	s.loadedSecrets = make([]SecretSource, 0, len(s.secrets))
+	for _, source := range s.secrets {
+		if snapshots, ok := source.(snapshotSource); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), secretLookupTimeout)
+			snapshot, err := snapshots.snapshot(ctx)
+			cancel()
+			if err != nil {
+				snapshot = failedSecretSource{err: err}
+			}
+			source = snapshot
+		}
+		s.loadedSecrets = append(s.loadedSecrets, source)
+	}
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from operator configuration
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// getSecret resolves a secret key. In order, it uses a value set directly
+// (flags, env, config file), a file named by KEY_FILE, and then the registered
+// secret sources. When the value comes from a file, KEY_FILE or SECRETS_DIR,
+// its path is returned so the consumer can re-read it on rotation.
+func getSecret(s *sources, key string) (value, file string) {
+	direct, hasDirect := s.lookup(key)
+	path, hasFile := s.lookup(key + "_FILE")
+
+	if hasDirect && direct != "" {
+		return direct, ""
+	}
+
+	if hasFile && path != "" {
+		value, err := readSecretFile(path)
+		if err != nil {
+			s.errs = append(s.errs, fmt.Errorf("%s_FILE: %w", key, err))
+			return "", ""
+		}
+		return value, path
+	}
+
+	for _, source := range s.loadedSecrets {
+		ctx, cancel := context.WithTimeout(context.Background(), secretLookupTimeout)
+		value, ok, err := source.Lookup(ctx, key)
+		cancel()
+		if err != nil {
+			s.errs = append(s.errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if files, isFiles := source.(fileBackedSource); isFiles {
+			if path, found := files.secretPath(key); found {
+				return value, path
+			}
+		}
+		return value, ""
+	}
+
+	return "", ""
+}
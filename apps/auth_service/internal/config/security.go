@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// minHSTSPreloadMaxAge is the shortest max-age browsers accept for the HSTS
+// preload list
+const minHSTSPreloadMaxAge = 365 * 24 * time.Hour
+
+// referrerPolicies are the Referrer-Policy values browsers understand
+var referrerPolicies = []string{
+	"no-referrer",
+	"no-referrer-when-downgrade",
+	"origin",
+	"origin-when-cross-origin",
+	"same-origin",
+	"strict-origin",
+	"strict-origin-when-cross-origin",
+	"unsafe-url",
+}
+
+// SecurityHeadersConfig holds the security headers set on every response
+type SecurityHeadersConfig struct {
+	// HSTSMaxAge is the Strict-Transport-Security max-age; zero disables HSTS
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// ReferrerPolicy keeps the code and state of callback URLs out of the
+	// Referer header of whatever the browser loads next
+	ReferrerPolicy string
+	// FrameAncestors are the origins allowed to frame responses, 'none' by
+	// default
+	FrameAncestors []string
+}
+
+func newSecurityHeadersConfig(s *sources) *SecurityHeadersConfig {
+	return &SecurityHeadersConfig{
+		HSTSMaxAge:            getValue(s, "SECURITY_HSTS_MAX_AGE", minHSTSPreloadMaxAge),
+		HSTSIncludeSubdomains: getValue(s, "SECURITY_HSTS_INCLUDE_SUBDOMAINS", false),
+		HSTSPreload:           getValue(s, "SECURITY_HSTS_PRELOAD", false),
+		ReferrerPolicy:        getValue(s, "SECURITY_REFERRER_POLICY", "no-referrer"),
+		FrameAncestors:        getValue(s, "SECURITY_FRAME_ANCESTORS", []string{"'none'"}),
+	}
+}
+
+func validateSecurityHeadersConfig(cfg *SecurityHeadersConfig) []error {
+	var errs []error
+	if cfg.HSTSMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("SECURITY_HSTS_MAX_AGE must not be negative"))
+	}
+	if cfg.HSTSPreload && (!cfg.HSTSIncludeSubdomains || cfg.HSTSMaxAge < minHSTSPreloadMaxAge) {
+		errs = append(errs, fmt.Errorf("SECURITY_HSTS_PRELOAD requires SECURITY_HSTS_INCLUDE_SUBDOMAINS and a SECURITY_HSTS_MAX_AGE of at least %s", minHSTSPreloadMaxAge))
+	}
+	if !slices.Contains(referrerPolicies, cfg.ReferrerPolicy) {
+		errs = append(errs, fmt.Errorf("SECURITY_REFERRER_POLICY %q is not a valid Referrer-Policy", cfg.ReferrerPolicy))
+	}
+	if len(cfg.FrameAncestors) == 0 {
+		errs = append(errs, fmt.Errorf("SECURITY_FRAME_ANCESTORS must not be empty; use 'none' to forbid framing"))
+	} else if slices.Contains(cfg.FrameAncestors, "'none'") && len(cfg.FrameAncestors) > 1 {
+		errs = append(errs, fmt.Errorf("SECURITY_FRAME_ANCESTORS 'none' cannot be combined with other sources"))
+	}
+	return errs
+}
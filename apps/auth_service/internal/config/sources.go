@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sources resolves configuration keys across layers.
+// Precedence, highest first: flags, environment, file, defaults.
+// Secrets additionally fall back to KEY_FILE and the registered secret sources.
+type sources struct {
+	flags   map[string]string
+	env     bool
+	file    map[string]string
+	secrets []SecretSource
+	// loadedSecrets are the secret sources of the current load, with
+	// snapshots in place of the sources that take them
+	loadedSecrets []SecretSource
+
+	// used records every key read while loading, to report unknown keys
+	used map[string]bool
+	errs []error
+}
+
+func newSources() *sources {
+	return &sources{
+		flags: make(map[string]string),
+		file:  make(map[string]string),
+	}
+}
+
+// lookup returns the raw value for key from the highest-precedence layer that sets it
+func (s *sources) lookup(key string) (string, bool) {
+	s.used[key] = true
+
+	if value, ok := s.flags[key]; ok {
+		return value, true
+	}
+	if s.env {
+		if value := os.Getenv(key); value != "" {
+			return value, true
+		}
+	}
+	if value, ok := s.file[key]; ok {
+		return value, true
+	}
+	return "", false
+}
+
+// unknownKeys reports file and flag keys that no configuration section reads
+func (s *sources) unknownKeys() []error {
+	var errs []error
+	for _, layer := range []struct {
+		name   string
+		values map[string]string
+	}{{"config file", s.file}, {"flag", s.flags}} {
+		keys := make([]string, 0, len(layer.values))
+		for key := range layer.values {
+			if !s.used[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			errs = append(errs, fmt.Errorf("%s: unknown configuration key %s", layer.name, key))
+		}
+	}
+	return errs
+}
+
+// getValue resolves key and converts it to the type of defaultValue.
+// Parse errors are recorded and reported by Validate instead of silently
+// falling back to the default.
+func getValue[T string | int | bool | []string | time.Duration](s *sources, key string, defaultValue T) T {
+	valueStr, ok := s.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+
+	var result any
+	var err error
+
+	switch any(defaultValue).(type) {
+	case string:
+		result = valueStr
+	case int:
+		result, err = strconv.Atoi(strings.TrimSpace(valueStr))
+	case bool:
+		result, err = strconv.ParseBool(strings.TrimSpace(valueStr))
+	case []string:
+		result = splitList(valueStr)
+	case time.Duration:
+		result, err = time.ParseDuration(strings.TrimSpace(valueStr))
+	}
+
+	if err != nil {
+		s.errs = append(s.errs, fmt.Errorf("%s: invalid value %q: %w", key, valueStr, err))
+		return defaultValue
+	}
+
+	return result.(T)
+}
+
+// splitList splits comma or space separated values
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// loadFile reads a YAML file and flattens it into configuration keys.
+// Nested keys are joined with "_" and upper-cased, so oidc.client_id becomes
+// OIDC_CLIENT_ID. Keys under the top-level "app" section carry no prefix,
+// mirroring the environment variable names (app.port becomes PORT).
+func loadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	for section, value := range doc {
+		prefix := strings.ToUpper(section)
+		if section == "app" {
+			prefix = ""
+		}
+		if err := flatten(values, prefix, value); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+	return values, nil
+}
+
+func flatten(values map[string]string, prefix string, value any) error {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, nested := range v {
+			name := strings.ToUpper(key)
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			if err := flatten(values, name, nested); err != nil {
+				return err
+			}
+		}
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		values[prefix] = strings.Join(items, ",")
+	case nil:
+		values[prefix] = ""
+	default:
+		if prefix == "" {
+			return fmt.Errorf("top-level value must be a section")
+		}
+		values[prefix] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// parseFlags turns command line arguments into configuration keys.
+// Flags are the lower-case, dash-separated form of the environment variable
+// names: --oidc-client-id=app or --oidc-client-id app sets OIDC_CLIENT_ID.
+// A flag without a value is treated as a boolean "true".
+func parseFlags(args []string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			return nil, fmt.Errorf("unexpected argument %q", arg)
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name == "" {
+			return nil, fmt.Errorf("invalid flag %q", arg)
+		}
+
+		if !hasValue {
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				value = args[i+1]
+				i++
+			} else {
+				value = "true"
+			}
+		}
+
+		values[strings.ToUpper(strings.ReplaceAll(name, "-", "_"))] = value
+	}
+
+	return values, nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Session store backends
+const (
+	StoreRedis    = "redis"
+	StorePostgres = "postgres"
+	StoreMemory   = "memory"
+)
+
+// StorageConfig selects and configures the session store backend
+type StorageConfig struct {
+	// Backend is redis (default), postgres or memory (single replica only)
+	Backend string
+
+	// DatabaseURL is the PostgreSQL connection string used by the postgres backend
+	DatabaseURL string
+	// DatabaseURLFile is set when the URL comes from a file; new connections
+	// take the credentials of its current contents
+	DatabaseURLFile string
+	// MaxConns bounds the PostgreSQL pool; zero keeps the pgx default
+	MaxConns int
+	// SweepInterval is how often expired rows are deleted from PostgreSQL
+	SweepInterval time.Duration
+}
+
+func newStorageConfig(s *sources) *StorageConfig {
+	databaseURL, databaseURLFile := getSecret(s, "DATABASE_URL")
+
+	return &StorageConfig{
+		Backend:         getValue(s, "SESSION_STORE", StoreRedis),
+		DatabaseURL:     databaseURL,
+		DatabaseURLFile: databaseURLFile,
+		MaxConns:        getValue(s, "DATABASE_MAX_CONNS", 0),
+		SweepInterval:   getValue(s, "SESSION_SWEEP_INTERVAL", time.Minute),
+	}
+}
+
+func validateStorageConfig(cfg *StorageConfig) []error {
+	var errs []error
+
+	switch cfg.Backend {
+	case StoreRedis, StoreMemory:
+	case StorePostgres:
+		if cfg.DatabaseURL == "" {
+			errs = append(errs, fmt.Errorf("DATABASE_URL is required when SESSION_STORE is postgres"))
+		}
+		if cfg.SweepInterval <= 0 {
+			errs = append(errs, fmt.Errorf("SESSION_SWEEP_INTERVAL must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("SESSION_STORE must be one of redis, postgres or memory"))
+	}
+
+	if cfg.MaxConns < 0 {
+		errs = append(errs, fmt.Errorf("DATABASE_MAX_CONNS must not be negative"))
+	}
+
+	return errs
+}
@@ -0,0 +1,145 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQPBroker publishes to RabbitMQ in confirm mode. It connects on first use
+// and again after the connection or channel is lost, so it can be created
+// while the broker is down. Messages are published as mandatory: a message no
+// queue is bound for comes back from the broker and its publish fails, so it
+// is retried until a consumer has declared its queue.
+type AMQPBroker struct {
+	// url returns the broker URL, looked up on every dial so rotated
+	// credentials apply on reconnection
+	url func() string
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	// returns receives the mandatory messages the broker could not route
+	returns  chan amqp.Return
+	declared map[string]bool
+}
+
+// NewAMQPBroker creates a broker for the RabbitMQ at the URL (amqp:// or
+// amqps://) url returns
+func NewAMQPBroker(url func() string) *AMQPBroker {
+	return &AMQPBroker{url: url, declared: make(map[string]bool)}
+}
+
+// Publish sends msg as a persistent message and waits for the broker's confirm.
+// Publishes are serialized on one channel.
+func (b *AMQPBroker) Publish(ctx context.Context, exchange, routingKey string, msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	channel, err := b.connect()
+	if err != nil {
+		return err
+	}
+
+	if !b.declared[exchange] {
+		if err := channel.ExchangeDeclare(exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+			b.reset()
+			return fmt.Errorf("failed to declare exchange %s: %w", exchange, err)
+		}
+		b.declared[exchange] = true
+	}
+
+	confirmation, err := channel.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, true, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.ID,
+		Type:         msg.Type,
+		Timestamp:    msg.Timestamp,
+		AppId:        Source,
+		Headers:      amqp.Table{"version": int32(msg.Version)}, //nolint:gosec // small schema versions
+		Body:         msg.Body,
+	})
+	if err != nil {
+		b.reset()
+		return fmt.Errorf("failed to publish %s: %w", msg.Type, err)
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		// The confirm may still arrive on this channel; start over on a new one
+		b.reset()
+		return fmt.Errorf("failed to confirm %s: %w", msg.Type, err)
+	}
+	if !acked {
+		return fmt.Errorf("broker rejected %s", msg.Type)
+	}
+	// The broker sends basic.return before the ack of the same message, and
+	// the library hands it over before the ack, so it is here by now
+	select {
+	case returned, ok := <-b.returns:
+		if ok {
+			return fmt.Errorf("broker returned %s: %s", msg.Type, returned.ReplyText)
+		}
+	default:
+	}
+	return nil
+}
+
+// connect returns the open channel, dialing a new connection when needed.
+// Callers hold b.mu.
+func (b *AMQPBroker) connect() (*amqp.Channel, error) {
+	if b.channel != nil && !b.channel.IsClosed() {
+		return b.channel, nil
+	}
+	b.reset()
+
+	conn, err := amqp.Dial(b.url())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+	if err := channel.Confirm(false); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	b.conn = conn
+	b.channel = channel
+	b.returns = channel.NotifyReturn(make(chan amqp.Return, 1))
+	return channel, nil
+}
+
+// reset drops the connection so the next publish dials again. Callers hold b.mu.
+func (b *AMQPBroker) reset() {
+	if b.conn != nil {
+		_ = b.conn.Close()
+	}
+	b.conn = nil
+	b.channel = nil
+	b.returns = nil
+	b.declared = make(map[string]bool)
+}
+
+// Close closes the connection
+func (b *AMQPBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn = nil
+	b.channel = nil
+	if errors.Is(err, amqp.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,23 @@
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// Message is an event as handed to the broker
+type Message struct {
+	ID        string
+	Type      string
+	Version   int
+	Timestamp time.Time
+	// Body is the JSON-encoded Event
+	Body []byte
+}
+
+// Broker publishes messages to a topic exchange. Publish returns only once the
+// broker confirmed the message, so a nil error means it was accepted.
+type Broker interface {
+	Publish(ctx context.Context, exchange, routingKey string, msg Message) error
+	Close() error
+}
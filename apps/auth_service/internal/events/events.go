@@ -0,0 +1,170 @@
+// Package events publishes auth events (logins, registrations, revoked
+// sessions) to RabbitMQ for other services. Events are first recorded in an
+// outbox and delivered by a Relay with publisher confirms, so a broker outage
+// delays events instead of failing requests or losing them.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Source identifies this service in every event
+const Source = "auth_service"
+
+// Event types, also used as routing keys on the topic exchange
+const (
+	TypeUserRegistered    = "user.registered"
+	TypeUserLoggedIn      = "user.logged_in"
+	TypeSessionRevoked    = "session.revoked"
+	TypeSuspiciousRefresh = "session.suspicious_refresh"
+)
+
+// Login methods reported in UserLoggedIn
+const (
+	LoginBrowser = "browser"
+	LoginNative  = "native"
+	LoginDevice  = "device"
+)
+
+// Event is the JSON envelope published for every event. Consumers should
+// deduplicate by ID, since delivery is at least once.
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Version is the schema version of Data; it changes only on breaking changes
+	Version    int             `json:"version"`
+	Source     string          `json:"source"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Payload is the data of one event type
+type Payload interface {
+	EventType() string
+	EventVersion() int
+}
+
+// UserRegistered is published when a first login creates a local user
+type UserRegistered struct {
+	UserID   string `json:"user_id"`
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email,omitempty"`
+}
+
+// EventType implements Payload
+func (UserRegistered) EventType() string { return TypeUserRegistered }
+
+// EventVersion implements Payload
+func (UserRegistered) EventVersion() int { return 1 }
+
+// UserLoggedIn is published when a login creates a session
+type UserLoggedIn struct {
+	UserID  string `json:"user_id,omitempty"`
+	Subject string `json:"subject"`
+	// SessionRef is a keyed hash of the session ID, never the ID itself; it
+	// matches the SessionRef of the session's other events
+	SessionRef string `json:"session_ref,omitempty"`
+	// Method is browser, native or device
+	Method    string `json:"method"`
+	Remember  bool   `json:"remember"`
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// EventType implements Payload
+func (UserLoggedIn) EventType() string { return TypeUserLoggedIn }
+
+// EventVersion implements Payload
+func (UserLoggedIn) EventVersion() int { return 1 }
+
+// Reasons a session is revoked
+const (
+	RevokedLogout         = "logout"
+	RevokedAdmin          = "admin"
+	RevokedDeviceMismatch = "device_mismatch"
+	RevokedCodeMismatch   = "code_mismatch"
+	// RevokedClientMismatch is a native refresh by another client than the
+	// one the session was issued to, or a cookie refresh of a native session
+	RevokedClientMismatch = "client_mismatch"
+	RevokedAtProvider     = "provider"
+	// RevokedImpersonationEnded ends an impersonation session, from either
+	// the support engineer's or the user's side
+	RevokedImpersonationEnded = "impersonation_ended"
+)
+
+// SessionRevoked is published when a session ends before it expires
+type SessionRevoked struct {
+	UserID     string `json:"user_id,omitempty"`
+	Subject    string `json:"subject,omitempty"`
+	SessionRef string `json:"session_ref,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// EventType implements Payload
+func (SessionRevoked) EventType() string { return TypeSessionRevoked }
+
+// EventVersion implements Payload
+func (SessionRevoked) EventVersion() int { return 1 }
+
+// SuspiciousRefresh is published when a session is used in a way that hints
+// at a stolen credential, such as a refresh from another device
+type SuspiciousRefresh struct {
+	UserID     string `json:"user_id,omitempty"`
+	Subject    string `json:"subject"`
+	SessionRef string `json:"session_ref,omitempty"`
+	Reason     string `json:"reason"`
+	IP         string `json:"ip,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+}
+
+// EventType implements Payload
+func (SuspiciousRefresh) EventType() string { return TypeSuspiciousRefresh }
+
+// EventVersion implements Payload
+func (SuspiciousRefresh) EventVersion() int { return 1 }
+
+// NewEvent wraps payload in an envelope with a new ID
+func NewEvent(payload Payload, now time.Time) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to encode %s event: %w", payload.EventType(), err)
+	}
+
+	return Event{
+		ID:         uuid.New().String(),
+		Type:       payload.EventType(),
+		Version:    payload.EventVersion(),
+		Source:     Source,
+		OccurredAt: now.UTC(),
+		Data:       data,
+	}, nil
+}
+
+// Publisher records events in an outbox; a Relay delivers them
+type Publisher struct {
+	outbox Outbox
+	now    func() time.Time
+}
+
+// NewPublisher creates a Publisher writing to outbox
+func NewPublisher(outbox Outbox) *Publisher {
+	return &Publisher{outbox: outbox, now: time.Now}
+}
+
+// Publish records payload for delivery
+func (p *Publisher) Publish(ctx context.Context, payload Payload) error {
+	event, err := NewEvent(payload, p.now())
+	if err != nil {
+		return err
+	}
+	if err := p.outbox.Add(ctx, event); err != nil {
+		return fmt.Errorf("failed to record %s event: %w", event.Type, err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrOutboxFull is returned by the memory outbox when it holds too many
+// undelivered events, e.g. during a long broker outage
+var ErrOutboxFull = errors.New("event outbox is full")
+
+// defaultMemoryCapacity bounds the undelivered events kept in memory
+const defaultMemoryCapacity = 10_000
+
+// Pending is an outbox event claimed for delivery
+type Pending struct {
+	Event
+	// Attempts counts the failed deliveries so far
+	Attempts int
+}
+
+// Outbox stores events until they are delivered
+type Outbox interface {
+	// Add records an event for delivery
+	Add(ctx context.Context, event Event) error
+	// Claim returns up to limit events due at now, oldest first, and hides
+	// them from other claims for lease so concurrent relays do not deliver
+	// them twice
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Pending, error)
+	// MarkSent removes an event that was delivered or dropped
+	MarkSent(ctx context.Context, id string) error
+	// MarkFailed records a failed delivery and when to try again
+	MarkFailed(ctx context.Context, id string, retryAt time.Time) error
+}
+
+type memoryEntry struct {
+	pending Pending
+	dueAt   time.Time
+	// seq keeps events recorded at the same instant in order
+	seq uint64
+}
+
+type memoryOutbox struct {
+	mu       sync.Mutex
+	entries  map[string]*memoryEntry
+	capacity int
+	added    uint64
+}
+
+// NewMemoryOutbox creates an in-process Outbox. Undelivered events are lost
+// on restart.
+func NewMemoryOutbox() Outbox {
+	return &memoryOutbox{
+		entries:  make(map[string]*memoryEntry),
+		capacity: defaultMemoryCapacity,
+	}
+}
+
+func (m *memoryOutbox) Add(_ context.Context, event Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.entries) >= m.capacity {
+		return ErrOutboxFull
+	}
+	m.added++
+	m.entries[event.ID] = &memoryEntry{pending: Pending{Event: event}, seq: m.added}
+	return nil
+}
+
+func (m *memoryOutbox) Claim(_ context.Context, now time.Time, lease time.Duration, limit int) ([]Pending, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := make([]*memoryEntry, 0)
+	for _, entry := range m.entries {
+		if !entry.dueAt.After(now) {
+			due = append(due, entry)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].seq < due[j].seq
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]Pending, 0, len(due))
+	for _, entry := range due {
+		entry.dueAt = now.Add(lease)
+		claimed = append(claimed, entry.pending)
+	}
+	return claimed, nil
+}
+
+// sortPending orders events oldest first
+func sortPending(events []Pending) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt.Before(events[j].OccurredAt)
+	})
+}
+
+func (m *memoryOutbox) MarkSent(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, id)
+	return nil
+}
+
+func (m *memoryOutbox) MarkFailed(_ context.Context, id string, retryAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry, ok := m.entries[id]; ok {
+		entry.pending.Attempts++
+		entry.dueAt = retryAt
+	}
+	return nil
+}
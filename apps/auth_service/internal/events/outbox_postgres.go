@@ -0,0 +1,88 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresOutbox is an Outbox in the auth_event_outbox table, so events
+// survive restarts and are shared by every replica. The schema is created by
+// storage.MigratePostgres.
+type PostgresOutbox struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresOutbox creates an Outbox on pool
+func NewPostgresOutbox(pool *pgxpool.Pool) *PostgresOutbox {
+	return &PostgresOutbox{pool: pool}
+}
+
+// Add records an event for delivery
+func (p *PostgresOutbox) Add(ctx context.Context, event Event) error {
+	if _, err := p.pool.Exec(ctx, `
+		INSERT INTO auth_event_outbox (id, type, version, occurred_at, data, due_at)
+		VALUES ($1, $2, $3, $4, $5, $4)`,
+		event.ID, event.Type, event.Version, event.OccurredAt, []byte(event.Data),
+	); err != nil {
+		return fmt.Errorf("failed to add event: %w", err)
+	}
+	return nil
+}
+
+// Claim leases due events; rows locked by another replica's claim are skipped
+func (p *PostgresOutbox) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Pending, error) {
+	rows, err := p.pool.Query(ctx, `
+		UPDATE auth_event_outbox SET due_at = $2
+		WHERE id IN (
+			SELECT id FROM auth_event_outbox
+			WHERE due_at <= $1
+			ORDER BY occurred_at, id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, type, version, occurred_at, data, attempts`,
+		now, now.Add(lease), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim events: %w", err)
+	}
+
+	claimed, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Pending, error) {
+		var pending Pending
+		var data []byte
+		err := row.Scan(&pending.ID, &pending.Type, &pending.Version, &pending.OccurredAt, &data, &pending.Attempts)
+		pending.Source = Source
+		pending.Data = data
+		return pending, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim events: %w", err)
+	}
+
+	// UPDATE ... RETURNING does not keep the subquery's order
+	sortPending(claimed)
+	return claimed, nil
+}
+
+// MarkSent removes a delivered event
+func (p *PostgresOutbox) MarkSent(ctx context.Context, id string) error {
+	if _, err := p.pool.Exec(ctx, "DELETE FROM auth_event_outbox WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to mark event sent: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed records a failed delivery and when to try again
+func (p *PostgresOutbox) MarkFailed(ctx context.Context, id string, retryAt time.Time) error {
+	if _, err := p.pool.Exec(ctx,
+		"UPDATE auth_event_outbox SET attempts = attempts + 1, due_at = $2 WHERE id = $1",
+		id, retryAt,
+	); err != nil {
+		return fmt.Errorf("failed to mark event failed: %w", err)
+	}
+	return nil
+}
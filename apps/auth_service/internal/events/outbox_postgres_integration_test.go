@@ -0,0 +1,81 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	testpostgres "github.com/testcontainers/testcontainers-go/modules/postgres"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+func setupPostgresContainer(t *testing.T) *pgxpool.Pool {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	postgresContainer, err := testpostgres.Run(ctx,
+		"docker.io/postgres:16-alpine",
+		testpostgres.WithDatabase("auth"),
+		testpostgres.WithUsername("auth"),
+		testpostgres.WithPassword("auth"),
+		testpostgres.BasicWaitStrategies(),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		if err := postgresContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate container: %s", err)
+		}
+	})
+
+	connectionString, err := postgresContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	pool, err := pgxpool.New(ctx, connectionString)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	require.NoError(t, storage.MigratePostgres(ctx, pool))
+
+	return pool
+}
+
+func TestPostgresOutbox(t *testing.T) {
+	pool := setupPostgresContainer(t)
+	ctx := context.Background()
+	outbox := events.NewPostgresOutbox(pool)
+	now := time.Now()
+
+	first, err := events.NewEvent(events.UserRegistered{UserID: "user-1", Provider: "https://idp", Subject: "sub-1"}, now.Add(-2*time.Second))
+	require.NoError(t, err)
+	second, err := events.NewEvent(events.SessionRevoked{SessionRef: "hmac-sha256:1", Reason: events.RevokedLogout}, now.Add(-time.Second))
+	require.NoError(t, err)
+	require.NoError(t, outbox.Add(ctx, second))
+	require.NoError(t, outbox.Add(ctx, first))
+
+	claimed, err := outbox.Claim(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	assert.Equal(t, first.ID, claimed[0].ID, "oldest first")
+	assert.Equal(t, events.TypeUserRegistered, claimed[0].Type)
+	assert.JSONEq(t, string(first.Data), string(claimed[0].Data))
+
+	again, err := outbox.Claim(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, again, "claimed events are leased")
+
+	require.NoError(t, outbox.MarkSent(ctx, first.ID))
+	require.NoError(t, outbox.MarkFailed(ctx, second.ID, now.Add(time.Second)))
+
+	claimed, err = outbox.Claim(ctx, now.Add(time.Second), time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, second.ID, claimed[0].ID)
+	assert.Equal(t, 1, claimed[0].Attempts)
+}
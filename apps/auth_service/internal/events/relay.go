@@ -0,0 +1,168 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+// RelayConfig tunes how a Relay delivers events
+type RelayConfig struct {
+	// Exchange is the topic exchange events are published to
+	Exchange string
+	// Interval is how often the outbox is polled
+	Interval time.Duration
+	// BatchSize bounds the events delivered per poll
+	BatchSize int
+	// Backoff is the delay after the first failed delivery; it doubles with
+	// every further failure up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// PublishTimeout bounds one publish including its confirm
+	PublishTimeout time.Duration
+	// MaxAttempts is how often an event is published before it is dropped;
+	// 0 retries forever
+	MaxAttempts int
+}
+
+// Relay delivers outbox events to the broker, retrying failed deliveries
+// with exponential backoff. Events are delivered at least once, unless they
+// fail MaxAttempts times.
+type Relay struct {
+	outbox Outbox
+	broker Broker
+	cfg    RelayConfig
+	logger logger.Logger
+	now    func() time.Time
+}
+
+// RelayOption configures a Relay
+type RelayOption func(*Relay)
+
+// WithRelayClock replaces time.Now, letting tests control retry schedules
+func WithRelayClock(now func() time.Time) RelayOption {
+	return func(r *Relay) {
+		r.now = now
+	}
+}
+
+// NewRelay creates a Relay from outbox to broker
+func NewRelay(outbox Outbox, broker Broker, cfg RelayConfig, log logger.Logger, opts ...RelayOption) *Relay {
+	r := &Relay{
+		outbox: outbox,
+		broker: broker,
+		cfg:    cfg,
+		logger: log,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run delivers events every interval until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.drain(ctx)
+		}
+	}
+}
+
+// drain relays batches while full ones come back, so a backlog clears faster
+// than one batch per interval
+func (r *Relay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		if r.RelayOnce(ctx) < r.cfg.BatchSize {
+			return
+		}
+	}
+}
+
+// RelayOnce delivers one batch of due events and returns how many were claimed
+func (r *Relay) RelayOnce(ctx context.Context) int {
+	// A claimed event stays hidden from other relays until every publish of
+	// the batch had its chance
+	lease := time.Duration(r.cfg.BatchSize+1) * r.cfg.PublishTimeout
+	pending, err := r.outbox.Claim(ctx, r.now(), lease, r.cfg.BatchSize)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to claim outbox events")
+		return 0
+	}
+
+	for _, event := range pending {
+		if err := r.deliver(ctx, event.Event); err != nil {
+			if r.cfg.MaxAttempts > 0 && event.Attempts+1 >= r.cfg.MaxAttempts {
+				r.drop(ctx, event, err)
+				continue
+			}
+			retryAt := r.now().Add(r.backoff(event.Attempts))
+			r.logger.Warn().Err(err).
+				Str("event_id", event.ID).
+				Str("type", event.Type).
+				Int("attempts", event.Attempts+1).
+				Time("retry_at", retryAt).
+				Msg("Failed to publish event")
+			if err := r.outbox.MarkFailed(ctx, event.ID, retryAt); err != nil {
+				r.logger.Error().Err(err).Str("event_id", event.ID).Msg("Failed to record failed event delivery")
+			}
+			continue
+		}
+
+		if err := r.outbox.MarkSent(ctx, event.ID); err != nil {
+			// The event is delivered again once its lease ends
+			r.logger.Error().Err(err).Str("event_id", event.ID).Msg("Failed to remove delivered event from outbox")
+		}
+	}
+	return len(pending)
+}
+
+// drop removes an event that failed its last attempt, logging it in full so
+// it can still be replayed by hand
+func (r *Relay) drop(ctx context.Context, event Pending, err error) {
+	r.logger.Error().Err(err).
+		Str("event_id", event.ID).
+		Str("type", event.Type).
+		Int("attempts", event.Attempts+1).
+		RawJSON("data", event.Data).
+		Msg("Dropping event after its last failed delivery")
+	if err := r.outbox.MarkSent(ctx, event.ID); err != nil {
+		r.logger.Error().Err(err).Str("event_id", event.ID).Msg("Failed to remove dropped event from outbox")
+	}
+}
+
+func (r *Relay) deliver(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.PublishTimeout)
+	defer cancel()
+
+	return r.broker.Publish(ctx, r.cfg.Exchange, event.Type, Message{
+		ID:        event.ID,
+		Type:      event.Type,
+		Version:   event.Version,
+		Timestamp: event.OccurredAt,
+		Body:      body,
+	})
+}
+
+// backoff returns the delay before retrying an event that failed attempts+1 times
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.cfg.Backoff
+	for i := 0; i < attempts && delay < r.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, r.cfg.MaxBackoff)
+}
@@ -0,0 +1,163 @@
+package events_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/phuslu/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+var testRelayConfig = events.RelayConfig{
+	Exchange:       "auth.events",
+	Interval:       10 * time.Millisecond,
+	BatchSize:      10,
+	Backoff:        time.Second,
+	MaxBackoff:     4 * time.Second,
+	PublishTimeout: time.Second,
+}
+
+func newTestRelay(outbox events.Outbox, broker events.Broker, now *time.Time) *events.Relay {
+	return events.NewRelay(outbox, broker, testRelayConfig, logger.New(&bytes.Buffer{}, log.InfoLevel),
+		events.WithRelayClock(func() time.Time { return *now }))
+}
+
+func TestRelay_PublishesEvents(t *testing.T) {
+	ctx := context.Background()
+	outbox := events.NewMemoryOutbox()
+	broker := mocks.NewFakeBroker()
+	now := time.Now()
+
+	publisher := events.NewPublisher(outbox)
+	require.NoError(t, publisher.Publish(ctx, events.UserRegistered{UserID: "user-1", Provider: "https://idp", Subject: "sub-1"}))
+	require.NoError(t, publisher.Publish(ctx, events.SessionRevoked{SessionRef: "hmac-sha256:1", Reason: events.RevokedLogout}))
+
+	relay := newTestRelay(outbox, broker, &now)
+	assert.Equal(t, 2, relay.RelayOnce(ctx))
+
+	published := broker.Published()
+	require.Len(t, published, 2)
+	assert.Equal(t, "auth.events", published[0].Exchange)
+	assert.Equal(t, events.TypeUserRegistered, published[0].RoutingKey)
+	assert.Equal(t, events.TypeSessionRevoked, published[1].RoutingKey)
+	assert.Equal(t, 1, published[0].Message.Version)
+
+	var event events.Event
+	require.NoError(t, json.Unmarshal(published[0].Message.Body, &event))
+	assert.Equal(t, published[0].Message.ID, event.ID)
+	assert.Equal(t, events.TypeUserRegistered, event.Type)
+	assert.Equal(t, 1, event.Version)
+	assert.Equal(t, events.Source, event.Source)
+	assert.JSONEq(t, `{"user_id":"user-1","provider":"https://idp","subject":"sub-1"}`, string(event.Data))
+
+	// Delivered events leave the outbox
+	assert.Equal(t, 0, relay.RelayOnce(ctx))
+	assert.Len(t, broker.Published(), 2)
+}
+
+func TestRelay_RetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	outbox := events.NewMemoryOutbox()
+	broker := mocks.NewFakeBroker()
+	now := time.Now()
+	relay := newTestRelay(outbox, broker, &now)
+
+	require.NoError(t, events.NewPublisher(outbox).Publish(ctx, events.UserLoggedIn{Subject: "sub-1", SessionRef: "hmac-sha256:1", Method: events.LoginBrowser}))
+
+	broker.FailPublishes(3)
+	relay.RelayOnce(ctx)
+	assert.Equal(t, 1, broker.Attempts())
+
+	// Not retried before its backoff ends
+	assert.Equal(t, 0, relay.RelayOnce(ctx))
+
+	now = now.Add(time.Second)
+	relay.RelayOnce(ctx)
+	assert.Equal(t, 2, broker.Attempts())
+
+	// The backoff doubles with every failure
+	now = now.Add(time.Second)
+	assert.Equal(t, 0, relay.RelayOnce(ctx))
+	now = now.Add(time.Second)
+	relay.RelayOnce(ctx)
+	assert.Equal(t, 3, broker.Attempts())
+
+	now = now.Add(4 * time.Second)
+	relay.RelayOnce(ctx)
+	assert.Equal(t, 4, broker.Attempts())
+	require.Len(t, broker.Published(), 1, "delivered once the broker is back")
+	assert.Equal(t, events.TypeUserLoggedIn, broker.Published()[0].RoutingKey)
+}
+
+func TestRelay_DropsEventsAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	outbox := events.NewMemoryOutbox()
+	broker := mocks.NewFakeBroker()
+	now := time.Now()
+	cfg := testRelayConfig
+	cfg.MaxAttempts = 3
+	relay := events.NewRelay(outbox, broker, cfg, logger.New(&bytes.Buffer{}, log.InfoLevel),
+		events.WithRelayClock(func() time.Time { return now }))
+
+	require.NoError(t, events.NewPublisher(outbox).Publish(ctx, events.UserLoggedIn{Subject: "sub-1", Method: events.LoginBrowser}))
+
+	broker.FailPublishes(10)
+	for range 3 {
+		assert.Equal(t, 1, relay.RelayOnce(ctx))
+		now = now.Add(time.Minute)
+	}
+	assert.Equal(t, 3, broker.Attempts())
+
+	// The last failure dropped the event instead of scheduling a retry
+	assert.Equal(t, 0, relay.RelayOnce(ctx))
+	assert.Equal(t, 3, broker.Attempts())
+	assert.Empty(t, broker.Published())
+}
+
+func TestRelay_ClaimedEventsAreLeased(t *testing.T) {
+	ctx := context.Background()
+	outbox := events.NewMemoryOutbox()
+	now := time.Now()
+
+	require.NoError(t, events.NewPublisher(outbox).Publish(ctx, events.SessionRevoked{SessionRef: "hmac-sha256:1", Reason: events.RevokedAdmin}))
+
+	claimed, err := outbox.Claim(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	// A concurrent relay does not get the same event
+	again, err := outbox.Claim(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, again)
+
+	// The lease ends if the claiming relay never reported back
+	again, err = outbox.Claim(ctx, now.Add(time.Minute), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Len(t, again, 1)
+}
+
+func TestRelay_Run(t *testing.T) {
+	outbox := events.NewMemoryOutbox()
+	broker := mocks.NewFakeBroker()
+	relay := events.NewRelay(outbox, broker, testRelayConfig, logger.New(&bytes.Buffer{}, log.InfoLevel))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go relay.Run(ctx)
+
+	publisher := events.NewPublisher(outbox)
+	for range 25 {
+		require.NoError(t, publisher.Publish(ctx, events.UserLoggedIn{Subject: "sub-1", Method: events.LoginDevice}))
+	}
+
+	assert.Eventually(t, func() bool { return len(broker.Published()) == 25 }, 2*time.Second, 10*time.Millisecond,
+		"a backlog larger than one batch drains")
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phuslu/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/audit"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+func newTestAuditLog(t *testing.T) *audit.FileSink {
+	t.Helper()
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sink.Close() })
+	return sink
+}
+
+// auditEntries returns every entry in the log, oldest first
+func auditEntries(t *testing.T, sink *audit.FileSink) []audit.Entry {
+	t.Helper()
+	entries, err := sink.Query(context.Background(), audit.Filter{Limit: maxAuditLimit})
+	require.NoError(t, err)
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+func TestAuthHandler_AuditsLoginAndLogout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	auditLog := newTestAuditLog(t)
+	handler.store = storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.auditLog = auditLog
+	handler.appConfig.SessionHashKey = testSessionHashKey
+	router := userInfoRouter(handler)
+	router.POST("/auth/logout", handler.Logout)
+
+	// A forged callback fails before the user is known
+	req := httptest.NewRequest("GET", "/auth/callback?code=mock-auth-code&state=forged", nil)
+	req.Header.Set("User-Agent", "curl/8.0")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	sessionID := loginSession(t, router)
+
+	req = httptest.NewRequest("POST", "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: sessionID})
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := auditEntries(t, auditLog)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, audit.ActionLogin, entries[0].Action)
+	assert.Equal(t, audit.OutcomeFailure, entries[0].Outcome)
+	assert.Equal(t, "invalid_state", entries[0].Reason)
+	assert.Empty(t, entries[0].Actor)
+	assert.Equal(t, "curl/8.0", entries[0].UserAgent)
+	assert.NotEmpty(t, entries[0].IP)
+
+	assert.Equal(t, audit.Record{
+		Action:    audit.ActionLogin,
+		Actor:     "test-user",
+		Subject:   "test-user",
+		SessionID: sessionRef(testSessionHashKey, sessionID),
+		IP:        entries[1].IP,
+		Outcome:   audit.OutcomeSuccess,
+	}, entries[1].Record)
+
+	assert.Equal(t, audit.ActionLogout, entries[2].Action)
+	assert.Equal(t, "test-user", entries[2].Subject)
+	assert.Equal(t, entries[1].SessionID, entries[2].SessionID, "entries of one session share its reference")
+	assert.NotContains(t, entries[2].SessionID, sessionID, "the audit log never holds session IDs")
+
+	result, err := auditLog.Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, result.Valid)
+}
+
+func TestAuthHandler_AuditsRefreshFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	auditLog := newTestAuditLog(t)
+	handler.store = storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.auditLog = auditLog
+
+	router := gin.New()
+	router.GET("/auth/login", handler.Login)
+	router.GET("/auth/callback", handler.Callback)
+	router.POST("/auth/refresh", handler.Refresh)
+
+	refresh := func(cookies ...*http.Cookie) {
+		req := httptest.NewRequest("POST", "/auth/refresh", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	refresh()
+	refresh(&http.Cookie{Name: cookieSessionID, Value: "unknown-session"})
+	sessionCookie, _ := rememberLogin(t, router, "Mozilla/5.0 (iPhone)")
+	refresh(sessionCookie)
+
+	failures, err := auditLog.Query(context.Background(), audit.Filter{Action: audit.ActionRefresh})
+	require.NoError(t, err)
+	require.Len(t, failures, 3)
+	assert.Equal(t, "device_mismatch", failures[0].Reason)
+	assert.Equal(t, "test-user", failures[0].Subject)
+	assert.Empty(t, failures[0].SessionID, "without a key no session reference is recorded")
+	assert.Equal(t, "invalid_session", failures[1].Reason)
+	assert.Empty(t, failures[1].SessionID)
+	assert.Equal(t, "missing_session", failures[2].Reason)
+	for _, entry := range failures {
+		assert.Equal(t, audit.OutcomeFailure, entry.Outcome)
+	}
+}
+
+func TestSessionAdminHandler_AuditsRevocations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auditLog := newTestAuditLog(t)
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler := NewSessionAdminHandler(store, nil, logger.New(&bytes.Buffer{}, log.InfoLevel),
+		WithAdminAuditSink(auditLog), WithAdminSessionHashKey(testSessionHashKey))
+
+	router := gin.New()
+	router.DELETE("/admin/sessions/:session_id", handler.RevokeSession)
+	router.DELETE("/admin/users/:user_id/sessions", handler.RevokeUserSessions)
+
+	sessionID := createTestSession(t, store, "user-1", "refresh-1")
+	for _, path := range []string{"/admin/sessions/" + sessionID, "/admin/users/user-2/sessions"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", path, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	entries := auditEntries(t, auditLog)
+	require.Len(t, entries, 2)
+	assert.Equal(t, audit.ActionSessionRevoke, entries[0].Action)
+	assert.Equal(t, audit.ActorAdmin, entries[0].Actor)
+	assert.Equal(t, "user-1", entries[0].Subject)
+	assert.Equal(t, sessionRef(testSessionHashKey, sessionID), entries[0].SessionID)
+	assert.Equal(t, audit.ActionUserSessionsRevoke, entries[1].Action)
+	assert.Equal(t, "user-2", entries[1].Subject)
+	assert.Equal(t, audit.OutcomeSuccess, entries[1].Outcome)
+}
+
+func TestAuditAdminHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auditLog := newTestAuditLog(t)
+	ctx := context.Background()
+	for _, record := range []audit.Record{
+		{Action: audit.ActionLogin, Actor: "user-1", Subject: "user-1", Outcome: audit.OutcomeSuccess},
+		{Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Reason: "invalid_state"},
+		{Action: audit.ActionSessionRevoke, Actor: audit.ActorAdmin, Subject: "user-1", Outcome: audit.OutcomeSuccess},
+	} {
+		_, err := auditLog.Append(ctx, record)
+		require.NoError(t, err)
+	}
+
+	handler := NewAuditAdminHandler(auditLog, logger.New(&bytes.Buffer{}, log.InfoLevel))
+	router := gin.New()
+	router.GET("/admin/audit", handler.Query)
+	router.GET("/admin/audit/verify", handler.Verify)
+
+	get := func(path string) (int, []byte) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.Bytes()
+	}
+
+	code, body := get("/admin/audit?subject=user-1")
+	require.Equal(t, http.StatusOK, code)
+	var resp auditQueryResponse
+	require.NoError(t, json.Unmarshal(body, &resp))
+	require.Len(t, resp.Entries, 2)
+	assert.Equal(t, audit.ActionSessionRevoke, resp.Entries[0].Action)
+	assert.NotEmpty(t, resp.Entries[0].Hash)
+
+	code, body = get("/admin/audit?outcome=failure&limit=1")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal(body, &resp))
+	require.Len(t, resp.Entries, 1)
+	assert.Equal(t, "invalid_state", resp.Entries[0].Reason)
+
+	code, body = get("/admin/audit?actor=nobody")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"entries":[]}`, string(body))
+
+	code, body = get("/admin/audit/verify")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"valid":true,"entries":3}`, string(body))
+
+	for _, query := range []string{"since=yesterday", "until=2026-13-01", "limit=0", "limit=1001"} {
+		code, _ = get("/admin/audit?" + query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
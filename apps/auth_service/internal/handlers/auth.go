@@ -2,45 +2,153 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
 
+	"github.com/carlosealves2/short-stream/authservice/internal/audit"
 	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
 	"github.com/carlosealves2/short-stream/authservice/internal/oidc"
 	"github.com/carlosealves2/short-stream/authservice/internal/storage"
 	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
 )
 
+// EventPublisher records auth events for other services
+type EventPublisher interface {
+	Publish(ctx context.Context, payload events.Payload) error
+}
+
 // AuthHandler handles authentication-related HTTP requests
 type AuthHandler struct {
 	oidcClient *oidc.Client
 	store      storage.Store
 	appConfig  *config.AppConfig
 	logger     logger.Logger
+	// revoker, when set, revokes refresh tokens at the provider as sessions end
+	revoker TokenRevoker
+	// postLogoutRedirectURL is where the provider returns after logout; the
+	// logout state is only sent along with it
+	postLogoutRedirectURL string
+	// native holds the public clients allowed to use the token-response mode
+	native *config.NativeConfig
+	// users, when set, keeps a local user record linked to each login
+	users storage.UserStore
+	// events, when set, receives logins, registrations and ended sessions
+	events EventPublisher
+	// auditLog, when set, records logins, failed refreshes and logouts
+	auditLog AuditSink
+	// impersonation, when set, lets its admins act as other users
+	impersonation *config.ImpersonationConfig
+}
+
+// AuthHandlerOption customizes an AuthHandler
+type AuthHandlerOption func(*AuthHandler)
+
+// WithTokenRevoker revokes a session's refresh token at the provider whenever
+// the handler ends the session
+func WithTokenRevoker(revoker TokenRevoker) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.revoker = revoker
+	}
+}
+
+// WithPostLogoutRedirectURL sets the URL, registered at the provider, that it
+// redirects to after RP-Initiated Logout (normally /auth/logout/callback)
+func WithPostLogoutRedirectURL(postLogoutRedirectURL string) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.postLogoutRedirectURL = postLogoutRedirectURL
+	}
+}
+
+// WithNativeClients enables the token-response mode (NativeAuthorize and
+// NativeToken) for the registered native clients
+func WithNativeClients(native *config.NativeConfig) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.native = native
+	}
+}
+
+// WithUserStore links every login to a local user record, whose ID is kept in
+// the session and returned by UserInfo
+func WithUserStore(users storage.UserStore) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.users = users
+	}
+}
+
+// WithEventPublisher publishes auth events (logins, registrations, revoked
+// sessions and suspicious refreshes) through publisher
+func WithEventPublisher(publisher EventPublisher) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.events = publisher
+	}
+}
+
+// WithAuditSink records security-relevant actions (login successes and
+// failures, failed refreshes, logouts) in auditLog
+func WithAuditSink(auditLog AuditSink) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.auditLog = auditLog
+	}
+}
+
+// WithImpersonation lets the admins of cfg impersonate users. It needs a user
+// store, to find the user to act as.
+func WithImpersonation(cfg *config.ImpersonationConfig) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.impersonation = cfg
+	}
 }
 
 // NewAuthHandler creates a new AuthHandler with the given dependencies
-func NewAuthHandler(oidcClient *oidc.Client, store storage.Store, appConfig *config.AppConfig, log logger.Logger) *AuthHandler {
-	return &AuthHandler{
+func NewAuthHandler(oidcClient *oidc.Client, store storage.Store, appConfig *config.AppConfig, log logger.Logger, opts ...AuthHandlerOption) *AuthHandler {
+	h := &AuthHandler{
 		oidcClient: oidcClient,
 		store:      store,
 		appConfig:  appConfig,
 		logger:     log,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
-// Login initiates the OIDC authentication flow
+// Login initiates the OIDC authentication flow. With remember=true, and
+// remember-me enabled, the session created on callback is long-lived and bound
+// to the device. acr_values and max_age are passed on to the provider for
+// step-up authentication.
 func (h *AuthHandler) Login(c *gin.Context) {
-	state, err := h.store.CreateState(c.Request.Context())
+	remember, _ := strconv.ParseBool(c.Query("remember"))
+	data := storage.StateData{Remember: remember && h.appConfig.RememberMeEnabled()}
+
+	authOpts, err := loginRequirements(c, &data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	state, err := h.store.CreateState(c.Request.Context(), data)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to create state")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create state"})
 		return
 	}
 
-	authURL := h.oidcClient.GetAuthURL(state)
+	authURL, err := h.oidcClient.GetAuthURL(state, authOpts...)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to build authorization URL")
+		h.providerUnavailable(c)
+		return
+	}
 	h.logger.Info().Str("auth_url", authURL).Msg("Redirecting to OIDC provider")
 	c.Redirect(http.StatusFound, authURL)
 }
@@ -52,13 +160,23 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 
 	if code == "" || state == "" {
 		h.logger.Warn().Msg("Missing code or state in callback")
+		h.auditLoginFailed(c, "", "missing_code_or_state")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "missing code or state"})
 		return
 	}
 
 	// Validate state
-	if err := h.store.ValidateState(c.Request.Context(), state); err != nil {
+	stateData, err := h.store.ValidateState(c.Request.Context(), state)
+	switch {
+	case err != nil:
+	case stateData.Logout:
+		err = errors.New("logout state used for login")
+	case stateData.Native != nil && stateData.Native.SessionID != "":
+		err = errors.New("native authorization code used as state")
+	}
+	if err != nil {
 		h.logger.Error().Err(err).Msg("Invalid state")
+		h.auditLoginFailed(c, "", "invalid_state")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid state"})
 		return
 	}
@@ -67,28 +185,98 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 	token, err := h.oidcClient.ExchangeCode(c.Request.Context(), code)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to exchange code for tokens")
+		if errors.Is(err, oidc.ErrProviderUnavailable) {
+			h.auditLoginFailed(c, "", "provider_unavailable")
+			h.providerUnavailable(c)
+			return
+		}
+		h.auditLoginFailed(c, "", "code_exchange_failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to exchange code"})
+		return
+	}
+
+	// Extract tokens
+	accessToken := token.AccessToken
+	idToken, _ := token.Extra("id_token").(string)
+
+	// Identify the user so sessions can be looked up per subject
+	verified, err := h.oidcClient.VerifyIDToken(c.Request.Context(), idToken)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to verify ID token")
+		h.auditLoginFailed(c, "", "invalid_id_token")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to exchange code"})
 		return
 	}
 
+	authContext, err := h.sessionAuthContext(verified, stateData)
+	switch {
+	case errors.Is(err, errMaxAgeExceeded):
+		h.logger.Warn().Str("user_id", verified.Subject).Msg("Provider did not reauthenticate within max_age")
+		h.auditLoginFailed(c, verified.Subject, "max_age_exceeded")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "reauthentication required"})
+		return
+	case err != nil:
+		h.logger.Error().Err(err).Msg("Failed to read auth context")
+		h.auditLoginFailed(c, verified.Subject, "invalid_id_token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to exchange code"})
+		return
+	}
+
+	internalUserID, err := h.linkUser(c, verified)
+	if err != nil {
+		h.auditLoginFailed(c, verified.Subject, "user_link_failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+		return
+	}
+
 	// Create session with refresh token
-	sessionID, err := h.store.CreateSession(c.Request.Context(), token.RefreshToken)
+	newSession := storage.NewSession{
+		UserID:         verified.Subject,
+		InternalUserID: internalUserID,
+		RefreshToken:   token.RefreshToken,
+		Auth:           authContext,
+	}
+	if stateData.Native != nil {
+		// Only the client the login was started for may refresh the session
+		newSession.ClientID = stateData.Native.ClientID
+	}
+	sessionMaxAge := h.sessionCookieMaxAge()
+	if stateData.Remember && h.appConfig.RememberMeEnabled() {
+		newSession.Lifetime = storage.Lifetime{
+			Idle:     h.appConfig.SessionRememberIdleTimeout,
+			Absolute: h.appConfig.SessionRememberAbsoluteTimeout,
+		}
+		newSession.DeviceHash = deviceFingerprint(h.ensureDeviceID(c), c.Request.UserAgent())
+		sessionMaxAge = min(newSession.Lifetime.Idle, newSession.Lifetime.Absolute)
+	}
+
+	sessionID, err := h.store.CreateSession(c.Request.Context(), newSession)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to create session")
+		h.auditLoginFailed(c, verified.Subject, "session_create_failed")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
 		return
 	}
 
-	// Extract tokens
-	accessToken := token.AccessToken
-	idToken, _ := token.Extra("id_token").(string)
+	method := events.LoginBrowser
+	if stateData.Native != nil {
+		method = events.LoginNative
+	}
+	h.publishLogin(c, newSession, sessionID, method)
+	h.auditLogin(c, verified.Subject, sessionID)
+
+	// Native apps get the tokens from NativeToken instead of cookies
+	if stateData.Native != nil {
+		h.completeNativeLogin(c, *stateData.Native, sessionID, token, idToken)
+		return
+	}
 
 	// Set cookies
 	h.setCookie(c, "access_token", accessToken, int(time.Until(token.Expiry).Seconds()))
 	h.setCookie(c, "id_token", idToken, int(time.Until(token.Expiry).Seconds()))
-	h.setCookie(c, "session_id", sessionID, h.appConfig.SessionMaxAge)
+	h.setCookie(c, "session_id", sessionID, int(sessionMaxAge.Seconds()))
 
-	h.logger.Info().Str("session_id", sessionID).Msg("User authenticated successfully")
+	h.logger.Info().Str("session_id", sessionID).Bool("remember", newSession.DeviceHash != "").Msg("User authenticated successfully")
 
 	// Redirect to frontend
 	c.Redirect(http.StatusFound, h.appConfig.FrontendURL)
@@ -99,33 +287,96 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 	sessionID, err := c.Cookie("session_id")
 	if err != nil {
 		h.logger.Warn().Msg("Missing session cookie in refresh request")
+		h.auditRefreshFailed(c, storage.Session{}, "missing_session")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing session"})
 		return
 	}
 
 	// Get refresh token from storage
-	refreshToken, err := h.store.GetRefreshToken(c.Request.Context(), sessionID)
+	stored, err := h.store.GetSession(c.Request.Context(), sessionID)
 	if err != nil {
 		h.logger.Error().Err(err).Str("session_id", sessionID).Msg("Invalid or expired session")
+		h.auditRefreshFailed(c, storage.Session{ID: sessionID}, "invalid_session")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
 		return
 	}
+	refreshToken := stored.RefreshToken
+
+	// Native sessions are refreshed by their client through NativeToken; one
+	// presented as a cookie is treated as leaked
+	if stored.ClientID != "" {
+		h.logger.Warn().Str("session_id", sessionID).Str("client_id", stored.ClientID).Msg("Cookie refresh of a native session, ending session")
+		h.auditRefreshFailed(c, stored, events.RevokedClientMismatch)
+		h.publishSuspiciousRefresh(c, stored, events.RevokedClientMismatch)
+		h.endSession(c, stored, events.RevokedClientMismatch)
+		h.clearCookie(c, "access_token")
+		h.clearCookie(c, "id_token")
+		h.clearCookie(c, "session_id")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "reauthentication required"})
+		return
+	}
+
+	// A remember-me session only refreshes from the device it was created on;
+	// anywhere else the session is ended and the user must log in again
+	if !sameDevice(c, stored) {
+		h.logger.Warn().Str("session_id", sessionID).Msg("Refresh from a different device, ending session")
+		h.auditRefreshFailed(c, stored, events.RevokedDeviceMismatch)
+		h.publishSuspiciousRefresh(c, stored, events.RevokedDeviceMismatch)
+		h.endSession(c, stored, events.RevokedDeviceMismatch)
+		h.clearCookie(c, "access_token")
+		h.clearCookie(c, "id_token")
+		h.clearCookie(c, "session_id")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "reauthentication required"})
+		return
+	}
 
-	// Refresh the token
-	newToken, err := h.oidcClient.RefreshToken(c.Request.Context(), refreshToken)
+	// Refresh the token; impersonation sessions get theirs through the
+	// support engineer's session
+	var newToken *oauth2.Token
+	if stored.Impersonator != "" {
+		newToken, err = h.refreshImpersonation(c, stored)
+	} else {
+		newToken, err = h.oidcClient.RefreshToken(c.Request.Context(), refreshToken)
+	}
+	if errors.Is(err, errImpersonatorLoggedOut) || errors.Is(err, errImpersonationRevoked) {
+		reason := "impersonator_logged_out"
+		if errors.Is(err, errImpersonationRevoked) {
+			reason = "impersonation_revoked"
+		}
+		h.logger.Warn().Err(err).Str("session_id", sessionID).Msg("Impersonation no longer valid, ending it")
+		h.auditRefreshFailed(c, stored, reason)
+		h.endSession(c, stored, events.RevokedImpersonationEnded)
+		h.clearCookie(c, "access_token")
+		h.clearCookie(c, "id_token")
+		h.clearCookie(c, "session_id")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "impersonation ended"})
+		return
+	}
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to refresh token")
+		if errors.Is(err, oidc.ErrProviderUnavailable) {
+			// The session stays valid; the client should retry later
+			h.auditRefreshFailed(c, stored, "provider_unavailable")
+			h.providerUnavailable(c)
+			return
+		}
+		h.auditRefreshFailed(c, stored, "provider_refresh_failed")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
 		return
 	}
 
-	// Update session with new refresh token if it changed
+	// Extend the session (sliding idle expiry, capped by the absolute lifetime)
+	// and store the new refresh token if it rotated
+	rotated := ""
 	if newToken.RefreshToken != "" && newToken.RefreshToken != refreshToken {
-		if err := h.store.UpdateSession(c.Request.Context(), sessionID, newToken.RefreshToken); err != nil {
-			h.logger.Error().Err(err).Msg("Failed to update session with new refresh token")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update session"})
-			return
-		}
+		rotated = newToken.RefreshToken
+	}
+	session, err := h.store.UpdateSession(c.Request.Context(), sessionID, rotated)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to update session")
+		h.auditRefreshFailed(c, stored, "session_update_failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update session"})
+		return
 	}
 
 	// Extract tokens
@@ -138,12 +389,23 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 		h.setCookie(c, "id_token", idToken, int(time.Until(newToken.Expiry).Seconds()))
 	}
 
+	sessionExpiresIn := int(time.Until(session.ExpiresAt).Seconds())
+	h.setCookie(c, "session_id", sessionID, sessionExpiresIn)
+
 	h.logger.Info().Str("session_id", sessionID).Msg("Token refreshed successfully")
-	c.JSON(http.StatusOK, gin.H{"message": "token refreshed"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":                     "token refreshed",
+		"session_expires_in":          sessionExpiresIn,
+		"session_absolute_expires_in": int(time.Until(session.AbsoluteExpiresAt).Seconds()),
+	})
 }
 
-// Logout logs out the user from the application and OIDC provider
+// Logout logs out the user from the application and OIDC provider. The
+// browser ends up at ?redirect_uri= when it is on the allow-list, otherwise at
+// the frontend.
 func (h *AuthHandler) Logout(c *gin.Context) {
+	target := h.logoutTarget(c.Query("redirect_uri"))
+
 	// Get ID token for OIDC logout
 	idToken, err := c.Cookie("id_token")
 	if err != nil {
@@ -154,11 +416,24 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 	// Delete session from storage
 	sessionID, err := c.Cookie("session_id")
 	if err == nil {
-		if err := h.store.DeleteSession(c.Request.Context(), sessionID); err != nil {
-			h.logger.Error().Err(err).Str("session_id", sessionID).Msg("Failed to delete session")
-		} else {
-			h.logger.Info().Str("session_id", sessionID).Msg("Session deleted successfully")
+		session := storage.Session{ID: sessionID}
+		if h.revoker != nil || h.events != nil || h.auditLog != nil {
+			// Load the refresh token so it can be revoked, and the user for
+			// the event and audit log; an unknown session still gets a delete
+			// attempt
+			if stored, err := h.store.GetSession(c.Request.Context(), sessionID); err == nil {
+				session = stored
+			}
 		}
+		h.endSession(c, session, events.RevokedLogout)
+		h.recordAudit(c, audit.Record{
+			Action:       audit.ActionLogout,
+			Actor:        sessionActor(session),
+			Subject:      session.UserID,
+			SessionID:    session.ID,
+			Outcome:      audit.OutcomeSuccess,
+			Impersonator: session.Impersonator,
+		})
 	}
 
 	// Clear cookies
@@ -166,24 +441,133 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 	h.clearCookie(c, "id_token")
 	h.clearCookie(c, "session_id")
 
-	// If we have an ID token, redirect to OIDC provider logout
-	// This performs RP-Initiated Logout (logs out from Keycloak)
+	// If we have an ID token, perform RP-Initiated Logout at the provider. It
+	// returns to /auth/logout/callback with the state, which carries the target.
 	if idToken != "" {
-		logoutURL := h.oidcClient.GetEndSessionURL(idToken, h.appConfig.FrontendURL)
-		if logoutURL != "" {
+		if logoutURL := h.endSessionURL(c, idToken, target); logoutURL != "" {
 			h.logger.Info().Str("logout_url", logoutURL).Msg("Redirecting to OIDC provider logout")
 			c.Redirect(http.StatusFound, logoutURL)
 			return
 		}
 	}
 
-	// Fallback: if no ID token or OIDC logout URL, just redirect to frontend
+	// Fallback: without an ID token or end_session_endpoint, only log out locally
 	h.logger.Info().Msg("Performing local logout only, redirecting to frontend")
-	c.Redirect(http.StatusFound, h.appConfig.FrontendURL)
+	c.Redirect(http.StatusFound, target)
+}
+
+// LogoutCallback is where the provider returns after RP-Initiated Logout. It
+// consumes the logout state and redirects to the target chosen at logout.
+func (h *AuthHandler) LogoutCallback(c *gin.Context) {
+	data, err := h.store.ValidateState(c.Request.Context(), c.Query("state"))
+	if err != nil || !data.Logout {
+		h.logger.Warn().Msg("Invalid state in logout callback")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid state"})
+		return
+	}
+
+	target := data.RedirectTo
+	if target == "" {
+		target = h.appConfig.FrontendURL
+	}
+
+	h.logger.Info().Msg("Provider logout completed, redirecting to frontend")
+	c.Redirect(http.StatusFound, target)
 }
 
 // Helper methods
 
+// endSession deletes a session and, when a revoker is configured, revokes its
+// refresh token at the provider so it stops working even if the browser never
+// reaches the provider's logout page. reason is reported in the
+// session.revoked event.
+func (h *AuthHandler) endSession(c *gin.Context, session storage.Session, reason string) {
+	if err := h.store.DeleteSession(c.Request.Context(), session.ID); err != nil {
+		h.logger.Error().Err(err).Str("session_id", session.ID).Msg("Failed to delete session")
+	} else {
+		h.logger.Info().Str("session_id", session.ID).Msg("Session deleted successfully")
+		// Sessions that were never loaded are unknown; there is nothing to report
+		if session.UserID != "" {
+			h.publish(c, events.SessionRevoked{
+				UserID:     session.InternalUserID,
+				Subject:    session.UserID,
+				SessionRef: h.sessionRef(session.ID),
+				Reason:     reason,
+			})
+		}
+	}
+
+	if h.revoker == nil || session.RefreshToken == "" {
+		return
+	}
+	if err := h.revoker.RevokeToken(c.Request.Context(), session.RefreshToken, "refresh_token"); err != nil {
+		h.logger.Warn().Err(err).Str("session_id", session.ID).Msg("Failed to revoke refresh token at provider")
+	}
+}
+
+// providerUnavailable responds that the identity provider cannot be reached
+// (not discovered yet, or its circuit breaker is open)
+func (h *AuthHandler) providerUnavailable(c *gin.Context) {
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "identity provider unavailable"})
+}
+
+// endSessionURL creates a logout state carrying target and returns the
+// provider logout URL, or "" when the provider has no end_session_endpoint
+func (h *AuthHandler) endSessionURL(c *gin.Context, idToken, target string) string {
+	if !h.oidcClient.SupportsEndSession() {
+		return ""
+	}
+	if h.postLogoutRedirectURL == "" {
+		// The provider keeps the browser on its own logged-out page
+		return h.oidcClient.GetEndSessionURL(idToken, "", "")
+	}
+
+	state, err := h.store.CreateState(c.Request.Context(), storage.StateData{Logout: true, RedirectTo: target})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to create logout state")
+		return ""
+	}
+	return h.oidcClient.GetEndSessionURL(idToken, h.postLogoutRedirectURL, state)
+}
+
+// logoutTarget returns the requested post-logout redirect when it matches the
+// frontend or an allow-list entry (same origin, path under the entry's path),
+// and the frontend otherwise
+func (h *AuthHandler) logoutTarget(requested string) string {
+	if requested == "" {
+		return h.appConfig.FrontendURL
+	}
+
+	target, err := url.Parse(requested)
+	if err == nil && target.User == nil {
+		for _, entry := range append([]string{h.appConfig.FrontendURL}, h.appConfig.LogoutRedirectAllowList...) {
+			allowed, err := url.Parse(entry)
+			if err != nil {
+				continue
+			}
+			if target.Scheme == allowed.Scheme && target.Host == allowed.Host &&
+				pathUnder(target.Path, allowed.Path) {
+				return target.String()
+			}
+		}
+	}
+
+	h.logger.Warn().Str("redirect_uri", requested).Msg("Logout redirect not allowed, using frontend")
+	return h.appConfig.FrontendURL
+}
+
+// pathUnder reports whether path equals prefix or lies below it
+func pathUnder(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// sessionCookieMaxAge is the lifetime of a new session: its idle timeout,
+// unless the absolute lifetime is shorter
+func (h *AuthHandler) sessionCookieMaxAge() time.Duration {
+	return min(h.appConfig.SessionIdleTimeout, h.appConfig.SessionAbsoluteTimeout)
+}
+
 func (h *AuthHandler) setCookie(c *gin.Context, name, value string, maxAge int) {
 	c.SetCookie(
 		name,
@@ -6,7 +6,10 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/phuslu/log"
@@ -16,6 +19,7 @@ import (
 
 	"github.com/carlosealves2/short-stream/authservice/internal/config"
 	"github.com/carlosealves2/short-stream/authservice/internal/oidc"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
 	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
 	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
 )
@@ -27,10 +31,19 @@ const (
 )
 
 func setupTestHandler(t *testing.T) (*AuthHandler, *mocks.MockStore, *mocks.MockOIDCServer) {
+	handler, mockStore, mockOIDCServer, _ := setupTestHandlerWithLogs(t, log.InfoLevel)
+	return handler, mockStore, mockOIDCServer
+}
+
+// setupTestHandlerWithLogs also returns the buffer the handler and OIDC client log to
+func setupTestHandlerWithLogs(t *testing.T, level log.Level) (*AuthHandler, *mocks.MockStore, *mocks.MockOIDCServer, *bytes.Buffer) {
 	mockStore := &mocks.MockStore{}
 	mockOIDCServer, err := mocks.NewMockOIDCServer()
 	require.NoError(t, err)
 
+	buf := &bytes.Buffer{}
+	testLogger := logger.New(buf, level)
+
 	cfg := &config.OIDCConfig{
 		ProviderURL:  mockOIDCServer.Issuer,
 		ClientID:     mockOIDCServer.ClientID,
@@ -40,7 +53,7 @@ func setupTestHandler(t *testing.T) (*AuthHandler, *mocks.MockStore, *mocks.Mock
 	}
 
 	ctx := context.Background()
-	oidcClient, err := oidc.NewClient(ctx, cfg)
+	oidcClient, err := oidc.NewClient(ctx, cfg, oidc.WithLogger(testLogger.Component("oidc")))
 	require.NoError(t, err)
 
 	appConfig := &config.AppConfig{
@@ -49,14 +62,37 @@ func setupTestHandler(t *testing.T) (*AuthHandler, *mocks.MockStore, *mocks.Mock
 		CookieSecure:   false,
 		CookieHTTPOnly: true,
 		SessionMaxAge:  3600,
-	}
 
-	buf := &bytes.Buffer{}
-	testLogger := logger.New(buf, log.InfoLevel)
+		SessionIdleTimeout:     time.Hour,
+		SessionAbsoluteTimeout: 24 * time.Hour,
+
+		SessionRememberIdleTimeout:     7 * 24 * time.Hour,
+		SessionRememberAbsoluteTimeout: 30 * 24 * time.Hour,
+	}
 
 	handler := NewAuthHandler(oidcClient, mockStore, appConfig, testLogger)
 
-	return handler, mockStore, mockOIDCServer
+	return handler, mockStore, mockOIDCServer, buf
+}
+
+// testSession returns an active session as the store reports it after a refresh
+func testSession(id string) storage.Session {
+	now := time.Now()
+	return storage.Session{
+		ID:                id,
+		UserID:            "test-user",
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(time.Hour),
+		AbsoluteExpiresAt: now.Add(24 * time.Hour),
+		IdleTimeout:       time.Hour,
+	}
+}
+
+// isSessionFor matches an unbound session for userID with the default lifetime
+func isSessionFor(userID string) func(storage.NewSession) bool {
+	return func(s storage.NewSession) bool {
+		return s.UserID == userID && s.DeviceHash == "" && s.Lifetime == (storage.Lifetime{})
+	}
 }
 
 func TestNewAuthHandler(t *testing.T) {
@@ -75,7 +111,7 @@ func TestAuthHandler_Login_Success(t *testing.T) {
 	handler, mockStore, mockServer := setupTestHandler(t)
 	defer mockServer.Close()
 
-	mockStore.On("CreateState", mock.Anything).Return("test-state", nil)
+	mockStore.On("CreateState", mock.Anything, storage.StateData{}).Return("test-state", nil)
 
 	router := gin.New()
 	router.GET("/auth/login", handler.Login)
@@ -99,7 +135,7 @@ func TestAuthHandler_Login_CreateStateError(t *testing.T) {
 	handler, mockStore, mockServer := setupTestHandler(t)
 	defer mockServer.Close()
 
-	mockStore.On("CreateState", mock.Anything).Return("", errors.New("storage error"))
+	mockStore.On("CreateState", mock.Anything, storage.StateData{}).Return("", errors.New("storage error"))
 
 	router := gin.New()
 	router.GET("/auth/login", handler.Login)
@@ -120,8 +156,8 @@ func TestAuthHandler_Callback_Success(t *testing.T) {
 	handler, mockStore, mockServer := setupTestHandler(t)
 	defer mockServer.Close()
 
-	mockStore.On("ValidateState", mock.Anything, "test-state").Return(nil)
-	mockStore.On("CreateSession", mock.Anything, mock.AnythingOfType("string")).Return("session-123", nil)
+	mockStore.On("ValidateState", mock.Anything, "test-state").Return(storage.StateData{}, nil)
+	mockStore.On("CreateSession", mock.Anything, mock.MatchedBy(isSessionFor("test-user"))).Return("session-123", nil)
 
 	router := gin.New()
 	router.GET("/auth/callback", handler.Callback)
@@ -190,7 +226,7 @@ func TestAuthHandler_Callback_InvalidState(t *testing.T) {
 	handler, mockStore, mockServer := setupTestHandler(t)
 	defer mockServer.Close()
 
-	mockStore.On("ValidateState", mock.Anything, "invalid-state").Return(errors.New("invalid state"))
+	mockStore.On("ValidateState", mock.Anything, "invalid-state").Return(storage.StateData{}, errors.New("invalid state"))
 
 	router := gin.New()
 	router.GET("/auth/callback", handler.Callback)
@@ -211,8 +247,8 @@ func TestAuthHandler_Callback_CreateSessionError(t *testing.T) {
 	handler, mockStore, mockServer := setupTestHandler(t)
 	defer mockServer.Close()
 
-	mockStore.On("ValidateState", mock.Anything, "test-state").Return(nil)
-	mockStore.On("CreateSession", mock.Anything, mock.AnythingOfType("string")).Return("", errors.New("storage error"))
+	mockStore.On("ValidateState", mock.Anything, "test-state").Return(storage.StateData{}, nil)
+	mockStore.On("CreateSession", mock.Anything, mock.MatchedBy(isSessionFor("test-user"))).Return("", errors.New("storage error"))
 
 	router := gin.New()
 	router.GET("/auth/callback", handler.Callback)
@@ -233,8 +269,8 @@ func TestAuthHandler_Refresh_Success(t *testing.T) {
 	handler, mockStore, mockServer := setupTestHandler(t)
 	defer mockServer.Close()
 
-	mockStore.On("GetRefreshToken", mock.Anything, "session-123").Return("mock-refresh-token-123", nil)
-	mockStore.On("UpdateSession", mock.Anything, "session-123", mock.AnythingOfType("string")).Return(nil)
+	mockStore.On("GetSession", mock.Anything, "session-123").Return(storage.Session{ID: "session-123", RefreshToken: "mock-refresh-token-123"}, nil)
+	mockStore.On("UpdateSession", mock.Anything, "session-123", mock.AnythingOfType("string")).Return(testSession("session-123"), nil)
 
 	router := gin.New()
 	router.POST("/auth/refresh", handler.Refresh)
@@ -247,6 +283,40 @@ func TestAuthHandler_Refresh_Success(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "token refreshed")
+	assert.Contains(t, w.Body.String(), `"session_expires_in":`)
+	assert.Contains(t, w.Body.String(), `"session_absolute_expires_in":`)
+
+	var sessionCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == cookieSessionID {
+			sessionCookie = cookie
+		}
+	}
+	require.NotNil(t, sessionCookie, "refresh extends the session cookie")
+	assert.InDelta(t, 3600, sessionCookie.MaxAge, 2)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestAuthHandler_Refresh_SessionExpired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	mockStore.On("GetSession", mock.Anything, "session-123").Return(storage.Session{ID: "session-123", RefreshToken: "mock-refresh-token-123"}, nil)
+	mockStore.On("UpdateSession", mock.Anything, "session-123", mock.Anything).Return(storage.Session{}, errors.New("session not found"))
+
+	router := gin.New()
+	router.POST("/auth/refresh", handler.Refresh)
+
+	req := httptest.NewRequest("POST", "/auth/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: "session-123"})
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "failed to update session")
 
 	mockStore.AssertExpectations(t)
 }
@@ -267,7 +337,7 @@ func TestAuthHandler_Refresh_MissingSession(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 	assert.Contains(t, w.Body.String(), "missing session")
 
-	mockStore.AssertNotCalled(t, "GetRefreshToken")
+	mockStore.AssertNotCalled(t, "GetSession")
 }
 
 func TestAuthHandler_Refresh_InvalidSession(t *testing.T) {
@@ -275,7 +345,7 @@ func TestAuthHandler_Refresh_InvalidSession(t *testing.T) {
 	handler, mockStore, mockServer := setupTestHandler(t)
 	defer mockServer.Close()
 
-	mockStore.On("GetRefreshToken", mock.Anything, "invalid-session").Return("", errors.New("session not found"))
+	mockStore.On("GetSession", mock.Anything, "invalid-session").Return(storage.Session{}, errors.New("session not found"))
 
 	router := gin.New()
 	router.POST("/auth/refresh", handler.Refresh)
@@ -396,8 +466,8 @@ func TestAuthHandler_Callback_TokenExpiry(t *testing.T) {
 	handler, mockStore, mockServer := setupTestHandler(t)
 	defer mockServer.Close()
 
-	mockStore.On("ValidateState", mock.Anything, "test-state").Return(nil)
-	mockStore.On("CreateSession", mock.Anything, mock.AnythingOfType("string")).Return("session-123", nil)
+	mockStore.On("ValidateState", mock.Anything, "test-state").Return(storage.StateData{}, nil)
+	mockStore.On("CreateSession", mock.Anything, mock.MatchedBy(isSessionFor("test-user"))).Return("session-123", nil)
 
 	router := gin.New()
 	router.GET("/auth/callback", handler.Callback)
@@ -431,8 +501,8 @@ func TestAuthHandler_Refresh_WithTokenRotation(t *testing.T) {
 	defer mockServer.Close()
 
 	oldRefreshToken := "mock-refresh-token-old"
-	mockStore.On("GetRefreshToken", mock.Anything, "session-123").Return(oldRefreshToken, nil)
-	mockStore.On("UpdateSession", mock.Anything, "session-123", mock.AnythingOfType("string")).Return(nil)
+	mockStore.On("GetSession", mock.Anything, "session-123").Return(storage.Session{ID: "session-123", RefreshToken: oldRefreshToken}, nil)
+	mockStore.On("UpdateSession", mock.Anything, "session-123", mock.AnythingOfType("string")).Return(testSession("session-123"), nil)
 
 	router := gin.New()
 	router.POST("/auth/refresh", handler.Refresh)
@@ -535,3 +605,465 @@ func TestAuthHandler_Logout_NoIDTokenNoSession(t *testing.T) {
 
 	mockStore.AssertNotCalled(t, "DeleteSession")
 }
+
+func TestAuthHandler_LogsNoSensitiveData(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer, logs := setupTestHandlerWithLogs(t, log.DebugLevel)
+	defer mockServer.Close()
+
+	mockStore.On("CreateState", mock.Anything, storage.StateData{}).Return("test-state", nil)
+	mockStore.On("ValidateState", mock.Anything, "test-state").Return(storage.StateData{}, nil)
+	mockStore.On("CreateSession", mock.Anything, mock.MatchedBy(isSessionFor("test-user"))).Return("session-123", nil)
+	mockStore.On("GetSession", mock.Anything, "session-123").Return(storage.Session{ID: "session-123", RefreshToken: "mock-refresh-token-old"}, nil)
+	mockStore.On("UpdateSession", mock.Anything, "session-123", mock.AnythingOfType("string")).Return(testSession("session-123"), nil)
+	mockStore.On("DeleteSession", mock.Anything, "session-123").Return(nil)
+
+	router := gin.New()
+	router.GET("/auth/login", handler.Login)
+	router.GET("/auth/callback", handler.Callback)
+	router.POST("/auth/refresh", handler.Refresh)
+	router.POST("/auth/logout", handler.Logout)
+
+	// Login
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login", nil))
+	require.Equal(t, http.StatusFound, w.Code)
+
+	// Callback
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/callback?code=mock-auth-code&state=test-state", nil))
+	require.Equal(t, http.StatusFound, w.Code)
+
+	var idToken string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == cookieIDToken {
+			idToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, idToken)
+
+	// Refresh
+	req := httptest.NewRequest("POST", "/auth/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: "session-123"})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Logout with the real ID token
+	req = httptest.NewRequest("POST", "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: "session-123"})
+	req.AddCookie(&http.Cookie{Name: cookieIDToken, Value: idToken})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusFound, w.Code)
+
+	output := logs.String()
+	require.NotEmpty(t, output)
+	assert.NotRegexp(t, regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.`), output, "no JWT may reach the log writer")
+	assert.NotContains(t, output, "session-123")
+	assert.NotContains(t, output, "test-state")
+	assert.NotContains(t, output, "mock-refresh-token")
+}
+
+func TestAuthHandler_FullFlow_MemoryStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.store = store
+
+	router := gin.New()
+	router.GET("/auth/login", handler.Login)
+	router.GET("/auth/callback", handler.Callback)
+	router.POST("/auth/refresh", handler.Refresh)
+	router.POST("/auth/logout", handler.Logout)
+
+	// Login issues a state that the callback consumes
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login", nil))
+	require.Equal(t, http.StatusFound, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	state := location.Query().Get("state")
+	require.NotEmpty(t, state)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/callback?code=mock-auth-code&state="+state, nil))
+	require.Equal(t, http.StatusFound, w.Code)
+
+	var sessionID string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == cookieSessionID {
+			sessionID = cookie.Value
+		}
+	}
+	require.NotEmpty(t, sessionID)
+
+	sessions, err := store.ListUserSessions(context.Background(), "test-user")
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, sessionID, sessions[0].ID)
+
+	// The state cannot be replayed
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/callback?code=mock-auth-code&state="+state, nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req := httptest.NewRequest("POST", "/auth/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: sessionID})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest("POST", "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: sessionID})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	_, err = store.GetSession(context.Background(), sessionID)
+	assert.Error(t, err)
+}
+
+// rememberLogin runs login and callback with remember=true and returns the
+// session_id and device_id cookies
+func rememberLogin(t *testing.T, router *gin.Engine, userAgent string) (*http.Cookie, *http.Cookie) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login?remember=true", nil))
+	require.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/auth/callback?code=mock-auth-code&state="+location.Query().Get("state"), nil)
+	req.Header.Set("User-Agent", userAgent)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusFound, w.Code)
+
+	var sessionCookie, deviceCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		switch cookie.Name {
+		case cookieSessionID:
+			sessionCookie = cookie
+		case "device_id":
+			deviceCookie = cookie
+		}
+	}
+	require.NotNil(t, sessionCookie)
+	require.NotNil(t, deviceCookie)
+	return sessionCookie, deviceCookie
+}
+
+func TestAuthHandler_RememberMe(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.store = store
+
+	router := gin.New()
+	router.GET("/auth/login", handler.Login)
+	router.GET("/auth/callback", handler.Callback)
+	router.POST("/auth/refresh", handler.Refresh)
+
+	const userAgent = "Mozilla/5.0 (iPhone)"
+	sessionCookie, deviceCookie := rememberLogin(t, router, userAgent)
+	assert.Equal(t, int((7 * 24 * time.Hour).Seconds()), sessionCookie.MaxAge)
+	assert.Equal(t, int((30 * 24 * time.Hour).Seconds()), deviceCookie.MaxAge)
+
+	session, err := store.GetSession(context.Background(), sessionCookie.Value)
+	require.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, session.IdleTimeout)
+	assert.NotEmpty(t, session.DeviceHash)
+	assert.NotContains(t, session.DeviceHash, deviceCookie.Value, "the device is stored hashed")
+
+	refresh := func(userAgent string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/auth/refresh", nil)
+		req.Header.Set("User-Agent", userAgent)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := refresh(userAgent, sessionCookie, deviceCookie)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = refresh(userAgent, sessionCookie)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "missing device cookie")
+	assert.Contains(t, w.Body.String(), "reauthentication required")
+
+	_, err = store.GetSession(context.Background(), sessionCookie.Value)
+	assert.Error(t, err, "a mismatching refresh ends the session")
+
+	// Same device cookie, different browser
+	sessionCookie, deviceCookie = rememberLogin(t, router, userAgent)
+	w = refresh("curl/8.0", sessionCookie, deviceCookie)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "reauthentication required")
+}
+
+func TestAuthHandler_RememberMe_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	handler.appConfig.SessionRememberIdleTimeout = 0
+
+	mockStore.On("CreateState", mock.Anything, storage.StateData{Remember: false}).Return("test-state", nil)
+
+	router := gin.New()
+	router.GET("/auth/login", handler.Login)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login?remember=true", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestAuthHandler_Logout_RevokesRefreshToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	WithTokenRevoker(oidc.NewAsyncRevoker(handler.oidcClient, oidc.WithRetry(3, 10*time.Millisecond)))(handler)
+
+	// The first attempt fails; the revocation is retried in the background
+	mockServer.FailRevocations(1)
+
+	mockStore.On("GetSession", mock.Anything, "session-123").Return(storage.Session{ID: "session-123", RefreshToken: "mock-refresh-token-123"}, nil)
+	mockStore.On("DeleteSession", mock.Anything, "session-123").Return(nil)
+
+	router := gin.New()
+	router.POST("/auth/logout", handler.Logout)
+
+	req := httptest.NewRequest("POST", "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: "session-123"})
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusFound, w.Code, "logout does not wait for the provider")
+	assert.Eventually(t, func() bool {
+		return len(mockServer.RevokedTokens()) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"mock-refresh-token-123"}, mockServer.RevokedTokens())
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestAuthHandler_Logout_SendsLogoutState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	WithPostLogoutRedirectURL("http://localhost:8080/auth/logout/callback")(handler)
+
+	mockStore.On("DeleteSession", mock.Anything, "session-123").Return(nil)
+	mockStore.On("CreateState", mock.Anything, storage.StateData{Logout: true, RedirectTo: "http://localhost:3000/goodbye?from=menu"}).
+		Return("logout-state", nil)
+
+	router := gin.New()
+	router.POST("/auth/logout", handler.Logout)
+
+	req := httptest.NewRequest("POST", "/auth/logout?redirect_uri="+url.QueryEscape("http://localhost:3000/goodbye?from=menu"), nil)
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: "session-123"})
+	req.AddCookie(&http.Cookie{Name: cookieIDToken, Value: "test-id-token"})
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, mockServer.Issuer+"/logout", location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal(t, url.Values{
+		"client_id":                {mockServer.ClientID},
+		"id_token_hint":            {"test-id-token"},
+		"post_logout_redirect_uri": {"http://localhost:8080/auth/logout/callback"},
+		"state":                    {"logout-state"},
+	}, location.Query())
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestAuthHandler_Logout_RedirectAllowList(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	handler.appConfig.LogoutRedirectAllowList = []string{"https://app.example.com/account"}
+
+	router := gin.New()
+	router.POST("/auth/logout", handler.Logout)
+
+	tests := []struct {
+		redirectURI string
+		want        string
+	}{
+		{"", "http://localhost:3000"},
+		{"http://localhost:3000/bye", "http://localhost:3000/bye"},
+		{"https://app.example.com/account", "https://app.example.com/account"},
+		{"https://app.example.com/account/deleted", "https://app.example.com/account/deleted"},
+		{"https://app.example.com/accounting", "http://localhost:3000"},
+		{"https://app.example.com/", "http://localhost:3000"},
+		{"https://evil.example.com/account", "http://localhost:3000"},
+		{"http://localhost:3000.evil.com/", "http://localhost:3000"},
+		{"http://localhost:3001/", "http://localhost:3000"},
+		{"http://user@localhost:3000/", "http://localhost:3000"},
+		{"//evil.example.com", "http://localhost:3000"},
+		{"/relative", "http://localhost:3000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.redirectURI, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/auth/logout?redirect_uri="+url.QueryEscape(tt.redirectURI), nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusFound, w.Code)
+			assert.Equal(t, tt.want, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestAuthHandler_LogoutCallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	mockStore.On("ValidateState", mock.Anything, "logout-state").Return(storage.StateData{Logout: true, RedirectTo: "http://localhost:3000/bye"}, nil)
+	mockStore.On("ValidateState", mock.Anything, "logout-no-target").Return(storage.StateData{Logout: true}, nil)
+	mockStore.On("ValidateState", mock.Anything, "login-state").Return(storage.StateData{}, nil)
+	mockStore.On("ValidateState", mock.Anything, "unknown").Return(storage.StateData{}, errors.New("invalid state"))
+
+	router := gin.New()
+	router.GET("/auth/logout/callback", handler.LogoutCallback)
+	router.GET("/auth/callback", handler.Callback)
+
+	callback := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := callback("/auth/logout/callback?state=logout-state")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "http://localhost:3000/bye", w.Header().Get("Location"))
+
+	w = callback("/auth/logout/callback?state=logout-no-target")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Location"))
+
+	for _, path := range []string{
+		"/auth/logout/callback?state=login-state",
+		"/auth/logout/callback?state=unknown",
+		"/auth/callback?code=mock-auth-code&state=logout-state",
+	} {
+		w = callback(path)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		assert.Contains(t, w.Body.String(), "invalid state", path)
+	}
+}
+
+func TestAuthHandler_LogoutFlow_MemoryStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	handler.store = storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	WithPostLogoutRedirectURL("http://localhost:8080/auth/logout/callback")(handler)
+
+	router := gin.New()
+	router.GET("/auth/login", handler.Login)
+	router.GET("/auth/callback", handler.Callback)
+	router.GET("/auth/logout", handler.Logout)
+	router.GET("/auth/logout/callback", handler.LogoutCallback)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login", nil))
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/callback?code=mock-auth-code&state="+location.Query().Get("state"), nil))
+	require.Equal(t, http.StatusFound, w.Code)
+
+	req := httptest.NewRequest("GET", "/auth/logout?redirect_uri="+url.QueryEscape("http://localhost:3000/bye"), nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusFound, w.Code)
+
+	// The provider ends its session and sends the browser back with the state
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(w.Header().Get("Location"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	require.Len(t, mockServer.Logouts(), 1)
+
+	back, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/auth/logout/callback", back.Path)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", back.RequestURI(), nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "http://localhost:3000/bye", w.Header().Get("Location"))
+
+	// The logout state cannot be replayed
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", back.RequestURI(), nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAuthHandler_ProviderUnavailable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	// A client that started while the provider was down
+	mockServer.FailDiscovery(1)
+	degraded, err := oidc.NewClient(context.Background(), &config.OIDCConfig{
+		ProviderURL:   mockServer.Issuer,
+		ClientID:      mockServer.ClientID,
+		ClientSecret:  "test-secret",
+		RedirectURL:   mockServer.RedirectURL,
+		Scopes:        []string{"openid"},
+		LazyDiscovery: true,
+	})
+	require.NoError(t, err)
+	handler.oidcClient = degraded
+
+	mockStore.On("CreateState", mock.Anything, storage.StateData{}).Return("test-state", nil)
+	mockStore.On("GetSession", mock.Anything, "session-123").Return(testSession("session-123"), nil)
+
+	router := gin.New()
+	router.GET("/auth/login", handler.Login)
+	router.POST("/auth/refresh", handler.Refresh)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "identity provider unavailable")
+
+	req := httptest.NewRequest("POST", "/auth/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: "session-123"})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// The session is kept so the client can retry once the provider is back
+	mockStore.AssertNotCalled(t, "DeleteSession", mock.Anything, mock.Anything)
+	mockStore.AssertNotCalled(t, "UpdateSession", mock.Anything, mock.Anything, mock.Anything)
+	mockStore.AssertExpectations(t)
+}
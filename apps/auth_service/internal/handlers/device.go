@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// deviceFingerprint identifies the device a remember-me session is bound to:
+// the random device_id cookie plus the user agent, hashed so neither is stored
+func deviceFingerprint(deviceID, userAgent string) string {
+	sum := sha256.Sum256([]byte(deviceID + "\n" + userAgent))
+	return hex.EncodeToString(sum[:])
+}
+
+// ensureDeviceID returns the device_id cookie, issuing a new one when missing,
+// and extends it to outlive the remember-me session being created
+func (h *AuthHandler) ensureDeviceID(c *gin.Context) string {
+	deviceID, err := c.Cookie("device_id")
+	if err != nil || deviceID == "" {
+		deviceID = uuid.New().String()
+	}
+	h.setCookie(c, "device_id", deviceID, int(h.appConfig.SessionRememberAbsoluteTimeout.Seconds()))
+	return deviceID
+}
+
+// sameDevice reports whether the request comes from the device the session is
+// bound to. Unbound sessions match any device.
+func sameDevice(c *gin.Context, session storage.Session) bool {
+	if session.DeviceHash == "" {
+		return true
+	}
+	deviceID, err := c.Cookie("device_id")
+	if err != nil {
+		return false
+	}
+	fingerprint := deviceFingerprint(deviceID, c.Request.UserAgent())
+	return subtle.ConstantTimeCompare([]byte(fingerprint), []byte(session.DeviceHash)) == 1
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+	"github.com/carlosealves2/short-stream/authservice/internal/oidc"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// slowDownStep is how much RFC 8628 asks a device to add to its polling
+// interval after a slow_down error
+const slowDownStep = 5 * time.Second
+
+// devicePollRequest is the body of a device poll, as JSON or form
+type devicePollRequest struct {
+	DeviceCode string `json:"device_code" form:"device_code" binding:"required"`
+}
+
+// DeviceStart begins the OAuth 2.0 Device Authorization Grant (RFC 8628) for
+// clients without a browser, such as TV apps and CLIs. The provider's device
+// code is kept in the store; the client polls DevicePoll with the returned
+// device_code, an opaque handle, while the user enters user_code at
+// verification_uri on another device.
+func (h *AuthHandler) DeviceStart(c *gin.Context) {
+	authorization, err := h.oidcClient.StartDeviceAuthorization(c.Request.Context())
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to start device authorization")
+		switch {
+		case errors.Is(err, oidc.ErrProviderUnavailable):
+			h.providerUnavailable(c)
+		case errors.Is(err, oidc.ErrDeviceGrantUnsupported):
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "device authorization not supported"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start device authorization"})
+		}
+		return
+	}
+
+	grantID, err := h.store.CreateDeviceGrant(c.Request.Context(), storage.DeviceGrant{
+		DeviceCode: authorization.DeviceCode,
+		Interval:   authorization.Interval,
+		ExpiresAt:  authorization.ExpiresAt,
+	})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to store device grant")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start device authorization"})
+		return
+	}
+
+	response := gin.H{
+		"device_code":      grantID,
+		"user_code":        authorization.UserCode,
+		"verification_uri": authorization.VerificationURI,
+		"expires_in":       int(time.Until(authorization.ExpiresAt).Seconds()),
+		"interval":         int(authorization.Interval.Seconds()),
+	}
+	if authorization.VerificationURIComplete != "" {
+		response["verification_uri_complete"] = authorization.VerificationURIComplete
+	}
+
+	h.logger.Info().Msg("Device authorization started")
+	c.JSON(http.StatusOK, response)
+}
+
+// DevicePoll checks once whether the user approved a device authorization.
+// Until then it answers 400 with the RFC 8628 error code (authorization_pending
+// or slow_down, with the interval to wait); access_denied and expired_token
+// are final. Polls arriving faster than the grant's interval get slow_down
+// without reaching the provider. On approval it creates a normal session and returns the tokens and
+// the session ID as JSON, since devices do not keep cookies of their own.
+func (h *AuthHandler) DevicePoll(c *gin.Context) {
+	var req devicePollRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing device_code"})
+		return
+	}
+
+	grant, err := h.store.GetDeviceGrant(c.Request.Context(), req.DeviceCode)
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("Unknown or expired device grant")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expired_token"})
+		return
+	}
+
+	now := time.Now()
+	if !grant.LastPolledAt.IsZero() && now.Sub(grant.LastPolledAt) < grant.Interval {
+		grant.LastPolledAt = now
+		h.slowDown(c, req.DeviceCode, grant)
+		return
+	}
+	grant.LastPolledAt = now
+	h.saveDeviceGrant(c, req.DeviceCode, grant)
+
+	token, err := h.oidcClient.PollDeviceToken(c.Request.Context(), grant.DeviceCode)
+	if err != nil {
+		h.devicePollFailed(c, req.DeviceCode, grant, err)
+		return
+	}
+
+	// The provider redeems a device code once; drop the grant before anything
+	// else can fail so it is not polled again
+	if err := h.store.DeleteDeviceGrant(c.Request.Context(), req.DeviceCode); err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to delete device grant")
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	verified, err := h.oidcClient.VerifyIDToken(c.Request.Context(), idToken)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to verify ID token")
+		h.auditLoginFailed(c, "", "invalid_id_token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete device authorization"})
+		return
+	}
+
+	authContext, err := h.sessionAuthContext(verified, storage.StateData{})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to read auth context")
+		h.auditLoginFailed(c, verified.Subject, "invalid_id_token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete device authorization"})
+		return
+	}
+
+	internalUserID, err := h.linkUser(c, verified)
+	if err != nil {
+		h.auditLoginFailed(c, verified.Subject, "user_link_failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+		return
+	}
+
+	sessionID, err := h.store.CreateSession(c.Request.Context(), storage.NewSession{
+		UserID:         verified.Subject,
+		InternalUserID: internalUserID,
+		RefreshToken:   token.RefreshToken,
+		Auth:           authContext,
+	})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to create session")
+		h.auditLoginFailed(c, verified.Subject, "session_create_failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+		return
+	}
+
+	h.publishLogin(c, storage.NewSession{UserID: verified.Subject, InternalUserID: internalUserID}, sessionID, events.LoginDevice)
+	h.auditLogin(c, verified.Subject, sessionID)
+
+	h.logger.Info().Str("session_id", sessionID).Msg("Device authenticated successfully")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":       token.AccessToken,
+		"id_token":           idToken,
+		"token_type":         "Bearer",
+		"expires_in":         int(time.Until(token.Expiry).Seconds()),
+		"session_id":         sessionID,
+		"session_expires_in": int(h.sessionCookieMaxAge().Seconds()),
+	})
+}
+
+// devicePollFailed answers a poll the provider did not approve
+func (h *AuthHandler) devicePollFailed(c *gin.Context, grantID string, grant storage.DeviceGrant, err error) {
+	switch {
+	case errors.Is(err, oidc.ErrAuthorizationPending):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "authorization_pending", "interval": int(grant.Interval.Seconds())})
+	case errors.Is(err, oidc.ErrSlowDown):
+		h.slowDown(c, grantID, grant)
+	case errors.Is(err, oidc.ErrDeviceAccessDenied):
+		h.endDeviceGrant(c, grantID, "access_denied")
+	case errors.Is(err, oidc.ErrDeviceCodeExpired):
+		h.endDeviceGrant(c, grantID, "expired_token")
+	case errors.Is(err, oidc.ErrProviderUnavailable):
+		h.logger.Error().Err(err).Msg("Failed to poll device authorization")
+		h.providerUnavailable(c)
+	default:
+		h.logger.Error().Err(err).Msg("Failed to poll device authorization")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to poll device authorization"})
+	}
+}
+
+// slowDown raises the grant's interval as RFC 8628 asks of the device, and
+// keeps it so later polls are held to it
+func (h *AuthHandler) slowDown(c *gin.Context, grantID string, grant storage.DeviceGrant) {
+	grant.Interval += slowDownStep
+	h.saveDeviceGrant(c, grantID, grant)
+	c.JSON(http.StatusBadRequest, gin.H{"error": "slow_down", "interval": int(grant.Interval.Seconds())})
+}
+
+// saveDeviceGrant stores the polling state of a grant. A failure only loosens
+// the pacing, so the poll goes on.
+func (h *AuthHandler) saveDeviceGrant(c *gin.Context, grantID string, grant storage.DeviceGrant) {
+	if err := h.store.UpdateDeviceGrant(c.Request.Context(), grantID, grant); err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to update device grant")
+	}
+}
+
+// endDeviceGrant drops a grant the provider will never approve and reports why
+func (h *AuthHandler) endDeviceGrant(c *gin.Context, grantID, code string) {
+	if err := h.store.DeleteDeviceGrant(c.Request.Context(), grantID); err != nil {
+		h.logger.Warn().Err(err).Msg("Failed to delete device grant")
+	}
+	h.logger.Info().Str("reason", code).Msg("Device authorization ended")
+	h.auditLoginFailed(c, "", code)
+	c.JSON(http.StatusBadRequest, gin.H{"error": code})
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+func deviceRouter(handler *AuthHandler) *gin.Engine {
+	router := gin.New()
+	router.POST("/auth/device/start", handler.DeviceStart)
+	router.POST("/auth/device/poll", handler.DevicePoll)
+	return router
+}
+
+// pollDevice polls with a form body and decodes the JSON response
+func pollDevice(t *testing.T, router *gin.Engine, deviceCode string) (int, map[string]any) {
+	t.Helper()
+	form := url.Values{"device_code": {deviceCode}}
+	req := httptest.NewRequest("POST", "/auth/device/poll", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w.Code, body
+}
+
+func startDevice(t *testing.T, router *gin.Engine) map[string]any {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/auth/device/start", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body
+}
+
+func TestAuthHandler_DeviceFlow_MemoryStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.store = store
+	router := deviceRouter(handler)
+
+	started := startDevice(t, router)
+	deviceCode, _ := started["device_code"].(string)
+	userCode, _ := started["user_code"].(string)
+	require.NotEmpty(t, deviceCode)
+	assert.NotContains(t, deviceCode, "mock-device-code", "the provider's device code stays in the store")
+	assert.Equal(t, mockServer.Issuer+"/device/verify", started["verification_uri"])
+	assert.Contains(t, started["verification_uri_complete"], userCode)
+	assert.EqualValues(t, 5, started["interval"])
+	assert.Greater(t, started["expires_in"], float64(0))
+
+	code, body := pollDevice(t, router, deviceCode)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "authorization_pending", body["error"])
+	assert.EqualValues(t, 5, body["interval"])
+
+	// Polling again within the interval is answered without the provider,
+	// and the longer interval sticks to the grant
+	code, body = pollDevice(t, router, deviceCode)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "slow_down", body["error"])
+	assert.EqualValues(t, 10, body["interval"])
+
+	grant, err := store.GetDeviceGrant(context.Background(), deviceCode)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, grant.Interval)
+	grant.LastPolledAt = time.Now().Add(-grant.Interval)
+	require.NoError(t, store.UpdateDeviceGrant(context.Background(), deviceCode, grant))
+
+	require.True(t, mockServer.ApproveDevice(userCode))
+
+	code, body = pollDevice(t, router, deviceCode)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.NotEmpty(t, body["access_token"])
+	assert.NotEmpty(t, body["id_token"])
+	assert.Equal(t, "Bearer", body["token_type"])
+	assert.EqualValues(t, 3600, body["session_expires_in"])
+
+	sessionID, _ := body["session_id"].(string)
+	session, err := store.GetSession(context.Background(), sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "test-user", session.UserID)
+	assert.NotEmpty(t, session.RefreshToken)
+	assert.Empty(t, session.DeviceHash)
+
+	// The grant is consumed with the first successful poll
+	code, body = pollDevice(t, router, deviceCode)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "expired_token", body["error"])
+}
+
+func TestAuthHandler_DevicePoll_Denied(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.store = store
+	router := deviceRouter(handler)
+
+	started := startDevice(t, router)
+	deviceCode, _ := started["device_code"].(string)
+	userCode, _ := started["user_code"].(string)
+	require.True(t, mockServer.DenyDevice(userCode))
+
+	code, body := pollDevice(t, router, deviceCode)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "access_denied", body["error"])
+
+	_, err := store.GetDeviceGrant(context.Background(), deviceCode)
+	assert.Error(t, err, "a denied grant is dropped")
+}
+
+func TestAuthHandler_DevicePoll_MissingDeviceCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	code, body := pollDevice(t, deviceRouter(handler), "")
+
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "missing device_code", body["error"])
+	mockStore.AssertNotCalled(t, "GetDeviceGrant", mock.Anything, mock.Anything)
+}
+
+func TestAuthHandler_DevicePoll_ProviderError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	mockServer.Close()
+
+	grant := storage.DeviceGrant{DeviceCode: "mock-device-code-1", Interval: 5 * time.Second, ExpiresAt: time.Now().Add(time.Minute)}
+	mockStore.On("GetDeviceGrant", mock.Anything, "grant-1").Return(grant, nil)
+	mockStore.On("UpdateDeviceGrant", mock.Anything, "grant-1", mock.Anything).Return(nil)
+
+	code, body := pollDevice(t, deviceRouter(handler), "grant-1")
+
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, "failed to poll device authorization", body["error"])
+	mockStore.AssertNotCalled(t, "DeleteDeviceGrant", mock.Anything, mock.Anything)
+}
+
+func TestAuthHandler_DeviceStart_StoreError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	mockStore.On("CreateDeviceGrant", mock.Anything, mock.MatchedBy(func(grant storage.DeviceGrant) bool {
+		return grant.DeviceCode != "" && grant.Interval == 5*time.Second
+	})).Return("", errors.New("storage error"))
+
+	w := httptest.NewRecorder()
+	deviceRouter(handler).ServeHTTP(w, httptest.NewRequest("POST", "/auth/device/start", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "failed to start device authorization")
+	mockStore.AssertExpectations(t)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// publish records an auth event. Events are best effort from the request's
+// point of view: a failure is logged and the request goes on.
+func (h *AuthHandler) publish(c *gin.Context, payload events.Payload) {
+	if h.events == nil {
+		return
+	}
+	if err := h.events.Publish(c.Request.Context(), payload); err != nil {
+		h.logger.Error().Err(err).Str("type", payload.EventType()).Msg("Failed to publish event")
+	}
+}
+
+// publishLogin reports a login that created sessionID
+func (h *AuthHandler) publishLogin(c *gin.Context, session storage.NewSession, sessionID, method string) {
+	h.publish(c, events.UserLoggedIn{
+		UserID:     session.InternalUserID,
+		Subject:    session.UserID,
+		SessionRef: h.sessionRef(sessionID),
+		Method:     method,
+		Remember:   session.DeviceHash != "",
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	})
+}
+
+// publishSuspiciousRefresh reports a refresh that hints at a stolen session
+func (h *AuthHandler) publishSuspiciousRefresh(c *gin.Context, session storage.Session, reason string) {
+	h.publish(c, events.SuspiciousRefresh{
+		UserID:     session.InternalUserID,
+		Subject:    session.UserID,
+		SessionRef: h.sessionRef(session.ID),
+		Reason:     reason,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	})
+}
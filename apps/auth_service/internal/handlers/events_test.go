@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phuslu/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+// testSessionHashKey keys the session references of events in tests
+const testSessionHashKey = "0123456789abcdef0123456789abcdef"
+
+type recordingPublisher struct {
+	mu       sync.Mutex
+	payloads []events.Payload
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, payload events.Payload) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.payloads = append(p.payloads, payload)
+	return nil
+}
+
+func (p *recordingPublisher) published() []events.Payload {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]events.Payload(nil), p.payloads...)
+}
+
+func TestAuthHandler_PublishesLoginEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	publisher := &recordingPublisher{}
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.store = store
+	handler.users = storage.NewMemoryUserStore()
+	handler.events = publisher
+	handler.appConfig.SessionHashKey = testSessionHashKey
+	router := userInfoRouter(handler)
+	router.POST("/auth/logout", handler.Logout)
+
+	sessionID := loginSession(t, router)
+	session, err := store.GetSession(context.Background(), sessionID)
+	require.NoError(t, err)
+
+	published := publisher.published()
+	require.Len(t, published, 2)
+	assert.Equal(t, events.UserRegistered{
+		UserID:   session.InternalUserID,
+		Provider: mockServer.Issuer,
+		Subject:  "test-user",
+		Email:    "test-user@example.com",
+	}, published[0])
+	login, ok := published[1].(events.UserLoggedIn)
+	require.True(t, ok)
+	assert.Equal(t, session.InternalUserID, login.UserID)
+	assert.Equal(t, sessionRef(testSessionHashKey, sessionID), login.SessionRef)
+	assert.NotContains(t, login.SessionRef, sessionID, "events never carry the session ID")
+	assert.Equal(t, events.LoginBrowser, login.Method)
+	assert.NotEmpty(t, login.IP)
+
+	// A returning user only logs in
+	loginSession(t, router)
+	published = publisher.published()
+	require.Len(t, published, 3)
+	assert.Equal(t, events.TypeUserLoggedIn, published[2].EventType())
+
+	req := httptest.NewRequest("POST", "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: sessionID})
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	published = publisher.published()
+	require.Len(t, published, 4)
+	assert.Equal(t, events.SessionRevoked{
+		UserID:     session.InternalUserID,
+		Subject:    "test-user",
+		SessionRef: sessionRef(testSessionHashKey, sessionID),
+		Reason:     events.RevokedLogout,
+	}, published[3])
+}
+
+func TestAuthHandler_PublishesSuspiciousRefresh(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	publisher := &recordingPublisher{}
+	handler.store = storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.events = publisher
+
+	router := gin.New()
+	router.GET("/auth/login", handler.Login)
+	router.GET("/auth/callback", handler.Callback)
+	router.POST("/auth/refresh", handler.Refresh)
+
+	sessionCookie, _ := rememberLogin(t, router, "Mozilla/5.0 (iPhone)")
+	login, ok := publisher.published()[0].(events.UserLoggedIn)
+	require.True(t, ok)
+	assert.True(t, login.Remember)
+
+	// Refresh without the device cookie
+	req := httptest.NewRequest("POST", "/auth/refresh", nil)
+	req.Header.Set("User-Agent", "curl/8.0")
+	req.AddCookie(sessionCookie)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	published := publisher.published()
+	require.Len(t, published, 3)
+	suspicious, ok := published[1].(events.SuspiciousRefresh)
+	require.True(t, ok)
+	assert.Empty(t, suspicious.SessionRef, "without a key no session reference is sent")
+	assert.Equal(t, events.RevokedDeviceMismatch, suspicious.Reason)
+	assert.Equal(t, "curl/8.0", suspicious.UserAgent)
+	revoked, ok := published[2].(events.SessionRevoked)
+	require.True(t, ok)
+	assert.Equal(t, events.RevokedDeviceMismatch, revoked.Reason)
+}
+
+func TestSessionAdminHandler_PublishesRevokedSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	publisher := &recordingPublisher{}
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler := NewSessionAdminHandler(store, nil, logger.New(&bytes.Buffer{}, log.InfoLevel),
+		WithAdminEventPublisher(publisher), WithAdminSessionHashKey(testSessionHashKey))
+
+	router := gin.New()
+	router.DELETE("/admin/users/:user_id/sessions", handler.RevokeUserSessions)
+
+	first := createTestSession(t, store, "user-1", "refresh-1")
+	second := createTestSession(t, store, "user-1", "refresh-2")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/users/user-1/sessions", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	published := publisher.published()
+	require.Len(t, published, 2)
+	var refs []string
+	for _, payload := range published {
+		revoked, ok := payload.(events.SessionRevoked)
+		require.True(t, ok)
+		assert.Equal(t, "user-1", revoked.Subject)
+		assert.Equal(t, events.RevokedAdmin, revoked.Reason)
+		refs = append(refs, revoked.SessionRef)
+	}
+	assert.ElementsMatch(t, []string{sessionRef(testSessionHashKey, first), sessionRef(testSessionHashKey, second)}, refs)
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/audit"
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+	"github.com/carlosealves2/short-stream/authservice/internal/oidc"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// maxImpersonationReasonLength bounds the justification kept in the audit log
+const maxImpersonationReasonLength = 500
+
+// errImpersonatorLoggedOut is an impersonation session whose support engineer
+// no longer has a session of their own
+var errImpersonatorLoggedOut = errors.New("impersonator session ended")
+
+// errImpersonationRevoked is an impersonation session whose support engineer
+// is no longer an admin, or whose user has become protected
+var errImpersonationRevoked = errors.New("impersonation no longer allowed")
+
+type impersonationRequest struct {
+	// UserID is the internal ID of the user to act as
+	UserID string `json:"user_id" binding:"required"`
+	// Reason is why, e.g. a support ticket, recorded in the audit log
+	Reason string `json:"reason" binding:"required"`
+}
+
+// actClaim names the support engineer acting as the user (RFC 8693)
+type actClaim struct {
+	Subject string `json:"sub"`
+}
+
+// sessionActor is who acts through session: the support engineer of an
+// impersonation session, the user otherwise
+func sessionActor(session storage.Session) string {
+	if session.Impersonator != "" {
+		return session.Impersonator
+	}
+	return session.UserID
+}
+
+// StartImpersonation lets a support engineer act as a user. The engineer's
+// own session must belong to one of the configured admins; the new session
+// carries both identities, lasts the configured TTL at most and replaces the
+// engineer's session cookie until EndImpersonation.
+func (h *AuthHandler) StartImpersonation(c *gin.Context) {
+	actor, ok := h.cookieSession(c)
+	if !ok {
+		return
+	}
+	if actor.Impersonator != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "already impersonating"})
+		return
+	}
+
+	var req impersonationRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Reason) > maxImpersonationReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id and a reason of at most 500 characters are required"})
+		return
+	}
+
+	record := audit.Record{
+		Action:       audit.ActionImpersonationStart,
+		Actor:        actor.UserID,
+		Subject:      req.UserID,
+		Outcome:      audit.OutcomeFailure,
+		Reason:       req.Reason,
+		Impersonator: actor.UserID,
+	}
+	if h.impersonation == nil || h.users == nil || !h.impersonation.IsAdmin(actor.UserID) {
+		h.logger.Warn().Str("user_id", actor.UserID).Msg("Impersonation attempt by a non-admin")
+		h.recordAudit(c, record)
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	target, err := h.users.GetUser(c.Request.Context(), req.UserID)
+	var subject string
+	if err == nil {
+		subject = currentSubject(target)
+	}
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound) || (err == nil && subject == ""):
+		h.recordAudit(c, record)
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	case err != nil:
+		h.logger.Error().Err(err).Msg("Failed to get user")
+		h.recordAudit(c, record)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+		return
+	}
+	record.Subject = subject
+
+	if h.protectedUser(target, actor.UserID) {
+		h.logger.Warn().Str("user_id", actor.UserID).Str("target", target.ID).Msg("Impersonation of a protected user refused")
+		h.recordAudit(c, record)
+		c.JSON(http.StatusForbidden, gin.H{"error": "user cannot be impersonated"})
+		return
+	}
+
+	token, err := h.impersonationToken(c, actor, subject)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to get impersonation token")
+		h.recordAudit(c, record)
+		if errors.Is(err, oidc.ErrProviderUnavailable) {
+			h.providerUnavailable(c)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to impersonate user"})
+		return
+	}
+
+	ttl := h.impersonation.TTL
+	sessionID, err := h.store.CreateSession(c.Request.Context(), storage.NewSession{
+		UserID:                subject,
+		InternalUserID:        target.ID,
+		Lifetime:              storage.Lifetime{Idle: ttl, Absolute: ttl},
+		Impersonator:          actor.UserID,
+		ImpersonatorSessionID: actor.ID,
+	})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to create session")
+		h.recordAudit(c, record)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+		return
+	}
+
+	record.SessionID, record.Outcome = sessionID, audit.OutcomeSuccess
+	h.recordAudit(c, record)
+
+	// The engineer's ID token would end their own login at the provider on
+	// logout; impersonation sessions only log out locally
+	h.clearCookie(c, "id_token")
+	h.setCookie(c, "access_token", token.AccessToken, int(time.Until(token.Expiry).Seconds()))
+	h.setCookie(c, "session_id", sessionID, int(ttl.Seconds()))
+
+	h.logger.Warn().Str("session_id", sessionID).Str("user_id", actor.UserID).Str("target", target.ID).Msg("Impersonation started")
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":    target.ID,
+		"sub":        subject,
+		"act":        actClaim{Subject: actor.UserID},
+		"expires_in": int(ttl.Seconds()),
+	})
+}
+
+// EndImpersonation ends impersonation from either side. From an impersonation
+// session it ends that session and gives the support engineer their own
+// session back; from a user's own session it ends every impersonation of the
+// user.
+func (h *AuthHandler) EndImpersonation(c *gin.Context) {
+	session, ok := h.cookieSession(c)
+	if !ok {
+		return
+	}
+
+	if session.Impersonator == "" {
+		h.endUserImpersonations(c, session)
+		return
+	}
+
+	h.endSession(c, session, events.RevokedImpersonationEnded)
+	h.recordAudit(c, audit.Record{
+		Action:       audit.ActionImpersonationEnd,
+		Actor:        session.Impersonator,
+		Subject:      session.UserID,
+		SessionID:    session.ID,
+		Outcome:      audit.OutcomeSuccess,
+		Reason:       "ended_by_impersonator",
+		Impersonator: session.Impersonator,
+	})
+
+	h.clearCookie(c, "access_token")
+	restored := false
+	if actor, err := h.store.GetSession(c.Request.Context(), session.ImpersonatorSessionID); err == nil {
+		// The engineer gets fresh tokens of their own on the next refresh
+		h.setCookie(c, "session_id", actor.ID, int(time.Until(actor.ExpiresAt).Seconds()))
+		restored = true
+	} else {
+		h.clearCookie(c, "session_id")
+	}
+
+	h.logger.Warn().Str("session_id", session.ID).Str("user_id", session.Impersonator).Msg("Impersonation ended by impersonator")
+	c.JSON(http.StatusOK, gin.H{"ended": 1, "session_restored": restored})
+}
+
+// endUserImpersonations ends every impersonation session of the user of
+// session
+func (h *AuthHandler) endUserImpersonations(c *gin.Context, session storage.Session) {
+	sessions, err := h.localUserSessions(c.Request.Context(), session)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list user sessions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to end impersonation"})
+		return
+	}
+
+	ended := 0
+	for _, impersonated := range sessions {
+		if impersonated.Impersonator == "" {
+			continue
+		}
+		h.endSession(c, impersonated, events.RevokedImpersonationEnded)
+		h.recordAudit(c, audit.Record{
+			Action:       audit.ActionImpersonationEnd,
+			Actor:        session.UserID,
+			Subject:      session.UserID,
+			SessionID:    impersonated.ID,
+			Outcome:      audit.OutcomeSuccess,
+			Reason:       "ended_by_user",
+			Impersonator: impersonated.Impersonator,
+		})
+		ended++
+	}
+
+	if ended > 0 {
+		h.logger.Warn().Str("user_id", session.UserID).Int("sessions", ended).Msg("Impersonation ended by user")
+	}
+	c.JSON(http.StatusOK, gin.H{"ended": ended})
+}
+
+// localUserSessions returns the sessions of the local user of session,
+// whichever of the user's identities they were created for. Sessions from
+// before user records were kept only have their own subject to go by.
+func (h *AuthHandler) localUserSessions(ctx context.Context, session storage.Session) ([]storage.Session, error) {
+	if h.users == nil || session.InternalUserID == "" {
+		return h.store.ListUserSessions(ctx, session.UserID)
+	}
+
+	user, err := h.users.GetUser(ctx, session.InternalUserID)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []storage.Session
+	seen := make(map[string]bool)
+	for _, identity := range user.Identities {
+		listed, err := h.store.ListUserSessions(ctx, identity.Subject)
+		if err != nil {
+			return nil, err
+		}
+		for _, listedSession := range listed {
+			// Another provider may use the same subject for someone else
+			if listedSession.InternalUserID == user.ID && !seen[listedSession.ID] {
+				seen[listedSession.ID] = true
+				sessions = append(sessions, listedSession)
+			}
+		}
+	}
+	return sessions, nil
+}
+
+// cookieSession returns the session of the session_id cookie, responding 401
+// when there is none
+func (h *AuthHandler) cookieSession(c *gin.Context) (storage.Session, bool) {
+	sessionID, err := c.Cookie("session_id")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing session"})
+		return storage.Session{}, false
+	}
+	session, err := h.store.GetSession(c.Request.Context(), sessionID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return storage.Session{}, false
+	}
+	return session, true
+}
+
+// impersonationToken gets a token of subject on behalf of the support
+// engineer of actor, using a fresh access token of the engineer's session
+func (h *AuthHandler) impersonationToken(c *gin.Context, actor storage.Session, subject string) (*oauth2.Token, error) {
+	actorToken, err := h.oidcClient.RefreshToken(c.Request.Context(), actor.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if actorToken.RefreshToken != "" && actorToken.RefreshToken != actor.RefreshToken {
+		if _, err := h.store.UpdateSession(c.Request.Context(), actor.ID, actorToken.RefreshToken); err != nil {
+			return nil, err
+		}
+	}
+	return h.oidcClient.ImpersonateToken(c.Request.Context(), actorToken.AccessToken, subject)
+}
+
+// refreshImpersonation gets a new token for an impersonation session. It
+// lives off the support engineer's own session, so it ends with it, and is
+// checked like a new impersonation, so it ends once the engineer is no longer
+// an admin or the user becomes protected.
+func (h *AuthHandler) refreshImpersonation(c *gin.Context, session storage.Session) (*oauth2.Token, error) {
+	actor, err := h.store.GetSession(c.Request.Context(), session.ImpersonatorSessionID)
+	if err != nil {
+		return nil, errImpersonatorLoggedOut
+	}
+	if h.impersonation == nil || h.users == nil || !h.impersonation.IsAdmin(actor.UserID) {
+		return nil, errImpersonationRevoked
+	}
+
+	target, err := h.users.GetUser(c.Request.Context(), session.InternalUserID)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil, errImpersonationRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	if h.protectedUser(target, actor.UserID) {
+		return nil, errImpersonationRevoked
+	}
+
+	return h.impersonationToken(c, actor, session.UserID)
+}
+
+// protectedUser reports whether user may not be impersonated by the admin
+// with actorSubject: the user is the admin, or any of its identities or its
+// internal ID is protected
+func (h *AuthHandler) protectedUser(user storage.User, actorSubject string) bool {
+	for _, identity := range user.Identities {
+		if identity.Subject == actorSubject || h.impersonation.IsProtected(identity.Subject, user.ID) {
+			return true
+		}
+	}
+	return h.impersonation.IsProtected("", user.ID)
+}
+
+// currentSubject is the provider subject of the identity user last logged in
+// with
+func currentSubject(user storage.User) string {
+	var latest storage.LinkedIdentity
+	for _, identity := range user.Identities {
+		if identity.LastLoginAt.After(latest.LastLoginAt) || latest.Subject == "" {
+			latest = identity
+		}
+	}
+	return latest.Subject
+}
@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/audit"
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// impersonationFixture is a handler where test-user, the user every mock
+// login returns, is an impersonation admin
+type impersonationFixture struct {
+	handler  *AuthHandler
+	router   *gin.Engine
+	store    storage.Store
+	auditLog *audit.FileSink
+	users    storage.UserStore
+	// target is a regular user to impersonate, with provider subject user-42
+	target storage.User
+}
+
+// otherIssuer is a second provider the target can log in with
+const otherIssuer = "https://other-idp.example.com"
+
+func newImpersonationFixture(t *testing.T) *impersonationFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	t.Cleanup(mockServer.Close)
+
+	f := &impersonationFixture{
+		handler:  handler,
+		store:    storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour}),
+		auditLog: newTestAuditLog(t),
+	}
+	users := storage.NewMemoryUserStore(storage.WithEmailLinking(mockServer.Issuer, otherIssuer))
+	f.users = users
+	handler.store = f.store
+	handler.users = users
+	handler.auditLog = f.auditLog
+	handler.appConfig.SessionHashKey = testSessionHashKey
+	handler.impersonation = &config.ImpersonationConfig{
+		Admins:    []string{"test-user"},
+		Protected: []string{"ceo"},
+		TTL:       30 * time.Minute,
+	}
+
+	var err error
+	f.target, _, err = users.LinkIdentity(context.Background(), storage.Identity{
+		Provider:      mockServer.Issuer,
+		Subject:       "user-42",
+		Email:         "user-42@example.com",
+		EmailVerified: true,
+	})
+	require.NoError(t, err)
+
+	f.router = userInfoRouter(handler)
+	f.router.POST("/auth/refresh", handler.Refresh)
+	f.router.POST("/auth/logout", handler.Logout)
+	f.router.POST("/auth/impersonation", handler.StartImpersonation)
+	f.router.DELETE("/auth/impersonation", handler.EndImpersonation)
+	return f
+}
+
+// do sends a request with the session cookie and returns the response and
+// its cookies by name
+func (f *impersonationFixture) do(t *testing.T, method, path, sessionID, body string) (*httptest.ResponseRecorder, map[string]*http.Cookie) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if sessionID != "" {
+		req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: sessionID})
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return w, cookies
+}
+
+// start impersonates userID from the session of the support engineer
+// linkIdentity links an identity of otherIssuer with subject to the target,
+// making it the one the target logged in with last
+func (f *impersonationFixture) linkIdentity(t *testing.T, subject string) {
+	t.Helper()
+	user, created, err := f.users.LinkIdentity(context.Background(), storage.Identity{
+		Provider:      otherIssuer,
+		Subject:       subject,
+		Email:         "user-42@example.com",
+		EmailVerified: true,
+	})
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Equal(t, f.target.ID, user.ID)
+}
+
+func (f *impersonationFixture) start(t *testing.T, actorSessionID, userID string) (*httptest.ResponseRecorder, map[string]*http.Cookie) {
+	t.Helper()
+	return f.do(t, "POST", "/auth/impersonation", actorSessionID, `{"user_id":"`+userID+`","reason":"TICKET-123 feed is empty"}`)
+}
+
+func TestAuthHandler_Impersonation(t *testing.T) {
+	f := newImpersonationFixture(t)
+	actorSessionID := loginSession(t, f.router)
+
+	w, cookies := f.start(t, actorSessionID, f.target.ID)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var started map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &started))
+	assert.Equal(t, "user-42", started["sub"])
+	assert.Equal(t, map[string]any{"sub": "test-user"}, started["act"])
+	assert.EqualValues(t, 1800, started["expires_in"])
+
+	sessionID := cookies[cookieSessionID].Value
+	require.NotEqual(t, actorSessionID, sessionID)
+	assert.Equal(t, 1800, cookies[cookieSessionID].MaxAge)
+	assert.Negative(t, cookies[cookieIDToken].MaxAge, "the engineer's ID token is dropped")
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(cookies[cookieAccessToken].Value, claims)
+	require.NoError(t, err)
+	assert.Equal(t, "user-42", claims["sub"])
+	assert.Equal(t, map[string]any{"sub": "test-user"}, claims["act"])
+
+	// The session carries both identities
+	code, info := getUserInfo(t, f.router, sessionID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, f.target.ID, info.UserID)
+	assert.Equal(t, "user-42", info.Subject)
+	require.NotNil(t, info.Act)
+	assert.Equal(t, "test-user", info.Act.Subject)
+
+	session, err := f.store.GetSession(context.Background(), sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "test-user", session.Impersonator)
+	assert.Equal(t, actorSessionID, session.ImpersonatorSessionID)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), session.AbsoluteExpiresAt, time.Minute)
+
+	// Refreshing gets another token of the user through the engineer's session
+	w, cookies = f.do(t, "POST", "/auth/refresh", sessionID, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	claims = jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(cookies[cookieAccessToken].Value, claims)
+	require.NoError(t, err)
+	assert.Equal(t, "user-42", claims["sub"])
+
+	// A refresh does not stretch the session past its TTL
+	refreshed, err := f.store.GetSession(context.Background(), sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, session.AbsoluteExpiresAt, refreshed.AbsoluteExpiresAt)
+
+	// Ending it gives the engineer their own session back
+	w, cookies = f.do(t, "DELETE", "/auth/impersonation", sessionID, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"ended":1,"session_restored":true}`, w.Body.String())
+	assert.Equal(t, actorSessionID, cookies[cookieSessionID].Value)
+	_, err = f.store.GetSession(context.Background(), sessionID)
+	assert.Error(t, err)
+
+	entries := auditEntries(t, f.auditLog)
+	require.GreaterOrEqual(t, len(entries), 3)
+	start, end := entries[len(entries)-2], entries[len(entries)-1]
+	assert.Equal(t, audit.ActionImpersonationStart, start.Action)
+	assert.Equal(t, audit.OutcomeSuccess, start.Outcome)
+	assert.Equal(t, "test-user", start.Actor)
+	assert.Equal(t, "user-42", start.Subject)
+	assert.Equal(t, "test-user", start.Impersonator)
+	assert.Equal(t, "TICKET-123 feed is empty", start.Reason)
+	assert.Equal(t, sessionRef(testSessionHashKey, sessionID), start.SessionID)
+	assert.Equal(t, audit.ActionImpersonationEnd, end.Action)
+	assert.Equal(t, "test-user", end.Impersonator)
+	assert.Equal(t, "ended_by_impersonator", end.Reason)
+}
+
+func TestAuthHandler_Impersonation_EndedByUser(t *testing.T) {
+	f := newImpersonationFixture(t)
+	actorSessionID := loginSession(t, f.router)
+
+	_, cookies := f.start(t, actorSessionID, f.target.ID)
+	impersonationID := cookies[cookieSessionID].Value
+
+	userSessionID, err := f.store.CreateSession(context.Background(), storage.NewSession{UserID: "user-42", RefreshToken: "mock-refresh-token-user"})
+	require.NoError(t, err)
+
+	w, _ := f.do(t, "DELETE", "/auth/impersonation", userSessionID, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"ended":1}`, w.Body.String())
+
+	_, err = f.store.GetSession(context.Background(), impersonationID)
+	assert.Error(t, err)
+	_, err = f.store.GetSession(context.Background(), userSessionID)
+	assert.NoError(t, err, "the user's own session stays")
+
+	entries := auditEntries(t, f.auditLog)
+	end := entries[len(entries)-1]
+	assert.Equal(t, audit.ActionImpersonationEnd, end.Action)
+	assert.Equal(t, "user-42", end.Actor)
+	assert.Equal(t, "test-user", end.Impersonator)
+	assert.Equal(t, "ended_by_user", end.Reason)
+	assert.Equal(t, sessionRef(testSessionHashKey, impersonationID), end.SessionID)
+}
+
+func TestAuthHandler_Impersonation_EndedByUserWithAnotherIdentity(t *testing.T) {
+	f := newImpersonationFixture(t)
+	actorSessionID := loginSession(t, f.router)
+
+	// The impersonation acts as user-42, the identity the target used last
+	_, cookies := f.start(t, actorSessionID, f.target.ID)
+	impersonationID := cookies[cookieSessionID].Value
+
+	// The user ends it from a session of their other identity
+	f.linkIdentity(t, "user-42-other")
+	userSessionID, err := f.store.CreateSession(context.Background(), storage.NewSession{
+		UserID:         "user-42-other",
+		InternalUserID: f.target.ID,
+		RefreshToken:   "mock-refresh-token-user",
+	})
+	require.NoError(t, err)
+
+	w, _ := f.do(t, "DELETE", "/auth/impersonation", userSessionID, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"ended":1}`, w.Body.String())
+
+	_, err = f.store.GetSession(context.Background(), impersonationID)
+	assert.Error(t, err)
+}
+
+func TestAuthHandler_Impersonation_EndsWithImpersonatorSession(t *testing.T) {
+	f := newImpersonationFixture(t)
+	actorSessionID := loginSession(t, f.router)
+
+	_, cookies := f.start(t, actorSessionID, f.target.ID)
+	impersonationID := cookies[cookieSessionID].Value
+
+	// The engineer logs out elsewhere
+	f.do(t, "POST", "/auth/logout", actorSessionID, "")
+
+	w, _ := f.do(t, "POST", "/auth/refresh", impersonationID, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "impersonation ended")
+	_, err := f.store.GetSession(context.Background(), impersonationID)
+	assert.Error(t, err)
+
+	entries := auditEntries(t, f.auditLog)
+	refresh := entries[len(entries)-1]
+	assert.Equal(t, audit.ActionRefresh, refresh.Action)
+	assert.Equal(t, "impersonator_logged_out", refresh.Reason)
+	assert.Equal(t, "test-user", refresh.Actor)
+	assert.Equal(t, "user-42", refresh.Subject)
+	assert.Equal(t, "test-user", refresh.Impersonator)
+}
+
+func TestAuthHandler_Impersonation_RefreshRechecksAccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, f *impersonationFixture)
+	}{
+		{
+			name: "no longer an admin",
+			revoke: func(_ *testing.T, f *impersonationFixture) {
+				f.handler.impersonation.Admins = []string{"support-1"}
+			},
+		},
+		{
+			name: "user became protected",
+			revoke: func(_ *testing.T, f *impersonationFixture) {
+				f.handler.impersonation.Protected = []string{f.target.ID}
+			},
+		},
+		{
+			name: "user linked a protected identity",
+			revoke: func(t *testing.T, f *impersonationFixture) {
+				f.handler.impersonation.Protected = []string{"user-42-other"}
+				f.linkIdentity(t, "user-42-other")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newImpersonationFixture(t)
+			actorSessionID := loginSession(t, f.router)
+
+			_, cookies := f.start(t, actorSessionID, f.target.ID)
+			impersonationID := cookies[cookieSessionID].Value
+			w, _ := f.do(t, "POST", "/auth/refresh", impersonationID, "")
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+			tt.revoke(t, f)
+
+			w, _ = f.do(t, "POST", "/auth/refresh", impersonationID, "")
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Contains(t, w.Body.String(), "impersonation ended")
+			_, err := f.store.GetSession(context.Background(), impersonationID)
+			assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+			_, err = f.store.GetSession(context.Background(), actorSessionID)
+			assert.NoError(t, err, "the engineer keeps their own session")
+
+			entries := auditEntries(t, f.auditLog)
+			refresh := entries[len(entries)-1]
+			assert.Equal(t, audit.ActionRefresh, refresh.Action)
+			assert.Equal(t, "impersonation_revoked", refresh.Reason)
+		})
+	}
+}
+
+func TestAuthHandler_Impersonation_Refused(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, f *impersonationFixture) (actorSessionID, body string)
+		status int
+		// existing is how many impersonations of the target exist afterwards
+		existing int
+	}{
+		{
+			name: "not an admin",
+			setup: func(t *testing.T, f *impersonationFixture) (string, string) {
+				f.handler.impersonation.Admins = []string{"support-1"}
+				return loginSession(t, f.router), `{"user_id":"` + f.target.ID + `","reason":"ticket"}`
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "protected user",
+			setup: func(t *testing.T, f *impersonationFixture) (string, string) {
+				f.handler.impersonation.Protected = []string{f.target.ID}
+				return loginSession(t, f.router), `{"user_id":"` + f.target.ID + `","reason":"ticket"}`
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "protected through an identity not used last",
+			setup: func(t *testing.T, f *impersonationFixture) (string, string) {
+				f.handler.impersonation.Protected = []string{"user-42"}
+				f.linkIdentity(t, "user-42-other")
+				return loginSession(t, f.router), `{"user_id":"` + f.target.ID + `","reason":"ticket"}`
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "the admin through another identity",
+			setup: func(t *testing.T, f *impersonationFixture) (string, string) {
+				f.linkIdentity(t, "test-user")
+				f.linkIdentity(t, "user-42-other")
+				return loginSession(t, f.router), `{"user_id":"` + f.target.ID + `","reason":"ticket"}`
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "another admin",
+			setup: func(t *testing.T, f *impersonationFixture) (string, string) {
+				f.handler.impersonation.Admins = append(f.handler.impersonation.Admins, "user-42")
+				return loginSession(t, f.router), `{"user_id":"` + f.target.ID + `","reason":"ticket"}`
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "from an impersonation session",
+			setup: func(t *testing.T, f *impersonationFixture) (string, string) {
+				_, cookies := f.start(t, loginSession(t, f.router), f.target.ID)
+				return cookies[cookieSessionID].Value, `{"user_id":"` + f.target.ID + `","reason":"ticket"}`
+			},
+			status:   http.StatusForbidden,
+			existing: 1,
+		},
+		{
+			name: "unknown user",
+			setup: func(t *testing.T, f *impersonationFixture) (string, string) {
+				return loginSession(t, f.router), `{"user_id":"nobody","reason":"ticket"}`
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "missing reason",
+			setup: func(t *testing.T, f *impersonationFixture) (string, string) {
+				return loginSession(t, f.router), `{"user_id":"` + f.target.ID + `"}`
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "no session",
+			setup: func(_ *testing.T, f *impersonationFixture) (string, string) {
+				return "", `{"user_id":"` + f.target.ID + `","reason":"ticket"}`
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newImpersonationFixture(t)
+			actorSessionID, body := tt.setup(t, f)
+
+			w, cookies := f.do(t, "POST", "/auth/impersonation", actorSessionID, body)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.NotContains(t, cookies, cookieSessionID, "no session is handed out")
+
+			sessions, err := f.store.ListUserSessions(context.Background(), "user-42")
+			require.NoError(t, err)
+			impersonations := 0
+			for _, session := range sessions {
+				if session.Impersonator != "" {
+					impersonations++
+				}
+			}
+			assert.Equal(t, tt.existing, impersonations)
+		})
+	}
+}
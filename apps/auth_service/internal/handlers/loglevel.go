@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phuslu/log"
+
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+// LogLevelHandler exposes the logger level registry so levels can be changed without a restart
+type LogLevelHandler struct {
+	levels *logger.Levels
+	logger logger.Logger
+}
+
+// NewLogLevelHandler creates a new LogLevelHandler
+func NewLogLevelHandler(levels *logger.Levels, log logger.Logger) *LogLevelHandler {
+	return &LogLevelHandler{
+		levels: levels,
+		logger: log,
+	}
+}
+
+type logLevelsResponse struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+type updateLogLevelsRequest struct {
+	// Level changes the default level when set
+	Level string `json:"level"`
+	// Components sets per-component overrides; an empty level removes the override
+	Components map[string]string `json:"components"`
+}
+
+// Get returns the current default level and per-component overrides
+func (h *LogLevelHandler) Get(c *gin.Context) {
+	c.JSON(http.StatusOK, h.snapshot())
+}
+
+// Update changes the default level and/or per-component overrides
+func (h *LogLevelHandler) Update(c *gin.Context) {
+	var req updateLogLevelsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	// Parse everything first so an invalid entry doesn't leave a partial update behind
+	var defaultLevel log.Level
+	if req.Level != "" {
+		level, err := logger.ParseLevel(req.Level)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defaultLevel = level
+	}
+
+	overrides := make(map[string]log.Level, len(req.Components))
+	for name, value := range req.Components {
+		if value == "" {
+			continue
+		}
+		level, err := logger.ParseLevel(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "component " + name + ": " + err.Error()})
+			return
+		}
+		overrides[name] = level
+	}
+
+	if req.Level != "" {
+		h.levels.SetDefault(defaultLevel)
+	}
+	for name, value := range req.Components {
+		if value == "" {
+			h.levels.ResetComponent(name)
+			continue
+		}
+		h.levels.SetComponent(name, overrides[name])
+	}
+
+	snapshot := h.snapshot()
+	h.logger.Warn().
+		Str("level", snapshot.Level).
+		Interface("components", snapshot.Components).
+		Msg("Log levels changed at runtime")
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+func (h *LogLevelHandler) snapshot() logLevelsResponse {
+	components := make(map[string]string)
+	for name, level := range h.levels.Components() {
+		components[name] = level.String()
+	}
+
+	return logLevelsResponse{
+		Level:      h.levels.Default().String(),
+		Components: components,
+	}
+}
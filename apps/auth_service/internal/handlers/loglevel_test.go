@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phuslu/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+func setupLogLevelRouter() (*gin.Engine, *logger.Levels) {
+	gin.SetMode(gin.TestMode)
+
+	testLogger := logger.New(&bytes.Buffer{}, log.InfoLevel)
+	handler := NewLogLevelHandler(testLogger.Levels(), testLogger)
+
+	router := gin.New()
+	router.GET("/admin/log-level", handler.Get)
+	router.PUT("/admin/log-level", handler.Update)
+
+	return router, testLogger.Levels()
+}
+
+func TestLogLevelHandler_Get(t *testing.T) {
+	router, levels := setupLogLevelRouter()
+	levels.SetComponent("oidc", log.DebugLevel)
+
+	req := httptest.NewRequest("GET", "/admin/log-level", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp logLevelsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "info", resp.Level)
+	assert.Equal(t, map[string]string{"oidc": "debug"}, resp.Components)
+}
+
+func TestLogLevelHandler_Update(t *testing.T) {
+	router, levels := setupLogLevelRouter()
+	levels.SetComponent("http", log.WarnLevel)
+
+	body := `{"level":"warn","components":{"oidc":"debug","http":""}}`
+	req := httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, log.WarnLevel, levels.Default())
+	assert.Equal(t, log.DebugLevel, levels.Level("oidc"))
+	assert.Equal(t, log.WarnLevel, levels.Level("http"), "reset component should fall back to default")
+	assert.NotContains(t, levels.Components(), "http")
+}
+
+func TestLogLevelHandler_Update_InvalidLevel(t *testing.T) {
+	router, levels := setupLogLevelRouter()
+
+	body := `{"level":"debug","components":{"oidc":"loud"}}`
+	req := httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "oidc")
+	assert.Equal(t, log.InfoLevel, levels.Default(), "invalid request must not apply a partial update")
+}
+
+func TestLogLevelHandler_Update_InvalidBody(t *testing.T) {
+	router, _ := setupLogLevelRouter()
+
+	req := httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader("not json"))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+	"github.com/carlosealves2/short-stream/authservice/internal/oidc"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// pkceValuePattern matches a PKCE code verifier, and an S256 challenge (RFC 7636)
+var pkceValuePattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+// NativeAuthorize starts the token-response login of a registered native
+// client. The app sends client_id, one of the client's registered
+// redirect_uri, an S256 code_challenge and optionally its own state; the user
+// then logs in at the provider as usual, and Callback redirects to
+// redirect_uri with a one-time code instead of setting cookies.
+func (h *AuthHandler) NativeAuthorize(c *gin.Context) {
+	client, ok := h.nativeClient(c.Query("client_id"))
+	if !ok {
+		h.logger.Warn().Msg("Native authorization for an unknown client")
+		nativeError(c, http.StatusBadRequest, "invalid_client", "unknown client_id")
+		return
+	}
+
+	redirectURI := c.Query("redirect_uri")
+	if !client.AllowsRedirect(redirectURI) {
+		h.logger.Warn().Str("client_id", client.ID).Msg("Native authorization with an unregistered redirect_uri")
+		nativeError(c, http.StatusBadRequest, "invalid_request", "redirect_uri is not registered for this client")
+		return
+	}
+
+	challenge := c.Query("code_challenge")
+	if c.Query("code_challenge_method") != "S256" || !pkceValuePattern.MatchString(challenge) {
+		nativeError(c, http.StatusBadRequest, "invalid_request", "code_challenge with code_challenge_method S256 is required")
+		return
+	}
+
+	state, err := h.store.CreateState(c.Request.Context(), storage.StateData{
+		Native: &storage.NativeAuthorization{
+			ClientID:      client.ID,
+			RedirectURI:   redirectURI,
+			CodeChallenge: challenge,
+			AppState:      c.Query("state"),
+		},
+	})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to create state")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create state"})
+		return
+	}
+
+	authURL, err := h.oidcClient.GetAuthURL(state)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to build authorization URL")
+		h.providerUnavailable(c)
+		return
+	}
+
+	h.logger.Info().Str("client_id", client.ID).Msg("Redirecting native client to OIDC provider")
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// completeNativeLogin finishes a login started by NativeAuthorize: the tokens
+// are parked under a one-time code the app redeems at NativeToken, and the
+// browser returns to the app's redirect URI
+func (h *AuthHandler) completeNativeLogin(c *gin.Context, auth storage.NativeAuthorization, sessionID string, token *oauth2.Token, idToken string) {
+	auth.SessionID = sessionID
+	auth.AccessToken = token.AccessToken
+	auth.IDToken = idToken
+	auth.Expiry = token.Expiry
+
+	code, err := h.store.CreateState(c.Request.Context(), storage.StateData{Native: &auth})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to create native authorization code")
+		if err := h.store.DeleteSession(c.Request.Context(), sessionID); err != nil {
+			h.logger.Error().Err(err).Str("session_id", sessionID).Msg("Failed to delete session")
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+		return
+	}
+
+	target, err := url.Parse(auth.RedirectURI)
+	if err != nil {
+		// Registered redirect URIs are validated at startup
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid redirect_uri"})
+		return
+	}
+	query := target.Query()
+	query.Set("code", code)
+	if auth.AppState != "" {
+		query.Set("state", auth.AppState)
+	}
+	target.RawQuery = query.Encode()
+
+	h.logger.Info().Str("session_id", sessionID).Str("client_id", auth.ClientID).Msg("Native client authenticated successfully")
+	c.Redirect(http.StatusFound, target.String())
+}
+
+// NativeToken is the token endpoint of native clients. It takes form
+// parameters like an OAuth 2.0 token endpoint:
+//
+//   - grant_type=authorization_code with code, client_id, redirect_uri and
+//     code_verifier redeems the code from the login redirect;
+//   - grant_type=refresh_token with refresh_token and client_id refreshes
+//     the tokens.
+//
+// Both answer with the tokens as JSON. The refresh_token handed out is the
+// session ID, a bearer credential for the session kept by this service; the
+// provider's refresh token never leaves the store.
+func (h *AuthHandler) NativeToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := h.nativeClient(c.PostForm("client_id"))
+	if !ok {
+		nativeError(c, http.StatusUnauthorized, "invalid_client", "unknown client_id")
+		return
+	}
+
+	switch c.PostForm("grant_type") {
+	case "authorization_code":
+		h.nativeCodeGrant(c, client)
+	case "refresh_token":
+		h.nativeRefreshGrant(c, client)
+	default:
+		nativeError(c, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+}
+
+func (h *AuthHandler) nativeCodeGrant(c *gin.Context, client config.NativeClient) {
+	data, err := h.store.ValidateState(c.Request.Context(), c.PostForm("code"))
+	if err != nil || data.Native == nil || data.Native.SessionID == "" {
+		h.logger.Warn().Str("client_id", client.ID).Msg("Invalid native authorization code")
+		nativeError(c, http.StatusBadRequest, "invalid_grant", "invalid or expired code")
+		return
+	}
+	auth := data.Native
+
+	session, err := h.store.GetSession(c.Request.Context(), auth.SessionID)
+	if err != nil {
+		h.logger.Warn().Err(err).Str("session_id", auth.SessionID).Msg("Session of native authorization code is gone")
+		nativeError(c, http.StatusBadRequest, "invalid_grant", "invalid or expired code")
+		return
+	}
+
+	if auth.ClientID != client.ID || auth.RedirectURI != c.PostForm("redirect_uri") ||
+		!pkceMatches(c.PostForm("code_verifier"), auth.CodeChallenge) {
+		// The code is spent either way; a failed redemption may be an
+		// intercepted code, so the session it was issued for ends too
+		h.logger.Warn().Str("client_id", client.ID).Str("session_id", session.ID).Msg("Native authorization code redeemed with wrong client, redirect_uri or code_verifier")
+		h.auditLoginFailed(c, session.UserID, events.RevokedCodeMismatch)
+		h.endSession(c, session, events.RevokedCodeMismatch)
+		nativeError(c, http.StatusBadRequest, "invalid_grant", "client_id, redirect_uri or code_verifier does not match")
+		return
+	}
+
+	nativeTokenResponse(c, auth.AccessToken, auth.IDToken, auth.Expiry, session)
+}
+
+func (h *AuthHandler) nativeRefreshGrant(c *gin.Context, client config.NativeClient) {
+	sessionID := c.PostForm("refresh_token")
+	stored, err := h.store.GetSession(c.Request.Context(), sessionID)
+	if sessionID == "" || err != nil {
+		h.logger.Warn().Msg("Invalid or expired session in native refresh")
+		h.auditRefreshFailed(c, storage.Session{ID: sessionID}, "invalid_session")
+		nativeError(c, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh_token")
+		return
+	}
+
+	// A session is refreshed only by the client it was issued to, so a leaked
+	// refresh_token is useless to other clients and browser sessions cannot
+	// be refreshed here
+	if stored.ClientID != client.ID {
+		h.logger.Warn().Str("session_id", sessionID).Str("client_id", client.ID).Msg("Native refresh by another client, ending session")
+		h.auditRefreshFailed(c, stored, events.RevokedClientMismatch)
+		h.publishSuspiciousRefresh(c, stored, events.RevokedClientMismatch)
+		h.endSession(c, stored, events.RevokedClientMismatch)
+		nativeError(c, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh_token")
+		return
+	}
+
+	// Remember-me sessions belong to a browser
+	if !sameDevice(c, stored) {
+		h.logger.Warn().Str("session_id", sessionID).Msg("Native refresh of a device-bound session, ending session")
+		h.auditRefreshFailed(c, stored, events.RevokedDeviceMismatch)
+		h.publishSuspiciousRefresh(c, stored, events.RevokedDeviceMismatch)
+		h.endSession(c, stored, events.RevokedDeviceMismatch)
+		nativeError(c, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh_token")
+		return
+	}
+
+	newToken, err := h.oidcClient.RefreshToken(c.Request.Context(), stored.RefreshToken)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to refresh token")
+		var retrieveErr *oauth2.RetrieveError
+		switch {
+		case errors.Is(err, oidc.ErrProviderUnavailable):
+			h.auditRefreshFailed(c, stored, "provider_unavailable")
+			h.providerUnavailable(c)
+		case errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant":
+			// The provider ended the session; the app has to log in again
+			h.auditRefreshFailed(c, stored, "session_ended_at_provider")
+			h.endSession(c, stored, events.RevokedAtProvider)
+			nativeError(c, http.StatusBadRequest, "invalid_grant", "session ended at the identity provider")
+		default:
+			h.auditRefreshFailed(c, stored, "provider_refresh_failed")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		}
+		return
+	}
+
+	rotated := ""
+	if newToken.RefreshToken != "" && newToken.RefreshToken != stored.RefreshToken {
+		rotated = newToken.RefreshToken
+	}
+	session, err := h.store.UpdateSession(c.Request.Context(), sessionID, rotated)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to update session")
+		h.auditRefreshFailed(c, stored, "session_update_failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update session"})
+		return
+	}
+
+	idToken, _ := newToken.Extra("id_token").(string)
+	h.logger.Info().Str("session_id", sessionID).Msg("Native token refreshed successfully")
+	nativeTokenResponse(c, newToken.AccessToken, idToken, newToken.Expiry, session)
+}
+
+// nativeClient returns the registered native client with id
+func (h *AuthHandler) nativeClient(id string) (config.NativeClient, bool) {
+	if h.native == nil || id == "" {
+		return config.NativeClient{}, false
+	}
+	return h.native.Client(id)
+}
+
+func nativeTokenResponse(c *gin.Context, accessToken, idToken string, expiry time.Time, session storage.Session) {
+	response := gin.H{
+		"access_token":       accessToken,
+		"token_type":         "Bearer",
+		"expires_in":         int(time.Until(expiry).Seconds()),
+		"refresh_token":      session.ID,
+		"refresh_expires_in": int(time.Until(session.ExpiresAt).Seconds()),
+	}
+	if idToken != "" {
+		response["id_token"] = idToken
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// nativeError answers with an OAuth 2.0 error (RFC 6749 section 5.2)
+func nativeError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// pkceMatches reports whether verifier hashes to the S256 challenge
+func pkceMatches(verifier, challenge string) bool {
+	if !pkceValuePattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
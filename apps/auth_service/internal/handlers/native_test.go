@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
+)
+
+const (
+	nativeClientID    = "ios"
+	nativeRedirectURI = "com.shortstream.ios:/oauth2redirect"
+	nativeVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func nativeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// setupNativeHandler returns a handler with a registered native client, a
+// memory store and a router serving the login and native routes
+func setupNativeHandler(t *testing.T) (*AuthHandler, storage.Store, *mocks.MockOIDCServer, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.store = store
+	WithNativeClients(&config.NativeConfig{Clients: []config.NativeClient{
+		{ID: nativeClientID, RedirectURIs: []string{nativeRedirectURI}},
+	}})(handler)
+
+	router := gin.New()
+	router.GET("/auth/callback", handler.Callback)
+	router.GET("/auth/native/authorize", handler.NativeAuthorize)
+	router.POST("/auth/native/token", handler.NativeToken)
+	return handler, store, mockServer, router
+}
+
+func nativeAuthorizeQuery(overrides map[string]string) string {
+	query := url.Values{
+		"client_id":             {nativeClientID},
+		"redirect_uri":          {nativeRedirectURI},
+		"code_challenge":        {nativeChallenge(nativeVerifier)},
+		"code_challenge_method": {"S256"},
+		"state":                 {"app-state"},
+	}
+	for key, value := range overrides {
+		query.Set(key, value)
+	}
+	return query.Encode()
+}
+
+// nativeLogin runs the authorize and callback steps and returns the redirect
+// to the app
+func nativeLogin(t *testing.T, router *gin.Engine) *url.URL {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/native/authorize?"+nativeAuthorizeQuery(nil), nil))
+	require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+	providerURL, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/callback?code=mock-auth-code&state="+providerURL.Query().Get("state"), nil))
+	require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+	assert.Empty(t, w.Result().Cookies(), "native logins set no cookies")
+
+	appURL, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	return appURL
+}
+
+func nativeTokenRequest(t *testing.T, router *gin.Engine, form url.Values) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/auth/native/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w.Code, body
+}
+
+func codeGrant(code, verifier string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {nativeClientID},
+		"redirect_uri":  {nativeRedirectURI},
+		"code":          {code},
+		"code_verifier": {verifier},
+	}
+}
+
+func TestAuthHandler_NativeFlow(t *testing.T) {
+	_, store, mockServer, router := setupNativeHandler(t)
+	defer mockServer.Close()
+
+	appURL := nativeLogin(t, router)
+	assert.Equal(t, "com.shortstream.ios", appURL.Scheme)
+	assert.Equal(t, "/oauth2redirect", appURL.Path)
+	assert.Equal(t, "app-state", appURL.Query().Get("state"))
+	code := appURL.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	status, body := nativeTokenRequest(t, router, codeGrant(code, nativeVerifier))
+	require.Equal(t, http.StatusOK, status, body)
+	assert.NotEmpty(t, body["access_token"])
+	assert.NotEmpty(t, body["id_token"])
+	assert.Equal(t, "Bearer", body["token_type"])
+	assert.Greater(t, body["expires_in"], float64(0))
+	assert.Greater(t, body["refresh_expires_in"], float64(0))
+
+	sessionID, _ := body["refresh_token"].(string)
+	session, err := store.GetSession(context.Background(), sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "test-user", session.UserID)
+	assert.NotEqual(t, sessionID, session.RefreshToken, "the provider refresh token stays in the store")
+	assert.Equal(t, nativeClientID, session.ClientID, "the session is bound to its client")
+
+	// Codes are single use
+	status, body = nativeTokenRequest(t, router, codeGrant(code, nativeVerifier))
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", body["error"])
+
+	status, body = nativeTokenRequest(t, router, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {nativeClientID},
+		"refresh_token": {sessionID},
+	})
+	require.Equal(t, http.StatusOK, status, body)
+	assert.NotEmpty(t, body["access_token"])
+	assert.Equal(t, sessionID, body["refresh_token"])
+}
+
+func TestAuthHandler_NativeToken_WrongVerifierEndsSession(t *testing.T) {
+	_, store, mockServer, router := setupNativeHandler(t)
+	defer mockServer.Close()
+
+	code := nativeLogin(t, router).Query().Get("code")
+	data, err := store.ValidateState(context.Background(), code)
+	require.NoError(t, err)
+	sessionID := data.Native.SessionID
+	// Put the code back, as peeking consumed it
+	code, err = store.CreateState(context.Background(), data)
+	require.NoError(t, err)
+
+	status, body := nativeTokenRequest(t, router, codeGrant(code, strings.Repeat("x", 43)))
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", body["error"])
+
+	_, err = store.GetSession(context.Background(), sessionID)
+	assert.Error(t, err, "an intercepted code must not leave a usable session")
+}
+
+func TestAuthHandler_NativeRefresh_OtherClientEndsSession(t *testing.T) {
+	handler, store, mockServer, router := setupNativeHandler(t)
+	defer mockServer.Close()
+	WithNativeClients(&config.NativeConfig{Clients: []config.NativeClient{
+		{ID: nativeClientID, RedirectURIs: []string{nativeRedirectURI}},
+		{ID: "android", RedirectURIs: []string{"com.shortstream.android:/oauth2redirect"}},
+	}})(handler)
+
+	code := nativeLogin(t, router).Query().Get("code")
+	status, body := nativeTokenRequest(t, router, codeGrant(code, nativeVerifier))
+	require.Equal(t, http.StatusOK, status, body)
+	sessionID, _ := body["refresh_token"].(string)
+
+	status, body = nativeTokenRequest(t, router, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {"android"},
+		"refresh_token": {sessionID},
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", body["error"])
+
+	_, err := store.GetSession(context.Background(), sessionID)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "a refresh_token presented by another client is treated as leaked")
+}
+
+func TestAuthHandler_NativeRefresh_RejectsBrowserSession(t *testing.T) {
+	_, store, mockServer, router := setupNativeHandler(t)
+	defer mockServer.Close()
+
+	sessionID, err := store.CreateSession(context.Background(), storage.NewSession{UserID: "test-user", RefreshToken: "mock-refresh-token"})
+	require.NoError(t, err)
+
+	status, body := nativeTokenRequest(t, router, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {nativeClientID},
+		"refresh_token": {sessionID},
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", body["error"])
+}
+
+func TestAuthHandler_Refresh_RejectsNativeSession(t *testing.T) {
+	handler, store, mockServer, router := setupNativeHandler(t)
+	defer mockServer.Close()
+	router.POST("/auth/refresh", handler.Refresh)
+
+	code := nativeLogin(t, router).Query().Get("code")
+	status, body := nativeTokenRequest(t, router, codeGrant(code, nativeVerifier))
+	require.Equal(t, http.StatusOK, status, body)
+	sessionID, _ := body["refresh_token"].(string)
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/refresh", http.NoBody)
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	_, err := store.GetSession(context.Background(), sessionID)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "a native session presented as a cookie is treated as leaked")
+}
+
+func TestAuthHandler_NativeToken_Errors(t *testing.T) {
+	_, _, mockServer, router := setupNativeHandler(t)
+	defer mockServer.Close()
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+		error  string
+	}{
+		{"unknown client", url.Values{"grant_type": {"authorization_code"}, "client_id": {"web"}}, http.StatusUnauthorized, "invalid_client"},
+		{"unsupported grant", url.Values{"grant_type": {"password"}, "client_id": {nativeClientID}}, http.StatusBadRequest, "unsupported_grant_type"},
+		{"unknown code", codeGrant("unknown", nativeVerifier), http.StatusBadRequest, "invalid_grant"},
+		{"unknown session", url.Values{"grant_type": {"refresh_token"}, "client_id": {nativeClientID}, "refresh_token": {"unknown"}}, http.StatusBadRequest, "invalid_grant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := nativeTokenRequest(t, router, tt.form)
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.error, body["error"])
+		})
+	}
+}
+
+func TestAuthHandler_NativeAuthorize_Validation(t *testing.T) {
+	_, _, mockServer, router := setupNativeHandler(t)
+	defer mockServer.Close()
+
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		error     string
+	}{
+		{"unknown client", map[string]string{"client_id": "web"}, "invalid_client"},
+		{"unregistered redirect", map[string]string{"redirect_uri": "com.evil.app:/cb"}, "invalid_request"},
+		{"missing challenge", map[string]string{"code_challenge": ""}, "invalid_request"},
+		{"plain challenge", map[string]string{"code_challenge_method": "plain"}, "invalid_request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/native/authorize?"+nativeAuthorizeQuery(tt.overrides), nil))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.error)
+			assert.Empty(t, w.Header().Get("Location"), "never redirect to an unvalidated URI")
+		})
+	}
+}
+
+func TestAuthHandler_Callback_RejectsNativeCode(t *testing.T) {
+	_, _, mockServer, router := setupNativeHandler(t)
+	defer mockServer.Close()
+
+	code := nativeLogin(t, router).Query().Get("code")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/callback?code=mock-auth-code&state="+code, nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid state")
+}
+
+func TestAuthHandler_Native_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/auth/native/authorize?"+nativeAuthorizeQuery(nil), nil)
+	handler.NativeAuthorize(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_client")
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// sessionRef stands in for a session ID in records that outlive the session
+// and are read by other services or operators. It is a keyed hash, so records
+// of one session can be correlated without any of them holding a usable
+// session ID; without a key no reference is recorded at all.
+func sessionRef(key, sessionID string) string {
+	if key == "" || sessionID == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(sessionID))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// sessionRef returns the reference events and audit entries record for
+// sessionID
+func (h *AuthHandler) sessionRef(sessionID string) string {
+	return sessionRef(h.appConfig.SessionHashKey, sessionID)
+}
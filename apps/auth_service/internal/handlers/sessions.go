@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/audit"
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+// TokenRevoker revokes tokens at the identity provider (RFC 7009)
+type TokenRevoker interface {
+	RevokeToken(ctx context.Context, token, tokenTypeHint string) error
+}
+
+// SessionAdminHandler lets operators inspect and end user sessions, e.g. when
+// responding to an account compromise
+type SessionAdminHandler struct {
+	store    storage.Store
+	users    storage.UserStore
+	revoker  TokenRevoker
+	events   EventPublisher
+	auditLog AuditSink
+	// sessionHashKey keys the session references in events and audit entries
+	sessionHashKey string
+	logger         logger.Logger
+}
+
+// SessionAdminOption configures optional SessionAdminHandler behavior
+type SessionAdminOption func(*SessionAdminHandler)
+
+// WithAdminEventPublisher publishes a session.revoked event for every session
+// an operator ends
+func WithAdminEventPublisher(publisher EventPublisher) SessionAdminOption {
+	return func(h *SessionAdminHandler) {
+		h.events = publisher
+	}
+}
+
+// WithAdminSessionHashKey sets the key of the session references in events
+// and audit entries, which must match the AuthHandler's so both can be
+// correlated
+func WithAdminSessionHashKey(key string) SessionAdminOption {
+	return func(h *SessionAdminHandler) {
+		h.sessionHashKey = key
+	}
+}
+
+// WithAdminAuditSink records every revocation, successful or not, in auditLog
+func WithAdminAuditSink(auditLog AuditSink) SessionAdminOption {
+	return func(h *SessionAdminHandler) {
+		h.auditLog = auditLog
+	}
+}
+
+// WithAdminUserStore makes RevokeUserSessions end the sessions of every
+// identity linked to the user, not only those of the given subject
+func WithAdminUserStore(users storage.UserStore) SessionAdminOption {
+	return func(h *SessionAdminHandler) {
+		h.users = users
+	}
+}
+
+// NewSessionAdminHandler creates a new SessionAdminHandler. revoker may be nil,
+// in which case provider revocation requests are reported as failed.
+func NewSessionAdminHandler(store storage.Store, revoker TokenRevoker, log logger.Logger, opts ...SessionAdminOption) *SessionAdminHandler {
+	h := &SessionAdminHandler{
+		store:   store,
+		revoker: revoker,
+		logger:  log,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+type sessionResponse struct {
+	ID                string    `json:"id"`
+	UserID            string    `json:"user_id"`
+	InternalUserID    string    `json:"internal_user_id,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at"`
+	// Remember is set for long-lived sessions bound to a device
+	Remember bool `json:"remember"`
+	// Impersonator is the support engineer who opened the session as the user
+	Impersonator string `json:"impersonator,omitempty"`
+}
+
+type userSessionsResponse struct {
+	UserID   string            `json:"user_id"`
+	Sessions []sessionResponse `json:"sessions"`
+}
+
+type revokeResponse struct {
+	Revoked int `json:"revoked"`
+	// ProviderRevocationFailed counts refresh tokens the provider did not
+	// revoke; only present when provider revocation was requested
+	ProviderRevocationFailed *int `json:"provider_revocation_failed,omitempty"`
+}
+
+type sessionCountsResponse struct {
+	Sessions int64 `json:"sessions"`
+	Users    int64 `json:"users"`
+}
+
+// ListUserSessions returns the active sessions of a user
+func (h *SessionAdminHandler) ListUserSessions(c *gin.Context) {
+	userID := c.Param("user_id")
+
+	sessions, err := h.store.ListUserSessions(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list user sessions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list sessions"})
+		return
+	}
+
+	resp := userSessionsResponse{UserID: userID, Sessions: make([]sessionResponse, 0, len(sessions))}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, sessionResponse{
+			ID:                session.ID,
+			UserID:            session.UserID,
+			InternalUserID:    session.InternalUserID,
+			CreatedAt:         session.CreatedAt,
+			ExpiresAt:         session.ExpiresAt,
+			AbsoluteExpiresAt: session.AbsoluteExpiresAt,
+			Remember:          session.DeviceHash != "",
+			Impersonator:      session.Impersonator,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// RevokeSession ends one session. With ?revoke_at_provider=true its refresh
+// token is also revoked at the identity provider.
+func (h *SessionAdminHandler) RevokeSession(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	session, err := h.store.GetSession(c.Request.Context(), sessionID)
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error().Err(err).Str("session_id", sessionID).Msg("Failed to load session")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+
+	record := audit.Record{
+		Action:       audit.ActionSessionRevoke,
+		Actor:        audit.ActorAdmin,
+		Subject:      session.UserID,
+		SessionID:    sessionID,
+		Outcome:      audit.OutcomeSuccess,
+		Impersonator: session.Impersonator,
+	}
+	if err := h.store.DeleteSession(c.Request.Context(), sessionID); err != nil {
+		h.logger.Error().Err(err).Str("session_id", sessionID).Msg("Failed to delete session")
+		record.Outcome, record.Reason = audit.OutcomeFailure, "store_error"
+		appendAudit(c, h.auditLog, h.sessionHashKey, h.logger, record)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+
+	resp := revokeResponse{Revoked: 1}
+	h.revokeAtProvider(c, []storage.Session{session}, &resp)
+	h.publishRevoked(c, []storage.Session{session})
+	appendAudit(c, h.auditLog, h.sessionHashKey, h.logger, record)
+
+	h.logger.Warn().Str("session_id", sessionID).Str("user_id", session.UserID).Msg("Session revoked by admin")
+	c.JSON(http.StatusOK, resp)
+}
+
+// RevokeUserSessions ends every session of a user, given by provider subject
+// or internal user ID. With a user store, the sessions of the identities
+// linked to the user end as well. With ?revoke_at_provider=true their refresh
+// tokens are also revoked at the identity provider.
+func (h *SessionAdminHandler) RevokeUserSessions(c *gin.Context) {
+	userID := c.Param("user_id")
+
+	record := audit.Record{
+		Action:  audit.ActionUserSessionsRevoke,
+		Actor:   audit.ActorAdmin,
+		Subject: userID,
+		Outcome: audit.OutcomeSuccess,
+	}
+	sessions, err := h.store.DeleteUserSessions(c.Request.Context(), userID)
+	if err == nil {
+		var linked []storage.Session
+		linked, err = h.deleteLinkedSessions(c.Request.Context(), userID, sessions)
+		sessions = append(sessions, linked...)
+	}
+	if err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to delete user sessions")
+		h.publishRevoked(c, sessions)
+		record.Outcome, record.Reason = audit.OutcomeFailure, "store_error"
+		appendAudit(c, h.auditLog, h.sessionHashKey, h.logger, record)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+
+	resp := revokeResponse{Revoked: len(sessions)}
+	h.revokeAtProvider(c, sessions, &resp)
+	h.publishRevoked(c, sessions)
+	appendAudit(c, h.auditLog, h.sessionHashKey, h.logger, record)
+
+	h.logger.Warn().Str("user_id", userID).Int("sessions", len(sessions)).Msg("User sessions revoked by admin")
+	c.JSON(http.StatusOK, resp)
+}
+
+// deleteLinkedSessions ends the sessions of the identities linked to the user
+// with internal ID userID, or to the users of the revoked sessions of subject
+// userID. It returns the sessions deleted, even on error.
+func (h *SessionAdminHandler) deleteLinkedSessions(ctx context.Context, userID string, revoked []storage.Session) ([]storage.Session, error) {
+	if h.users == nil {
+		return nil, nil
+	}
+
+	internalIDs := []string{userID}
+	seen := make(map[string]bool, len(revoked))
+	for _, session := range revoked {
+		seen[session.ID] = true
+		if session.InternalUserID != "" && !slices.Contains(internalIDs, session.InternalUserID) {
+			internalIDs = append(internalIDs, session.InternalUserID)
+		}
+	}
+
+	var sessions []storage.Session
+	for _, internalID := range internalIDs {
+		user, err := h.users.GetUser(ctx, internalID)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return sessions, err
+		}
+		for _, identity := range user.Identities {
+			listed, err := h.store.ListUserSessions(ctx, identity.Subject)
+			if err != nil {
+				return sessions, err
+			}
+			for _, session := range listed {
+				// Another provider may use the same subject for someone else
+				if session.InternalUserID != user.ID || seen[session.ID] {
+					continue
+				}
+				if err := h.store.DeleteSession(ctx, session.ID); err != nil {
+					return sessions, err
+				}
+				seen[session.ID] = true
+				sessions = append(sessions, session)
+			}
+		}
+	}
+	return sessions, nil
+}
+
+// Counts reports how many sessions and distinct users are active
+func (h *SessionAdminHandler) Counts(c *gin.Context) {
+	counts, err := h.store.CountSessions(c.Request.Context())
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to count sessions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessionCountsResponse{Sessions: counts.Sessions, Users: counts.Users})
+}
+
+// revokeAtProvider revokes the refresh tokens of removed sessions when the
+// request asks for it. The local sessions are already gone, so failures are
+// reported rather than turned into an error response.
+func (h *SessionAdminHandler) revokeAtProvider(c *gin.Context, sessions []storage.Session, resp *revokeResponse) {
+	if revoke, _ := strconv.ParseBool(c.Query("revoke_at_provider")); !revoke {
+		return
+	}
+
+	failed := 0
+	for _, session := range sessions {
+		if session.RefreshToken == "" {
+			continue
+		}
+		if h.revoker == nil {
+			failed++
+			continue
+		}
+		if err := h.revoker.RevokeToken(c.Request.Context(), session.RefreshToken, "refresh_token"); err != nil {
+			h.logger.Error().Err(err).Str("session_id", session.ID).Msg("Failed to revoke refresh token at provider")
+			failed++
+		}
+	}
+	resp.ProviderRevocationFailed = &failed
+}
+
+// publishRevoked reports sessions ended by an operator
+func (h *SessionAdminHandler) publishRevoked(c *gin.Context, sessions []storage.Session) {
+	if h.events == nil {
+		return
+	}
+	for _, session := range sessions {
+		err := h.events.Publish(c.Request.Context(), events.SessionRevoked{
+			UserID:     session.InternalUserID,
+			Subject:    session.UserID,
+			SessionRef: sessionRef(h.sessionHashKey, session.ID),
+			Reason:     events.RevokedAdmin,
+		})
+		if err != nil {
+			h.logger.Error().Err(err).Str("session_id", session.ID).Msg("Failed to publish event")
+		}
+	}
+}
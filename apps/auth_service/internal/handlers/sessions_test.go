@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phuslu/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+type fakeRevoker struct {
+	mu      sync.Mutex
+	revoked []string
+	err     error
+}
+
+func (f *fakeRevoker) RevokeToken(_ context.Context, token, _ string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.revoked = append(f.revoked, token)
+	return nil
+}
+
+func setupSessionAdminRouter(t *testing.T, revoker TokenRevoker, opts ...SessionAdminOption) (*gin.Engine, storage.Store) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler := NewSessionAdminHandler(store, revoker, logger.New(&bytes.Buffer{}, log.InfoLevel), opts...)
+
+	router := gin.New()
+	router.GET("/admin/sessions/stats", handler.Counts)
+	router.DELETE("/admin/sessions/:session_id", handler.RevokeSession)
+	router.GET("/admin/users/:user_id/sessions", handler.ListUserSessions)
+	router.DELETE("/admin/users/:user_id/sessions", handler.RevokeUserSessions)
+
+	return router, store
+}
+
+func createTestSession(t *testing.T, store storage.Store, userID, refreshToken string) string {
+	t.Helper()
+	sessionID, err := store.CreateSession(context.Background(), storage.NewSession{UserID: userID, RefreshToken: refreshToken})
+	require.NoError(t, err)
+	return sessionID
+}
+
+func TestSessionAdminHandler_ListUserSessions(t *testing.T) {
+	router, store := setupSessionAdminRouter(t, nil)
+	sessionID := createTestSession(t, store, "user-1", "refresh-1")
+	createTestSession(t, store, "user-2", "refresh-2")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/users/user-1/sessions", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "refresh-1", "refresh tokens are never exposed")
+
+	var resp userSessionsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "user-1", resp.UserID)
+	require.Len(t, resp.Sessions, 1)
+	assert.Equal(t, sessionID, resp.Sessions[0].ID)
+	assert.False(t, resp.Sessions[0].Remember)
+}
+
+func TestSessionAdminHandler_ListUserSessions_Empty(t *testing.T) {
+	router, _ := setupSessionAdminRouter(t, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/users/nobody/sessions", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"user_id":"nobody","sessions":[]}`, w.Body.String())
+}
+
+func TestSessionAdminHandler_RevokeSession(t *testing.T) {
+	revoker := &fakeRevoker{}
+	router, store := setupSessionAdminRouter(t, revoker)
+	sessionID := createTestSession(t, store, "user-1", "refresh-1")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/sessions/"+sessionID, nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"revoked":1}`, w.Body.String())
+	assert.Empty(t, revoker.revoked, "provider revocation is opt-in")
+
+	_, err := store.GetSession(context.Background(), sessionID)
+	assert.Error(t, err)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/sessions/"+sessionID, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSessionAdminHandler_RevokeUserSessions_AtProvider(t *testing.T) {
+	revoker := &fakeRevoker{}
+	router, store := setupSessionAdminRouter(t, revoker)
+	createTestSession(t, store, "user-1", "refresh-1")
+	createTestSession(t, store, "user-1", "refresh-2")
+	other := createTestSession(t, store, "user-2", "refresh-3")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/users/user-1/sessions?revoke_at_provider=true", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"revoked":2,"provider_revocation_failed":0}`, w.Body.String())
+	assert.ElementsMatch(t, []string{"refresh-1", "refresh-2"}, revoker.revoked)
+
+	sessions, err := store.ListUserSessions(context.Background(), "user-1")
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	_, err = store.GetSession(context.Background(), other)
+	assert.NoError(t, err)
+}
+
+func TestSessionAdminHandler_RevokeUserSessions_LinkedIdentities(t *testing.T) {
+	const issuerA, issuerB = "https://idp-a.example.com", "https://idp-b.example.com"
+	users := storage.NewMemoryUserStore(storage.WithEmailLinking(issuerA, issuerB))
+	router, store := setupSessionAdminRouter(t, nil, WithAdminUserStore(users))
+	ctx := context.Background()
+
+	user, _, err := users.LinkIdentity(ctx, storage.Identity{Provider: issuerA, Subject: "sub-a", Email: "user@example.com", EmailVerified: true})
+	require.NoError(t, err)
+	linked, _, err := users.LinkIdentity(ctx, storage.Identity{Provider: issuerB, Subject: "sub-b", Email: "user@example.com", EmailVerified: true})
+	require.NoError(t, err)
+	require.Equal(t, user.ID, linked.ID)
+
+	newSession := func(subject, internalUserID string) string {
+		sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: subject, InternalUserID: internalUserID, RefreshToken: "refresh"})
+		require.NoError(t, err)
+		return sessionID
+	}
+	newSession("sub-a", user.ID)
+	linkedSession := newSession("sub-b", user.ID)
+	// Another provider's user with the same subject
+	other := newSession("sub-b", "someone-else")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/users/sub-a/sessions", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"revoked":2}`, w.Body.String())
+	_, err = store.GetSession(ctx, linkedSession)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "sessions of linked identities end too")
+	_, err = store.GetSession(ctx, other)
+	assert.NoError(t, err)
+
+	// By internal ID, with no session under the subject revoked
+	linkedSession = newSession("sub-b", user.ID)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/users/"+user.ID+"/sessions", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"revoked":1}`, w.Body.String())
+	_, err = store.GetSession(ctx, linkedSession)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+	_, err = store.GetSession(ctx, other)
+	assert.NoError(t, err)
+}
+
+func TestSessionAdminHandler_RevokeUserSessions_ProviderFailure(t *testing.T) {
+	router, store := setupSessionAdminRouter(t, &fakeRevoker{err: errors.New("provider down")})
+	createTestSession(t, store, "user-1", "refresh-1")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/users/user-1/sessions?revoke_at_provider=true", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code, "local sessions are revoked even if the provider fails")
+	assert.JSONEq(t, `{"revoked":1,"provider_revocation_failed":1}`, w.Body.String())
+}
+
+func TestSessionAdminHandler_Counts(t *testing.T) {
+	router, store := setupSessionAdminRouter(t, nil)
+	createTestSession(t, store, "user-1", "refresh-1")
+	createTestSession(t, store, "user-1", "refresh-2")
+	createTestSession(t, store, "user-2", "refresh-3")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/sessions/stats", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"sessions":3,"users":2}`, w.Body.String())
+}
+
+func TestSessionAdminHandler_StoreError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockStore := new(mocks.MockStore)
+	mockStore.On("CountSessions", mock.Anything).Return(storage.SessionCounts{}, errors.New("redis down"))
+	handler := NewSessionAdminHandler(mockStore, nil, logger.New(&bytes.Buffer{}, log.InfoLevel))
+
+	router := gin.New()
+	router.GET("/admin/sessions/stats", handler.Counts)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/sessions/stats", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "failed to count sessions")
+}
+
+func TestSessionAdminHandler_RevokeSession_StoreError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockStore := new(mocks.MockStore)
+	mockStore.On("GetSession", mock.Anything, "session-1").Return(storage.Session{}, errors.New("redis down"))
+	handler := NewSessionAdminHandler(mockStore, nil, logger.New(&bytes.Buffer{}, log.InfoLevel))
+
+	router := gin.New()
+	router.DELETE("/admin/sessions/:session_id", handler.RevokeSession)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/sessions/session-1", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code, "only a missing session is a 404")
+	mockStore.AssertNotCalled(t, "DeleteSession", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/oidc"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// maxACRValuesLength bounds the acr_values login parameter
+const maxACRValuesLength = 256
+
+// errMaxAgeExceeded is a login whose authentication is older than the
+// max_age it was started with
+var errMaxAgeExceeded = errors.New("authentication older than max_age")
+
+// loginRequirements reads the acr_values and max_age login parameters into
+// data and returns the matching authorization request options
+func loginRequirements(c *gin.Context, data *storage.StateData) ([]oauth2.AuthCodeOption, error) {
+	var opts []oauth2.AuthCodeOption
+
+	if acrValues := c.Query("acr_values"); acrValues != "" {
+		if len(acrValues) > maxACRValuesLength {
+			return nil, fmt.Errorf("acr_values must be at most %d characters", maxACRValuesLength)
+		}
+		data.ACRValues = acrValues
+		opts = append(opts, oidc.WithACRValues(acrValues))
+	}
+
+	if raw := c.Query("max_age"); raw != "" {
+		maxAge, err := strconv.Atoi(raw)
+		if err != nil || maxAge < 0 {
+			return nil, errors.New("max_age must be a non-negative number of seconds")
+		}
+		data.MaxAge = &maxAge
+		opts = append(opts, oidc.WithMaxAge(time.Duration(maxAge)*time.Second))
+	}
+
+	return opts, nil
+}
+
+// sessionAuthContext reads how the user authenticated from idToken and, when
+// the login asked for a max_age, checks the authentication is recent enough.
+// The acr is stored as is: which acr values satisfy which is up to the
+// provider, and services enforce the level they need.
+func (h *AuthHandler) sessionAuthContext(idToken *gooidc.IDToken, data storage.StateData) (storage.AuthContext, error) {
+	auth, err := oidc.ReadAuthContext(idToken)
+	if err != nil {
+		return storage.AuthContext{}, err
+	}
+	if data.MaxAge != nil && !auth.AuthenticatedWithin(time.Duration(*data.MaxAge)*time.Second, time.Now()) {
+		return storage.AuthContext{}, errMaxAgeExceeded
+	}
+	return storage.AuthContext{ACR: auth.ACR, AMR: auth.AMR, AuthTime: auth.AuthTime}, nil
+}
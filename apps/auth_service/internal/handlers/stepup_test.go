@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// stepUpLogin starts a login with query and returns the provider URL it
+// redirects to
+func stepUpLogin(t *testing.T, router *gin.Engine, query string) *url.URL {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login?"+query, nil))
+	require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	return location
+}
+
+func TestAuthHandler_Login_StepUpParameters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.store = store
+	router := userInfoRouter(handler)
+
+	location := stepUpLogin(t, router, "acr_values=mfa+pwd&max_age=300")
+	assert.Equal(t, "mfa pwd", location.Query().Get("acr_values"))
+	assert.Equal(t, "300", location.Query().Get("max_age"))
+
+	data, err := store.ValidateState(context.Background(), location.Query().Get("state"))
+	require.NoError(t, err)
+	assert.Equal(t, "mfa pwd", data.ACRValues)
+	require.NotNil(t, data.MaxAge)
+	assert.Equal(t, 300, *data.MaxAge)
+
+	// Without them the provider decides
+	location = stepUpLogin(t, router, "")
+	assert.False(t, location.Query().Has("acr_values"))
+	assert.False(t, location.Query().Has("max_age"))
+}
+
+func TestAuthHandler_Login_InvalidStepUpParameters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, mockStore, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	router := userInfoRouter(handler)
+
+	for _, query := range []string{"max_age=-1", "max_age=soon", "acr_values=" + strings.Repeat("a", maxACRValuesLength+1)} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	mockStore.AssertNotCalled(t, "CreateState")
+}
+
+func TestAuthHandler_Callback_StoresAuthContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	handler.store = storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	router := userInfoRouter(handler)
+
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	mockServer.ACR = "mfa"
+	mockServer.AMR = []string{"pwd", "otp"}
+	mockServer.AuthTime = authTime
+
+	sessionID := loginSession(t, router)
+	session, err := handler.store.GetSession(context.Background(), sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "mfa", session.Auth.ACR)
+	assert.Equal(t, []string{"pwd", "otp"}, session.Auth.AMR)
+	assert.True(t, authTime.Equal(session.Auth.AuthTime))
+
+	code, info := getUserInfo(t, router, sessionID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "mfa", info.ACR)
+	assert.Equal(t, []string{"pwd", "otp"}, info.AMR)
+	require.NotNil(t, info.AuthTime)
+	assert.True(t, authTime.Equal(*info.AuthTime))
+}
+
+func TestAuthHandler_Callback_MaxAge(t *testing.T) {
+	tests := []struct {
+		name     string
+		authTime time.Time
+		status   int
+	}{
+		{"recent authentication", time.Now().Add(-time.Minute), http.StatusFound},
+		{"stale authentication", time.Now().Add(-time.Hour), http.StatusUnauthorized},
+		{"no auth_time", time.Time{}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			handler, _, mockServer := setupTestHandler(t)
+			defer mockServer.Close()
+			store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+			handler.store = store
+			router := userInfoRouter(handler)
+			mockServer.AuthTime = tt.authTime
+
+			location := stepUpLogin(t, router, "max_age=300")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/callback?code=mock-auth-code&state="+location.Query().Get("state"), nil))
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+
+			sessions, err := store.ListUserSessions(context.Background(), "test-user")
+			require.NoError(t, err)
+			assert.Equal(t, tt.status == http.StatusFound, len(sessions) == 1)
+		})
+	}
+}
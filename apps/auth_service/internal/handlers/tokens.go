@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/middleware"
+	"github.com/carlosealves2/short-stream/authservice/internal/oidc"
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+// TokenExchanger trades a user's access token for one meant for another
+// audience (RFC 8693)
+type TokenExchanger interface {
+	ExchangeToken(ctx context.Context, subjectToken, audience, scope string) (*oauth2.Token, error)
+}
+
+// ServiceTokenSource issues client credentials tokens for pure service calls,
+// kept apart per calling service
+type ServiceTokenSource interface {
+	Token(ctx context.Context, caller, audience, scope string) (*oauth2.Token, error)
+}
+
+// ServiceTokenHandler serves the internal token API backend services use to
+// call each other, on behalf of a user or as themselves. Callers are
+// authenticated by middleware.ServiceAuth and limited to the audiences and
+// scopes registered for them.
+type ServiceTokenHandler struct {
+	exchanger TokenExchanger
+	source    ServiceTokenSource
+	services  *config.InternalConfig
+	logger    logger.Logger
+}
+
+// NewServiceTokenHandler creates a new ServiceTokenHandler
+func NewServiceTokenHandler(exchanger TokenExchanger, source ServiceTokenSource, services *config.InternalConfig, log logger.Logger) *ServiceTokenHandler {
+	return &ServiceTokenHandler{
+		exchanger: exchanger,
+		source:    source,
+		services:  services,
+		logger:    log,
+	}
+}
+
+type exchangeRequest struct {
+	SubjectToken string `json:"subject_token" binding:"required"`
+	Audience     string `json:"audience"`
+	Scope        string `json:"scope"`
+}
+
+type serviceTokenRequest struct {
+	Audience string `json:"audience"`
+	Scope    string `json:"scope"`
+}
+
+// Exchange trades the user's access token in subject_token for a token meant
+// for audience, so the caller can reach another service on the user's behalf
+func (h *ServiceTokenHandler) Exchange(c *gin.Context) {
+	var req exchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "subject_token is required"})
+		return
+	}
+	service, ok := h.authorize(c, req.Audience, req.Scope)
+	if !ok {
+		return
+	}
+
+	token, err := h.exchanger.ExchangeToken(c.Request.Context(), req.SubjectToken, req.Audience, req.Scope)
+	if err != nil {
+		h.tokenFailed(c, err, "failed to exchange token")
+		return
+	}
+
+	h.logger.Debug().Str("service", service.ID).Str("audience", req.Audience).Msg("Token exchanged")
+	c.JSON(http.StatusOK, serviceTokenResponse(token))
+}
+
+// ServiceToken returns a client credentials token for audience and scope.
+// Tokens are cached per calling service until shortly before they expire.
+func (h *ServiceTokenHandler) ServiceToken(c *gin.Context) {
+	var req serviceTokenRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+
+	service, ok := h.authorize(c, req.Audience, req.Scope)
+	if !ok {
+		return
+	}
+
+	token, err := h.source.Token(c.Request.Context(), service.ID, req.Audience, req.Scope)
+	if err != nil {
+		h.tokenFailed(c, err, "failed to get service token")
+		return
+	}
+
+	c.JSON(http.StatusOK, serviceTokenResponse(token))
+}
+
+// authorize returns the calling service when audience and every requested
+// scope are registered for it; otherwise it answers with the RFC 8693 error
+// code a provider would use
+func (h *ServiceTokenHandler) authorize(c *gin.Context, audience, scope string) (config.InternalService, bool) {
+	var service config.InternalService
+	ok := false
+	if h.services != nil {
+		service, ok = h.services.Service(middleware.ServiceID(c))
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return config.InternalService{}, false
+	}
+
+	if !service.AllowsAudience(audience) {
+		h.logger.Warn().Str("service", service.ID).Str("audience", audience).Msg("Internal service asked for an audience it is not registered for")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_target"})
+		return config.InternalService{}, false
+	}
+	if !service.AllowsScope(scope) {
+		h.logger.Warn().Str("service", service.ID).Str("scope", scope).Msg("Internal service asked for a scope it is not registered for")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+		return config.InternalService{}, false
+	}
+
+	return service, true
+}
+
+// tokenFailed maps a provider error: requests the provider rejected are the
+// caller's fault and keep the provider's error code
+func (h *ServiceTokenHandler) tokenFailed(c *gin.Context, err error, message string) {
+	h.logger.Error().Err(err).Msg(message)
+
+	var retrieveErr *oauth2.RetrieveError
+	switch {
+	case errors.Is(err, oidc.ErrProviderUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "identity provider unavailable"})
+	case errors.As(err, &retrieveErr) && retrieveErr.Response != nil && retrieveErr.Response.StatusCode < http.StatusInternalServerError:
+		code := retrieveErr.ErrorCode
+		if code == "" {
+			code = "invalid_request"
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": code})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+func serviceTokenResponse(token *oauth2.Token) gin.H {
+	response := gin.H{
+		"access_token": token.AccessToken,
+		"token_type":   "Bearer",
+	}
+	if !token.Expiry.IsZero() {
+		response["expires_in"] = int(time.Until(token.Expiry).Seconds())
+	}
+	return response
+}
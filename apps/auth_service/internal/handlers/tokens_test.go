@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phuslu/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/middleware"
+	"github.com/carlosealves2/short-stream/authservice/internal/oidc"
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+type failingTokenSource struct {
+	err error
+}
+
+func (f failingTokenSource) ExchangeToken(context.Context, string, string, string) (*oauth2.Token, error) {
+	return nil, f.err
+}
+
+func (f failingTokenSource) Token(context.Context, string, string, string) (*oauth2.Token, error) {
+	return nil, f.err
+}
+
+// testServices registers the service tokenRouter authenticates requests as
+var testServices = &config.InternalConfig{Services: []config.InternalService{
+	{ID: "video-upload", Token: "upload-token", Audiences: []string{"video-metadata", "billing"}, Scopes: []string{"videos:read"}},
+	{ID: "billing", Token: "billing-token", Audiences: []string{"payments"}},
+}}
+
+func newTestTokenHandler(exchanger TokenExchanger, source ServiceTokenSource) *ServiceTokenHandler {
+	return NewServiceTokenHandler(exchanger, source, testServices, logger.New(&bytes.Buffer{}, log.InfoLevel))
+}
+
+func tokenRouter(handler *ServiceTokenHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	credentials := make([]middleware.ServiceCredential, 0, len(testServices.Services))
+	for _, service := range testServices.Services {
+		token := service.Token
+		credentials = append(credentials, middleware.ServiceCredential{ID: service.ID, Token: func() string { return token }})
+	}
+
+	router := gin.New()
+	router.Use(middleware.ServiceAuth(credentials))
+	router.POST("/internal/token/exchange", handler.Exchange)
+	router.POST("/internal/token/service", handler.ServiceToken)
+	return router
+}
+
+// postTokenJSON posts body as the video-upload service
+func postTokenJSON(t *testing.T, router *gin.Engine, path, body string) (int, map[string]any) {
+	t.Helper()
+	return postTokenJSONAs(t, router, "upload-token", path, body)
+}
+
+func postTokenJSONAs(t *testing.T, router *gin.Engine, token, path, body string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w.Code, response
+}
+
+func TestServiceTokenHandler_Exchange(t *testing.T) {
+	authHandler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+	mockServer.ExchangeAudiences = []string{"video-metadata"}
+
+	client := authHandler.oidcClient
+	router := tokenRouter(newTestTokenHandler(client, oidc.NewServiceTokenCache(client)))
+
+	userToken, err := client.ExchangeCode(context.Background(), "mock-auth-code")
+	require.NoError(t, err)
+
+	code, body := postTokenJSON(t, router, "/internal/token/exchange",
+		fmt.Sprintf(`{"subject_token":%q,"audience":"video-metadata"}`, userToken.AccessToken))
+	require.Equal(t, http.StatusOK, code, body)
+	assert.NotEmpty(t, body["access_token"])
+	assert.NotEqual(t, userToken.AccessToken, body["access_token"])
+	assert.Equal(t, "Bearer", body["token_type"])
+	assert.Greater(t, body["expires_in"], float64(0))
+
+	code, body = postTokenJSON(t, router, "/internal/token/exchange",
+		fmt.Sprintf(`{"subject_token":%q,"audience":"billing"}`, userToken.AccessToken))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_target", body["error"])
+
+	code, body = postTokenJSON(t, router, "/internal/token/exchange", `{"audience":"video-metadata"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "subject_token is required", body["error"])
+}
+
+func TestServiceTokenHandler_ServiceToken(t *testing.T) {
+	authHandler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	client := authHandler.oidcClient
+	router := tokenRouter(newTestTokenHandler(client, oidc.NewServiceTokenCache(client)))
+
+	code, first := postTokenJSON(t, router, "/internal/token/service", `{"audience":"video-metadata"}`)
+	require.Equal(t, http.StatusOK, code, first)
+	assert.NotEmpty(t, first["access_token"])
+	requests := mockServer.TokenRequests()
+
+	code, second := postTokenJSON(t, router, "/internal/token/service", `{"audience":"video-metadata"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, first["access_token"], second["access_token"])
+	assert.Equal(t, requests, mockServer.TokenRequests(), "the service token is cached")
+
+	// Each service gets its own token
+	code, other := postTokenJSONAs(t, router, "billing-token", "/internal/token/service", `{"audience":"payments"}`)
+	require.Equal(t, http.StatusOK, code, other)
+	assert.Equal(t, requests+1, mockServer.TokenRequests(), "services do not share cached tokens")
+
+	// An empty body asks for a token without audience, which no service is
+	// registered for
+	req := httptest.NewRequest("POST", "/internal/token/service", nil)
+	req.Header.Set("Authorization", "Bearer upload-token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_target")
+}
+
+func TestServiceTokenHandler_AllowList(t *testing.T) {
+	source := failingTokenSource{err: errors.New("the provider must not be called")}
+	router := tokenRouter(newTestTokenHandler(source, source))
+
+	tests := []struct {
+		name  string
+		token string
+		path  string
+		body  string
+		error string
+	}{
+		{"exchange for another service's audience", "upload-token", "/internal/token/exchange", `{"subject_token":"token","audience":"payments"}`, "invalid_target"},
+		{"exchange with unregistered scope", "upload-token", "/internal/token/exchange", `{"subject_token":"token","audience":"billing","scope":"videos:read videos:delete"}`, "invalid_scope"},
+		{"service token for another service's audience", "billing-token", "/internal/token/service", `{"audience":"video-metadata"}`, "invalid_target"},
+		{"service token with unregistered scope", "billing-token", "/internal/token/service", `{"audience":"payments","scope":"videos:read"}`, "invalid_scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := postTokenJSONAs(t, router, tt.token, tt.path, tt.body)
+			assert.Equal(t, http.StatusBadRequest, code)
+			assert.Equal(t, tt.error, body["error"])
+		})
+	}
+
+	code, body := postTokenJSONAs(t, router, "unknown-token", "/internal/token/service", `{"audience":"payments"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "unauthorized", body["error"])
+}
+
+func TestServiceTokenHandler_ProviderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"provider unavailable", fmt.Errorf("failed to exchange token: %w", oidc.ErrProviderUnavailable), http.StatusServiceUnavailable},
+		{"provider error", &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusBadGateway}}, http.StatusInternalServerError},
+		{"rejected request without code", &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusUnauthorized}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := failingTokenSource{err: tt.err}
+			router := tokenRouter(newTestTokenHandler(source, source))
+
+			code, _ := postTokenJSON(t, router, "/internal/token/exchange", `{"subject_token":"token","audience":"billing"}`)
+			assert.Equal(t, tt.status, code)
+
+			code, _ = postTokenJSON(t, router, "/internal/token/service", `{"audience":"billing"}`)
+			assert.Equal(t, tt.status, code)
+		})
+	}
+}
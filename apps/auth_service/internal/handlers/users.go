@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// profileClaims are the standard OIDC claims copied into the user record
+type profileClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+}
+
+type userInfoResponse struct {
+	// UserID is the stable internal user ID; empty for sessions created
+	// before user records were kept
+	UserID        string                   `json:"user_id,omitempty"`
+	Subject       string                   `json:"sub"`
+	Email         string                   `json:"email,omitempty"`
+	EmailVerified bool                     `json:"email_verified"`
+	Name          string                   `json:"name,omitempty"`
+	Picture       string                   `json:"picture,omitempty"`
+	CreatedAt     *time.Time               `json:"created_at,omitempty"`
+	Identities    []storage.LinkedIdentity `json:"identities,omitempty"`
+	// ACR, AMR and AuthTime describe how the user logged in to this session,
+	// for frontends deciding whether to step up
+	ACR      string     `json:"acr,omitempty"`
+	AMR      []string   `json:"amr,omitempty"`
+	AuthTime *time.Time `json:"auth_time,omitempty"`
+	// Act names the support engineer of an impersonation session
+	Act *actClaim `json:"act,omitempty"`
+}
+
+// linkUser records the login of the identity in idToken and returns the
+// internal ID of its user, or "" when no user store is configured
+func (h *AuthHandler) linkUser(c *gin.Context, idToken *gooidc.IDToken) (string, error) {
+	if h.users == nil {
+		return "", nil
+	}
+
+	var claims profileClaims
+	if err := idToken.Claims(&claims); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to decode ID token claims")
+		return "", err
+	}
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+
+	user, created, err := h.users.LinkIdentity(c.Request.Context(), storage.Identity{
+		Provider:      idToken.Issuer,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		DisplayName:   name,
+		AvatarURL:     claims.Picture,
+	})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to link user identity")
+		return "", err
+	}
+
+	if created {
+		h.logger.Info().Str("user_id", user.ID).Msg("User registered")
+		h.publish(c, events.UserRegistered{
+			UserID:   user.ID,
+			Provider: idToken.Issuer,
+			Subject:  idToken.Subject,
+			Email:    user.Email,
+		})
+	}
+	return user.ID, nil
+}
+
+// UserInfo returns the user of the session cookie: the internal user ID other
+// services should key data by, the provider subject and the stored profile
+func (h *AuthHandler) UserInfo(c *gin.Context) {
+	sessionID, err := c.Cookie("session_id")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing session"})
+		return
+	}
+
+	session, err := h.store.GetSession(c.Request.Context(), sessionID)
+	if err != nil {
+		h.logger.Warn().Str("session_id", sessionID).Msg("Invalid or expired session in userinfo request")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	resp := userInfoResponse{
+		UserID:  session.InternalUserID,
+		Subject: session.UserID,
+		ACR:     session.Auth.ACR,
+		AMR:     session.Auth.AMR,
+	}
+	if !session.Auth.AuthTime.IsZero() {
+		resp.AuthTime = &session.Auth.AuthTime
+	}
+	if session.Impersonator != "" {
+		resp.Act = &actClaim{Subject: session.Impersonator}
+	}
+	if h.users == nil || session.InternalUserID == "" {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	user, err := h.users.GetUser(c.Request.Context(), session.InternalUserID)
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		// The record is gone (e.g. an in-memory user store restarted)
+		h.logger.Warn().Str("user_id", session.InternalUserID).Msg("User of session not found")
+	case err != nil:
+		h.logger.Error().Err(err).Msg("Failed to get user")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+		return
+	default:
+		resp.Email = user.Email
+		resp.EmailVerified = user.EmailVerified
+		resp.Name = user.DisplayName
+		resp.Picture = user.AvatarURL
+		resp.CreatedAt = &user.CreatedAt
+		resp.Identities = user.Identities
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+func userInfoRouter(handler *AuthHandler) *gin.Engine {
+	router := gin.New()
+	router.GET("/auth/login", handler.Login)
+	router.GET("/auth/callback", handler.Callback)
+	router.GET("/auth/userinfo", handler.UserInfo)
+	return router
+}
+
+// loginSession runs the login and callback and returns the session cookie
+func loginSession(t *testing.T, router *gin.Engine) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login", nil))
+	require.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/auth/callback?code=mock-auth-code&state="+location.Query().Get("state"), nil))
+	require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == cookieSessionID {
+			return cookie.Value
+		}
+	}
+	t.Fatal("no session cookie")
+	return ""
+}
+
+func getUserInfo(t *testing.T, router *gin.Engine, sessionID string) (int, userInfoResponse) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/auth/userinfo", nil)
+	if sessionID != "" {
+		req.AddCookie(&http.Cookie{Name: cookieSessionID, Value: sessionID})
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp userInfoResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	}
+	return w.Code, resp
+}
+
+func TestAuthHandler_Callback_LinksUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	users := storage.NewMemoryUserStore()
+	handler.store = store
+	handler.users = users
+	router := userInfoRouter(handler)
+
+	sessionID := loginSession(t, router)
+	session, err := store.GetSession(context.Background(), sessionID)
+	require.NoError(t, err)
+	require.NotEmpty(t, session.InternalUserID)
+	assert.Equal(t, "test-user", session.UserID)
+
+	code, info := getUserInfo(t, router, sessionID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, session.InternalUserID, info.UserID)
+	assert.Equal(t, "test-user", info.Subject)
+	assert.Equal(t, "test-user@example.com", info.Email)
+	assert.True(t, info.EmailVerified)
+	assert.Equal(t, "Test User", info.Name)
+	require.Len(t, info.Identities, 1)
+	assert.Equal(t, mockServer.Issuer, info.Identities[0].Provider)
+
+	// Logging in again keeps the internal user ID
+	again := loginSession(t, router)
+	_, info = getUserInfo(t, router, again)
+	assert.Equal(t, session.InternalUserID, info.UserID)
+}
+
+func TestAuthHandler_DevicePoll_LinksUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.store = store
+	handler.users = storage.NewMemoryUserStore()
+	router := deviceRouter(handler)
+
+	started := startDevice(t, router)
+	require.True(t, mockServer.ApproveDevice(started["user_code"].(string)))
+	code, body := pollDevice(t, router, started["device_code"].(string))
+	require.Equal(t, http.StatusOK, code, body)
+
+	session, err := store.GetSession(context.Background(), body["session_id"].(string))
+	require.NoError(t, err)
+	assert.NotEmpty(t, session.InternalUserID)
+}
+
+func TestAuthHandler_UserInfo_WithoutUserRecord(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler, _, mockServer := setupTestHandler(t)
+	defer mockServer.Close()
+
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour})
+	handler.store = store
+	handler.users = storage.NewMemoryUserStore()
+	router := userInfoRouter(handler)
+
+	// A session created before user records were kept
+	sessionID, err := store.CreateSession(context.Background(), storage.NewSession{UserID: "legacy-user", RefreshToken: "refresh"})
+	require.NoError(t, err)
+
+	code, info := getUserInfo(t, router, sessionID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Empty(t, info.UserID)
+	assert.Equal(t, "legacy-user", info.Subject)
+
+	code, _ = getUserInfo(t, router, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = getUserInfo(t, router, "unknown-session")
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
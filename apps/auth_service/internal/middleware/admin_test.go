@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupAdminRouter(token string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(BearerAuthFunc(func() string { return token }))
+	router.GET("/admin", func(c *gin.Context) {
+		c.String(200, "ok")
+	})
+	return router
+}
+
+func TestBearerAuthFunc_ValidToken(t *testing.T) {
+	router := setupAdminRouter("admin-secret")
+
+	req := httptest.NewRequest("GET", "/admin", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+}
+
+func TestBearerAuthFunc_RejectsInvalidCredentials(t *testing.T) {
+	router := setupAdminRouter("admin-secret")
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"missing header", ""},
+		{"wrong token", "Bearer wrong"},
+		{"wrong scheme", "Basic admin-secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, 401, w.Code)
+			assert.Contains(t, w.Body.String(), "unauthorized")
+		})
+	}
+}
+
+func TestBearerAuthFunc_EmptyConfiguredToken(t *testing.T) {
+	router := setupAdminRouter("")
+
+	req := httptest.NewRequest("GET", "/admin", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 401, w.Code)
+}
+
+func TestBearerAuthFunc_FollowsRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	token := "v1"
+	router := gin.New()
+	router.POST("/internal", BearerAuthFunc(func() string { return token }), func(c *gin.Context) {
+		c.String(200, "ok")
+	})
+	post := func(credential string) int {
+		req := httptest.NewRequest("POST", "/internal", nil)
+		req.Header.Set("Authorization", "Bearer "+credential)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, 200, post("v1"))
+	token = "v2"
+	assert.Equal(t, 401, post("v1"))
+	assert.Equal(t, 200, post("v2"))
+}
+
+func TestServiceAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ServiceAuth([]ServiceCredential{
+		{ID: "video", Token: func() string { return "video-token" }},
+		{ID: "billing", Token: func() string { return "billing-token" }},
+		{ID: "unconfigured", Token: func() string { return "" }},
+	}))
+	router.GET("/internal", func(c *gin.Context) {
+		c.String(200, ServiceID(c))
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+		caller string
+	}{
+		{"first service", "Bearer video-token", 200, "video"},
+		{"second service", "Bearer billing-token", 200, "billing"},
+		{"unknown token", "Bearer other-token", 401, ""},
+		{"empty token", "Bearer ", 401, ""},
+		{"missing header", "", 401, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/internal", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == 200 {
+				assert.Equal(t, tt.caller, w.Body.String())
+			}
+		})
+	}
+}
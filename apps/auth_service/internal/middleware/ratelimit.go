@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+// RateLimitResult describes the outcome of a single rate limit check
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is the time until the window frees up capacity again
+	ResetAfter time.Duration
+}
+
+// RateLimiter counts requests for a key in a sliding window
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc extracts the identity a policy counts requests for.
+// Returning an empty string skips the policy for that request.
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// RateLimitPolicy configures the limit applied to a route
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	Key    RateLimitKeyFunc
+}
+
+// KeyByIP identifies clients by their IP address
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyBySession identifies clients by their session cookie, so a single
+// session cannot be used to hammer the provider from many addresses
+func KeyBySession(c *gin.Context) string {
+	sessionID, err := c.Cookie("session_id")
+	if err != nil || sessionID == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(sessionID))
+	return "session:" + hex.EncodeToString(sum[:16])
+}
+
+// RateLimit returns a middleware enforcing policy with limiter. When limiter
+// fails (e.g. Redis is unavailable) the request is checked against fallback
+// instead, so limits keep applying per replica in degraded mode.
+func RateLimit(limiter, fallback RateLimiter, policy RateLimitPolicy, log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity := policy.Key(c)
+		if identity == "" {
+			c.Next()
+			return
+		}
+		key := policy.Name + ":" + identity
+
+		result, err := limiter.Allow(c.Request.Context(), key, policy.Limit, policy.Window)
+		if err != nil {
+			log.Warn().Err(err).Str("policy", policy.Name).Msg("Rate limiter unavailable, using local fallback")
+			result, err = fallback.Allow(c.Request.Context(), key, policy.Limit, policy.Window)
+			if err != nil {
+				// Fail open: rate limiting must not take authentication down
+				log.Error().Err(err).Str("policy", policy.Name).Msg("Fallback rate limiter failed")
+				c.Next()
+				return
+			}
+		}
+
+		setRateLimitHeaders(c, result, policy.Window)
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+			log.Warn().
+				Str("policy", policy.Name).
+				Str("ip", c.ClientIP()).
+				Str("path", c.Request.URL.Path).
+				Msg("Rate limit exceeded")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func setRateLimitHeaders(c *gin.Context, result RateLimitResult, window time.Duration) {
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+	c.Header("RateLimit-Policy", strconv.Itoa(result.Limit)+";w="+strconv.Itoa(ceilSeconds(window)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
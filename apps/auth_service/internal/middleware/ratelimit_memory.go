@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery controls how often the memory limiter drops idle keys
+const sweepEvery = 1024
+
+type memoryWindow struct {
+	hits   []time.Time
+	window time.Duration
+}
+
+type memoryRateLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*memoryWindow
+	now     func() time.Time
+	checks  int
+}
+
+// NewMemoryRateLimiter creates an in-process sliding window limiter.
+// It is used as the degraded-mode fallback when Redis is unavailable.
+func NewMemoryRateLimiter() RateLimiter {
+	return newMemoryRateLimiter(time.Now)
+}
+
+func newMemoryRateLimiter(now func() time.Time) *memoryRateLimiter {
+	return &memoryRateLimiter{
+		windows: make(map[string]*memoryWindow),
+		now:     now,
+	}
+}
+
+func (m *memoryRateLimiter) Allow(_ context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.checks++
+	if m.checks%sweepEvery == 0 {
+		m.sweep(now)
+	}
+
+	w, ok := m.windows[key]
+	if !ok {
+		w = &memoryWindow{}
+		m.windows[key] = w
+	}
+	w.window = window
+	w.hits = prune(w.hits, now.Add(-window))
+	hits := w.hits
+
+	if len(hits) >= limit {
+		resetAfter := window
+		if len(hits) > 0 {
+			resetAfter = hits[0].Add(window).Sub(now)
+		}
+		return RateLimitResult{
+			Allowed:    false,
+			Limit:      limit,
+			Remaining:  0,
+			ResetAfter: resetAfter,
+		}, nil
+	}
+
+	hits = append(hits, now)
+	w.hits = hits
+
+	return RateLimitResult{
+		Allowed:    true,
+		Limit:      limit,
+		Remaining:  limit - len(hits),
+		ResetAfter: hits[0].Add(window).Sub(now),
+	}, nil
+}
+
+// sweep drops keys with no hits inside the window
+func (m *memoryRateLimiter) sweep(now time.Time) {
+	for key, w := range m.windows {
+		if len(prune(w.hits, now.Add(-w.window))) == 0 {
+			delete(m.windows, key)
+		}
+	}
+}
+
+// prune removes hits older than cutoff; hits are kept in chronological order
+func prune(hits []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	return hits[i:]
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const rateLimitPrefix = "ratelimit:"
+
+// slidingWindowScript records a hit in a sorted set scored by timestamp and
+// counts hits inside the window atomically.
+// Returns {allowed, remaining, reset_after_ms}.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local member = ARGV[4]
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, member)
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+local reset = window
+if oldest[2] then
+	reset = window - (now - tonumber(oldest[2]))
+end
+
+return {allowed, limit - count, reset}
+`)
+
+// errRedisLimiterOpen is returned without calling Redis while the limiter
+// waits out the cooldown after a failure
+var errRedisLimiterOpen = errors.New("redis rate limiter is cooling down after a failure")
+
+const (
+	defaultRedisLimiterTimeout  = 100 * time.Millisecond
+	defaultRedisLimiterCooldown = 5 * time.Second
+)
+
+// RedisRateLimiterOption configures NewRedisRateLimiter
+type RedisRateLimiterOption func(*redisRateLimiter)
+
+// WithRedisTimeout bounds each call to Redis, so a degraded Redis delays
+// requests by at most timeout before they fall back to the local limiter
+func WithRedisTimeout(timeout time.Duration) RedisRateLimiterOption {
+	return func(r *redisRateLimiter) {
+		r.timeout = timeout
+	}
+}
+
+// WithRedisCooldown sets how long the limiter stops calling Redis after a
+// failure
+func WithRedisCooldown(cooldown time.Duration) RedisRateLimiterOption {
+	return func(r *redisRateLimiter) {
+		r.cooldown = cooldown
+	}
+}
+
+type redisRateLimiter struct {
+	client   redis.UniversalClient
+	timeout  time.Duration
+	cooldown time.Duration
+	// openUntil is when, in Unix nanoseconds, Redis is tried again
+	openUntil atomic.Int64
+}
+
+// NewRedisRateLimiter creates a sliding window limiter shared by all replicas through Redis
+func NewRedisRateLimiter(client redis.UniversalClient, opts ...RedisRateLimiterOption) RateLimiter {
+	r := &redisRateLimiter{
+		client:   client,
+		timeout:  defaultRedisLimiterTimeout,
+		cooldown: defaultRedisLimiterCooldown,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *redisRateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	if time.Now().UnixNano() < r.openUntil.Load() {
+		return RateLimitResult{}, errRedisLimiterOpen
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	now := time.Now().UnixMilli()
+	res, err := slidingWindowScript.Run(callCtx, r.client,
+		[]string{rateLimitPrefix + key},
+		now, window.Milliseconds(), limit, uuid.New().String(),
+	).Int64Slice()
+	if err != nil {
+		// A client that went away is no sign of a failing Redis, and must not
+		// switch every request to the fallback
+		if ctx.Err() == nil {
+			r.openUntil.Store(time.Now().Add(r.cooldown).UnixNano())
+		}
+		return RateLimitResult{}, fmt.Errorf("failed to evaluate rate limit: %w", err)
+	}
+
+	return RateLimitResult{
+		Allowed:    res[0] == 1,
+		Limit:      limit,
+		Remaining:  int(max(res[1], 0)),
+		ResetAfter: time.Duration(res[2]) * time.Millisecond,
+	}, nil
+}
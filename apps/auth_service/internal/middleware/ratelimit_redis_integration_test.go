@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	testredis "github.com/testcontainers/testcontainers-go/modules/redis"
+)
+
+func setupRedisContainer(t *testing.T) redis.UniversalClient {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	redisContainer, err := testredis.Run(ctx, "docker.io/redis:7-alpine")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		if err := redisContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate container: %s", err)
+		}
+	})
+
+	connectionString, err := redisContainer.ConnectionString(ctx)
+	require.NoError(t, err)
+
+	opts, err := redis.ParseURL(connectionString)
+	require.NoError(t, err)
+
+	return redis.NewClient(opts)
+}
+
+func TestRedisRateLimiter_Allow(t *testing.T) {
+	client := setupRedisContainer(t)
+	limiter := NewRedisRateLimiter(client)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		res, err := limiter.Allow(ctx, "login:ip:10.0.0.1", 3, time.Minute)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 2-i, res.Remaining)
+	}
+
+	res, err := limiter.Allow(ctx, "login:ip:10.0.0.1", 3, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Greater(t, res.ResetAfter, time.Duration(0))
+	assert.LessOrEqual(t, res.ResetAfter, time.Minute)
+
+	// Key expires with the window
+	ttl, err := client.PTTL(ctx, "ratelimit:login:ip:10.0.0.1").Result()
+	require.NoError(t, err)
+	assert.LessOrEqual(t, ttl, time.Minute)
+}
+
+func TestRedisRateLimiter_WindowSlides(t *testing.T) {
+	client := setupRedisContainer(t)
+	limiter := NewRedisRateLimiter(client)
+	ctx := context.Background()
+
+	res, err := limiter.Allow(ctx, "refresh:session:abc", 1, time.Second)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	res, err = limiter.Allow(ctx, "refresh:session:abc", 1, time.Second)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	res, err = limiter.Allow(ctx, "refresh:session:abc", 1, time.Second)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
+
+func TestRedisRateLimiter_Unavailable(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond})
+	limiter := NewRedisRateLimiter(client)
+
+	_, err := limiter.Allow(context.Background(), "login:ip:10.0.0.1", 1, time.Minute)
+	assert.Error(t, err)
+}
+
+func TestRedisRateLimiter_TimeoutAndCooldown(t *testing.T) {
+	// A Redis that accepts connections but never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					_ = conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{Addr: listener.Addr().String(), ContextTimeoutEnabled: true, MaxRetries: -1})
+	defer func() { _ = client.Close() }()
+	limiter := NewRedisRateLimiter(client, WithRedisTimeout(50*time.Millisecond), WithRedisCooldown(time.Minute))
+
+	start := time.Now()
+	_, err = limiter.Allow(context.Background(), "login:ip:10.0.0.1", 1, time.Minute)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Redis is not called again until the cooldown ends
+	_, err = limiter.Allow(context.Background(), "login:ip:10.0.0.1", 1, time.Minute)
+	assert.ErrorIs(t, err, errRedisLimiterOpen)
+}
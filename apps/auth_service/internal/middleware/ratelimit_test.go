@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/phuslu/log"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+type failingRateLimiter struct{}
+
+func (failingRateLimiter) Allow(context.Context, string, int, time.Duration) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("redis: connection refused")
+}
+
+func setupRateLimitRouter(limiter, fallback RateLimiter, policy RateLimitPolicy) (*gin.Engine, *bytes.Buffer) {
+	gin.SetMode(gin.TestMode)
+	buf := &bytes.Buffer{}
+	router := gin.New()
+	router.GET("/auth/login", RateLimit(limiter, fallback, policy, logger.New(buf, log.InfoLevel)), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	return router, buf
+}
+
+func doRequest(router *gin.Engine, ip string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/auth/login", nil)
+	req.RemoteAddr = ip + ":12345"
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimit_AllowsUpToLimitThenRejects(t *testing.T) {
+	policy := RateLimitPolicy{Name: "login", Limit: 2, Window: time.Minute, Key: KeyByIP}
+	router, _ := setupRateLimitRouter(NewMemoryRateLimiter(), NewMemoryRateLimiter(), policy)
+
+	w := doRequest(router, "10.0.0.1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+
+	w = doRequest(router, "10.0.0.1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	w = doRequest(router, "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "too many requests")
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.NotEqual(t, "0", w.Header().Get("Retry-After"))
+
+	// Other clients are not affected
+	w = doRequest(router, "10.0.0.2")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimit_KeyBySession(t *testing.T) {
+	policy := RateLimitPolicy{Name: "refresh-session", Limit: 1, Window: time.Minute, Key: KeyBySession}
+	router, _ := setupRateLimitRouter(NewMemoryRateLimiter(), NewMemoryRateLimiter(), policy)
+
+	session := &http.Cookie{Name: "session_id", Value: "session-123"}
+
+	assert.Equal(t, http.StatusOK, doRequest(router, "10.0.0.1", session).Code)
+	// Same session from another address is still limited
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(router, "10.0.0.2", session).Code)
+
+	// Requests without a session are not counted by this policy
+	assert.Equal(t, http.StatusOK, doRequest(router, "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, doRequest(router, "10.0.0.1").Code)
+}
+
+func TestRateLimit_DegradedModeUsesFallback(t *testing.T) {
+	policy := RateLimitPolicy{Name: "login", Limit: 1, Window: time.Minute, Key: KeyByIP}
+	router, buf := setupRateLimitRouter(failingRateLimiter{}, NewMemoryRateLimiter(), policy)
+
+	assert.Equal(t, http.StatusOK, doRequest(router, "10.0.0.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(router, "10.0.0.1").Code)
+	assert.Contains(t, buf.String(), "local fallback")
+}
+
+func TestRateLimit_FailsOpenWhenAllLimitersFail(t *testing.T) {
+	policy := RateLimitPolicy{Name: "login", Limit: 1, Window: time.Minute, Key: KeyByIP}
+	router, _ := setupRateLimitRouter(failingRateLimiter{}, failingRateLimiter{}, policy)
+
+	assert.Equal(t, http.StatusOK, doRequest(router, "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, doRequest(router, "10.0.0.1").Code)
+}
+
+func TestMemoryRateLimiter_SlidingWindow(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	limiter := newMemoryRateLimiter(func() time.Time { return now })
+	ctx := context.Background()
+
+	res, err := limiter.Allow(ctx, "k", 2, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	now = now.Add(30 * time.Second)
+	res, _ = limiter.Allow(ctx, "k", 2, time.Minute)
+	assert.True(t, res.Allowed)
+
+	now = now.Add(10 * time.Second)
+	res, _ = limiter.Allow(ctx, "k", 2, time.Minute)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 20*time.Second, res.ResetAfter)
+
+	// First hit leaves the window
+	now = now.Add(21 * time.Second)
+	res, _ = limiter.Allow(ctx, "k", 2, time.Minute)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+}
+
+func TestMemoryRateLimiter_SweepDropsIdleKeys(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	limiter := newMemoryRateLimiter(func() time.Time { return now })
+	ctx := context.Background()
+
+	_, _ = limiter.Allow(ctx, "idle", 5, time.Second)
+	now = now.Add(time.Minute)
+	limiter.sweep(now)
+
+	assert.NotContains(t, limiter.windows, "idle")
+}
+
+func TestRedisRateLimiter_CanceledRequestKeepsRedis(t *testing.T) {
+	// Nothing listens on port 1, so every call to Redis fails
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer func() { _ = client.Close() }()
+	limiter := NewRedisRateLimiter(client, WithRedisCooldown(time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := limiter.Allow(ctx, "login:203.0.113.7", 5, time.Minute)
+	require.Error(t, err)
+
+	// The canceled request did not open the cooldown: Redis is tried again
+	_, err = limiter.Allow(context.Background(), "login:203.0.113.7", 5, time.Minute)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, errRedisLimiterOpen)
+
+	// A failure of Redis itself does
+	_, err = limiter.Allow(context.Background(), "login:203.0.113.7", 5, time.Minute)
+	assert.ErrorIs(t, err, errRedisLimiterOpen)
+}
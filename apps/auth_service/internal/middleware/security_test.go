@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(SecurityHeaders(SecurityHeadersPolicy{
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		HSTSPreload:           true,
+		ReferrerPolicy:        "no-referrer",
+		FrameAncestors:        []string{"'none'"},
+	}))
+	router.GET("/json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	router.GET("/redirect", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, "https://provider.example.com/authorize?code=secret")
+	})
+
+	for _, path := range []string{"/json", "/redirect", "/not-found"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		assert.Equal(t, "max-age=31536000; includeSubDomains; preload", w.Header().Get("Strict-Transport-Security"), path)
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"), path)
+		assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"), path)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"), path)
+		assert.Equal(t, "no-cache", w.Header().Get("Pragma"), path)
+		assert.Equal(t, "frame-ancestors 'none'", w.Header().Get("Content-Security-Policy"), path)
+		assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"), path)
+	}
+}
+
+func TestSecurityHeaders_AllowedFramingWithoutHSTS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(SecurityHeaders(SecurityHeadersPolicy{
+		ReferrerPolicy: "same-origin",
+		FrameAncestors: []string{"'self'", "https://app.example.com"},
+	}))
+	router.GET("/test", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+	assert.Equal(t, "same-origin", w.Header().Get("Referrer-Policy"))
+	assert.Equal(t, "frame-ancestors 'self' https://app.example.com", w.Header().Get("Content-Security-Policy"))
+	assert.Empty(t, w.Header().Get("X-Frame-Options"))
+}
@@ -0,0 +1,62 @@
+package oidc
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// authTimeLeeway absorbs clock skew between this service and the provider
+// when checking max_age
+const authTimeLeeway = 30 * time.Second
+
+// AuthContext is how the user authenticated, from the ID token's acr, amr and
+// auth_time claims
+type AuthContext struct {
+	ACR      string
+	AMR      []string
+	AuthTime time.Time
+}
+
+// ReadAuthContext reads the authentication context claims of a verified ID
+// token. Claims the provider did not include are left empty.
+func ReadAuthContext(idToken *oidc.IDToken) (AuthContext, error) {
+	var claims struct {
+		ACR      string   `json:"acr"`
+		AMR      []string `json:"amr"`
+		AuthTime float64  `json:"auth_time"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return AuthContext{}, fmt.Errorf("failed to read auth context claims: %w", err)
+	}
+
+	auth := AuthContext{ACR: claims.ACR, AMR: claims.AMR}
+	if claims.AuthTime > 0 {
+		auth.AuthTime = time.Unix(int64(claims.AuthTime), 0)
+	}
+	return auth, nil
+}
+
+// AuthenticatedWithin reports whether the user authenticated at most maxAge
+// before now. Without auth_time it cannot tell, and reports false.
+func (a AuthContext) AuthenticatedWithin(maxAge time.Duration, now time.Time) bool {
+	if a.AuthTime.IsZero() {
+		return false
+	}
+	return now.Sub(a.AuthTime) <= maxAge+authTimeLeeway
+}
+
+// WithACRValues asks the provider for one of the space-separated acr values,
+// in order of preference
+func WithACRValues(acrValues string) oauth2.AuthCodeOption {
+	return oauth2.SetAuthURLParam("acr_values", acrValues)
+}
+
+// WithMaxAge asks the provider to authenticate the user again unless they did
+// within maxAge; zero always asks for a fresh login
+func WithMaxAge(maxAge time.Duration) oauth2.AuthCodeOption {
+	return oauth2.SetAuthURLParam("max_age", strconv.Itoa(int(maxAge.Seconds())))
+}
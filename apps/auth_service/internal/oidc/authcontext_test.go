@@ -0,0 +1,90 @@
+package oidc
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
+)
+
+func TestClient_GetAuthURL_AuthRequirements(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	client, err := NewClient(context.Background(), testOIDCConfig(mockServer))
+	require.NoError(t, err)
+
+	authURL, err := client.GetAuthURL("state", WithACRValues("gold silver"), WithMaxAge(5*time.Minute))
+	require.NoError(t, err)
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "gold silver", parsed.Query().Get("acr_values"))
+	assert.Equal(t, "300", parsed.Query().Get("max_age"))
+
+	authURL, err = client.GetAuthURL("state")
+	require.NoError(t, err)
+	assert.NotContains(t, authURL, "acr_values")
+	assert.NotContains(t, authURL, "max_age")
+}
+
+func TestReadAuthContext(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+
+	verify := func() AuthContext {
+		t.Helper()
+		token, err := client.ExchangeCode(ctx, "mock-auth-code")
+		require.NoError(t, err)
+		idToken, err := client.VerifyIDToken(ctx, token.Extra("id_token").(string))
+		require.NoError(t, err)
+		auth, err := ReadAuthContext(idToken)
+		require.NoError(t, err)
+		return auth
+	}
+
+	assert.Equal(t, AuthContext{}, verify(), "claims are optional")
+
+	authTime := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	mockServer.ACR = "gold"
+	mockServer.AMR = []string{"pwd", "otp"}
+	mockServer.AuthTime = authTime
+
+	auth := verify()
+	assert.Equal(t, "gold", auth.ACR)
+	assert.Equal(t, []string{"pwd", "otp"}, auth.AMR)
+	assert.True(t, authTime.Equal(auth.AuthTime))
+}
+
+func TestAuthContext_AuthenticatedWithin(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		authTime time.Time
+		maxAge   time.Duration
+		want     bool
+	}{
+		{"recent login", now.Add(-time.Minute), 5 * time.Minute, true},
+		{"old login", now.Add(-10 * time.Minute), 5 * time.Minute, false},
+		{"within clock skew", now.Add(-5*time.Minute - 10*time.Second), 5 * time.Minute, true},
+		{"fresh login required", now.Add(-2 * time.Second), 0, true},
+		{"no auth_time", time.Time{}, time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, AuthContext{AuthTime: tt.authTime}.AuthenticatedWithin(tt.maxAge, now))
+		})
+	}
+}
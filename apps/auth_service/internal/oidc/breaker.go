@@ -0,0 +1,98 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// breaker is a circuit breaker around calls to the provider. After threshold
+// consecutive failures it opens and rejects calls until cooldown has passed;
+// then a single probe call is let through, closing the breaker on success and
+// reopening it on failure.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// newBreaker creates a breaker; a threshold of 0 disables it
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a call may proceed. A call that was allowed must be
+// followed by done with its result.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// done records the result of an allowed call
+func (b *breaker) done(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !isProviderFailure(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// open reports whether calls are currently being rejected
+func (b *breaker) open() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold && (b.probing || b.now().Before(b.openUntil))
+}
+
+// isProviderFailure reports whether err means the provider is unhealthy, as
+// opposed to rejecting a bad request (invalid code, revoked refresh token) or
+// the caller giving up
+func isProviderFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.Response != nil {
+		return retrieveErr.Response.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
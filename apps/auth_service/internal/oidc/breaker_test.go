@@ -0,0 +1,67 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestBreaker_OpensAndRecovers(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	failure := errors.New("connection refused")
+
+	assert.True(t, b.allow())
+	b.done(failure)
+	assert.False(t, b.open())
+
+	assert.True(t, b.allow())
+	b.done(failure)
+	assert.True(t, b.open())
+	assert.False(t, b.allow(), "open breaker rejects calls")
+
+	// After the cooldown a single probe is let through
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow())
+	assert.False(t, b.allow(), "only one probe at a time")
+
+	b.done(failure)
+	assert.True(t, b.open(), "a failed probe reopens the breaker")
+	assert.False(t, b.allow())
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow())
+	b.done(nil)
+	assert.False(t, b.open())
+	assert.True(t, b.allow())
+}
+
+func TestBreaker_Disabled(t *testing.T) {
+	b := newBreaker(0, time.Minute)
+	for range 10 {
+		assert.True(t, b.allow())
+		b.done(errors.New("boom"))
+	}
+	assert.False(t, b.open())
+}
+
+func TestIsProviderFailure(t *testing.T) {
+	retrieveErr := func(status int) error {
+		return fmt.Errorf("failed to refresh token: %w", &oauth2.RetrieveError{Response: &http.Response{StatusCode: status}})
+	}
+
+	assert.False(t, isProviderFailure(nil))
+	assert.False(t, isProviderFailure(context.Canceled), "the caller gave up")
+	assert.False(t, isProviderFailure(retrieveErr(http.StatusBadRequest)), "invalid grant is the client's problem")
+	assert.True(t, isProviderFailure(retrieveErr(http.StatusServiceUnavailable)))
+	assert.True(t, isProviderFailure(context.DeadlineExceeded))
+	assert.True(t, isProviderFailure(errors.New("connection refused")))
+}
@@ -3,55 +3,318 @@ package oidc
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/phuslu/log"
 	"golang.org/x/oauth2"
 
 	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
 )
 
-// Client is an OIDC authentication client that handles OAuth2 flows
+// Client is an OIDC authentication client that handles OAuth2 flows. Provider
+// metadata comes from discovery, which can be retried and repeated in the
+// background (see Start); the last successful result stays in use while the
+// provider is unreachable.
 type Client struct {
-	provider     *oidc.Provider
-	oauth2Config *oauth2.Config
-	verifier     *oidc.IDTokenVerifier
+	issuer       string
+	oauth2Config *oauth2.Config // Endpoint is taken from the discovered metadata
+	logger       logger.Logger
+	timeout      time.Duration
+	// httpClient carries every request to the provider, including JWKS fetches
+	httpClient *http.Client
+	// clientSecret is set when the secret is mounted as a file that may rotate
+	clientSecret *config.SecretFile
+	// secretAuth is false when the client authenticates with private_key_jwt
+	// or tls_client_auth, which send client_id in the request body instead
+	secretAuth bool
+
+	meta    atomic.Pointer[metadata]
+	breaker *breaker
+
+	// discoveryInterval is how often discovery is repeated once it succeeded
+	discoveryInterval time.Duration
+	// retryBackoff and maxRetryBackoff bound the delay between failed discoveries
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
+}
+
+// metadata is the provider configuration obtained through discovery
+type metadata struct {
+	endpoint oauth2.Endpoint
+	verifier *oidc.IDTokenVerifier
+	// revocationURL is the RFC 7009 endpoint; empty when unsupported
+	revocationURL string
+	// endSessionURL is the RP-Initiated Logout endpoint; empty when unsupported
+	endSessionURL string
+}
+
+// ErrRevocationUnsupported is returned when the provider advertises no revocation endpoint
+var ErrRevocationUnsupported = errors.New("provider does not support token revocation")
+
+// ErrProviderUnavailable is returned while the provider has not been
+// discovered yet or while the circuit breaker is open after repeated failures
+var ErrProviderUnavailable = errors.New("OIDC provider unavailable")
+
+// maxResponseSize bounds provider responses read outside the oauth2 package
+const maxResponseSize = 1 << 20
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultMaxRetryBackoff = time.Minute
+)
+
+// Option customizes a Client created by NewClient
+type Option func(*Client)
+
+// WithLogger sets the logger used for provider interaction diagnostics
+func WithLogger(log logger.Logger) Option {
+	return func(c *Client) {
+		c.logger = log
+	}
+}
+
+// WithHTTPClient sets the client used for requests to the provider instead of
+// the one NewHTTPClient builds from the configuration
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// NewClient creates a new OIDC client with the given configuration. Discovery
+// is attempted once; if it fails, NewClient returns the error unless
+// cfg.LazyDiscovery is set, in which case the client starts degraded and
+// Start keeps retrying.
+func NewClient(ctx context.Context, cfg *config.OIDCConfig, opts ...Option) (*Client, error) {
+	client := &Client{
+		issuer: cfg.ProviderURL,
+		oauth2Config: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       cfg.Scopes,
+		},
+		timeout:           cfg.Timeout,
+		secretAuth:        cfg.ClientAuthMethod == config.ClientAuthSecret || cfg.ClientAuthMethod == "",
+		breaker:           newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		discoveryInterval: cfg.DiscoveryInterval,
+		retryBackoff:      defaultRetryBackoff,
+		maxRetryBackoff:   defaultMaxRetryBackoff,
+	}
+	if !client.secretAuth {
+		client.oauth2Config.ClientSecret = ""
+	} else if cfg.ClientSecretFile != "" {
+		client.clientSecret = config.NewSecretFile(cfg.ClientSecretFile)
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	if client.httpClient == nil {
+		httpClient, err := NewHTTPClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		client.httpClient = httpClient
+	}
+	if cfg.ClientAuthMethod == config.ClientAuthPrivateKeyJWT {
+		if err := client.usePrivateKeyJWT(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := client.discover(ctx); err != nil {
+		if !cfg.LazyDiscovery {
+			return nil, err
+		}
+		if client.logger != nil {
+			client.logger.Warn().Err(err).Str("issuer", cfg.ProviderURL).Msg("OIDC discovery failed, starting degraded")
+		}
+	}
+
+	return client, nil
 }
 
-// NewClient creates a new OIDC client with the given configuration
-func NewClient(ctx context.Context, cfg *config.OIDCConfig) (*Client, error) {
-	provider, err := oidc.NewProvider(ctx, cfg.ProviderURL)
+// usePrivateKeyJWT wraps the HTTP client so requests to the token and
+// revocation endpoints carry a signed client assertion
+func (c *Client) usePrivateKeyJWT(cfg *config.OIDCConfig) error {
+	signer, err := newAssertionSigner(cfg.ClientID, cfg.PrivateKey, cfg.PrivateKeyID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create OIDC provider: %w", err)
+		return err
+	}
+	if cfg.PrivateKeyFile != "" {
+		signer.keyFile = config.NewSecretFile(cfg.PrivateKeyFile)
+		signer.reloadFailed = func(err error) {
+			if c.logger != nil {
+				c.logger.Warn().Err(err).Msg("Failed to reload private key file, using last known key")
+			}
+		}
 	}
 
-	oauth2Config := &oauth2.Config{
-		ClientID:     cfg.ClientID,
-		ClientSecret: cfg.ClientSecret,
-		RedirectURL:  cfg.RedirectURL,
-		Endpoint:     provider.Endpoint(),
-		Scopes:       cfg.Scopes,
+	base := c.httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
 	}
+	httpClient := *c.httpClient
+	httpClient.Transport = &assertionTransport{
+		base:   base,
+		signer: signer,
+		audience: func() string {
+			if meta := c.meta.Load(); meta != nil {
+				return meta.endpoint.TokenURL
+			}
+			return c.issuer
+		},
+	}
+	c.httpClient = &httpClient
+	return nil
+}
 
-	verifier := provider.Verifier(&oidc.Config{
-		ClientID: cfg.ClientID,
-	})
+// discover fetches the provider metadata and replaces the cached copy
+func (c *Client) discover(ctx context.Context) error {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
 
-	return &Client{
-		provider:     provider,
-		oauth2Config: oauth2Config,
-		verifier:     verifier,
-	}, nil
+	provider, err := oidc.NewProvider(ctx, c.issuer)
+	if err != nil {
+		return fmt.Errorf("failed to create OIDC provider: %w", err)
+	}
+
+	meta := &metadata{
+		endpoint: provider.Endpoint(),
+		verifier: provider.Verifier(&oidc.Config{ClientID: c.oauth2Config.ClientID}),
+	}
+	var claims struct {
+		RevocationEndpoint string `json:"revocation_endpoint"`
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&claims); err == nil {
+		meta.revocationURL = claims.RevocationEndpoint
+		meta.endSessionURL = claims.EndSessionEndpoint
+	}
+
+	previous := c.meta.Swap(meta)
+
+	if previous != nil && c.logger != nil && previous.endpoint.TokenURL != meta.endpoint.TokenURL {
+		c.logger.Info().
+			Str("previous_token_endpoint", previous.endpoint.TokenURL).
+			Str("token_endpoint", meta.endpoint.TokenURL).
+			Msg("OIDC provider endpoints changed")
+	}
+
+	c.debug().
+		Str("issuer", c.issuer).
+		Str("token_endpoint", meta.endpoint.TokenURL).
+		Str("revocation_endpoint", meta.revocationURL).
+		Msg("OIDC discovery completed")
+
+	return nil
 }
 
-// GetAuthURL generates the authorization URL for the OIDC flow
-func (c *Client) GetAuthURL(state string) string {
-	return c.oauth2Config.AuthCodeURL(state)
+// Start keeps the provider metadata current until ctx is done. While the
+// provider has not been discovered, or the last rediscovery failed, discovery
+// is retried with exponential backoff; otherwise it is repeated every
+// discovery interval, which also picks up rotated signing keys. A zero
+// interval disables rediscovery once the provider was discovered.
+func (c *Client) Start(ctx context.Context) {
+	go c.maintain(ctx)
+}
+
+func (c *Client) maintain(ctx context.Context) {
+	backoff := c.retryBackoff
+	retrying := c.meta.Load() == nil
+
+	for {
+		wait := c.discoveryInterval
+		if retrying {
+			wait = backoff
+		}
+		if wait <= 0 {
+			return
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		err := c.discover(ctx)
+		if err == nil {
+			if retrying && c.logger != nil {
+				c.logger.Info().Str("issuer", c.issuer).Msg("OIDC provider discovered")
+			}
+			retrying = false
+			backoff = c.retryBackoff
+			continue
+		}
+
+		if retrying {
+			backoff = min(backoff*2, c.maxRetryBackoff)
+		}
+		retrying = true
+		if c.logger != nil {
+			c.logger.Warn().Err(err).Dur("retry_in", backoff).Msg("OIDC discovery failed, keeping cached metadata")
+		}
+	}
+}
+
+// Ready reports whether the provider has been discovered
+func (c *Client) Ready() bool {
+	return c.meta.Load() != nil
+}
+
+// Healthy reports whether the provider is discovered and the circuit breaker
+// around its token endpoint is closed
+func (c *Client) Healthy() bool {
+	return c.Ready() && !c.breaker.open()
+}
+
+// metadata returns the cached provider metadata or ErrProviderUnavailable
+func (c *Client) metadata() (*metadata, error) {
+	meta := c.meta.Load()
+	if meta == nil {
+		return nil, ErrProviderUnavailable
+	}
+	return meta, nil
+}
+
+// GetAuthURL generates the authorization URL for the OIDC flow. opts add
+// request parameters such as WithACRValues and WithMaxAge.
+func (c *Client) GetAuthURL(state string, opts ...oauth2.AuthCodeOption) (string, error) {
+	meta, err := c.metadata()
+	if err != nil {
+		return "", err
+	}
+	return c.configFor(meta).AuthCodeURL(state, opts...), nil
 }
 
 // ExchangeCode exchanges the authorization code for tokens
 func (c *Client) ExchangeCode(ctx context.Context, code string) (*oauth2.Token, error) {
-	token, err := c.oauth2Config.Exchange(ctx, code)
+	meta, err := c.metadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code for token: %w", err)
+	}
+
+	c.debug().Str("token_endpoint", meta.endpoint.TokenURL).Msg("Exchanging authorization code")
+
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	token, err := c.callTokenEndpoint(func() (*oauth2.Token, error) {
+		return c.tokenConfig(meta).Exchange(ctx, code)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to exchange code for token: %w", err)
 	}
@@ -62,7 +325,7 @@ func (c *Client) ExchangeCode(ctx context.Context, code string) (*oauth2.Token,
 		return nil, fmt.Errorf("no id_token in token response")
 	}
 
-	if _, err := c.verifier.Verify(ctx, rawIDToken); err != nil {
+	if _, err := meta.verifier.Verify(ctx, rawIDToken); err != nil {
 		return nil, fmt.Errorf("failed to verify ID token: %w", err)
 	}
 
@@ -71,51 +334,259 @@ func (c *Client) ExchangeCode(ctx context.Context, code string) (*oauth2.Token,
 
 // RefreshToken refreshes an access token using a refresh token
 func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
-	tokenSource := c.oauth2Config.TokenSource(ctx, &oauth2.Token{
+	meta, err := c.metadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	c.debug().Str("token_endpoint", meta.endpoint.TokenURL).Msg("Refreshing token")
+
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	tokenSource := c.tokenConfig(meta).TokenSource(ctx, &oauth2.Token{
 		RefreshToken: refreshToken,
 	})
 
-	newToken, err := tokenSource.Token()
+	newToken, err := c.callTokenEndpoint(tokenSource.Token)
 	if err != nil {
 		return nil, fmt.Errorf("failed to refresh token: %w", err)
 	}
 
+	c.debug().
+		Bool("refresh_token_rotated", newToken.RefreshToken != "" && newToken.RefreshToken != refreshToken).
+		Time("expiry", newToken.Expiry).
+		Msg("Token refreshed at provider")
+
 	return newToken, nil
 }
 
+// callTokenEndpoint runs a token endpoint call through the circuit breaker
+func (c *Client) callTokenEndpoint(call func() (*oauth2.Token, error)) (*oauth2.Token, error) {
+	if !c.breaker.allow() {
+		return nil, ErrProviderUnavailable
+	}
+
+	token, err := call()
+	wasOpen := c.breaker.open()
+	c.breaker.done(err)
+
+	if c.logger != nil {
+		switch isOpen := c.breaker.open(); {
+		case isOpen && !wasOpen:
+			c.logger.Error().Err(err).Msg("OIDC token endpoint failing, circuit breaker opened")
+		case !isOpen && wasOpen:
+			c.logger.Info().Msg("OIDC token endpoint recovered, circuit breaker closed")
+		}
+	}
+
+	return token, err
+}
+
+// RevokeToken revokes a token at the provider (RFC 7009). tokenTypeHint is
+// "refresh_token", "access_token" or empty. Unknown tokens are not an error.
+func (c *Client) RevokeToken(ctx context.Context, token, tokenTypeHint string) error {
+	meta, err := c.metadata()
+	if err != nil {
+		return err
+	}
+	if meta.revocationURL == "" {
+		return ErrRevocationUnsupported
+	}
+
+	c.debug().Str("revocation_endpoint", meta.revocationURL).Str("token_type_hint", tokenTypeHint).Msg("Revoking token")
+
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	form := url.Values{"token": {token}}
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+
+	resp, err := c.postForm(ctx, meta, meta.revocationURL, form)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to revoke token: revocation endpoint returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// postForm sends an authenticated form POST to a provider endpoint: with the
+// client secret as Basic auth, or with client_id in the body for the other
+// methods (private_key_jwt adds its assertion in the transport)
+func (c *Client) postForm(ctx context.Context, meta *metadata, endpoint string, form url.Values) (*http.Response, error) {
+	cfg := c.tokenConfig(meta)
+	if cfg.ClientSecret == "" {
+		form.Set("client_id", cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// requestToken sends a token request the oauth2 package has no call for
+// (device code, token exchange, client credentials with our client
+// authentication) and decodes the response like the oauth2 package does
+func (c *Client) requestToken(ctx context.Context, meta *metadata, form url.Values) (*oauth2.Token, error) {
+	resp, err := c.postForm(ctx, meta, meta.endpoint.TokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, retrieveError(resp, body)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+
+	token := &oauth2.Token{}
+	token.AccessToken, _ = raw["access_token"].(string)
+	token.TokenType, _ = raw["token_type"].(string)
+	token.RefreshToken, _ = raw["refresh_token"].(string)
+	if expiresIn, ok := raw["expires_in"].(float64); ok && expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("server response missing access_token")
+	}
+	return token.WithExtra(raw), nil
+}
+
+// retrieveError builds the oauth2 error for a failed provider response, so the
+// circuit breaker and callers classify it like token endpoint errors
+func retrieveError(resp *http.Response, body []byte) *oauth2.RetrieveError {
+	retrieveErr := &oauth2.RetrieveError{Response: resp, Body: body}
+
+	var payload struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+		ErrorURI         string `json:"error_uri"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		retrieveErr.ErrorCode = payload.Error
+		retrieveErr.ErrorDescription = payload.ErrorDescription
+		retrieveErr.ErrorURI = payload.ErrorURI
+	}
+	return retrieveErr
+}
+
+// configFor returns the OAuth2 config using the endpoints in meta
+func (c *Client) configFor(meta *metadata) *oauth2.Config {
+	cfg := *c.oauth2Config
+	cfg.Endpoint = meta.endpoint
+	if !c.secretAuth {
+		cfg.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
+	return &cfg
+}
+
+// tokenConfig returns the OAuth2 config for a token endpoint call, carrying
+// the current client secret when it is read from a rotating file
+func (c *Client) tokenConfig(meta *metadata) *oauth2.Config {
+	cfg := c.configFor(meta)
+	if c.clientSecret == nil {
+		return cfg
+	}
+
+	secret, err := c.clientSecret.Value()
+	if err != nil && c.logger != nil {
+		c.logger.Warn().Err(err).Msg("Failed to re-read client secret file, using last known value")
+	}
+
+	cfg.ClientSecret = secret
+	return cfg
+}
+
+// callContext routes a provider call through the client's HTTP client and
+// bounds it by the configured timeout
+func (c *Client) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = oidc.ClientContext(ctx, c.httpClient)
+	if c.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// debug returns a debug entry, or nil when no logger was configured
+func (c *Client) debug() *log.Entry {
+	if c.logger == nil {
+		return nil
+	}
+	return c.logger.Debug()
+}
+
 // VerifyIDToken verifies the ID token signature and claims
 func (c *Client) VerifyIDToken(ctx context.Context, rawIDToken string) (*oidc.IDToken, error) {
-	idToken, err := c.verifier.Verify(ctx, rawIDToken)
+	meta, err := c.metadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+	}
+
+	idToken, err := meta.verifier.Verify(ctx, rawIDToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify ID token: %w", err)
 	}
 	return idToken, nil
 }
 
-// GetEndSessionURL generates the OIDC logout URL (RP-Initiated Logout)
-// This logs the user out from the OIDC provider (Keycloak)
-func (c *Client) GetEndSessionURL(idToken, postLogoutRedirectURI string) string {
-	// Get the end_session_endpoint from the provider's discovery document
-	var claims struct {
-		EndSessionEndpoint string `json:"end_session_endpoint"`
-	}
+// SupportsEndSession reports whether the provider advertises an
+// end_session_endpoint for RP-Initiated Logout
+func (c *Client) SupportsEndSession() bool {
+	meta := c.meta.Load()
+	return meta != nil && meta.endSessionURL != ""
+}
 
-	if err := c.provider.Claims(&claims); err != nil {
-		// Fallback: construct the URL manually for Keycloak
-		// This is a safe fallback since we know we're using Keycloak
+// GetEndSessionURL builds the provider logout URL for RP-Initiated Logout.
+// Empty arguments are omitted; state is echoed back to postLogoutRedirectURI.
+// Returns "" when the provider advertises no end_session_endpoint or has not
+// been discovered.
+func (c *Client) GetEndSessionURL(idToken, postLogoutRedirectURI, state string) string {
+	meta := c.meta.Load()
+	if meta == nil || meta.endSessionURL == "" {
 		return ""
 	}
 
-	if claims.EndSessionEndpoint == "" {
+	logoutURL, err := url.Parse(meta.endSessionURL)
+	if err != nil {
 		return ""
 	}
 
-	// Build the logout URL with parameters
-	logoutURL := fmt.Sprintf("%s?id_token_hint=%s&post_logout_redirect_uri=%s",
-		claims.EndSessionEndpoint,
-		idToken,
-		postLogoutRedirectURI,
-	)
+	// Keep any query parameters the endpoint already carries
+	query := logoutURL.Query()
+	query.Set("client_id", c.oauth2Config.ClientID)
+	if idToken != "" {
+		query.Set("id_token_hint", idToken)
+	}
+	if postLogoutRedirectURI != "" {
+		query.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	logoutURL.RawQuery = query.Encode()
 
-	return logoutURL
+	return logoutURL.String()
 }
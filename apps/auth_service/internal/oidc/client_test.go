@@ -5,7 +5,12 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -32,9 +37,13 @@ func TestNewClient_WithMockServer(t *testing.T) {
 
 	require.NoError(t, err)
 	assert.NotNil(t, client)
-	assert.NotNil(t, client.provider)
 	assert.NotNil(t, client.oauth2Config)
-	assert.NotNil(t, client.verifier)
+	assert.True(t, client.Ready())
+
+	meta, err := client.metadata()
+	require.NoError(t, err)
+	assert.Equal(t, mockServer.Issuer+"/token", meta.endpoint.TokenURL)
+	assert.NotNil(t, meta.verifier)
 }
 
 func TestClient_GetAuthURL(t *testing.T) {
@@ -54,7 +63,8 @@ func TestClient_GetAuthURL(t *testing.T) {
 	client, err := NewClient(ctx, cfg)
 	require.NoError(t, err)
 
-	authURL := client.GetAuthURL("test-state")
+	authURL, err := client.GetAuthURL("test-state")
+	require.NoError(t, err)
 
 	assert.NotEmpty(t, authURL)
 	assert.Contains(t, authURL, mockServer.Issuer)
@@ -243,15 +253,22 @@ func TestClient_GetEndSessionURL(t *testing.T) {
 	require.True(t, ok)
 	require.NotEmpty(t, rawIDToken)
 
-	postLogoutRedirectURI := "http://localhost:3000/logout"
+	postLogoutRedirectURI := "http://localhost:3000/logout?next=/feed&tab=1"
 
 	// Test GetEndSessionURL
-	logoutURL := client.GetEndSessionURL(rawIDToken, postLogoutRedirectURI)
+	logoutURL := client.GetEndSessionURL(rawIDToken, postLogoutRedirectURI, "logout-state")
 
 	assert.NotEmpty(t, logoutURL)
-	assert.Contains(t, logoutURL, mockServer.Issuer+"/logout")
-	assert.Contains(t, logoutURL, "id_token_hint="+rawIDToken)
-	assert.Contains(t, logoutURL, "post_logout_redirect_uri="+postLogoutRedirectURI)
+	assert.True(t, strings.HasPrefix(logoutURL, mockServer.Issuer+"/logout?"))
+
+	parsed, err := url.Parse(logoutURL)
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"id_token_hint":            {rawIDToken},
+		"post_logout_redirect_uri": {postLogoutRedirectURI},
+		"client_id":                {mockServer.ClientID},
+		"state":                    {"logout-state"},
+	}, parsed.Query(), "parameters are escaped and round-trip intact")
 }
 
 func TestClient_GetEndSessionURL_WithEmptyIDToken(t *testing.T) {
@@ -274,11 +291,16 @@ func TestClient_GetEndSessionURL_WithEmptyIDToken(t *testing.T) {
 	postLogoutRedirectURI := "http://localhost:3000/logout"
 
 	// Test GetEndSessionURL with empty ID token
-	logoutURL := client.GetEndSessionURL("", postLogoutRedirectURI)
+	logoutURL := client.GetEndSessionURL("", postLogoutRedirectURI, "")
 
 	assert.NotEmpty(t, logoutURL)
 	assert.Contains(t, logoutURL, mockServer.Issuer+"/logout")
-	assert.Contains(t, logoutURL, "post_logout_redirect_uri="+postLogoutRedirectURI)
+
+	parsed, err := url.Parse(logoutURL)
+	require.NoError(t, err)
+	assert.Equal(t, postLogoutRedirectURI, parsed.Query().Get("post_logout_redirect_uri"))
+	assert.False(t, parsed.Query().Has("id_token_hint"))
+	assert.False(t, parsed.Query().Has("state"))
 }
 
 func TestClient_GetEndSessionURL_WithEmptyPostLogoutRedirectURI(t *testing.T) {
@@ -306,11 +328,15 @@ func TestClient_GetEndSessionURL_WithEmptyPostLogoutRedirectURI(t *testing.T) {
 	require.True(t, ok)
 
 	// Test GetEndSessionURL with empty post logout redirect URI
-	logoutURL := client.GetEndSessionURL(rawIDToken, "")
+	logoutURL := client.GetEndSessionURL(rawIDToken, "", "")
 
 	assert.NotEmpty(t, logoutURL)
 	assert.Contains(t, logoutURL, mockServer.Issuer+"/logout")
-	assert.Contains(t, logoutURL, "id_token_hint="+rawIDToken)
+
+	parsed, err := url.Parse(logoutURL)
+	require.NoError(t, err)
+	assert.Equal(t, rawIDToken, parsed.Query().Get("id_token_hint"))
+	assert.False(t, parsed.Query().Has("post_logout_redirect_uri"))
 }
 
 func TestClient_VerifyIDToken_Invalid(t *testing.T) {
@@ -394,7 +420,7 @@ func TestClient_GetEndSessionURL_NoEndpoint(t *testing.T) {
 	require.NoError(t, err)
 
 	// Should return empty string when no end_session_endpoint
-	logoutURL := client.GetEndSessionURL("test-id-token", "http://localhost:3000")
+	logoutURL := client.GetEndSessionURL("test-id-token", "http://localhost:3000", "state")
 
 	assert.Empty(t, logoutURL)
 }
@@ -494,3 +520,252 @@ func createMockServerWithoutIDToken(_ *testing.T) *httptest.Server {
 
 	return httptest.NewServer(mux)
 }
+
+func TestClient_ClientSecretFileRotation(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	secretPath := filepath.Join(t.TempDir(), "client_secret")
+	require.NoError(t, os.WriteFile(secretPath, []byte("secret-v1\n"), 0o600))
+	mockServer.ClientSecret = "secret-v1"
+
+	cfg := &config.OIDCConfig{
+		ProviderURL:      mockServer.Issuer,
+		ClientID:         mockServer.ClientID,
+		ClientSecret:     "secret-v1",
+		ClientSecretFile: secretPath,
+		RedirectURL:      mockServer.RedirectURL,
+		Scopes:           []string{"openid"},
+	}
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+
+	_, err = client.ExchangeCode(ctx, "mock-auth-code")
+	require.NoError(t, err)
+
+	// Rotate the secret at the provider and in the mounted file
+	mockServer.ClientSecret = "secret-v2"
+	require.NoError(t, os.WriteFile(secretPath, []byte("secret-v2-rotated"), 0o600))
+	require.NoError(t, os.WriteFile(secretPath, []byte("secret-v2"), 0o600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(secretPath, future, future))
+
+	_, err = client.ExchangeCode(ctx, "mock-auth-code")
+	require.NoError(t, err, "client should pick up the rotated secret")
+}
+
+func TestClient_WrongClientSecret(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ClientSecret = "expected-secret"
+
+	cfg := &config.OIDCConfig{
+		ProviderURL:  mockServer.Issuer,
+		ClientID:     mockServer.ClientID,
+		ClientSecret: "wrong-secret",
+		RedirectURL:  mockServer.RedirectURL,
+		Scopes:       []string{"openid"},
+	}
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+
+	_, err = client.ExchangeCode(ctx, "mock-auth-code")
+	assert.Error(t, err)
+}
+
+func TestClient_RevokeToken(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ClientSecret = "test-secret"
+
+	cfg := &config.OIDCConfig{
+		ProviderURL:  mockServer.Issuer,
+		ClientID:     mockServer.ClientID,
+		ClientSecret: "test-secret",
+		RedirectURL:  mockServer.RedirectURL,
+		Scopes:       []string{"openid"},
+	}
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, client.RevokeToken(ctx, "mock-refresh-token-1", "refresh_token"))
+	assert.Equal(t, []string{"mock-refresh-token-1"}, mockServer.RevokedTokens())
+
+	cfg.ClientSecret = "wrong-secret"
+	client, err = NewClient(ctx, cfg)
+	require.NoError(t, err)
+	assert.Error(t, client.RevokeToken(ctx, "mock-refresh-token-2", "refresh_token"))
+}
+
+func TestClient_RevokeToken_Unsupported(t *testing.T) {
+	customServer := createMockServerWithoutEndSession(t)
+	defer customServer.Close()
+
+	cfg := &config.OIDCConfig{
+		ProviderURL:  customServer.URL,
+		ClientID:     "test-client",
+		ClientSecret: "test-secret",
+		RedirectURL:  "http://localhost:8080/callback",
+		Scopes:       []string{"openid"},
+	}
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, client.RevokeToken(ctx, "token", "refresh_token"), ErrRevocationUnsupported)
+}
+
+func TestNewClient_LazyDiscovery(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.FailDiscovery(3)
+
+	cfg := &config.OIDCConfig{
+		ProviderURL:   mockServer.Issuer,
+		ClientID:      mockServer.ClientID,
+		ClientSecret:  "test-secret",
+		RedirectURL:   mockServer.RedirectURL,
+		Scopes:        []string{"openid"},
+		LazyDiscovery: true,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err, "the client starts degraded")
+	assert.False(t, client.Ready())
+	assert.False(t, client.Healthy())
+
+	_, err = client.GetAuthURL("state")
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+	_, err = client.RefreshToken(ctx, "mock-refresh-token-1")
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+	_, err = client.VerifyIDToken(ctx, "token")
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+	assert.ErrorIs(t, client.RevokeToken(ctx, "token", ""), ErrProviderUnavailable, "retried by the revoker")
+	assert.False(t, client.SupportsEndSession())
+
+	// Discovery is retried in the background until the provider answers
+	client.retryBackoff = 5 * time.Millisecond
+	client.Start(ctx)
+	assert.Eventually(t, client.Ready, 2*time.Second, 5*time.Millisecond)
+
+	_, err = client.RefreshToken(ctx, "mock-refresh-token-1")
+	assert.NoError(t, err)
+}
+
+func TestNewClient_DiscoveryRequired(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.FailDiscovery(1)
+
+	_, err = NewClient(context.Background(), &config.OIDCConfig{
+		ProviderURL: mockServer.Issuer,
+		ClientID:    mockServer.ClientID,
+	})
+	assert.Error(t, err)
+}
+
+func TestClient_Rediscovery_KeepsCachedMetadata(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	cfg := &config.OIDCConfig{
+		ProviderURL:       mockServer.Issuer,
+		ClientID:          mockServer.ClientID,
+		ClientSecret:      "test-secret",
+		RedirectURL:       mockServer.RedirectURL,
+		Scopes:            []string{"openid"},
+		DiscoveryInterval: 5 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+	before, err := client.metadata()
+	require.NoError(t, err)
+
+	// A failed rediscovery keeps the last metadata in use
+	mockServer.FailDiscovery(1)
+	assert.Error(t, client.discover(ctx))
+	after, err := client.metadata()
+	require.NoError(t, err)
+	assert.Same(t, before, after)
+
+	_, err = client.RefreshToken(ctx, "mock-refresh-token-1")
+	assert.NoError(t, err)
+
+	// Periodic rediscovery replaces it
+	client.Start(ctx)
+	assert.Eventually(t, func() bool {
+		meta, _ := client.metadata()
+		return meta != before
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	cfg := &config.OIDCConfig{
+		ProviderURL:      mockServer.Issuer,
+		ClientID:         mockServer.ClientID,
+		ClientSecret:     "test-secret",
+		RedirectURL:      mockServer.RedirectURL,
+		Scopes:           []string{"openid"},
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+	}
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+	now := time.Now()
+	client.breaker.now = func() time.Time { return now }
+
+	// Rejected grants do not count as provider failures
+	for range 3 {
+		_, err = client.RefreshToken(ctx, "invalid-refresh-token")
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrProviderUnavailable)
+	}
+	assert.True(t, client.Healthy())
+
+	// The oauth2 package may retry with another auth style, so keep failing
+	mockServer.FailTokens(100)
+	for range 2 {
+		_, err = client.RefreshToken(ctx, "mock-refresh-token-1")
+		require.Error(t, err)
+	}
+	assert.False(t, client.Healthy())
+
+	requests := mockServer.TokenRequests()
+	_, err = client.RefreshToken(ctx, "mock-refresh-token-1")
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+	_, err = client.ExchangeCode(ctx, "mock-auth-code")
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+	assert.Equal(t, requests, mockServer.TokenRequests(), "open breaker fails fast")
+
+	mockServer.FailTokens(0)
+	now = now.Add(time.Minute)
+	_, err = client.RefreshToken(ctx, "mock-refresh-token-1")
+	assert.NoError(t, err, "the probe succeeds and closes the breaker")
+	assert.True(t, client.Healthy())
+}
@@ -0,0 +1,189 @@
+package oidc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+)
+
+// ClientAssertionType is the RFC 7523 assertion type sent with private_key_jwt
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// assertionLifetime bounds how long a signed client assertion is accepted
+const assertionLifetime = time.Minute
+
+// assertionSigner signs private_key_jwt client assertions (RFC 7523)
+type assertionSigner struct {
+	clientID string
+	keyID    string
+	now      func() time.Time
+
+	mu     sync.Mutex
+	key    crypto.Signer
+	method jwt.SigningMethod
+	// keyFile is set when the key is mounted as a file that may rotate;
+	// keyPEM is the contents key was parsed from
+	keyFile *config.SecretFile
+	keyPEM  string
+	// reloadFailed reports a key file that could not be read or parsed
+	reloadFailed func(error)
+}
+
+func newAssertionSigner(clientID, keyPEM, keyID string) (*assertionSigner, error) {
+	key, method, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &assertionSigner{clientID: clientID, keyID: keyID, key: key, method: method, keyPEM: keyPEM, now: time.Now}, nil
+}
+
+// currentKey returns the signing key, parsing the key file again when it
+// changed. A file that cannot be read or parsed keeps the last good key.
+func (s *assertionSigner) currentKey() (crypto.Signer, jwt.SigningMethod) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keyFile == nil {
+		return s.key, s.method
+	}
+	keyPEM, err := s.keyFile.Value()
+	if err == nil && keyPEM != s.keyPEM {
+		var key crypto.Signer
+		var method jwt.SigningMethod
+		if key, method, err = parsePrivateKey(keyPEM); err == nil {
+			s.key, s.method, s.keyPEM = key, method, keyPEM
+		}
+	}
+	if err != nil && s.reloadFailed != nil {
+		s.reloadFailed(err)
+	}
+	return s.key, s.method
+}
+
+// sign returns a single-use assertion for audience, the token endpoint URL
+func (s *assertionSigner) sign(audience string) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("failed to generate assertion id: %w", err)
+	}
+
+	key, method := s.currentKey()
+	now := s.now()
+	token := jwt.NewWithClaims(method, jwt.RegisteredClaims{
+		Issuer:    s.clientID,
+		Subject:   s.clientID,
+		Audience:  jwt.ClaimStrings{audience},
+		ID:        hex.EncodeToString(jti),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(assertionLifetime)),
+	})
+	if s.keyID != "" {
+		token.Header["kid"] = s.keyID
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+	return signed, nil
+}
+
+// parsePrivateKey reads a PKCS#1, SEC 1 or PKCS#8 PEM key and picks the
+// matching JWS algorithm
+func parsePrivateKey(keyPEM string) (crypto.Signer, jwt.SigningMethod, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, nil, errors.New("OIDC_PRIVATE_KEY is not PEM encoded")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse OIDC_PRIVATE_KEY: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return k, jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return k, jwt.SigningMethodES384, nil
+		}
+	}
+	return nil, nil, errors.New("OIDC_PRIVATE_KEY must be an RSA, P-256 or P-384 key")
+}
+
+// assertionTransport authenticates the client on form POSTs to the provider
+// (token and revocation endpoints) with a fresh private_key_jwt assertion.
+// Going through the transport covers the oauth2 package's code exchange and
+// refresh alike, which offer no hook for extra parameters on refresh.
+type assertionTransport struct {
+	base   http.RoundTripper
+	signer *assertionSigner
+	// audience returns the current token endpoint URL
+	audience func() string
+}
+
+func (t *assertionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse provider request: %w", err)
+	}
+
+	assertion, err := t.signer.sign(t.audience())
+	if err != nil {
+		return nil, err
+	}
+	form.Del("client_secret")
+	form.Set("client_id", t.signer.clientID)
+	form.Set("client_assertion_type", ClientAssertionType)
+	form.Set("client_assertion", assertion)
+
+	encoded := []byte(form.Encode())
+	out := req.Clone(req.Context())
+	out.Header.Del("Authorization")
+	out.Body = io.NopCloser(bytes.NewReader(encoded))
+	out.ContentLength = int64(len(encoded))
+	out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(encoded)), nil
+	}
+
+	return t.base.RoundTrip(out)
+}
@@ -0,0 +1,225 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
+)
+
+func pkcs8PEM(t *testing.T, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func privateKeyJWTConfig(mockServer *mocks.MockOIDCServer, keyPEM, keyID string) *config.OIDCConfig {
+	cfg := testOIDCConfig(mockServer)
+	cfg.ClientAuthMethod = config.ClientAuthPrivateKeyJWT
+	cfg.PrivateKey = keyPEM
+	cfg.PrivateKeyID = keyID
+	return cfg
+}
+
+func TestClient_PrivateKeyJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		public any
+		keyPEM string
+	}{
+		{"RSA PKCS#8", &rsaKey.PublicKey, pkcs8PEM(t, rsaKey)},
+		{"RSA PKCS#1", &rsaKey.PublicKey, string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))},
+		{"EC P-256", &ecKey.PublicKey, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer, err := mocks.NewMockOIDCServer()
+			require.NoError(t, err)
+			defer mockServer.Close()
+			mockServer.ClientPublicKey = tt.public
+			mockServer.ClientKeyID = "key-1"
+
+			ctx := context.Background()
+			client, err := NewClient(ctx, privateKeyJWTConfig(mockServer, tt.keyPEM, "key-1"))
+			require.NoError(t, err)
+
+			// Each call signs a fresh assertion, so repeated calls are not replays
+			token, err := client.ExchangeCode(ctx, "mock-auth-code")
+			require.NoError(t, err)
+			_, err = client.RefreshToken(ctx, token.RefreshToken)
+			require.NoError(t, err)
+			_, err = client.RefreshToken(ctx, token.RefreshToken)
+			require.NoError(t, err)
+			require.NoError(t, client.RevokeToken(ctx, token.RefreshToken, "refresh_token"))
+		})
+	}
+}
+
+func TestClient_PrivateKeyJWT_Rejected(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ClientPublicKey = &key.PublicKey
+	mockServer.ClientKeyID = "key-1"
+
+	ctx := context.Background()
+
+	client, err := NewClient(ctx, privateKeyJWTConfig(mockServer, pkcs8PEM(t, key), "key-2"))
+	require.NoError(t, err)
+	_, err = client.ExchangeCode(ctx, "mock-auth-code")
+	assert.Error(t, err, "unknown kid")
+
+	client, err = NewClient(ctx, privateKeyJWTConfig(mockServer, pkcs8PEM(t, otherKey), "key-1"))
+	require.NoError(t, err)
+	_, err = client.ExchangeCode(ctx, "mock-auth-code")
+	assert.Error(t, err, "wrong signing key")
+
+	client, err = NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+	_, err = client.ExchangeCode(ctx, "mock-auth-code")
+	assert.Error(t, err, "the shared secret is no longer accepted")
+}
+
+func TestClient_PrivateKeyJWT_KeyFileRotation(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rotatedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ClientPublicKey = &key.PublicKey
+	mockServer.ClientKeyID = "key-1"
+
+	keyPEM := pkcs8PEM(t, key)
+	cfg := privateKeyJWTConfig(mockServer, keyPEM, "key-1")
+	cfg.PrivateKeyFile = writeTestFile(t, "private-key.pem", []byte(keyPEM))
+	ctx := context.Background()
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+	_, err = client.ExchangeCode(ctx, "mock-auth-code")
+	require.NoError(t, err)
+
+	rotate := func(content string) {
+		require.NoError(t, os.WriteFile(cfg.PrivateKeyFile, []byte(content), 0o600))
+		future := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(cfg.PrivateKeyFile, future, future))
+	}
+
+	// The provider now only accepts the rotated key
+	rotate(pkcs8PEM(t, rotatedKey))
+	mockServer.ClientPublicKey = &rotatedKey.PublicKey
+	_, err = client.ExchangeCode(ctx, "mock-auth-code")
+	require.NoError(t, err)
+
+	// A file that does not parse keeps the last good key
+	rotate("not a key")
+	_, err = client.ExchangeCode(ctx, "mock-auth-code")
+	require.NoError(t, err)
+}
+
+func TestMockOIDCServer_RejectsReplayedAssertion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ClientPublicKey = &key.PublicKey
+	mockServer.ClientKeyID = "key-1"
+
+	signer, err := newAssertionSigner(mockServer.ClientID, pkcs8PEM(t, key), "key-1")
+	require.NoError(t, err)
+	assertion, err := signer.sign(mockServer.Issuer + "/token")
+	require.NoError(t, err)
+
+	form := url.Values{
+		"grant_type":            {"refresh_token"},
+		"refresh_token":         {"mock-refresh-token-1"},
+		"client_id":             {mockServer.ClientID},
+		"client_assertion_type": {ClientAssertionType},
+		"client_assertion":      {assertion},
+	}
+	post := func() int {
+		resp, err := http.Post(mockServer.Issuer+"/token", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, post())
+	assert.Equal(t, http.StatusUnauthorized, post())
+}
+
+func TestClient_TLSClientAuth(t *testing.T) {
+	ca, err := mocks.NewCertificateAuthority()
+	require.NoError(t, err)
+	mockServer, err := mocks.NewMockOIDCServerTLS(ca, true)
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ClientCertSubject = "authservice"
+
+	newClient := func(commonName string) *Client {
+		certPEM, keyPEM, err := ca.IssueClient(commonName)
+		require.NoError(t, err)
+
+		cfg := testOIDCConfig(mockServer)
+		cfg.ClientAuthMethod = config.ClientAuthTLS
+		cfg.ClientSecret = ""
+		cfg.HTTP.CAFile = writeTestFile(t, "ca.pem", ca.PEM)
+		cfg.HTTP.CertFile = writeTestFile(t, "client.pem", certPEM)
+		cfg.HTTP.KeyFile = writeTestFile(t, "client-key.pem", keyPEM)
+
+		client, err := NewClient(context.Background(), cfg)
+		require.NoError(t, err)
+		return client
+	}
+
+	ctx := context.Background()
+
+	_, err = newClient("authservice").ExchangeCode(ctx, "mock-auth-code")
+	assert.NoError(t, err)
+
+	_, err = newClient("someone-else").ExchangeCode(ctx, "mock-auth-code")
+	assert.Error(t, err, "the certificate identifies another client")
+}
+
+func TestParsePrivateKey_Invalid(t *testing.T) {
+	_, _, err := parsePrivateKey("not a key")
+	assert.ErrorContains(t, err, "not PEM encoded")
+
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	require.NoError(t, err)
+	_, _, err = parsePrivateKey(pkcs8PEM(t, p521))
+	assert.ErrorContains(t, err, "P-256 or P-384")
+}
@@ -0,0 +1,166 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DeviceCodeGrantType is the RFC 8628 grant type used to poll the token endpoint
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// defaultDeviceInterval is the polling interval RFC 8628 prescribes when the
+// provider does not send one
+const defaultDeviceInterval = 5 * time.Second
+
+// Errors returned by PollDeviceToken for the RFC 8628 polling error codes
+var (
+	// ErrAuthorizationPending means the user has not completed the authorization yet
+	ErrAuthorizationPending = errors.New("device authorization pending")
+	// ErrSlowDown means the device polls too often and must back off
+	ErrSlowDown = errors.New("device polling too fast")
+	// ErrDeviceAccessDenied means the user declined the authorization
+	ErrDeviceAccessDenied = errors.New("device authorization denied")
+	// ErrDeviceCodeExpired means the device code expired before it was authorized
+	ErrDeviceCodeExpired = errors.New("device code expired")
+)
+
+// ErrDeviceGrantUnsupported is returned when the provider advertises no device authorization endpoint
+var ErrDeviceGrantUnsupported = errors.New("provider does not support the device authorization grant")
+
+// DeviceAuthorization is the provider's answer to a device authorization request
+type DeviceAuthorization struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresAt               time.Time
+	// Interval is the minimum delay between two polls
+	Interval time.Duration
+}
+
+// SupportsDeviceGrant reports whether the provider advertises a
+// device_authorization_endpoint
+func (c *Client) SupportsDeviceGrant() bool {
+	meta := c.meta.Load()
+	return meta != nil && meta.endpoint.DeviceAuthURL != ""
+}
+
+// StartDeviceAuthorization requests a device and user code from the provider
+// (RFC 8628 section 3.1) for the configured scopes
+func (c *Client) StartDeviceAuthorization(ctx context.Context) (*DeviceAuthorization, error) {
+	meta, err := c.metadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device authorization: %w", err)
+	}
+	if meta.endpoint.DeviceAuthURL == "" {
+		return nil, ErrDeviceGrantUnsupported
+	}
+
+	c.debug().Str("device_authorization_endpoint", meta.endpoint.DeviceAuthURL).Msg("Starting device authorization")
+
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	form := url.Values{}
+	if len(c.oauth2Config.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.oauth2Config.Scopes, " "))
+	}
+
+	resp, err := c.postForm(ctx, meta, meta.endpoint.DeviceAuthURL, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device authorization: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device authorization response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to start device authorization: %w", retrieveError(resp, body))
+	}
+
+	var payload struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int64  `json:"expires_in"`
+		Interval                int64  `json:"interval"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode device authorization response: %w", err)
+	}
+	if payload.DeviceCode == "" || payload.UserCode == "" || payload.VerificationURI == "" || payload.ExpiresIn <= 0 {
+		return nil, errors.New("incomplete device authorization response")
+	}
+
+	authorization := &DeviceAuthorization{
+		DeviceCode:              payload.DeviceCode,
+		UserCode:                payload.UserCode,
+		VerificationURI:         payload.VerificationURI,
+		VerificationURIComplete: payload.VerificationURIComplete,
+		ExpiresAt:               time.Now().Add(time.Duration(payload.ExpiresIn) * time.Second),
+		Interval:                time.Duration(payload.Interval) * time.Second,
+	}
+	if authorization.Interval <= 0 {
+		authorization.Interval = defaultDeviceInterval
+	}
+	return authorization, nil
+}
+
+// PollDeviceToken asks the token endpoint once whether the device code has
+// been authorized (RFC 8628 section 3.4). While the user has not finished it
+// returns ErrAuthorizationPending or ErrSlowDown; ErrDeviceAccessDenied and
+// ErrDeviceCodeExpired are final. On success the ID token has been verified.
+func (c *Client) PollDeviceToken(ctx context.Context, deviceCode string) (*oauth2.Token, error) {
+	meta, err := c.metadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to poll device token: %w", err)
+	}
+
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	token, err := c.callTokenEndpoint(func() (*oauth2.Token, error) {
+		return c.requestToken(ctx, meta, url.Values{
+			"grant_type":  {DeviceCodeGrantType},
+			"device_code": {deviceCode},
+		})
+	})
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) {
+			switch retrieveErr.ErrorCode {
+			case "authorization_pending":
+				return nil, ErrAuthorizationPending
+			case "slow_down":
+				return nil, ErrSlowDown
+			case "access_denied":
+				return nil, ErrDeviceAccessDenied
+			case "expired_token":
+				return nil, ErrDeviceCodeExpired
+			}
+		}
+		return nil, fmt.Errorf("failed to poll device token: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("no id_token in token response")
+	}
+	if _, err := meta.verifier.Verify(ctx, rawIDToken); err != nil {
+		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+	}
+
+	return token, nil
+}
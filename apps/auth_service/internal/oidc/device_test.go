@@ -0,0 +1,95 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
+)
+
+func TestClient_DeviceAuthorization(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ClientSecret = "test-secret"
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+	assert.True(t, client.SupportsDeviceGrant())
+
+	authorization, err := client.StartDeviceAuthorization(ctx)
+	require.NoError(t, err)
+	assert.NotEmpty(t, authorization.DeviceCode)
+	assert.NotEmpty(t, authorization.UserCode)
+	assert.Equal(t, mockServer.Issuer+"/device/verify", authorization.VerificationURI)
+	assert.Equal(t, 5*time.Second, authorization.Interval)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), authorization.ExpiresAt, 5*time.Second)
+
+	_, err = client.PollDeviceToken(ctx, authorization.DeviceCode)
+	assert.ErrorIs(t, err, ErrAuthorizationPending)
+
+	require.True(t, mockServer.ApproveDevice(authorization.UserCode))
+
+	token, err := client.PollDeviceToken(ctx, authorization.DeviceCode)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+	assert.NotEmpty(t, token.RefreshToken)
+	assert.NotEmpty(t, token.Extra("id_token"))
+	assert.False(t, token.Expiry.IsZero())
+
+	// The device code is redeemed once
+	_, err = client.PollDeviceToken(ctx, authorization.DeviceCode)
+	assert.Error(t, err)
+	assert.True(t, client.Healthy(), "rejected grants are not provider failures")
+}
+
+func TestClient_DeviceAuthorization_Denied(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+
+	authorization, err := client.StartDeviceAuthorization(ctx)
+	require.NoError(t, err)
+	require.True(t, mockServer.DenyDevice(authorization.UserCode))
+
+	_, err = client.PollDeviceToken(ctx, authorization.DeviceCode)
+	assert.ErrorIs(t, err, ErrDeviceAccessDenied)
+}
+
+func TestClient_DeviceAuthorization_WrongClientSecret(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ClientSecret = "other-secret"
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+
+	_, err = client.StartDeviceAuthorization(ctx)
+	assert.Error(t, err)
+}
+
+func TestClient_DeviceAuthorization_Unsupported(t *testing.T) {
+	customServer := createMockServerWithoutEndSession(t)
+	defer customServer.Close()
+
+	cfg := testOIDCConfig(&mocks.MockOIDCServer{Issuer: customServer.URL, ClientID: "test-client"})
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+	assert.False(t, client.SupportsDeviceGrant())
+
+	_, err = client.StartDeviceAuthorization(ctx)
+	assert.ErrorIs(t, err, ErrDeviceGrantUnsupported)
+}
@@ -0,0 +1,167 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Token exchange (RFC 8693) identifiers
+const (
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	AccessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// ExchangeToken trades a user's access token for one meant for audience
+// (RFC 8693), so a backend can call another service on the user's behalf.
+// The provider decides whether this client may exchange for that audience.
+func (c *Client) ExchangeToken(ctx context.Context, subjectToken, audience, scope string) (*oauth2.Token, error) {
+	form := url.Values{
+		"grant_type":           {TokenExchangeGrantType},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {AccessTokenType},
+		"requested_token_type": {AccessTokenType},
+	}
+	if audience != "" {
+		form.Set("audience", audience)
+	}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+
+	token, err := c.tokenRequest(ctx, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange token: %w", err)
+	}
+	return token, nil
+}
+
+// ImpersonateToken trades a support engineer's access token for a token of
+// the user subject, using the requested_subject extension of token exchange.
+// Providers that support it mark the token with an act claim naming the
+// engineer; the provider decides whether the engineer may impersonate.
+func (c *Client) ImpersonateToken(ctx context.Context, actorToken, subject string) (*oauth2.Token, error) {
+	form := url.Values{
+		"grant_type":           {TokenExchangeGrantType},
+		"subject_token":        {actorToken},
+		"subject_token_type":   {AccessTokenType},
+		"requested_token_type": {AccessTokenType},
+		"requested_subject":    {subject},
+	}
+
+	token, err := c.tokenRequest(ctx, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate user: %w", err)
+	}
+	return token, nil
+}
+
+// ClientCredentialsToken requests a token for the service itself, without a
+// user, optionally restricted to audience and scope
+func (c *Client) ClientCredentialsToken(ctx context.Context, audience, scope string) (*oauth2.Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if audience != "" {
+		form.Set("audience", audience)
+	}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+
+	token, err := c.tokenRequest(ctx, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client credentials token: %w", err)
+	}
+	return token, nil
+}
+
+// tokenRequest sends form to the token endpoint through the circuit breaker
+func (c *Client) tokenRequest(ctx context.Context, form url.Values) (*oauth2.Token, error) {
+	meta, err := c.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	c.debug().Str("token_endpoint", meta.endpoint.TokenURL).Str("grant_type", form.Get("grant_type")).Msg("Requesting token")
+
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
+	return c.callTokenEndpoint(func() (*oauth2.Token, error) {
+		return c.requestToken(ctx, meta, form)
+	})
+}
+
+// defaultTokenLeeway is how long before expiry a cached token is replaced
+const defaultTokenLeeway = 30 * time.Second
+
+// ServiceTokenCache caches client credentials tokens per calling service,
+// audience and scope and fetches a new one shortly before the cached token
+// expires. Concurrent requests of a service for the same token share a single
+// provider request; services never share tokens.
+type ServiceTokenCache struct {
+	client *Client
+	leeway time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[serviceTokenKey]*serviceTokenEntry
+}
+
+type serviceTokenKey struct {
+	caller   string
+	audience string
+	scope    string
+}
+
+type serviceTokenEntry struct {
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// NewServiceTokenCache creates a cache of client credentials tokens from client
+func NewServiceTokenCache(client *Client) *ServiceTokenCache {
+	return &ServiceTokenCache{
+		client:  client,
+		leeway:  defaultTokenLeeway,
+		now:     time.Now,
+		entries: make(map[serviceTokenKey]*serviceTokenEntry),
+	}
+}
+
+// Token returns the token cached for caller, audience and scope, requesting a
+// new one when none is cached or the cached one is about to expire
+func (s *ServiceTokenCache) Token(ctx context.Context, caller, audience, scope string) (*oauth2.Token, error) {
+	key := serviceTokenKey{caller: caller, audience: audience, scope: scope}
+
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &serviceTokenEntry{}
+		s.entries[key] = entry
+	}
+	s.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.token != nil && s.fresh(entry.token) {
+		return entry.token, nil
+	}
+
+	token, err := s.client.ClientCredentialsToken(ctx, audience, scope)
+	if err != nil {
+		return nil, err
+	}
+	entry.token = token
+	return token, nil
+}
+
+// fresh reports whether token stays valid beyond the leeway; tokens without
+// an expiry are not cached
+func (s *ServiceTokenCache) fresh(token *oauth2.Token) bool {
+	return !token.Expiry.IsZero() && s.now().Add(s.leeway).Before(token.Expiry)
+}
@@ -0,0 +1,181 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
+)
+
+func tokenClaims(t *testing.T, token string) jwt.MapClaims {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+	return claims
+}
+
+func TestClient_ExchangeToken(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ExchangeAudiences = []string{"video-metadata"}
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+
+	userToken, err := client.ExchangeCode(ctx, "mock-auth-code")
+	require.NoError(t, err)
+
+	token, err := client.ExchangeToken(ctx, userToken.AccessToken, "video-metadata", "")
+	require.NoError(t, err)
+	assert.NotEqual(t, userToken.AccessToken, token.AccessToken)
+	assert.False(t, token.Expiry.IsZero())
+
+	claims := tokenClaims(t, token.AccessToken)
+	assert.Equal(t, "test-user", claims["sub"], "the exchanged token keeps the user")
+	assert.Equal(t, "video-metadata", claims["aud"])
+}
+
+func TestClient_ExchangeToken_Rejected(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ExchangeAudiences = []string{"video-metadata"}
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+
+	userToken, err := client.ExchangeCode(ctx, "mock-auth-code")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		subjectToken string
+		audience     string
+		code         string
+	}{
+		{"audience not allowed", userToken.AccessToken, "billing", "invalid_target"},
+		{"forged subject token", "not-a-token", "video-metadata", "invalid_grant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.ExchangeToken(ctx, tt.subjectToken, tt.audience, "")
+			var retrieveErr *oauth2.RetrieveError
+			require.True(t, errors.As(err, &retrieveErr))
+			assert.Equal(t, tt.code, retrieveErr.ErrorCode)
+		})
+	}
+	assert.True(t, client.Healthy(), "rejected exchanges are not provider failures")
+}
+
+func TestClient_ImpersonateToken(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+
+	actorToken, err := client.ExchangeCode(ctx, "mock-auth-code")
+	require.NoError(t, err)
+
+	token, err := client.ImpersonateToken(ctx, actorToken.AccessToken, "user-42")
+	require.NoError(t, err)
+
+	claims := tokenClaims(t, token.AccessToken)
+	assert.Equal(t, "user-42", claims["sub"])
+	assert.Equal(t, map[string]any{"sub": "test-user"}, claims["act"], "the token names the impersonator")
+
+	_, err = client.ImpersonateToken(ctx, "not-a-token", "user-42")
+	var retrieveErr *oauth2.RetrieveError
+	require.True(t, errors.As(err, &retrieveErr))
+	assert.Equal(t, "invalid_grant", retrieveErr.ErrorCode)
+}
+
+func TestClient_ClientCredentialsToken(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+
+	token, err := client.ClientCredentialsToken(ctx, "video-metadata", "")
+	require.NoError(t, err)
+
+	claims := tokenClaims(t, token.AccessToken)
+	assert.Equal(t, "service-account-"+mockServer.ClientID, claims["sub"])
+	assert.Equal(t, "video-metadata", claims["aud"])
+}
+
+func TestServiceTokenCache(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+
+	now := time.Now()
+	cache := NewServiceTokenCache(client)
+	cache.now = func() time.Time { return now }
+
+	first, err := cache.Token(ctx, "video-upload", "video-metadata", "")
+	require.NoError(t, err)
+	requests := mockServer.TokenRequests()
+
+	cached, err := cache.Token(ctx, "video-upload", "video-metadata", "")
+	require.NoError(t, err)
+	assert.Equal(t, first.AccessToken, cached.AccessToken)
+	assert.Equal(t, requests, mockServer.TokenRequests(), "cached token is reused")
+
+	// Another audience is a separate token
+	_, err = cache.Token(ctx, "video-upload", "billing", "")
+	require.NoError(t, err)
+	assert.Equal(t, requests+1, mockServer.TokenRequests())
+
+	// Another service gets its own token
+	_, err = cache.Token(ctx, "billing-worker", "video-metadata", "")
+	require.NoError(t, err)
+	assert.Equal(t, requests+2, mockServer.TokenRequests(), "tokens are not shared across services")
+
+	// Within the leeway of its expiry the token is replaced
+	now = first.Expiry.Add(-defaultTokenLeeway)
+	_, err = cache.Token(ctx, "video-upload", "video-metadata", "")
+	require.NoError(t, err)
+	assert.Equal(t, requests+3, mockServer.TokenRequests())
+}
+
+func TestServiceTokenCache_DoesNotCacheErrors(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+	mockServer.ExchangeAudiences = []string{"video-metadata"}
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer))
+	require.NoError(t, err)
+	cache := NewServiceTokenCache(client)
+
+	_, err = cache.Token(ctx, "video-upload", "billing", "")
+	assert.Error(t, err)
+
+	requests := mockServer.TokenRequests()
+	_, err = cache.Token(ctx, "video-upload", "billing", "")
+	assert.Error(t, err)
+	assert.Equal(t, requests+1, mockServer.TokenRequests())
+}
@@ -0,0 +1,130 @@
+package oidc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+)
+
+// retryBackoff is the delay before the first retry of an idempotent request;
+// it doubles on every further retry
+const retryBackoff = 100 * time.Millisecond
+
+// NewHTTPClient builds the client used for requests to the provider: bounded
+// by cfg.Timeout, retrying idempotent requests, and with the configured proxy,
+// extra root CAs and client certificate
+func NewHTTPClient(cfg *config.OIDCConfig) (*http.Client, error) {
+	tlsConfig, err := providerTLSConfig(cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.HTTP.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.HTTP.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OIDC_HTTP_PROXY: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	if cfg.HTTP.DialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: cfg.HTTP.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.TLSHandshakeTimeout = cfg.HTTP.DialTimeout
+	}
+
+	return &http.Client{
+		Transport: &retryTransport{base: transport, retries: cfg.HTTP.Retries, backoff: retryBackoff},
+		Timeout:   cfg.Timeout,
+	}, nil
+}
+
+// providerTLSConfig trusts CAFile on top of the system roots and presents the
+// client certificate, when configured
+func providerTLSConfig(cfg config.OIDCHTTPConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OIDC_HTTP_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("OIDC_HTTP_CA_FILE contains no PEM certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load OIDC_HTTP_CERT_FILE/OIDC_HTTP_KEY_FILE: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// retryTransport retries idempotent requests that fail with a network error
+// or a 429/5xx response. Token endpoint calls are POSTs and never retried,
+// since an authorization code can only be redeemed once.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	backoff time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.retries <= 0 || !idempotent(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	delay := t.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt == t.retries || !retryable(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func idempotent(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) &&
+		(req.Body == nil || req.Body == http.NoBody)
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
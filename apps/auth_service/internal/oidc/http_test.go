@@ -0,0 +1,223 @@
+package oidc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
+)
+
+func writeTestFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, content, 0o600))
+	return path
+}
+
+func testOIDCConfig(mockServer *mocks.MockOIDCServer) *config.OIDCConfig {
+	return &config.OIDCConfig{
+		ProviderURL:  mockServer.Issuer,
+		ClientID:     mockServer.ClientID,
+		ClientSecret: "test-secret",
+		RedirectURL:  mockServer.RedirectURL,
+		Scopes:       []string{"openid"},
+		Timeout:      5 * time.Second,
+	}
+}
+
+func TestNewClient_CustomCA(t *testing.T) {
+	ca, err := mocks.NewCertificateAuthority()
+	require.NoError(t, err)
+	mockServer, err := mocks.NewMockOIDCServerTLS(ca, false)
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	ctx := context.Background()
+	cfg := testOIDCConfig(mockServer)
+
+	_, err = NewClient(ctx, cfg)
+	require.Error(t, err, "the private CA is not trusted by default")
+
+	cfg.HTTP.CAFile = writeTestFile(t, "ca.pem", ca.PEM)
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+
+	_, err = client.RefreshToken(ctx, "mock-refresh-token-1")
+	assert.NoError(t, err)
+
+	cfg.HTTP.CAFile = writeTestFile(t, "empty.pem", []byte("not a certificate"))
+	_, err = NewClient(ctx, cfg)
+	assert.ErrorContains(t, err, "OIDC_HTTP_CA_FILE")
+}
+
+func TestNewClient_MutualTLS(t *testing.T) {
+	ca, err := mocks.NewCertificateAuthority()
+	require.NoError(t, err)
+	mockServer, err := mocks.NewMockOIDCServerTLS(ca, true)
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	ctx := context.Background()
+	cfg := testOIDCConfig(mockServer)
+	cfg.HTTP.CAFile = writeTestFile(t, "ca.pem", ca.PEM)
+
+	_, err = NewClient(ctx, cfg)
+	require.Error(t, err, "the provider requires a client certificate")
+
+	certPEM, keyPEM, err := ca.IssueClient("authservice")
+	require.NoError(t, err)
+	cfg.HTTP.CertFile = writeTestFile(t, "client.pem", certPEM)
+	cfg.HTTP.KeyFile = writeTestFile(t, "client-key.pem", keyPEM)
+
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, client.RevokeToken(ctx, "mock-refresh-token-1", "refresh_token"))
+	assert.Equal(t, []string{"mock-refresh-token-1"}, mockServer.RevokedTokens())
+}
+
+func TestNewClient_Proxy(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.Path)
+		mu.Unlock()
+
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		for key, values := range resp.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+
+	cfg := testOIDCConfig(mockServer)
+	cfg.HTTP.ProxyURL = proxy.URL
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, cfg)
+	require.NoError(t, err)
+	_, err = client.RefreshToken(ctx, "mock-refresh-token-1")
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, proxied, "/.well-known/openid-configuration")
+	assert.Contains(t, proxied, "/token")
+}
+
+func TestNewClient_WithHTTPClient(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	var requests atomic.Int32
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests.Add(1)
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testOIDCConfig(mockServer), WithHTTPClient(httpClient))
+	require.NoError(t, err)
+	require.NoError(t, client.RevokeToken(ctx, "mock-refresh-token-1", ""))
+
+	assert.Equal(t, int32(2), requests.Load(), "discovery and revocation use the injected client")
+}
+
+func TestNewClient_RetriesDiscovery(t *testing.T) {
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	defer mockServer.Close()
+
+	cfg := testOIDCConfig(mockServer)
+	cfg.HTTP.Retries = 2
+
+	mockServer.FailDiscovery(2)
+	_, err = NewClient(context.Background(), cfg)
+	assert.NoError(t, err)
+
+	mockServer.FailDiscovery(3)
+	_, err = NewClient(context.Background(), cfg)
+	assert.Error(t, err)
+}
+
+func TestRetryTransport(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &retryTransport{base: http.DefaultTransport, retries: 2, backoff: time.Millisecond}}
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Non-idempotent requests are sent once
+	requests.Store(0)
+	resp, err = client.Post(server.URL, "application/x-www-form-urlencoded", strings.NewReader("code=x"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestNewHTTPClient_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	httpClient, err := NewHTTPClient(&config.OIDCConfig{Timeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = httpClient.Get(server.URL)
+	assert.Error(t, err, "a hung provider does not block the caller")
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,145 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/carlosealves2/short-stream/authservice/pkg/logger"
+)
+
+// maxPendingRevocations bounds the revocations being retried at once, so a
+// provider outage cannot pile up unbounded goroutines
+const maxPendingRevocations = 1024
+
+// AsyncRevoker revokes tokens at the provider and, when an attempt fails,
+// keeps retrying in the background with exponential backoff. Pending retries
+// live in memory and are lost on restart.
+type AsyncRevoker struct {
+	revoke   func(ctx context.Context, token, tokenTypeHint string) error
+	logger   logger.Logger
+	attempts int
+	backoff  time.Duration
+	timeout  time.Duration
+	pending  chan struct{}
+	// ctx bounds the background retries, which stop once it is done
+	ctx     context.Context
+	retries sync.WaitGroup
+}
+
+// RevokerOption customizes an AsyncRevoker
+type RevokerOption func(*AsyncRevoker)
+
+// WithRetry sets how many attempts a revocation gets in total and the delay
+// before the first retry, which doubles on every further retry
+func WithRetry(attempts int, backoff time.Duration) RevokerOption {
+	return func(r *AsyncRevoker) {
+		r.attempts = attempts
+		r.backoff = backoff
+	}
+}
+
+// WithRevokerLogger sets the logger used to report failed revocations
+func WithRevokerLogger(log logger.Logger) RevokerOption {
+	return func(r *AsyncRevoker) {
+		r.logger = log
+	}
+}
+
+// WithRevokerContext ties the background retries to ctx: once it is done,
+// pending retries are abandoned
+func WithRevokerContext(ctx context.Context) RevokerOption {
+	return func(r *AsyncRevoker) {
+		r.ctx = ctx
+	}
+}
+
+// NewAsyncRevoker creates an AsyncRevoker revoking through client
+func NewAsyncRevoker(client *Client, opts ...RevokerOption) *AsyncRevoker {
+	r := &AsyncRevoker{
+		revoke:   client.RevokeToken,
+		attempts: 5,
+		backoff:  2 * time.Second,
+		timeout:  client.timeout,
+		pending:  make(chan struct{}, maxPendingRevocations),
+		ctx:      context.Background(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// RevokeToken makes a first revocation attempt and returns its result. On
+// failure the revocation is retried in the background; providers without a
+// revocation endpoint are not retried.
+func (r *AsyncRevoker) RevokeToken(ctx context.Context, token, tokenTypeHint string) error {
+	err := r.revoke(ctx, token, tokenTypeHint)
+	if err == nil || errors.Is(err, ErrRevocationUnsupported) || r.attempts <= 1 {
+		return err
+	}
+
+	select {
+	case r.pending <- struct{}{}:
+		r.retries.Add(1)
+		go r.retry(token, tokenTypeHint)
+	default:
+		if r.logger != nil {
+			r.logger.Error().Msg("Too many pending token revocations, dropping retry")
+		}
+	}
+
+	return err
+}
+
+// Wait blocks until every background retry has returned
+func (r *AsyncRevoker) Wait() {
+	r.retries.Wait()
+}
+
+func (r *AsyncRevoker) retry(token, tokenTypeHint string) {
+	defer r.retries.Done()
+	defer func() { <-r.pending }()
+
+	delay := r.backoff
+	for attempt := 2; attempt <= r.attempts; attempt++ {
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			if r.logger != nil {
+				r.logger.Warn().Int("attempt", attempt).Msg("Shutting down, abandoning token revocation retry")
+			}
+			return
+		case <-timer.C:
+		}
+		delay *= 2
+
+		// Detached from the request that triggered the revocation, which is long gone
+		ctx, cancel := context.WithTimeout(r.ctx, r.attemptTimeout())
+		err := r.revoke(ctx, token, tokenTypeHint)
+		cancel()
+
+		if err == nil {
+			if r.logger != nil {
+				r.logger.Info().Int("attempt", attempt).Msg("Token revoked at provider after retry")
+			}
+			return
+		}
+		if r.logger != nil {
+			r.logger.Warn().Err(err).Int("attempt", attempt).Int("attempts", r.attempts).Msg("Token revocation retry failed")
+		}
+	}
+
+	if r.logger != nil {
+		r.logger.Error().Int("attempts", r.attempts).Msg("Giving up revoking token at provider")
+	}
+}
+
+func (r *AsyncRevoker) attemptTimeout() time.Duration {
+	if r.timeout > 0 {
+		return r.timeout
+	}
+	return 30 * time.Second
+}
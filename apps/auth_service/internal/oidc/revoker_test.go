@@ -0,0 +1,104 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/config"
+	"github.com/carlosealves2/short-stream/authservice/internal/testutil/mocks"
+)
+
+func newRevokerTestClient(t *testing.T) (*Client, *mocks.MockOIDCServer) {
+	t.Helper()
+
+	mockServer, err := mocks.NewMockOIDCServer()
+	require.NoError(t, err)
+	t.Cleanup(mockServer.Close)
+
+	client, err := NewClient(context.Background(), &config.OIDCConfig{
+		ProviderURL:  mockServer.Issuer,
+		ClientID:     mockServer.ClientID,
+		ClientSecret: "test-secret",
+		RedirectURL:  mockServer.RedirectURL,
+		Scopes:       []string{"openid"},
+	})
+	require.NoError(t, err)
+
+	return client, mockServer
+}
+
+func TestAsyncRevoker_RetriesInBackground(t *testing.T) {
+	client, mockServer := newRevokerTestClient(t)
+	mockServer.FailRevocations(2)
+
+	revoker := NewAsyncRevoker(client, WithRetry(5, 10*time.Millisecond))
+
+	err := revoker.RevokeToken(context.Background(), "mock-refresh-token-1", "refresh_token")
+	assert.Error(t, err, "the first attempt's failure is reported")
+
+	assert.Eventually(t, func() bool {
+		return len(mockServer.RevokedTokens()) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"mock-refresh-token-1"}, mockServer.RevokedTokens())
+}
+
+func TestAsyncRevoker_GivesUp(t *testing.T) {
+	client, mockServer := newRevokerTestClient(t)
+	mockServer.FailRevocations(3)
+
+	revoker := NewAsyncRevoker(client, WithRetry(2, 10*time.Millisecond))
+	require.Error(t, revoker.RevokeToken(context.Background(), "mock-refresh-token-1", "refresh_token"))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, mockServer.RevokedTokens())
+
+	// Exactly two attempts were made, leaving one injected failure
+	require.Error(t, client.RevokeToken(context.Background(), "mock-refresh-token-2", "refresh_token"))
+	require.NoError(t, client.RevokeToken(context.Background(), "mock-refresh-token-3", "refresh_token"))
+}
+
+func TestAsyncRevoker_Unsupported(t *testing.T) {
+	customServer := createMockServerWithoutEndSession(t)
+	defer customServer.Close()
+
+	client, err := NewClient(context.Background(), &config.OIDCConfig{
+		ProviderURL:  customServer.URL,
+		ClientID:     "test-client",
+		ClientSecret: "test-secret",
+		RedirectURL:  "http://localhost:8080/callback",
+		Scopes:       []string{"openid"},
+	})
+	require.NoError(t, err)
+
+	revoker := NewAsyncRevoker(client, WithRetry(5, time.Millisecond))
+	assert.ErrorIs(t, revoker.RevokeToken(context.Background(), "token", "refresh_token"), ErrRevocationUnsupported)
+	assert.Empty(t, revoker.pending, "unsupported revocation is not retried")
+}
+
+func TestAsyncRevoker_StopsWithContext(t *testing.T) {
+	client, mockServer := newRevokerTestClient(t)
+	mockServer.FailRevocations(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	revoker := NewAsyncRevoker(client, WithRetry(5, time.Hour), WithRevokerContext(ctx))
+	require.Error(t, revoker.RevokeToken(context.Background(), "mock-refresh-token-1", "refresh_token"))
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		revoker.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("retry did not stop with its context")
+	}
+	assert.Empty(t, mockServer.RevokedTokens())
+	assert.Empty(t, revoker.pending)
+}
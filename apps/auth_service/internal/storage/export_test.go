@@ -0,0 +1,8 @@
+package storage
+
+// Redis key layout, for tests that inspect Redis directly
+var (
+	RedisSessionKey      = sessionKey
+	RedisUserSessionsKey = userSessionsKey
+	NewRedisSessionID    = newSessionID
+)
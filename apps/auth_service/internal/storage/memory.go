@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memorySweepEvery controls how often the memory store drops expired entries
+const memorySweepEvery = 256
+
+// MemoryOption configures the in-memory store
+type MemoryOption func(*memoryStore)
+
+// WithClock replaces time.Now, letting tests control expiry
+func WithClock(now func() time.Time) MemoryOption {
+	return func(m *memoryStore) {
+		m.now = now
+	}
+}
+
+type memoryState struct {
+	data      StateData
+	expiresAt time.Time
+}
+
+type memoryStore struct {
+	mu       sync.Mutex
+	states   map[string]memoryState
+	grants   map[string]DeviceGrant
+	sessions map[string]*Session
+	lifetime Lifetime
+	now      func() time.Time
+	writes   int
+}
+
+// NewMemoryStore creates an in-process storage implementation with the same
+// TTL semantics as the Redis and PostgreSQL stores. Data is lost on restart and
+// not shared between replicas, so it suits local development and single-replica runs.
+func NewMemoryStore(lifetime Lifetime, opts ...MemoryOption) Store {
+	m := &memoryStore{
+		states:   make(map[string]memoryState),
+		grants:   make(map[string]DeviceGrant),
+		sessions: make(map[string]*Session),
+		lifetime: lifetime,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *memoryStore) CreateState(_ context.Context, data StateData) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.wrote(now)
+
+	state := uuid.New().String()
+	m.states[state] = memoryState{data: data, expiresAt: now.Add(stateTTL)}
+	return state, nil
+}
+
+func (m *memoryStore) ValidateState(_ context.Context, state string) (StateData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.states[state]
+	delete(m.states, state)
+
+	if !ok || !stored.expiresAt.After(m.now()) {
+		return StateData{}, fmt.Errorf("invalid or expired state")
+	}
+	return stored.data, nil
+}
+
+func (m *memoryStore) CreateDeviceGrant(_ context.Context, grant DeviceGrant) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.wrote(m.now())
+
+	id := uuid.New().String()
+	m.grants[id] = grant
+	return id, nil
+}
+
+func (m *memoryStore) GetDeviceGrant(_ context.Context, id string) (DeviceGrant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	grant, ok := m.grants[id]
+	if !ok || !grant.ExpiresAt.After(m.now()) {
+		delete(m.grants, id)
+		return DeviceGrant{}, fmt.Errorf("device grant not found or expired")
+	}
+	return grant, nil
+}
+
+func (m *memoryStore) UpdateDeviceGrant(_ context.Context, id string, grant DeviceGrant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.grants[id]
+	if !ok || !stored.ExpiresAt.After(m.now()) {
+		delete(m.grants, id)
+		return fmt.Errorf("device grant not found or expired")
+	}
+
+	m.wrote(m.now())
+	stored.Interval = grant.Interval
+	stored.LastPolledAt = grant.LastPolledAt
+	m.grants[id] = stored
+	return nil
+}
+
+func (m *memoryStore) DeleteDeviceGrant(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.grants, id)
+	return nil
+}
+
+func (m *memoryStore) CreateSession(_ context.Context, params NewSession) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.wrote(now)
+
+	sessionID := uuid.New().String()
+	lifetime := lifetimeFor(params, m.lifetime)
+	absoluteExpiresAt := now.Add(lifetime.Absolute)
+	m.sessions[sessionID] = &Session{
+		ID:                sessionID,
+		UserID:            params.UserID,
+		InternalUserID:    params.InternalUserID,
+		RefreshToken:      params.RefreshToken,
+		CreatedAt:         now,
+		ExpiresAt:         slidingExpiry(now, lifetime.Idle, absoluteExpiresAt),
+		AbsoluteExpiresAt: absoluteExpiresAt,
+		IdleTimeout:       lifetime.Idle,
+		DeviceHash:        params.DeviceHash,
+		Auth:              params.Auth,
+
+		Impersonator:          params.Impersonator,
+		ImpersonatorSessionID: params.ImpersonatorSessionID,
+		ClientID:              params.ClientID,
+	}
+	return sessionID, nil
+}
+
+func (m *memoryStore) GetSession(_ context.Context, sessionID string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.activeSession(sessionID)
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return *session, nil
+}
+
+func (m *memoryStore) UpdateSession(_ context.Context, sessionID, refreshToken string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.activeSession(sessionID)
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+
+	if refreshToken != "" {
+		session.RefreshToken = refreshToken
+	}
+	session.ExpiresAt = slidingExpiry(m.now(), session.IdleTimeout, session.AbsoluteExpiresAt)
+	return *session, nil
+}
+
+func (m *memoryStore) DeleteSession(_ context.Context, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, sessionID)
+	return nil
+}
+
+func (m *memoryStore) ListUserSessions(_ context.Context, userID string) ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var sessions []Session
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.ExpiresAt.After(now) {
+			sessions = append(sessions, *session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (m *memoryStore) DeleteUserSessions(_ context.Context, userID string) ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var removed []Session
+	for id, session := range m.sessions {
+		if session.UserID != userID {
+			continue
+		}
+		if session.ExpiresAt.After(now) {
+			removed = append(removed, *session)
+		}
+		delete(m.sessions, id)
+	}
+
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].CreatedAt.Before(removed[j].CreatedAt)
+	})
+	return removed, nil
+}
+
+func (m *memoryStore) CountSessions(_ context.Context) (SessionCounts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	users := make(map[string]struct{})
+	var counts SessionCounts
+	for _, session := range m.sessions {
+		if session.ExpiresAt.After(now) {
+			counts.Sessions++
+			users[session.UserID] = struct{}{}
+		}
+	}
+	counts.Users = int64(len(users))
+	return counts, nil
+}
+
+// activeSession returns an unexpired session, dropping it if it has expired
+func (m *memoryStore) activeSession(sessionID string) (*Session, bool) {
+	session, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, false
+	}
+	if !session.ExpiresAt.After(m.now()) {
+		delete(m.sessions, sessionID)
+		return nil, false
+	}
+	return session, true
+}
+
+// wrote counts a write and periodically sweeps expired entries so abandoned
+// states, device grants and sessions do not accumulate
+func (m *memoryStore) wrote(now time.Time) {
+	m.writes++
+	if m.writes%memorySweepEvery != 0 {
+		return
+	}
+
+	for state, stored := range m.states {
+		if !stored.expiresAt.After(now) {
+			delete(m.states, state)
+		}
+	}
+	for id, grant := range m.grants {
+		if !grant.ExpiresAt.After(now) {
+			delete(m.grants, id)
+		}
+	}
+	for id, session := range m.sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(m.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage/storagetest"
+)
+
+// fakeClock is a manually advanced clock shared by the stores under test
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestMemoryStore_Conformance(t *testing.T) {
+	clock := newFakeClock()
+
+	storagetest.Run(t, func(_ *testing.T, lifetime storage.Lifetime) storage.Store {
+		return storage.NewMemoryStore(lifetime, storage.WithClock(clock.Now))
+	}, storagetest.WithAdvance(clock.Advance))
+}
+
+func TestMemoryUserStore_Conformance(t *testing.T) {
+	storagetest.RunUserStore(t, func(_ *testing.T, opts ...storage.UserStoreOption) storage.UserStore {
+		return storage.NewMemoryUserStore(opts...)
+	})
+}
+
+func TestMemoryStore_StateExpiration(t *testing.T) {
+	clock := newFakeClock()
+	store := storage.NewMemoryStore(testLifetime, storage.WithClock(clock.Now))
+	ctx := context.Background()
+
+	state, err := store.CreateState(ctx, storage.StateData{})
+	require.NoError(t, err)
+
+	clock.Advance(10 * time.Minute)
+
+	_, err = store.ValidateState(ctx, state)
+	assert.Error(t, err)
+}
+
+func TestMemoryStore_DeviceGrantExpiration(t *testing.T) {
+	clock := newFakeClock()
+	store := storage.NewMemoryStore(testLifetime, storage.WithClock(clock.Now))
+	ctx := context.Background()
+
+	id, err := store.CreateDeviceGrant(ctx, storage.DeviceGrant{DeviceCode: "device-code", ExpiresAt: clock.Now().Add(time.Minute)})
+	require.NoError(t, err)
+
+	clock.Advance(time.Minute)
+
+	_, err = store.GetDeviceGrant(ctx, id)
+	assert.Error(t, err)
+}
+
+func TestMemoryStore_UpdateExtendsExpiry(t *testing.T) {
+	clock := newFakeClock()
+	store := storage.NewMemoryStore(storage.Lifetime{Idle: time.Minute, Absolute: time.Hour}, storage.WithClock(clock.Now))
+	ctx := context.Background()
+
+	sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: testUserID, RefreshToken: testRefreshToken})
+	require.NoError(t, err)
+
+	clock.Advance(50 * time.Second)
+	_, err = store.UpdateSession(ctx, sessionID, "rotated")
+	require.NoError(t, err)
+
+	clock.Advance(50 * time.Second)
+	session, err := store.GetSession(ctx, sessionID)
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", session.RefreshToken)
+
+	sessions, err := store.ListUserSessions(ctx, testUserID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, clock.Now().Add(10*time.Second), sessions[0].ExpiresAt)
+}
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS auth_states (
+    state      TEXT        PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auth_states_expires_at_idx ON auth_states (expires_at);
+
+CREATE TABLE IF NOT EXISTS auth_sessions (
+    id            TEXT        PRIMARY KEY,
+    user_id       TEXT        NOT NULL,
+    refresh_token TEXT        NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL,
+    expires_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auth_sessions_user_id_idx ON auth_sessions (user_id, expires_at);
+CREATE INDEX IF NOT EXISTS auth_sessions_expires_at_idx ON auth_sessions (expires_at);
@@ -0,0 +1,14 @@
+ALTER TABLE auth_sessions
+    ADD COLUMN IF NOT EXISTS idle_timeout_ms     BIGINT,
+    ADD COLUMN IF NOT EXISTS absolute_expires_at TIMESTAMPTZ;
+
+-- Existing sessions keep their current expiry as the absolute cap and the
+-- interval since their last update as the idle timeout
+UPDATE auth_sessions
+SET idle_timeout_ms     = GREATEST(EXTRACT(EPOCH FROM (expires_at - updated_at)) * 1000, 0)::BIGINT,
+    absolute_expires_at = expires_at
+WHERE idle_timeout_ms IS NULL;
+
+ALTER TABLE auth_sessions
+    ALTER COLUMN idle_timeout_ms SET NOT NULL,
+    ALTER COLUMN absolute_expires_at SET NOT NULL;
@@ -0,0 +1,7 @@
+-- Data carried from the login request to the callback, e.g. remember-me
+ALTER TABLE auth_states
+    ADD COLUMN IF NOT EXISTS data JSONB NOT NULL DEFAULT '{}';
+
+-- Fingerprint of the device a remember-me session is bound to; empty when unbound
+ALTER TABLE auth_sessions
+    ADD COLUMN IF NOT EXISTS device_hash TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- Pending device authorizations (RFC 8628); the device polls with the id
+CREATE TABLE IF NOT EXISTS auth_device_grants (
+    id          TEXT        PRIMARY KEY,
+    device_code TEXT        NOT NULL,
+    interval_ms BIGINT      NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auth_device_grants_expires_at_idx ON auth_device_grants (expires_at);
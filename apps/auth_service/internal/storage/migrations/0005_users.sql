@@ -0,0 +1,28 @@
+-- Local user records, linked to one or more provider identities
+CREATE TABLE IF NOT EXISTS auth_users (
+    id             TEXT        PRIMARY KEY,
+    email          TEXT        NOT NULL DEFAULT '',
+    email_verified BOOLEAN     NOT NULL DEFAULT false,
+    display_name   TEXT        NOT NULL DEFAULT '',
+    avatar_url     TEXT        NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ NOT NULL,
+    updated_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auth_users_email_idx ON auth_users (lower(email)) WHERE email_verified;
+
+-- Provider identities (issuer and subject) of each user
+CREATE TABLE IF NOT EXISTS auth_identities (
+    provider      TEXT        NOT NULL,
+    subject       TEXT        NOT NULL,
+    user_id       TEXT        NOT NULL REFERENCES auth_users (id) ON DELETE CASCADE,
+    created_at    TIMESTAMPTZ NOT NULL,
+    last_login_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS auth_identities_user_id_idx ON auth_identities (user_id);
+
+-- Local user a session belongs to; empty for sessions created before users were kept
+ALTER TABLE auth_sessions
+    ADD COLUMN IF NOT EXISTS internal_user_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- Auth events waiting for delivery to the message broker
+CREATE TABLE IF NOT EXISTS auth_event_outbox (
+    id          TEXT        PRIMARY KEY,
+    type        TEXT        NOT NULL,
+    version     INTEGER     NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    data        JSONB       NOT NULL,
+    attempts    INTEGER     NOT NULL DEFAULT 0,
+    -- When the event may be claimed next; claims move it forward by a lease
+    due_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auth_event_outbox_due_at_idx ON auth_event_outbox (due_at);
@@ -0,0 +1,32 @@
+-- Hash-chained audit log of security-relevant actions
+CREATE TABLE IF NOT EXISTS auth_audit_log (
+    seq         BIGINT      PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    action      TEXT        NOT NULL,
+    actor       TEXT        NOT NULL DEFAULT '',
+    subject     TEXT        NOT NULL DEFAULT '',
+    session_id  TEXT        NOT NULL DEFAULT '',
+    ip          TEXT        NOT NULL DEFAULT '',
+    user_agent  TEXT        NOT NULL DEFAULT '',
+    outcome     TEXT        NOT NULL,
+    reason      TEXT        NOT NULL DEFAULT '',
+    prev_hash   TEXT        NOT NULL,
+    hash        TEXT        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auth_audit_log_actor_idx ON auth_audit_log (actor, seq);
+CREATE INDEX IF NOT EXISTS auth_audit_log_subject_idx ON auth_audit_log (subject, seq);
+CREATE INDEX IF NOT EXISTS auth_audit_log_occurred_at_idx ON auth_audit_log (occurred_at);
+
+-- Entries are append-only; the hash chain still exposes changes made by
+-- someone able to drop this trigger
+CREATE OR REPLACE FUNCTION auth_audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'auth_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS auth_audit_log_append_only ON auth_audit_log;
+CREATE TRIGGER auth_audit_log_append_only
+    BEFORE UPDATE OR DELETE ON auth_audit_log
+    FOR EACH ROW EXECUTE FUNCTION auth_audit_log_append_only();
@@ -0,0 +1,4 @@
+-- How the user authenticated, from the ID token's acr, amr and auth_time
+ALTER TABLE auth_sessions ADD COLUMN IF NOT EXISTS acr TEXT NOT NULL DEFAULT '';
+ALTER TABLE auth_sessions ADD COLUMN IF NOT EXISTS amr TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE auth_sessions ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ;
@@ -0,0 +1,6 @@
+-- Sessions a support engineer opened as another user
+ALTER TABLE auth_sessions ADD COLUMN IF NOT EXISTS impersonator TEXT NOT NULL DEFAULT '';
+ALTER TABLE auth_sessions ADD COLUMN IF NOT EXISTS impersonator_session_id TEXT NOT NULL DEFAULT '';
+
+-- Audit entries of actions taken while impersonating
+ALTER TABLE auth_audit_log ADD COLUMN IF NOT EXISTS impersonator TEXT NOT NULL DEFAULT '';
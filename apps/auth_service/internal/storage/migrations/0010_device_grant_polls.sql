@@ -0,0 +1,2 @@
+-- When the device last polled, so polls faster than the interval get slow_down
+ALTER TABLE auth_device_grants ADD COLUMN IF NOT EXISTS last_polled_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+-- Native client a session was issued to; its refresh grant only works for that client
+ALTER TABLE auth_sessions ADD COLUMN IF NOT EXISTS client_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+-- A verified email belongs to one user only: the oldest user keeps it and the
+-- others keep it unverified, then the email index is made unique
+UPDATE auth_users u SET email_verified = false
+WHERE email_verified AND EXISTS (
+    SELECT 1 FROM auth_users o
+    WHERE o.email_verified AND lower(o.email) = lower(u.email)
+        AND (o.created_at, o.id) < (u.created_at, u.id)
+);
+
+DROP INDEX IF EXISTS auth_users_email_idx;
+CREATE UNIQUE INDEX auth_users_email_idx ON auth_users (lower(email)) WHERE email_verified;
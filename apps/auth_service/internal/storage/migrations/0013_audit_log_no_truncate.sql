@@ -0,0 +1,8 @@
+-- TRUNCATE skips row triggers, so the audit log refuses it with a statement
+-- trigger of its own. The hash chain is not anchored outside this table: it
+-- exposes entries edited or removed before the last one, but a log cut short,
+-- or emptied, still verifies.
+DROP TRIGGER IF EXISTS auth_audit_log_no_truncate ON auth_audit_log;
+CREATE TRIGGER auth_audit_log_no_truncate
+    BEFORE TRUNCATE ON auth_audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION auth_audit_log_append_only();
@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore is a PostgreSQL-backed Store. Expired rows are ignored by every
+// query and removed by Sweep, which callers run periodically.
+type PostgresStore struct {
+	pool     *pgxpool.Pool
+	lifetime Lifetime
+}
+
+// sessionColumns are the auth_sessions columns scanned by scanSession
+const sessionColumns = "id, user_id, refresh_token, created_at, expires_at, absolute_expires_at, idle_timeout_ms, device_hash, internal_user_id, acr, amr, auth_time, impersonator, impersonator_session_id, client_id"
+
+// NewPostgresStore creates a new PostgreSQL-backed storage implementation.
+// The schema must be created first with MigratePostgres.
+func NewPostgresStore(pool *pgxpool.Pool, lifetime Lifetime) *PostgresStore {
+	return &PostgresStore{
+		pool:     pool,
+		lifetime: lifetime,
+	}
+}
+
+// CreateState creates a one-time OAuth state carrying data
+func (p *PostgresStore) CreateState(ctx context.Context, data StateData) (string, error) {
+	state := uuid.New().String()
+
+	if _, err := p.pool.Exec(ctx,
+		"INSERT INTO auth_states (state, data, expires_at) VALUES ($1, $2, $3)",
+		state, data, time.Now().Add(stateTTL),
+	); err != nil {
+		return "", fmt.Errorf("failed to create state: %w", err)
+	}
+
+	return state, nil
+}
+
+// ValidateState consumes a state, failing if it is unknown or expired
+func (p *PostgresStore) ValidateState(ctx context.Context, state string) (StateData, error) {
+	var data StateData
+	err := p.pool.QueryRow(ctx,
+		"DELETE FROM auth_states WHERE state = $1 AND expires_at > $2 RETURNING data",
+		state, time.Now(),
+	).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return StateData{}, fmt.Errorf("invalid or expired state")
+	}
+	if err != nil {
+		return StateData{}, fmt.Errorf("failed to validate state: %w", err)
+	}
+
+	return data, nil
+}
+
+// CreateDeviceGrant stores a pending device authorization until its expiry
+func (p *PostgresStore) CreateDeviceGrant(ctx context.Context, grant DeviceGrant) (string, error) {
+	id := uuid.New().String()
+
+	if _, err := p.pool.Exec(ctx,
+		"INSERT INTO auth_device_grants (id, device_code, interval_ms, expires_at) VALUES ($1, $2, $3, $4)",
+		id, grant.DeviceCode, grant.Interval.Milliseconds(), grant.ExpiresAt,
+	); err != nil {
+		return "", fmt.Errorf("failed to create device grant: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetDeviceGrant returns an unexpired device authorization
+func (p *PostgresStore) GetDeviceGrant(ctx context.Context, id string) (DeviceGrant, error) {
+	var grant DeviceGrant
+	var intervalMillis int64
+	var lastPolledAt *time.Time
+	err := p.pool.QueryRow(ctx,
+		"SELECT device_code, interval_ms, expires_at, last_polled_at FROM auth_device_grants WHERE id = $1 AND expires_at > $2",
+		id, time.Now(),
+	).Scan(&grant.DeviceCode, &intervalMillis, &grant.ExpiresAt, &lastPolledAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DeviceGrant{}, fmt.Errorf("device grant not found or expired")
+	}
+	if err != nil {
+		return DeviceGrant{}, fmt.Errorf("failed to get device grant: %w", err)
+	}
+
+	grant.Interval = time.Duration(intervalMillis) * time.Millisecond
+	if lastPolledAt != nil {
+		grant.LastPolledAt = *lastPolledAt
+	}
+	return grant, nil
+}
+
+// UpdateDeviceGrant saves the poll interval and last poll time of a device
+// authorization
+func (p *PostgresStore) UpdateDeviceGrant(ctx context.Context, id string, grant DeviceGrant) error {
+	tag, err := p.pool.Exec(ctx,
+		"UPDATE auth_device_grants SET interval_ms = $2, last_polled_at = $3 WHERE id = $1 AND expires_at > $4",
+		id, grant.Interval.Milliseconds(), nullTime(grant.LastPolledAt), time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update device grant: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("device grant not found or expired")
+	}
+	return nil
+}
+
+// DeleteDeviceGrant removes a device authorization
+func (p *PostgresStore) DeleteDeviceGrant(ctx context.Context, id string) error {
+	if _, err := p.pool.Exec(ctx, "DELETE FROM auth_device_grants WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete device grant: %w", err)
+	}
+	return nil
+}
+
+// CreateSession stores a refresh token for a user and returns the new session ID
+func (p *PostgresStore) CreateSession(ctx context.Context, params NewSession) (string, error) {
+	sessionID := uuid.New().String()
+	now := time.Now()
+	lifetime := lifetimeFor(params, p.lifetime)
+	absoluteExpiresAt := now.Add(lifetime.Absolute)
+
+	if _, err := p.pool.Exec(ctx, `
+		INSERT INTO auth_sessions
+			(id, user_id, refresh_token, created_at, updated_at, expires_at, absolute_expires_at, idle_timeout_ms, device_hash, internal_user_id, acr, amr, auth_time, impersonator, impersonator_session_id, client_id)
+		VALUES ($1, $2, $3, $4, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		sessionID, params.UserID, params.RefreshToken, now,
+		slidingExpiry(now, lifetime.Idle, absoluteExpiresAt), absoluteExpiresAt, lifetime.Idle.Milliseconds(),
+		params.DeviceHash, params.InternalUserID, params.Auth.ACR, append([]string{}, params.Auth.AMR...), nullTime(params.Auth.AuthTime),
+		params.Impersonator, params.ImpersonatorSessionID, params.ClientID,
+	); err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return sessionID, nil
+}
+
+// GetSession returns an active session
+func (p *PostgresStore) GetSession(ctx context.Context, sessionID string) (Session, error) {
+	row := p.pool.QueryRow(ctx,
+		"SELECT "+sessionColumns+" FROM auth_sessions WHERE id = $1 AND expires_at > $2",
+		sessionID, time.Now(),
+	)
+
+	session, err := scanSession(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return session, nil
+}
+
+// UpdateSession slides the expiry of an active session, capped by its absolute
+// expiry, and rotates the refresh token unless refreshToken is empty
+func (p *PostgresStore) UpdateSession(ctx context.Context, sessionID, refreshToken string) (Session, error) {
+	now := time.Now()
+
+	row := p.pool.QueryRow(ctx, `
+		UPDATE auth_sessions
+		SET refresh_token = COALESCE(NULLIF($2, ''), refresh_token),
+			updated_at    = $3,
+			expires_at    = LEAST($3 + idle_timeout_ms * interval '1 millisecond', absolute_expires_at)
+		WHERE id = $1 AND expires_at > $3 AND absolute_expires_at > $3
+		RETURNING `+sessionColumns,
+		sessionID, refreshToken, now,
+	)
+
+	session, err := scanSession(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	return session, nil
+}
+
+// DeleteSession removes a session; deleting an unknown session is not an error
+func (p *PostgresStore) DeleteSession(ctx context.Context, sessionID string) error {
+	if _, err := p.pool.Exec(ctx, "DELETE FROM auth_sessions WHERE id = $1", sessionID); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	return nil
+}
+
+// ListUserSessions returns the active sessions of a user, oldest first
+func (p *PostgresStore) ListUserSessions(ctx context.Context, userID string) ([]Session, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+sessionColumns+`
+		FROM auth_sessions
+		WHERE user_id = $1 AND expires_at > $2
+		ORDER BY created_at`,
+		userID, time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user sessions: %w", err)
+	}
+
+	sessions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Session, error) {
+		return scanSession(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteUserSessions removes every session of a user and returns the active ones removed
+func (p *PostgresStore) DeleteUserSessions(ctx context.Context, userID string) ([]Session, error) {
+	rows, err := p.pool.Query(ctx, `
+		WITH removed AS (
+			DELETE FROM auth_sessions WHERE user_id = $1
+			RETURNING `+sessionColumns+`
+		)
+		SELECT * FROM removed WHERE expires_at > $2 ORDER BY created_at`,
+		userID, time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete user sessions: %w", err)
+	}
+
+	sessions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Session, error) {
+		return scanSession(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete user sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// CountSessions reports how many sessions and distinct users are active
+func (p *PostgresStore) CountSessions(ctx context.Context) (SessionCounts, error) {
+	var counts SessionCounts
+	if err := p.pool.QueryRow(ctx,
+		"SELECT count(*), count(DISTINCT user_id) FROM auth_sessions WHERE expires_at > $1",
+		time.Now(),
+	).Scan(&counts.Sessions, &counts.Users); err != nil {
+		return SessionCounts{}, fmt.Errorf("failed to count sessions: %w", err)
+	}
+
+	return counts, nil
+}
+
+// Sweep deletes expired states, device grants and sessions and returns how
+// many rows were removed
+func (p *PostgresStore) Sweep(ctx context.Context) (int64, error) {
+	now := time.Now()
+
+	states, err := p.pool.Exec(ctx, "DELETE FROM auth_states WHERE expires_at <= $1", now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sweep states: %w", err)
+	}
+	removed := states.RowsAffected()
+
+	grants, err := p.pool.Exec(ctx, "DELETE FROM auth_device_grants WHERE expires_at <= $1", now)
+	if err != nil {
+		return removed, fmt.Errorf("failed to sweep device grants: %w", err)
+	}
+	removed += grants.RowsAffected()
+
+	sessions, err := p.pool.Exec(ctx, "DELETE FROM auth_sessions WHERE expires_at <= $1", now)
+	if err != nil {
+		return removed, fmt.Errorf("failed to sweep sessions: %w", err)
+	}
+
+	return removed + sessions.RowsAffected(), nil
+}
+
+func scanSession(row pgx.Row) (Session, error) {
+	var s Session
+	var idleMillis int64
+	var authTime *time.Time
+	err := row.Scan(&s.ID, &s.UserID, &s.RefreshToken, &s.CreatedAt, &s.ExpiresAt, &s.AbsoluteExpiresAt, &idleMillis, &s.DeviceHash, &s.InternalUserID,
+		&s.Auth.ACR, &s.Auth.AMR, &authTime, &s.Impersonator, &s.ImpersonatorSessionID, &s.ClientID)
+	s.IdleTimeout = time.Duration(idleMillis) * time.Millisecond
+	if authTime != nil {
+		s.Auth.AuthTime = *authTime
+	}
+	return s, err
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,99 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	testpostgres "github.com/testcontainers/testcontainers-go/modules/postgres"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage/storagetest"
+)
+
+func setupPostgresContainer(t *testing.T) *pgxpool.Pool {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	postgresContainer, err := testpostgres.Run(ctx,
+		"docker.io/postgres:16-alpine",
+		testpostgres.WithDatabase("auth"),
+		testpostgres.WithUsername("auth"),
+		testpostgres.WithPassword("auth"),
+		testpostgres.BasicWaitStrategies(),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		if err := postgresContainer.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate container: %s", err)
+		}
+	})
+
+	connectionString, err := postgresContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	pool, err := pgxpool.New(ctx, connectionString)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	require.NoError(t, storage.MigratePostgres(ctx, pool))
+
+	return pool
+}
+
+func TestPostgresStore_Conformance(t *testing.T) {
+	pool := setupPostgresContainer(t)
+
+	storagetest.Run(t, func(t *testing.T, lifetime storage.Lifetime) storage.Store {
+		_, err := pool.Exec(context.Background(), "TRUNCATE auth_states, auth_device_grants, auth_sessions")
+		require.NoError(t, err)
+		return storage.NewPostgresStore(pool, lifetime)
+	})
+}
+
+func TestPostgresUserStore_Conformance(t *testing.T) {
+	pool := setupPostgresContainer(t)
+
+	storagetest.RunUserStore(t, func(t *testing.T, opts ...storage.UserStoreOption) storage.UserStore {
+		_, err := pool.Exec(context.Background(), "TRUNCATE auth_users, auth_identities")
+		require.NoError(t, err)
+		return storage.NewPostgresUserStore(pool, opts...)
+	})
+}
+
+func TestMigratePostgres_Idempotent(t *testing.T) {
+	pool := setupPostgresContainer(t)
+	ctx := context.Background()
+
+	require.NoError(t, storage.MigratePostgres(ctx, pool))
+
+	var count int
+	require.NoError(t, pool.QueryRow(ctx, "SELECT count(*) FROM auth_schema_migrations").Scan(&count))
+	assert.Equal(t, 13, count)
+}
+
+func TestPostgresStore_Sweep(t *testing.T) {
+	pool := setupPostgresContainer(t)
+	ctx := context.Background()
+	store := storage.NewPostgresStore(pool, storage.Lifetime{Idle: time.Second, Absolute: time.Hour})
+
+	_, err := store.CreateSession(ctx, storage.NewSession{UserID: testUserID, RefreshToken: testRefreshToken})
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, "INSERT INTO auth_states (state, expires_at) VALUES ('old', $1)", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+
+	time.Sleep(1500 * time.Millisecond)
+
+	removed, err := store.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	var remaining int
+	require.NoError(t, pool.QueryRow(ctx, "SELECT count(*) FROM auth_sessions").Scan(&remaining))
+	assert.Zero(t, remaining)
+}
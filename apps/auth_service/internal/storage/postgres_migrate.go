@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the advisory lock key serializing migrations across replicas
+const migrationLockID = 7_214_392_011
+
+// MigratePostgres applies pending schema migrations. Migrations are embedded SQL
+// files applied in name order, each in its own transaction, and recorded in
+// auth_schema_migrations. Concurrent replicas wait on an advisory lock.
+func MigratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	if _, err := pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS auth_schema_migrations (
+			version    TEXT        PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return fmt.Errorf("failed to list migrations: %w", err)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := applyMigration(ctx, pool, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(ctx context.Context, pool *pgxpool.Pool, name string) error {
+	version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+
+	sql, err := migrationFiles.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("failed to read migration %s: %w", version, err)
+	}
+
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+
+		var applied bool
+		if err := tx.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM auth_schema_migrations WHERE version = $1)", version,
+		).Scan(&applied); err != nil {
+			return fmt.Errorf("failed to check migration %s: %w", version, err)
+		}
+		if applied {
+			return nil
+		}
+
+		if _, err := tx.Exec(ctx, string(sql)); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", version, err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO auth_schema_migrations (version) VALUES ($1)", version); err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", version, err)
+		}
+		return nil
+	})
+}
@@ -3,113 +3,569 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
+// Key layout. Each user has a slot tag, a short digest of the user ID, and a
+// sorted set of session IDs scored by expiry under user_sessions:{<tag>}<user>.
+// Session IDs start with the tag of their user (<tag>.<uuid>) and sessions are
+// hashes under session:{<tag>}<uuid>. The braces are a hash tag, so a user's
+// sessions and index share a Redis Cluster slot and can change in one
+// transaction.
+//
+// Sessions of the first release are strings holding the refresh token under
+// session:<uuid>, with no index. They carry no user, so they cannot be
+// migrated: reading one deletes it and reports it missing, and its user signs
+// in again.
 const (
-	sessionPrefix = "session:"
-	statePrefix   = "state:"
-	stateTTL      = 10 * time.Minute
+	sessionPrefix      = "session:"
+	statePrefix        = "state:"
+	deviceGrantPrefix  = "device_grant:"
+	userSessionsPrefix = "user_sessions:"
+	stateTTL           = 10 * time.Minute
 )
 
+// Session hash fields
+const (
+	fieldUserID              = "user_id"
+	fieldRefreshToken        = "refresh_token"
+	fieldCreatedAt           = "created_at"
+	fieldExpiresAt           = "expires_at"
+	fieldAbsolute            = "absolute_expires_at"
+	fieldIdle                = "idle_ms"
+	fieldDeviceHash          = "device_hash"
+	fieldInternalUser        = "internal_user_id"
+	fieldACR                 = "acr"
+	fieldAMR                 = "amr"
+	fieldAuthTime            = "auth_time"
+	fieldImpersonator        = "impersonator"
+	fieldImpersonatorSession = "impersonator_session_id"
+	fieldClientID            = "client_id"
+)
+
+// wrongTypeErr prefixes the error Redis returns for a command on a key of
+// another type, such as a hash command on a first release session
+const wrongTypeErr = "WRONGTYPE"
+
+// updateSessionScript slides the expiry of an existing session by its idle
+// timeout, capped at its absolute expiry, and rotates the refresh token when one
+// is given. Sessions stored before lifetimes were tracked fall back to the
+// store defaults. Returns the session hash, or false when missing, expired or
+// not a hash.
+var updateSessionScript = redis.NewScript(`
+if redis.call('TYPE', KEYS[1]).ok ~= 'hash' then
+	redis.call('DEL', KEYS[1])
+	return false
+end
+local now = tonumber(ARGV[2])
+local idle = tonumber(redis.call('HGET', KEYS[1], 'idle_ms') or ARGV[3])
+local created = tonumber(redis.call('HGET', KEYS[1], 'created_at') or now)
+local absolute = tonumber(redis.call('HGET', KEYS[1], 'absolute_expires_at') or (created + tonumber(ARGV[4])))
+local expires = math.min(now + idle, absolute)
+if expires <= now then
+	redis.call('DEL', KEYS[1])
+	return false
+end
+if ARGV[1] ~= '' then
+	redis.call('HSET', KEYS[1], 'refresh_token', ARGV[1])
+end
+redis.call('HSET', KEYS[1], 'expires_at', expires, 'idle_ms', idle, 'absolute_expires_at', absolute)
+redis.call('PEXPIREAT', KEYS[1], expires)
+return redis.call('HGETALL', KEYS[1])
+`)
+
+// deleteSessionScript deletes a session and returns its user ID, or false when
+// missing or not a hash
+var deleteSessionScript = redis.NewScript(`
+if redis.call('TYPE', KEYS[1]).ok ~= 'hash' then
+	redis.call('DEL', KEYS[1])
+	return false
+end
+local userID = redis.call('HGET', KEYS[1], 'user_id')
+redis.call('DEL', KEYS[1])
+return userID
+`)
+
 type redisStore struct {
-	client     redis.UniversalClient
-	sessionTTL time.Duration
+	client   redis.UniversalClient
+	lifetime Lifetime
 }
 
 // NewRedisStore creates a new Redis-backed storage implementation
-func NewRedisStore(client redis.UniversalClient, sessionMaxAge int) Store {
+func NewRedisStore(client redis.UniversalClient, lifetime Lifetime) Store {
 	return &redisStore{
-		client:     client,
-		sessionTTL: time.Duration(sessionMaxAge) * time.Second,
+		client:   client,
+		lifetime: lifetime,
+	}
+}
+
+// slotTag is the hash tag shared by the keys of userID
+func slotTag(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:4])
+}
+
+// newSessionID returns a session ID carrying the slot tag of userID
+func newSessionID(userID string) string {
+	return slotTag(userID) + "." + uuid.New().String()
+}
+
+func sessionKey(sessionID string) string {
+	tag, id, ok := strings.Cut(sessionID, ".")
+	if !ok {
+		return sessionPrefix + sessionID
 	}
+	return sessionPrefix + "{" + tag + "}" + id
+}
+
+func userSessionsKey(userID string) string {
+	return userSessionsPrefix + "{" + slotTag(userID) + "}" + userID
 }
 
-func (r *redisStore) CreateState(ctx context.Context) (string, error) {
+func (r *redisStore) CreateState(ctx context.Context, data StateData) (string, error) {
 	state := uuid.New().String()
 	key := statePrefix + state
 
-	if err := r.client.Set(ctx, key, "valid", stateTTL).Err(); err != nil {
+	value, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	if err := r.client.Set(ctx, key, value, stateTTL).Err(); err != nil {
 		return "", fmt.Errorf("failed to create state: %w", err)
 	}
 
 	return state, nil
 }
 
-func (r *redisStore) ValidateState(ctx context.Context, state string) error {
+func (r *redisStore) ValidateState(ctx context.Context, state string) (StateData, error) {
 	key := statePrefix + state
 
-	exists, err := r.client.Exists(ctx, key).Result()
+	// A single GETDEL both checks and consumes the state, so it cannot be
+	// replayed by concurrent callbacks
+	value, err := r.client.GetDel(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return StateData{}, fmt.Errorf("invalid or expired state")
+	}
+	if err != nil {
+		return StateData{}, fmt.Errorf("failed to validate state: %w", err)
+	}
+
+	// States written before they carried data hold a plain marker and decode
+	// to the zero value
+	var data StateData
+	_ = json.Unmarshal(value, &data)
+
+	return data, nil
+}
+
+func (r *redisStore) CreateDeviceGrant(ctx context.Context, grant DeviceGrant) (string, error) {
+	id := uuid.New().String()
+
+	value, err := json.Marshal(grant)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode device grant: %w", err)
+	}
+
+	ttl := time.Until(grant.ExpiresAt)
+	if ttl <= 0 {
+		return "", fmt.Errorf("device grant already expired")
+	}
+	if err := r.client.Set(ctx, deviceGrantPrefix+id, value, ttl).Err(); err != nil {
+		return "", fmt.Errorf("failed to create device grant: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *redisStore) GetDeviceGrant(ctx context.Context, id string) (DeviceGrant, error) {
+	value, err := r.client.Get(ctx, deviceGrantPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return DeviceGrant{}, fmt.Errorf("device grant not found or expired")
+	}
 	if err != nil {
-		return fmt.Errorf("failed to validate state: %w", err)
+		return DeviceGrant{}, fmt.Errorf("failed to get device grant: %w", err)
+	}
+
+	var grant DeviceGrant
+	if err := json.Unmarshal(value, &grant); err != nil {
+		return DeviceGrant{}, fmt.Errorf("failed to decode device grant: %w", err)
 	}
+	return grant, nil
+}
 
-	if exists == 0 {
-		return fmt.Errorf("invalid or expired state")
+func (r *redisStore) UpdateDeviceGrant(ctx context.Context, id string, grant DeviceGrant) error {
+	stored, err := r.GetDeviceGrant(ctx, id)
+	if err != nil {
+		return err
 	}
+	stored.Interval = grant.Interval
+	stored.LastPolledAt = grant.LastPolledAt
 
-	if err := r.client.Del(ctx, key).Err(); err != nil {
-		return fmt.Errorf("failed to delete state: %w", err)
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to encode device grant: %w", err)
 	}
 
+	// XX with KEEPTTL: a grant that expired meanwhile is not brought back
+	err = r.client.SetArgs(ctx, deviceGrantPrefix+id, value, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if errors.Is(err, redis.Nil) {
+		return fmt.Errorf("device grant not found or expired")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update device grant: %w", err)
+	}
 	return nil
 }
 
-func (r *redisStore) CreateSession(ctx context.Context, refreshToken string) (string, error) {
-	sessionID := uuid.New().String()
-	key := sessionPrefix + sessionID
+func (r *redisStore) DeleteDeviceGrant(ctx context.Context, id string) error {
+	if err := r.client.Del(ctx, deviceGrantPrefix+id).Err(); err != nil {
+		return fmt.Errorf("failed to delete device grant: %w", err)
+	}
+	return nil
+}
 
-	if err := r.client.Set(ctx, key, refreshToken, r.sessionTTL).Err(); err != nil {
+func (r *redisStore) CreateSession(ctx context.Context, params NewSession) (string, error) {
+	sessionID := newSessionID(params.UserID)
+	now := time.Now()
+	lifetime := lifetimeFor(params, r.lifetime)
+	absoluteExpiresAt := now.Add(lifetime.Absolute)
+	expiresAt := slidingExpiry(now, lifetime.Idle, absoluteExpiresAt)
+
+	// The session and its index entry share a slot, so they are written in
+	// one transaction
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		key := sessionKey(sessionID)
+		pipe.HSet(ctx, key,
+			fieldUserID, params.UserID,
+			fieldRefreshToken, params.RefreshToken,
+			fieldCreatedAt, now.UnixMilli(),
+			fieldExpiresAt, expiresAt.UnixMilli(),
+			fieldAbsolute, absoluteExpiresAt.UnixMilli(),
+			fieldIdle, lifetime.Idle.Milliseconds(),
+			fieldDeviceHash, params.DeviceHash,
+			fieldInternalUser, params.InternalUserID,
+			fieldACR, params.Auth.ACR,
+			fieldAMR, strings.Join(params.Auth.AMR, " "),
+			fieldAuthTime, formatMillis(params.Auth.AuthTime),
+			fieldImpersonator, params.Impersonator,
+			fieldImpersonatorSession, params.ImpersonatorSessionID,
+			fieldClientID, params.ClientID,
+		)
+		pipe.PExpireAt(ctx, key, expiresAt)
+		indexSession(ctx, pipe, params.UserID, sessionID, expiresAt, lifetime.Idle)
+		return nil
+	})
+	if err != nil {
 		return "", fmt.Errorf("failed to create session: %w", err)
 	}
 
 	return sessionID, nil
 }
 
-func (r *redisStore) GetRefreshToken(ctx context.Context, sessionID string) (string, error) {
-	key := sessionPrefix + sessionID
+func (r *redisStore) GetSession(ctx context.Context, sessionID string) (Session, error) {
+	key := sessionKey(sessionID)
+	fields, err := r.client.HGetAll(ctx, key).Result()
+	if err != nil && strings.HasPrefix(err.Error(), wrongTypeErr) {
+		if err := r.client.Del(ctx, key).Err(); err != nil {
+			return Session{}, fmt.Errorf("failed to delete session: %w", err)
+		}
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to get session: %w", err)
+	}
+	if len(fields) == 0 {
+		return Session{}, ErrSessionNotFound
+	}
+
+	return sessionFromHash(sessionID, fields), nil
+}
+
+func (r *redisStore) UpdateSession(ctx context.Context, sessionID, refreshToken string) (Session, error) {
+	values, err := updateSessionScript.Run(ctx, r.client,
+		[]string{sessionKey(sessionID)},
+		refreshToken, time.Now().UnixMilli(), r.lifetime.Idle.Milliseconds(), r.lifetime.Absolute.Milliseconds(),
+	).StringSlice()
+	if errors.Is(err, redis.Nil) {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	fields := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		fields[values[i]] = values[i+1]
+	}
+	session := sessionFromHash(sessionID, fields)
+
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		indexSession(ctx, pipe, session.UserID, sessionID, session.ExpiresAt, session.IdleTimeout)
+		return nil
+	})
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to update user session index: %w", err)
+	}
+
+	return session, nil
+}
+
+// indexSession records a session in its user's index, scored by expiry. The
+// index outlives every session in it: each expires within one idle timeout of
+// its last write, and the index TTL is only ever extended (NX sets it on a new
+// index, GT lengthens it), so a short session cannot cut a long one's entry.
+func indexSession(ctx context.Context, pipe redis.Pipeliner, userID, sessionID string, expiresAt time.Time, idle time.Duration) {
+	index := userSessionsKey(userID)
+	ttl := idle.Round(time.Second) + time.Second
+	pipe.ZAdd(ctx, index, redis.Z{Score: float64(expiresAt.UnixMilli()), Member: sessionID})
+	pipe.ExpireNX(ctx, index, ttl)
+	pipe.ExpireGT(ctx, index, ttl)
+}
 
-	refreshToken, err := r.client.Get(ctx, key).Result()
+func (r *redisStore) DeleteSession(ctx context.Context, sessionID string) error {
+	userID, err := deleteSessionScript.Run(ctx, r.client, []string{sessionKey(sessionID)}).Text()
 	if errors.Is(err, redis.Nil) {
-		return "", fmt.Errorf("session not found or expired")
+		return nil
 	}
 	if err != nil {
-		return "", fmt.Errorf("failed to get session: %w", err)
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	if err := r.client.ZRem(ctx, userSessionsKey(userID), sessionID).Err(); err != nil {
+		return fmt.Errorf("failed to update user session index: %w", err)
 	}
 
-	return refreshToken, nil
+	return nil
 }
 
-func (r *redisStore) UpdateSession(ctx context.Context, sessionID, refreshToken string) error {
-	key := sessionPrefix + sessionID
+func (r *redisStore) ListUserSessions(ctx context.Context, userID string) ([]Session, error) {
+	sessions, err := r.indexedSessions(ctx, userSessionsKey(userID))
+	if err != nil {
+		return nil, err
+	}
 
-	exists, err := r.client.Exists(ctx, key).Result()
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+// indexedSessions loads the sessions of a user index, pruning the entries of
+// sessions that are gone
+func (r *redisStore) indexedSessions(ctx context.Context, index string) ([]Session, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	if err := r.client.ZRemRangeByScore(ctx, index, "-inf", now).Err(); err != nil {
+		return nil, fmt.Errorf("failed to prune user sessions: %w", err)
+	}
+
+	ids, err := r.client.ZRange(ctx, index, 0, -1).Result()
 	if err != nil {
-		return fmt.Errorf("failed to check session: %w", err)
+		return nil, fmt.Errorf("failed to list user sessions: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
 	}
 
-	if exists == 0 {
-		return fmt.Errorf("session not found")
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, sessionKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user sessions: %w", err)
 	}
 
-	if err := r.client.Set(ctx, key, refreshToken, r.sessionTTL).Err(); err != nil {
-		return fmt.Errorf("failed to update session: %w", err)
+	sessions := make([]Session, 0, len(ids))
+	var stale []any
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			// Expired or deleted without updating the index
+			stale = append(stale, ids[i])
+			continue
+		}
+		sessions = append(sessions, sessionFromHash(ids[i], fields))
 	}
 
-	return nil
+	if len(stale) > 0 {
+		if err := r.client.ZRem(ctx, index, stale...).Err(); err != nil {
+			return nil, fmt.Errorf("failed to prune user sessions: %w", err)
+		}
+	}
+
+	return sessions, nil
 }
 
-func (r *redisStore) DeleteSession(ctx context.Context, sessionID string) error {
-	key := sessionPrefix + sessionID
+func (r *redisStore) DeleteUserSessions(ctx context.Context, userID string) ([]Session, error) {
+	sessions, err := r.deleteIndexedSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := r.client.Del(ctx, key).Err(); err != nil {
-		return fmt.Errorf("failed to delete session: %w", err)
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+// maxDeleteAttempts bounds how often DeleteUserSessions retries when sessions
+// are created or refreshed while it runs
+const maxDeleteAttempts = 5
+
+// deleteIndexedSessions lists and deletes the sessions of a user under WATCH,
+// so a session created concurrently either lands before the transaction and is
+// deleted with it, or aborts it and is picked up by the retry
+func (r *redisStore) deleteIndexedSessions(ctx context.Context, userID string) ([]Session, error) {
+	index := userSessionsKey(userID)
+	var sessions []Session
+
+	deleteAll := func(tx *redis.Tx) error {
+		ids, err := tx.ZRange(ctx, index, 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("failed to list user sessions: %w", err)
+		}
+
+		cmds := make([]*redis.MapStringStringCmd, len(ids))
+		if len(ids) > 0 {
+			_, err = tx.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for i, id := range ids {
+					cmds[i] = pipe.HGetAll(ctx, sessionKey(id))
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to load user sessions: %w", err)
+			}
+		}
+
+		now := time.Now()
+		sessions = sessions[:0]
+		for i, cmd := range cmds {
+			if fields := cmd.Val(); len(fields) > 0 {
+				if session := sessionFromHash(ids[i], fields); session.ExpiresAt.After(now) {
+					sessions = append(sessions, session)
+				}
+			}
+		}
+
+		// Every key shares the user's hash slot, so this is a single
+		// transaction even in cluster mode
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, id := range ids {
+				pipe.Del(ctx, sessionKey(id))
+			}
+			pipe.Del(ctx, index)
+			return nil
+		})
+		return err
 	}
 
-	return nil
+	for attempt := 0; attempt < maxDeleteAttempts; attempt++ {
+		err := r.client.Watch(ctx, deleteAll, index)
+		if err == nil {
+			return sessions, nil
+		}
+		if !errors.Is(err, redis.TxFailedErr) {
+			return nil, fmt.Errorf("failed to delete user sessions: %w", err)
+		}
+	}
+
+	return nil, fmt.Errorf("failed to delete user sessions: %w", redis.TxFailedErr)
+}
+
+// CountSessions counts the unexpired entries of every user index. It scans the
+// keyspace (each master in cluster mode), so it is meant for admin use only.
+func (r *redisStore) CountSessions(ctx context.Context) (SessionCounts, error) {
+	var mu sync.Mutex
+	var counts SessionCounts
+	from := "(" + strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	count := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, userSessionsPrefix+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			n, err := client.ZCount(ctx, iter.Val(), from, "+inf").Result()
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				mu.Lock()
+				counts.Sessions += n
+				counts.Users++
+				mu.Unlock()
+			}
+		}
+		return iter.Err()
+	}
+
+	var err error
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return count(ctx, client)
+		})
+	} else {
+		err = count(ctx, r.client)
+	}
+	if err != nil {
+		return SessionCounts{}, fmt.Errorf("failed to count sessions: %w", err)
+	}
+
+	return counts, nil
+}
+
+func sessionFromHash(sessionID string, fields map[string]string) Session {
+	idle, _ := strconv.ParseInt(fields[fieldIdle], 10, 64)
+
+	return Session{
+		ID:                sessionID,
+		UserID:            fields[fieldUserID],
+		InternalUserID:    fields[fieldInternalUser],
+		RefreshToken:      fields[fieldRefreshToken],
+		CreatedAt:         parseMillis(fields[fieldCreatedAt]),
+		ExpiresAt:         parseMillis(fields[fieldExpiresAt]),
+		AbsoluteExpiresAt: parseMillis(fields[fieldAbsolute]),
+		IdleTimeout:       time.Duration(idle) * time.Millisecond,
+		DeviceHash:        fields[fieldDeviceHash],
+		Auth: AuthContext{
+			ACR:      fields[fieldACR],
+			AMR:      strings.Fields(fields[fieldAMR]),
+			AuthTime: parseMillis(fields[fieldAuthTime]),
+		},
+		Impersonator:          fields[fieldImpersonator],
+		ImpersonatorSessionID: fields[fieldImpersonatorSession],
+		ClientID:              fields[fieldClientID],
+	}
+}
+
+// formatMillis stores t as Unix milliseconds, and the zero time as ""
+func formatMillis(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+func parseMillis(value string) time.Time {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
 }
@@ -1,19 +1,30 @@
-package storage
+package storage_test
 
 import (
 	"context"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
 	testredis "github.com/testcontainers/testcontainers-go/modules/redis"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+	"github.com/carlosealves2/short-stream/authservice/internal/storage/storagetest"
+)
+
+const (
+	testRefreshToken = "test-refresh-token"
+	testUserID       = "test-user"
 )
 
-const testRefreshToken = "test-refresh-token"
+var testLifetime = storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour}
 
 func setupRedisContainer(t *testing.T) (*testredis.RedisContainer, redis.UniversalClient) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
 	ctx := context.Background()
 
 	redisContainer, err := testredis.Run(ctx,
@@ -42,11 +53,11 @@ func setupRedisContainer(t *testing.T) (*testredis.RedisContainer, redis.Univers
 
 func TestRedisStore_CreateState(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 
-	state, err := store.CreateState(ctx)
+	state, err := store.CreateState(ctx, storage.StateData{})
 
 	require.NoError(t, err)
 	assert.NotEmpty(t, state)
@@ -54,7 +65,7 @@ func TestRedisStore_CreateState(t *testing.T) {
 	// Verify state exists in Redis
 	val, err := client.Get(ctx, "state:"+state).Result()
 	assert.NoError(t, err)
-	assert.Equal(t, "valid", val)
+	assert.JSONEq(t, `{}`, val)
 
 	// Verify state has TTL
 	ttl, err := client.TTL(ctx, "state:"+state).Result()
@@ -65,16 +76,16 @@ func TestRedisStore_CreateState(t *testing.T) {
 
 func TestRedisStore_ValidateState_Valid(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 
 	// Create a state first
-	state, err := store.CreateState(ctx)
+	state, err := store.CreateState(ctx, storage.StateData{})
 	require.NoError(t, err)
 
 	// Validate the state
-	err = store.ValidateState(ctx, state)
+	_, err = store.ValidateState(ctx, state)
 
 	assert.NoError(t, err)
 
@@ -83,116 +94,130 @@ func TestRedisStore_ValidateState_Valid(t *testing.T) {
 	assert.Equal(t, redis.Nil, err)
 }
 
+func TestRedisStore_ValidateState_LegacyMarker(t *testing.T) {
+	_, client := setupRedisContainer(t)
+	store := storage.NewRedisStore(client, testLifetime)
+
+	ctx := context.Background()
+
+	// States created before they carried data still validate
+	require.NoError(t, client.Set(ctx, "state:legacy", "valid", time.Minute).Err())
+
+	data, err := store.ValidateState(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, storage.StateData{}, data)
+}
+
 func TestRedisStore_ValidateState_Invalid(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 
 	// Try to validate non-existent state
-	err := store.ValidateState(ctx, "invalid-state")
+	_, err := store.ValidateState(ctx, "invalid-state")
 
 	assert.Error(t, err)
 }
 
 func TestRedisStore_CreateSession(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 	refreshToken := testRefreshToken
 
-	sessionID, err := store.CreateSession(ctx, refreshToken)
+	sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: testUserID, RefreshToken: refreshToken})
 
 	require.NoError(t, err)
 	assert.NotEmpty(t, sessionID)
 
 	// Verify session exists in Redis
-	val, err := client.Get(ctx, "session:"+sessionID).Result()
+	val, err := client.HGet(ctx, storage.RedisSessionKey(sessionID), "refresh_token").Result()
 	assert.NoError(t, err)
 	assert.Equal(t, refreshToken, val)
 
 	// Verify session has TTL
-	ttl, err := client.TTL(ctx, "session:"+sessionID).Result()
+	ttl, err := client.TTL(ctx, storage.RedisSessionKey(sessionID)).Result()
 	assert.NoError(t, err)
 	assert.Greater(t, ttl, time.Duration(0))
 	assert.LessOrEqual(t, ttl, 3600*time.Second)
 }
 
-func TestRedisStore_GetRefreshToken_Valid(t *testing.T) {
+func TestRedisStore_GetSession_Valid(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 	refreshToken := testRefreshToken
 
 	// Create session first
-	sessionID, err := store.CreateSession(ctx, refreshToken)
+	sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: testUserID, RefreshToken: refreshToken})
 	require.NoError(t, err)
 
-	// Get refresh token
-	retrievedToken, err := store.GetRefreshToken(ctx, sessionID)
+	// Get session
+	session, err := store.GetSession(ctx, sessionID)
 
 	require.NoError(t, err)
-	assert.Equal(t, refreshToken, retrievedToken)
+	assert.Equal(t, refreshToken, session.RefreshToken)
 }
 
-func TestRedisStore_GetRefreshToken_Invalid(t *testing.T) {
+func TestRedisStore_GetSession_Invalid(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 
 	// Try to get non-existent session
-	_, err := store.GetRefreshToken(ctx, "invalid-session-id")
+	_, err := store.GetSession(ctx, "invalid-session-id")
 
 	assert.Error(t, err)
 }
 
 func TestRedisStore_UpdateSession(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 	oldRefreshToken := "old-refresh-token"
 	newRefreshToken := "new-refresh-token"
 
 	// Create session first
-	sessionID, err := store.CreateSession(ctx, oldRefreshToken)
+	sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: testUserID, RefreshToken: oldRefreshToken})
 	require.NoError(t, err)
 
 	// Update session
-	err = store.UpdateSession(ctx, sessionID, newRefreshToken)
+	_, err = store.UpdateSession(ctx, sessionID, newRefreshToken)
 
 	require.NoError(t, err)
 
 	// Verify token was updated
-	val, err := client.Get(ctx, "session:"+sessionID).Result()
+	val, err := client.HGet(ctx, storage.RedisSessionKey(sessionID), "refresh_token").Result()
 	assert.NoError(t, err)
 	assert.Equal(t, newRefreshToken, val)
 }
 
 func TestRedisStore_UpdateSession_NonExistent(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 
 	// Try to update non-existent session
-	err := store.UpdateSession(ctx, "invalid-session-id", "new-token")
+	_, err := store.UpdateSession(ctx, "invalid-session-id", "new-token")
 
 	assert.Error(t, err)
 }
 
 func TestRedisStore_DeleteSession(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 	refreshToken := testRefreshToken
 
 	// Create session first
-	sessionID, err := store.CreateSession(ctx, refreshToken)
+	sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: testUserID, RefreshToken: refreshToken})
 	require.NoError(t, err)
 
 	// Delete session
@@ -201,13 +226,13 @@ func TestRedisStore_DeleteSession(t *testing.T) {
 	require.NoError(t, err)
 
 	// Verify session is deleted
-	_, err = client.Get(ctx, "session:"+sessionID).Result()
+	_, err = client.HGet(ctx, storage.RedisSessionKey(sessionID), "refresh_token").Result()
 	assert.Equal(t, redis.Nil, err)
 }
 
 func TestRedisStore_DeleteSession_NonExistent(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 
@@ -220,12 +245,12 @@ func TestRedisStore_DeleteSession_NonExistent(t *testing.T) {
 func TestRedisStore_StateExpiration(t *testing.T) {
 	_, client := setupRedisContainer(t)
 	// Create store with very short session TTL for testing
-	store := NewRedisStore(client, 1)
+	store := storage.NewRedisStore(client, storage.Lifetime{Idle: time.Second, Absolute: time.Hour})
 
 	ctx := context.Background()
 
 	// Create a state to test natural expiration
-	state, err := store.CreateState(ctx)
+	state, err := store.CreateState(ctx, storage.StateData{})
 	require.NoError(t, err)
 
 	// Manually set a short TTL for testing
@@ -236,37 +261,37 @@ func TestRedisStore_StateExpiration(t *testing.T) {
 	time.Sleep(2 * time.Second)
 
 	// State should be expired
-	err = store.ValidateState(ctx, state)
+	_, err = store.ValidateState(ctx, state)
 	assert.Error(t, err)
 }
 
 func TestRedisStore_SessionExpiration(t *testing.T) {
 	_, client := setupRedisContainer(t)
 	// Create store with very short session TTL for testing
-	store := NewRedisStore(client, 1)
+	store := storage.NewRedisStore(client, storage.Lifetime{Idle: time.Second, Absolute: time.Hour})
 
 	ctx := context.Background()
 	refreshToken := testRefreshToken
 
 	// Create session
-	sessionID, err := store.CreateSession(ctx, refreshToken)
+	sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: testUserID, RefreshToken: refreshToken})
 	require.NoError(t, err)
 
 	// Session should exist immediately
-	_, err = store.GetRefreshToken(ctx, sessionID)
+	_, err = store.GetSession(ctx, sessionID)
 	assert.NoError(t, err)
 
 	// Wait for expiration
 	time.Sleep(2 * time.Second)
 
 	// Session should be expired
-	_, err = store.GetRefreshToken(ctx, sessionID)
+	_, err = store.GetSession(ctx, sessionID)
 	assert.Error(t, err)
 }
 
 func TestRedisStore_ConcurrentOperations(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 
@@ -276,7 +301,7 @@ func TestRedisStore_ConcurrentOperations(t *testing.T) {
 
 	for i := 0; i < 10; i++ {
 		go func(index int) {
-			state, err := store.CreateState(ctx)
+			state, err := store.CreateState(ctx, storage.StateData{})
 			require.NoError(t, err)
 			states[index] = state
 			done <- true
@@ -299,46 +324,107 @@ func TestRedisStore_ConcurrentOperations(t *testing.T) {
 
 func TestRedisStore_Integration_FullFlow(t *testing.T) {
 	_, client := setupRedisContainer(t)
-	store := NewRedisStore(client, 3600)
+	store := storage.NewRedisStore(client, testLifetime)
 
 	ctx := context.Background()
 
 	// Simulate full authentication flow
 	// 1. Create state for OAuth
-	state, err := store.CreateState(ctx)
+	state, err := store.CreateState(ctx, storage.StateData{})
 	require.NoError(t, err)
 	assert.NotEmpty(t, state)
 
 	// 2. Validate state (simulating callback)
-	err = store.ValidateState(ctx, state)
+	_, err = store.ValidateState(ctx, state)
 	require.NoError(t, err)
 
 	// 3. Create session with refresh token
 	refreshToken := "initial-refresh-token"
-	sessionID, err := store.CreateSession(ctx, refreshToken)
+	sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: testUserID, RefreshToken: refreshToken})
 	require.NoError(t, err)
 	assert.NotEmpty(t, sessionID)
 
-	// 4. Get refresh token (simulating token refresh)
-	retrievedToken, err := store.GetRefreshToken(ctx, sessionID)
+	// 4. Get session (simulating token refresh)
+	session, err := store.GetSession(ctx, sessionID)
 	require.NoError(t, err)
-	assert.Equal(t, refreshToken, retrievedToken)
+	assert.Equal(t, refreshToken, session.RefreshToken)
 
 	// 5. Update session with new refresh token
 	newRefreshToken := "new-refresh-token"
-	err = store.UpdateSession(ctx, sessionID, newRefreshToken)
+	_, err = store.UpdateSession(ctx, sessionID, newRefreshToken)
 	require.NoError(t, err)
 
 	// 6. Verify token was updated
-	updatedToken, err := store.GetRefreshToken(ctx, sessionID)
+	session, err = store.GetSession(ctx, sessionID)
 	require.NoError(t, err)
-	assert.Equal(t, newRefreshToken, updatedToken)
+	assert.Equal(t, newRefreshToken, session.RefreshToken)
 
 	// 7. Delete session (simulating logout)
 	err = store.DeleteSession(ctx, sessionID)
 	require.NoError(t, err)
 
 	// 8. Verify session is gone
-	_, err = store.GetRefreshToken(ctx, sessionID)
+	_, err = store.GetSession(ctx, sessionID)
 	assert.Error(t, err)
 }
+
+func TestRedisStore_UserSessionIndex(t *testing.T) {
+	_, client := setupRedisContainer(t)
+	store := storage.NewRedisStore(client, testLifetime)
+
+	ctx := context.Background()
+
+	sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: testUserID, RefreshToken: testRefreshToken})
+	require.NoError(t, err)
+
+	// The per-user index and the session share a cluster slot
+	index := storage.RedisUserSessionsKey(testUserID)
+	ids, err := client.ZRange(ctx, index, 0, -1).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{sessionID}, ids)
+
+	ttl, err := client.TTL(ctx, index).Result()
+	require.NoError(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+
+	// Sessions that expired without updating the index are pruned on listing
+	require.NoError(t, client.Del(ctx, storage.RedisSessionKey(sessionID)).Err())
+
+	sessions, err := store.ListUserSessions(ctx, testUserID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	ids, err = client.ZRange(ctx, index, 0, -1).Result()
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestRedisStore_BaselineSession(t *testing.T) {
+	_, client := setupRedisContainer(t)
+	store := storage.NewRedisStore(client, testLifetime)
+	ctx := context.Background()
+
+	// The first release stored the refresh token as a string
+	baselineID := "0b5c8f7e-8d3c-4b8f-9a51-7f1f4f2c9d10"
+	require.NoError(t, client.Set(ctx, "session:"+baselineID, testRefreshToken, time.Hour).Err())
+
+	_, err := store.GetSession(ctx, baselineID)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+
+	exists, err := client.Exists(ctx, "session:"+baselineID).Result()
+	require.NoError(t, err)
+	assert.Zero(t, exists, "the baseline key is deleted")
+}
+
+func TestRedisStore_Conformance(t *testing.T) {
+	_, client := setupRedisContainer(t)
+
+	storagetest.Run(t, func(t *testing.T, lifetime storage.Lifetime) storage.Store {
+		require.NoError(t, client.FlushDB(context.Background()).Err())
+		return storage.NewRedisStore(client, lifetime)
+	}, storagetest.WithBaselineSession(func(t *testing.T) string {
+		sessionID := uuid.New().String()
+		require.NoError(t, client.Set(context.Background(), "session:"+sessionID, testRefreshToken, time.Hour).Err())
+		return sessionID
+	}))
+}
@@ -0,0 +1,34 @@
+package storage_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// hashTag returns what Redis Cluster hashes a key by: the first non-empty
+// {...} section, or the whole key
+func hashTag(key string) string {
+	if start := strings.Index(key, "{"); start >= 0 {
+		if end := strings.Index(key[start+1:], "}"); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+	return key
+}
+
+func TestRedisKeys_SessionSharesSlotWithUserIndex(t *testing.T) {
+	for _, userID := range []string{"test-user", "4f1c2d3e-0000-4000-8000-000000000001", "user{with}braces"} {
+		sessionID := storage.NewRedisSessionID(userID)
+		assert.Equal(t, hashTag(storage.RedisUserSessionsKey(userID)), hashTag(storage.RedisSessionKey(sessionID)), userID)
+	}
+
+	// Users get different tags, so sessions spread over the cluster
+	assert.NotEqual(t,
+		hashTag(storage.RedisUserSessionsKey("user-a")),
+		hashTag(storage.RedisUserSessionsKey("user-b")),
+	)
+}
@@ -1,16 +1,186 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned for a session that does not exist or expired
+var ErrSessionNotFound = errors.New("session not found or expired")
+
+// Lifetime bounds how long a session lives
+type Lifetime struct {
+	// Idle is how long a session survives without being refreshed; every
+	// refresh extends the expiry by Idle
+	Idle time.Duration
+	// Absolute caps the session age from creation, regardless of activity
+	Absolute time.Duration
+}
+
+// slidingExpiry returns when a session touched at now expires
+func slidingExpiry(now time.Time, idle time.Duration, absoluteExpiresAt time.Time) time.Time {
+	expiresAt := now.Add(idle)
+	if expiresAt.After(absoluteExpiresAt) {
+		return absoluteExpiresAt
+	}
+	return expiresAt
+}
+
+// StateData is carried from the login or logout request to its callback with
+// the state parameter
+type StateData struct {
+	// Remember requests a long-lived, device-bound session
+	Remember bool `json:"remember,omitempty"`
+	// Logout marks the state of an RP-Initiated Logout, which is only accepted
+	// by the logout callback (and login states only by the login callback)
+	Logout bool `json:"logout,omitempty"`
+	// RedirectTo is where the logout callback sends the browser
+	RedirectTo string `json:"redirect_to,omitempty"`
+	// Native carries a native app login; it is set on the login state and on
+	// the one-time code the app redeems for tokens
+	Native *NativeAuthorization `json:"native,omitempty"`
+	// ACRValues and MaxAge are the authentication requirements sent to the
+	// provider, checked again on callback
+	ACRValues string `json:"acr_values,omitempty"`
+	// MaxAge is in seconds; nil when the login did not ask for one
+	MaxAge *int `json:"max_age,omitempty"`
+}
+
+// NativeAuthorization is a PKCE-bound login of a registered public client
+type NativeAuthorization struct {
+	ClientID    string `json:"client_id"`
+	RedirectURI string `json:"redirect_uri"`
+	// CodeChallenge is the S256 PKCE challenge the code verifier must match
+	CodeChallenge string `json:"code_challenge"`
+	// AppState is the client's own state, echoed back on its redirect URI
+	AppState string `json:"app_state,omitempty"`
+
+	// Set once the user logged in: the session and the tokens the code redeems for
+	SessionID   string    `json:"session_id,omitempty"`
+	AccessToken string    `json:"access_token,omitempty"`
+	IDToken     string    `json:"id_token,omitempty"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// DeviceGrant is a pending device authorization (RFC 8628). The provider's
+// device code stays in the store; the device only holds the grant's handle.
+type DeviceGrant struct {
+	DeviceCode string `json:"device_code"`
+	// Interval is the minimum delay between two polls; every slow_down adds
+	// to it
+	Interval  time.Duration `json:"interval"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	// LastPolledAt is when the device last polled; zero before the first poll
+	LastPolledAt time.Time `json:"last_polled_at,omitzero"`
+}
+
+// NewSession describes a session to create
+type NewSession struct {
+	UserID string
+	// InternalUserID is the local user the provider identity is linked to
+	InternalUserID string
+	RefreshToken   string
+	// Lifetime overrides the store default when set
+	Lifetime Lifetime
+	// DeviceHash binds the session to a device fingerprint; empty for unbound sessions
+	DeviceHash string
+	// Auth is how the user authenticated at the provider
+	Auth AuthContext
+	// Impersonator is the subject of the support engineer acting as UserID;
+	// empty for the user's own sessions
+	Impersonator string
+	// ImpersonatorSessionID is the impersonator's own session, which the
+	// impersonation session gets its tokens from
+	ImpersonatorSessionID string
+	// ClientID is the native client the session is issued to; empty for
+	// browser sessions
+	ClientID string
+}
+
+// AuthContext is how a user authenticated, from the ID token's acr, amr and
+// auth_time claims. Refreshes keep the values of the login.
+type AuthContext struct {
+	// ACR is the authentication context class reference, e.g. a level of assurance
+	ACR string
+	// AMR lists the authentication methods used, e.g. pwd and otp
+	AMR []string
+	// AuthTime is when the user last authenticated; zero when the provider
+	// did not report it
+	AuthTime time.Time
+}
+
+// Session is a stored user session
+type Session struct {
+	ID string
+	// UserID is the subject at the identity provider
+	UserID string
+	// InternalUserID is the stable local user ID; empty for sessions created
+	// before user records were kept
+	InternalUserID string
+	RefreshToken   string
+	CreatedAt      time.Time
+	// ExpiresAt is the current expiry; it slides forward on refresh up to AbsoluteExpiresAt
+	ExpiresAt         time.Time
+	AbsoluteExpiresAt time.Time
+	IdleTimeout       time.Duration
+	// DeviceHash is the fingerprint of the device a remember-me session is bound to
+	DeviceHash string
+	// Auth is how the user authenticated when the session was created
+	Auth AuthContext
+	// Impersonator is set on sessions a support engineer opened as the user
+	Impersonator          string
+	ImpersonatorSessionID string
+	// ClientID is the native client the session belongs to; only that client
+	// may refresh it
+	ClientID string
+}
+
+// SessionCounts summarizes the active sessions in a store
+type SessionCounts struct {
+	Sessions int64
+	Users    int64
+}
+
+// lifetimeFor returns the lifetime of a new session, falling back to the store default
+func lifetimeFor(session NewSession, fallback Lifetime) Lifetime {
+	if session.Lifetime == (Lifetime{}) {
+		return fallback
+	}
+	return session.Lifetime
+}
 
 // Store defines the interface for session and state storage
 type Store interface {
 	// State management
-	CreateState(ctx context.Context) (string, error)
-	ValidateState(ctx context.Context, state string) error
+	CreateState(ctx context.Context, data StateData) (string, error)
+	// ValidateState consumes a state and returns the data stored with it
+	ValidateState(ctx context.Context, state string) (StateData, error)
+
+	// Device authorization grants
+	// CreateDeviceGrant stores a grant until its expiry and returns its handle
+	CreateDeviceGrant(ctx context.Context, grant DeviceGrant) (string, error)
+	// GetDeviceGrant returns an unexpired grant
+	GetDeviceGrant(ctx context.Context, id string) (DeviceGrant, error)
+	// UpdateDeviceGrant saves the interval and last poll time of an unexpired
+	// grant, keeping its expiry
+	UpdateDeviceGrant(ctx context.Context, id string, grant DeviceGrant) error
+	DeleteDeviceGrant(ctx context.Context, id string) error
 
 	// Session management
-	CreateSession(ctx context.Context, refreshToken string) (string, error)
-	GetRefreshToken(ctx context.Context, sessionID string) (string, error)
-	UpdateSession(ctx context.Context, sessionID, refreshToken string) error
+	CreateSession(ctx context.Context, session NewSession) (string, error)
+	// GetSession returns an active session, or ErrSessionNotFound
+	GetSession(ctx context.Context, sessionID string) (Session, error)
+	// UpdateSession extends the idle expiry of an active session, capped by its
+	// absolute lifetime, and rotates the refresh token unless refreshToken is
+	// empty. A missing or expired session is ErrSessionNotFound.
+	UpdateSession(ctx context.Context, sessionID, refreshToken string) (Session, error)
 	DeleteSession(ctx context.Context, sessionID string) error
+
+	// ListUserSessions returns the active sessions of a user, oldest first
+	ListUserSessions(ctx context.Context, userID string) ([]Session, error)
+	// DeleteUserSessions removes every session of a user and returns the removed sessions
+	DeleteUserSessions(ctx context.Context, userID string) ([]Session, error)
+	// CountSessions reports how many sessions and distinct users are active
+	CountSessions(ctx context.Context) (SessionCounts, error)
 }
@@ -0,0 +1,623 @@
+// Package storagetest provides a conformance suite for storage.Store implementations
+package storagetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
+)
+
+// Factory returns an empty Store whose sessions are bounded by lifetime
+type Factory func(t *testing.T, lifetime storage.Lifetime) storage.Store
+
+// Option customizes the suite
+type Option func(*options)
+
+type options struct {
+	advance      func(time.Duration)
+	seedBaseline func(t *testing.T) string
+}
+
+// WithAdvance sets how the suite moves time forward to test expiry. Backends
+// with an injectable clock pass a function advancing it; the default sleeps.
+func WithAdvance(advance func(time.Duration)) Option {
+	return func(o *options) {
+		o.advance = advance
+	}
+}
+
+// WithBaselineSession sets how the suite stores a session in the format of the
+// first release, returning its ID, for backends that kept sessions before
+func WithBaselineSession(seed func(t *testing.T) string) Option {
+	return func(o *options) {
+		o.seedBaseline = seed
+	}
+}
+
+// Run checks the state and session semantics every Store implementation must
+// share, so backends can be swapped without behavior changes
+func Run(t *testing.T, newStore Factory, opts ...Option) {
+	o := &options{advance: time.Sleep}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	ctx := context.Background()
+	lifetime := storage.Lifetime{Idle: time.Hour, Absolute: 24 * time.Hour}
+
+	t.Run("state is single use", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		state, err := store.CreateState(ctx, storage.StateData{})
+		require.NoError(t, err)
+		assert.NotEmpty(t, state)
+
+		_, err = store.ValidateState(ctx, state)
+		require.NoError(t, err)
+		_, err = store.ValidateState(ctx, state)
+		assert.Error(t, err, "state must not be replayable")
+	})
+
+	t.Run("state carries data", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		state, err := store.CreateState(ctx, storage.StateData{Remember: true})
+		require.NoError(t, err)
+
+		data, err := store.ValidateState(ctx, state)
+		require.NoError(t, err)
+		assert.True(t, data.Remember)
+	})
+
+	t.Run("unknown state is rejected", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		_, err := store.ValidateState(ctx, "invalid-state")
+		assert.Error(t, err)
+	})
+
+	t.Run("concurrent validation consumes state once", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		state, err := store.CreateState(ctx, storage.StateData{})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		successes := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := store.ValidateState(ctx, state); err == nil {
+					mu.Lock()
+					successes++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, 1, successes)
+	})
+
+	t.Run("device grant lifecycle", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		grant := storage.DeviceGrant{
+			DeviceCode: "device-code-1",
+			Interval:   5 * time.Second,
+			ExpiresAt:  time.Now().Add(time.Hour).Truncate(time.Millisecond),
+		}
+		id, err := store.CreateDeviceGrant(ctx, grant)
+		require.NoError(t, err)
+		assert.NotEmpty(t, id)
+
+		stored, err := store.GetDeviceGrant(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, grant.DeviceCode, stored.DeviceCode)
+		assert.Equal(t, grant.Interval, stored.Interval)
+		assert.True(t, grant.ExpiresAt.Equal(stored.ExpiresAt))
+
+		_, err = store.GetDeviceGrant(ctx, id)
+		require.NoError(t, err, "polling does not consume the grant")
+		assert.True(t, stored.LastPolledAt.IsZero())
+
+		polledAt := time.Now().Truncate(time.Millisecond)
+		require.NoError(t, store.UpdateDeviceGrant(ctx, id, storage.DeviceGrant{Interval: 10 * time.Second, LastPolledAt: polledAt}))
+		stored, err = store.GetDeviceGrant(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, grant.DeviceCode, stored.DeviceCode, "updates keep the device code")
+		assert.Equal(t, 10*time.Second, stored.Interval)
+		assert.True(t, polledAt.Equal(stored.LastPolledAt))
+		assert.True(t, grant.ExpiresAt.Equal(stored.ExpiresAt), "updates keep the expiry")
+
+		require.NoError(t, store.DeleteDeviceGrant(ctx, id))
+		_, err = store.GetDeviceGrant(ctx, id)
+		assert.Error(t, err)
+		assert.NoError(t, store.DeleteDeviceGrant(ctx, id), "deleting is idempotent")
+	})
+
+	t.Run("unknown device grant", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		_, err := store.GetDeviceGrant(ctx, "invalid-grant")
+		assert.Error(t, err)
+		assert.Error(t, store.UpdateDeviceGrant(ctx, "invalid-grant", storage.DeviceGrant{Interval: time.Second}), "updates do not create grants")
+	})
+
+	t.Run("session lifecycle", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-1", InternalUserID: "internal-1", RefreshToken: "refresh-1"})
+		require.NoError(t, err)
+		assert.NotEmpty(t, sessionID)
+
+		session, err := store.GetSession(ctx, sessionID)
+		require.NoError(t, err)
+		assert.Equal(t, sessionID, session.ID)
+		assert.Equal(t, "user-1", session.UserID)
+		assert.Equal(t, "internal-1", session.InternalUserID)
+		assert.Equal(t, "refresh-1", session.RefreshToken)
+		assert.Empty(t, session.DeviceHash)
+
+		updated, err := store.UpdateSession(ctx, sessionID, "refresh-2")
+		require.NoError(t, err)
+		assert.Equal(t, sessionID, updated.ID)
+		assert.Equal(t, "user-1", updated.UserID)
+		assert.Equal(t, "internal-1", updated.InternalUserID)
+		assert.Equal(t, "refresh-2", updated.RefreshToken)
+
+		session, err = store.GetSession(ctx, sessionID)
+		require.NoError(t, err)
+		assert.Equal(t, "refresh-2", session.RefreshToken)
+
+		require.NoError(t, store.DeleteSession(ctx, sessionID))
+
+		_, err = store.GetSession(ctx, sessionID)
+		assert.Error(t, err)
+	})
+
+	t.Run("baseline session is dropped", func(t *testing.T) {
+		if o.seedBaseline == nil {
+			t.Skip("backend has no baseline sessions")
+		}
+		store := newStore(t, lifetime)
+
+		sessionID := o.seedBaseline(t)
+		_, err := store.GetSession(ctx, sessionID)
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+		_, err = store.UpdateSession(ctx, sessionID, "refresh-2")
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+		assert.NoError(t, store.DeleteSession(ctx, sessionID))
+
+		sessionID = o.seedBaseline(t)
+		_, err = store.UpdateSession(ctx, sessionID, "refresh-2")
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound, "refreshing does not revive it")
+		assert.NoError(t, store.DeleteSession(ctx, sessionID))
+	})
+
+	t.Run("session auth context", func(t *testing.T) {
+		store := newStore(t, lifetime)
+		authTime := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+
+		sessionID, err := store.CreateSession(ctx, storage.NewSession{
+			UserID:       "user-1",
+			RefreshToken: "refresh-1",
+			Auth:         storage.AuthContext{ACR: "gold", AMR: []string{"pwd", "otp"}, AuthTime: authTime},
+		})
+		require.NoError(t, err)
+
+		session, err := store.GetSession(ctx, sessionID)
+		require.NoError(t, err)
+		assert.Equal(t, "gold", session.Auth.ACR)
+		assert.Equal(t, []string{"pwd", "otp"}, session.Auth.AMR)
+		assert.True(t, authTime.Equal(session.Auth.AuthTime), "auth_time %s", session.Auth.AuthTime)
+
+		// Refreshing does not reauthenticate the user
+		updated, err := store.UpdateSession(ctx, sessionID, "refresh-2")
+		require.NoError(t, err)
+		assert.Equal(t, "gold", updated.Auth.ACR)
+		assert.True(t, authTime.Equal(updated.Auth.AuthTime))
+
+		// Providers that report no auth context leave it empty
+		sessionID, err = store.CreateSession(ctx, storage.NewSession{UserID: "user-2", RefreshToken: "refresh-3"})
+		require.NoError(t, err)
+		session, err = store.GetSession(ctx, sessionID)
+		require.NoError(t, err)
+		assert.Empty(t, session.Auth.ACR)
+		assert.Empty(t, session.Auth.AMR)
+		assert.True(t, session.Auth.AuthTime.IsZero())
+	})
+
+	t.Run("impersonation session", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		sessionID, err := store.CreateSession(ctx, storage.NewSession{
+			UserID:                "user-1",
+			Impersonator:          "support-1",
+			ImpersonatorSessionID: "support-session",
+		})
+		require.NoError(t, err)
+
+		session, err := store.GetSession(ctx, sessionID)
+		require.NoError(t, err)
+		assert.Equal(t, "support-1", session.Impersonator)
+		assert.Equal(t, "support-session", session.ImpersonatorSessionID)
+		assert.Empty(t, session.RefreshToken)
+
+		sessions, err := store.ListUserSessions(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		assert.Equal(t, "support-1", sessions[0].Impersonator)
+	})
+
+	t.Run("native session client", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-1", ClientID: "ios"})
+		require.NoError(t, err)
+
+		session, err := store.GetSession(ctx, sessionID)
+		require.NoError(t, err)
+		assert.Equal(t, "ios", session.ClientID)
+
+		sessionID, err = store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-2"})
+		require.NoError(t, err)
+		session, err = store.GetSession(ctx, sessionID)
+		require.NoError(t, err)
+		assert.Empty(t, session.ClientID, "browser sessions have no client")
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		_, err := store.GetSession(ctx, "invalid-session-id")
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+		_, err = store.UpdateSession(ctx, "invalid-session-id", "refresh")
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+		assert.NoError(t, store.DeleteSession(ctx, "invalid-session-id"), "deleting is idempotent")
+	})
+
+	t.Run("sessions are listed per user", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		first, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-1"})
+		require.NoError(t, err)
+		o.advance(5 * time.Millisecond)
+		second, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-2"})
+		require.NoError(t, err)
+		other, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-2", RefreshToken: "refresh-3"})
+		require.NoError(t, err)
+
+		sessions, err := store.ListUserSessions(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, first, sessions[0].ID, "oldest first")
+		assert.Equal(t, second, sessions[1].ID)
+		for _, s := range sessions {
+			assert.Equal(t, "user-1", s.UserID)
+			assert.False(t, s.CreatedAt.IsZero())
+			assert.True(t, s.ExpiresAt.After(s.CreatedAt))
+		}
+
+		require.NoError(t, store.DeleteSession(ctx, first))
+
+		sessions, err = store.ListUserSessions(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		assert.Equal(t, second, sessions[0].ID)
+
+		sessions, err = store.ListUserSessions(ctx, "user-2")
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		assert.Equal(t, other, sessions[0].ID)
+
+		sessions, err = store.ListUserSessions(ctx, "nobody")
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+
+	t.Run("sessions expire when idle", func(t *testing.T) {
+		store := newStore(t, storage.Lifetime{Idle: time.Second, Absolute: time.Hour})
+
+		sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-1"})
+		require.NoError(t, err)
+
+		_, err = store.GetSession(ctx, sessionID)
+		require.NoError(t, err)
+
+		o.advance(1500 * time.Millisecond)
+
+		_, err = store.GetSession(ctx, sessionID)
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+		_, err = store.UpdateSession(ctx, sessionID, "refresh-2")
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound, "expired sessions cannot be revived")
+
+		sessions, err := store.ListUserSessions(ctx, "user-1")
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+
+	t.Run("update slides idle expiry", func(t *testing.T) {
+		store := newStore(t, storage.Lifetime{Idle: 2 * time.Second, Absolute: time.Hour})
+
+		sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-1"})
+		require.NoError(t, err)
+
+		o.advance(1500 * time.Millisecond)
+		updated, err := store.UpdateSession(ctx, sessionID, "")
+		require.NoError(t, err)
+		assert.Equal(t, "refresh-1", updated.RefreshToken, "empty refresh token keeps the current one")
+		assert.Equal(t, 2*time.Second, updated.IdleTimeout)
+
+		o.advance(1500 * time.Millisecond)
+
+		session, err := store.GetSession(ctx, sessionID)
+		require.NoError(t, err, "activity keeps the session alive past the first idle window")
+		assert.Equal(t, "refresh-1", session.RefreshToken)
+	})
+
+	t.Run("absolute lifetime caps sliding expiry", func(t *testing.T) {
+		store := newStore(t, storage.Lifetime{Idle: 2 * time.Second, Absolute: 3 * time.Second})
+
+		sessionID, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-1"})
+		require.NoError(t, err)
+
+		sessions, err := store.ListUserSessions(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		createdAt := sessions[0].CreatedAt
+		assert.WithinDuration(t, createdAt.Add(3*time.Second), sessions[0].AbsoluteExpiresAt, 10*time.Millisecond)
+
+		o.advance(1500 * time.Millisecond)
+		updated, err := store.UpdateSession(ctx, sessionID, "refresh-2")
+		require.NoError(t, err)
+		assert.WithinDuration(t, updated.AbsoluteExpiresAt, updated.ExpiresAt, 10*time.Millisecond,
+			"sliding expiry is capped by the absolute lifetime")
+
+		o.advance(1700 * time.Millisecond)
+
+		_, err = store.GetSession(ctx, sessionID)
+		assert.Error(t, err, "session ends at its absolute lifetime despite activity")
+		_, err = store.UpdateSession(ctx, sessionID, "refresh-3")
+		assert.Error(t, err)
+	})
+	t.Run("session lifetime and device can be set per session", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		remembered, err := store.CreateSession(ctx, storage.NewSession{
+			UserID:       "user-1",
+			RefreshToken: "refresh-1",
+			Lifetime:     storage.Lifetime{Idle: 48 * time.Hour, Absolute: 30 * 24 * time.Hour},
+			DeviceHash:   "device-1",
+		})
+		require.NoError(t, err)
+		o.advance(5 * time.Millisecond)
+		_, err = store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-2"})
+		require.NoError(t, err)
+
+		session, err := store.GetSession(ctx, remembered)
+		require.NoError(t, err)
+		assert.Equal(t, "device-1", session.DeviceHash)
+		assert.Equal(t, 48*time.Hour, session.IdleTimeout)
+		assert.WithinDuration(t, session.CreatedAt.Add(48*time.Hour), session.ExpiresAt, time.Second)
+		assert.WithinDuration(t, session.CreatedAt.Add(30*24*time.Hour), session.AbsoluteExpiresAt, time.Second)
+
+		updated, err := store.UpdateSession(ctx, remembered, "")
+		require.NoError(t, err)
+		assert.Equal(t, "device-1", updated.DeviceHash)
+		assert.Equal(t, 48*time.Hour, updated.IdleTimeout, "refresh keeps the session's own lifetime")
+
+		sessions, err := store.ListUserSessions(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, time.Hour, sessions[1].IdleTimeout, "other sessions keep the store default")
+	})
+	t.Run("user sessions are deleted at once", func(t *testing.T) {
+		store := newStore(t, lifetime)
+
+		first, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-1"})
+		require.NoError(t, err)
+		o.advance(5 * time.Millisecond)
+		second, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-2"})
+		require.NoError(t, err)
+		other, err := store.CreateSession(ctx, storage.NewSession{UserID: "user-2", RefreshToken: "refresh-3"})
+		require.NoError(t, err)
+
+		removed, err := store.DeleteUserSessions(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, removed, 2)
+		assert.Equal(t, first, removed[0].ID)
+		assert.Equal(t, "refresh-1", removed[0].RefreshToken, "removed sessions carry their refresh tokens")
+		assert.Equal(t, second, removed[1].ID)
+
+		for _, id := range []string{first, second} {
+			_, err := store.GetSession(ctx, id)
+			assert.Error(t, err)
+		}
+		sessions, err := store.ListUserSessions(ctx, "user-1")
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+
+		_, err = store.GetSession(ctx, other)
+		assert.NoError(t, err, "other users are untouched")
+
+		removed, err = store.DeleteUserSessions(ctx, "nobody")
+		require.NoError(t, err)
+		assert.Empty(t, removed)
+	})
+
+	t.Run("sessions are counted", func(t *testing.T) {
+		store := newStore(t, storage.Lifetime{Idle: time.Second, Absolute: time.Hour})
+
+		counts, err := store.CountSessions(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, storage.SessionCounts{}, counts)
+
+		_, err = store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-1"})
+		require.NoError(t, err)
+		_, err = store.CreateSession(ctx, storage.NewSession{UserID: "user-1", RefreshToken: "refresh-2"})
+		require.NoError(t, err)
+		_, err = store.CreateSession(ctx, storage.NewSession{
+			UserID:       "user-2",
+			RefreshToken: "refresh-3",
+			Lifetime:     storage.Lifetime{Idle: time.Hour, Absolute: time.Hour},
+		})
+		require.NoError(t, err)
+
+		counts, err = store.CountSessions(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, storage.SessionCounts{Sessions: 3, Users: 2}, counts)
+
+		o.advance(1500 * time.Millisecond)
+
+		counts, err = store.CountSessions(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, storage.SessionCounts{Sessions: 1, Users: 1}, counts, "expired sessions are not counted")
+	})
+}
+
+// UserFactory returns an empty UserStore configured with opts
+type UserFactory func(t *testing.T, opts ...storage.UserStoreOption) storage.UserStore
+
+// RunUserStore checks the identity linking semantics every UserStore
+// implementation must share
+func RunUserStore(t *testing.T, newStore UserFactory) {
+	ctx := context.Background()
+	google := storage.Identity{
+		Provider:      "https://accounts.google.com",
+		Subject:       "google-1",
+		Email:         "Ana@example.com",
+		EmailVerified: true,
+		DisplayName:   "Ana",
+		AvatarURL:     "https://example.com/ana.png",
+	}
+
+	t.Run("first login creates a user", func(t *testing.T) {
+		store := newStore(t)
+
+		user, created, err := store.LinkIdentity(ctx, google)
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.NotEmpty(t, user.ID)
+		assert.Equal(t, "Ana@example.com", user.Email)
+		assert.True(t, user.EmailVerified)
+		assert.Equal(t, "Ana", user.DisplayName)
+		assert.Equal(t, "https://example.com/ana.png", user.AvatarURL)
+		assert.False(t, user.CreatedAt.IsZero())
+		require.Len(t, user.Identities, 1)
+		assert.Equal(t, google.Provider, user.Identities[0].Provider)
+		assert.Equal(t, google.Subject, user.Identities[0].Subject)
+
+		stored, err := store.GetUser(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, stored.ID)
+		assert.Equal(t, "Ana", stored.DisplayName)
+		assert.Len(t, stored.Identities, 1)
+	})
+
+	t.Run("later logins keep the user and refresh the profile", func(t *testing.T) {
+		store := newStore(t)
+
+		first, _, err := store.LinkIdentity(ctx, google)
+		require.NoError(t, err)
+
+		renamed := google
+		renamed.DisplayName = "Ana Souza"
+		renamed.AvatarURL = ""
+		again, created, err := store.LinkIdentity(ctx, renamed)
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, first.ID, again.ID)
+		assert.Equal(t, "Ana Souza", again.DisplayName)
+		assert.Equal(t, "https://example.com/ana.png", again.AvatarURL, "claims left out keep their value")
+		assert.Len(t, again.Identities, 1)
+	})
+
+	github := storage.Identity{Provider: "https://github.com", Subject: "gh-7", Email: "ana@example.com", EmailVerified: true}
+
+	t.Run("identities of trusted issuers with the same verified email share a user", func(t *testing.T) {
+		store := newStore(t, storage.WithEmailLinking(google.Provider, github.Provider))
+
+		first, _, err := store.LinkIdentity(ctx, google)
+		require.NoError(t, err)
+
+		linked, created, err := store.LinkIdentity(ctx, github)
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, first.ID, linked.ID)
+		assert.Len(t, linked.Identities, 2)
+
+		stored, err := store.GetUser(ctx, first.ID)
+		require.NoError(t, err)
+		assert.Len(t, stored.Identities, 2)
+	})
+
+	t.Run("emails are not linked by default", func(t *testing.T) {
+		store := newStore(t)
+
+		first, _, err := store.LinkIdentity(ctx, google)
+		require.NoError(t, err)
+
+		other, created, err := store.LinkIdentity(ctx, github)
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.NotEqual(t, first.ID, other.ID)
+		assert.False(t, other.EmailVerified, "a verified email belongs to one user only")
+
+		stored, err := store.GetUser(ctx, first.ID)
+		require.NoError(t, err)
+		assert.True(t, stored.EmailVerified)
+	})
+
+	t.Run("untrusted issuers are not linked", func(t *testing.T) {
+		store := newStore(t, storage.WithEmailLinking(google.Provider))
+
+		first, _, err := store.LinkIdentity(ctx, google)
+		require.NoError(t, err)
+
+		other, created, err := store.LinkIdentity(ctx, github)
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.NotEqual(t, first.ID, other.ID)
+	})
+
+	t.Run("users known only through untrusted issuers are not linked", func(t *testing.T) {
+		store := newStore(t, storage.WithEmailLinking(github.Provider))
+
+		first, _, err := store.LinkIdentity(ctx, google)
+		require.NoError(t, err)
+
+		other, created, err := store.LinkIdentity(ctx, github)
+		require.NoError(t, err)
+		assert.True(t, created, "an untrusted issuer's verified email must not hand its user to a trusted one")
+		assert.NotEqual(t, first.ID, other.ID)
+	})
+
+	t.Run("unverified emails are not linked", func(t *testing.T) {
+		store := newStore(t, storage.WithEmailLinking(google.Provider, github.Provider))
+
+		first, _, err := store.LinkIdentity(ctx, google)
+		require.NoError(t, err)
+
+		unverified := storage.Identity{Provider: "https://github.com", Subject: "gh-7", Email: "ana@example.com"}
+		other, created, err := store.LinkIdentity(ctx, unverified)
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.NotEqual(t, first.ID, other.ID)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		store := newStore(t)
+
+		_, err := store.GetUser(ctx, "missing")
+		assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrUserNotFound is returned when no user has the requested ID
+var ErrUserNotFound = errors.New("user not found")
+
+// Identity is a user as one identity provider knows them, taken from the
+// claims of a verified ID token
+type Identity struct {
+	// Provider is the issuer of the identity
+	Provider string
+	Subject  string
+
+	Email         string
+	EmailVerified bool
+	DisplayName   string
+	AvatarURL     string
+}
+
+// LinkedIdentity is a provider identity linked to a user
+type LinkedIdentity struct {
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// User is a local user record. Its ID is stable across providers and
+// provider-side changes, so other services can own data by it.
+type User struct {
+	ID            string
+	Email         string
+	EmailVerified bool
+	DisplayName   string
+	AvatarURL     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	// Identities are the provider identities linked to the user, oldest first
+	Identities []LinkedIdentity
+}
+
+// UserStore keeps local user records and the provider identities linked to them
+type UserStore interface {
+	// LinkIdentity records a login with identity and returns its user. A
+	// known identity refreshes its user's profile. An unknown identity
+	// creates a new user, reported by created, unless email linking is
+	// enabled for its issuer (see WithEmailLinking). A verified email
+	// belongs to one user only; other users keep it unverified.
+	LinkIdentity(ctx context.Context, identity Identity) (user User, created bool, err error)
+	// GetUser returns a user with its linked identities, or ErrUserNotFound
+	GetUser(ctx context.Context, id string) (User, error)
+}
+
+// applyProfile copies the profile fields the provider sent onto user; claims
+// the provider left out keep their previous value
+func applyProfile(user *User, identity Identity, now time.Time) {
+	if identity.Email != "" {
+		user.Email = identity.Email
+		user.EmailVerified = identity.EmailVerified
+	}
+	if identity.DisplayName != "" {
+		user.DisplayName = identity.DisplayName
+	}
+	if identity.AvatarURL != "" {
+		user.AvatarURL = identity.AvatarURL
+	}
+	user.UpdatedAt = now
+}
+
+// UserStoreOption configures a UserStore
+type UserStoreOption func(*emailLinking)
+
+// WithEmailLinking links a new identity from one of issuers to the existing
+// user with the same verified email, when that user has an identity from one
+// of issuers as well. Only issuers that verify emails themselves belong here:
+// any of them can claim the accounts of the others.
+func WithEmailLinking(issuers ...string) UserStoreOption {
+	return func(l *emailLinking) {
+		l.issuers = append(l.issuers, issuers...)
+	}
+}
+
+// emailLinking holds the issuers trusted to link identities by email; with
+// none, every new identity gets a new user
+type emailLinking struct {
+	issuers []string
+}
+
+func newEmailLinking(opts []UserStoreOption) emailLinking {
+	var l emailLinking
+	for _, opt := range opts {
+		opt(&l)
+	}
+	return l
+}
+
+// trusts reports whether provider may link identities by email
+func (l emailLinking) trusts(provider string) bool {
+	return slices.Contains(l.issuers, provider)
+}
+
+// allows reports whether identity may be linked to an existing user by
+// email; unverified emails could claim someone else's account
+func (l emailLinking) allows(identity Identity) bool {
+	return l.trusts(identity.Provider) && identity.EmailVerified && strings.TrimSpace(identity.Email) != ""
+}
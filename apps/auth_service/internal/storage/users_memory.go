@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+type memoryUserStore struct {
+	mu         sync.Mutex
+	users      map[string]*User
+	identities map[identityKey]string
+	linking    emailLinking
+	now        func() time.Time
+}
+
+// NewMemoryUserStore creates an in-process UserStore. Users are lost on
+// restart, so internal user IDs are only stable for the life of the process.
+func NewMemoryUserStore(opts ...UserStoreOption) UserStore {
+	return &memoryUserStore{
+		users:      make(map[string]*User),
+		identities: make(map[identityKey]string),
+		linking:    newEmailLinking(opts),
+		now:        time.Now,
+	}
+}
+
+func (m *memoryUserStore) LinkIdentity(_ context.Context, identity Identity) (User, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	key := identityKey{provider: identity.Provider, subject: identity.Subject}
+
+	if userID, ok := m.identities[key]; ok {
+		user := m.users[userID]
+		m.applyProfile(user, identity, now)
+		for i := range user.Identities {
+			if user.Identities[i].Provider == key.provider && user.Identities[i].Subject == key.subject {
+				user.Identities[i].LastLoginAt = now
+			}
+		}
+		return copyUser(user), false, nil
+	}
+
+	user, created := m.userByEmail(identity), false
+	if user == nil {
+		user = &User{ID: uuid.New().String(), CreatedAt: now}
+		m.users[user.ID] = user
+		created = true
+	}
+	m.applyProfile(user, identity, now)
+	user.Identities = append(user.Identities, LinkedIdentity{
+		Provider:    identity.Provider,
+		Subject:     identity.Subject,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	})
+	m.identities[key] = user.ID
+
+	return copyUser(user), created, nil
+}
+
+// applyProfile is applyProfile keeping a verified email unique: a user
+// claiming the verified email of another keeps it unverified
+func (m *memoryUserStore) applyProfile(user *User, identity Identity, now time.Time) {
+	applyProfile(user, identity, now)
+	if user.EmailVerified {
+		if m.verifiedEmailHolder(user.Email, user.ID) != nil {
+			user.EmailVerified = false
+		}
+	}
+}
+
+// userByEmail returns the user holding the identity's verified email, when
+// the identity may be linked to it
+func (m *memoryUserStore) userByEmail(identity Identity) *User {
+	if !m.linking.allows(identity) {
+		return nil
+	}
+
+	user := m.verifiedEmailHolder(identity.Email, "")
+	if user == nil {
+		return nil
+	}
+	for _, linked := range user.Identities {
+		if m.linking.trusts(linked.Provider) {
+			return user
+		}
+	}
+	return nil
+}
+
+// verifiedEmailHolder returns the user other than exceptID whose verified
+// email is email
+func (m *memoryUserStore) verifiedEmailHolder(email, exceptID string) *User {
+	for _, user := range m.users {
+		if user.ID != exceptID && user.EmailVerified && strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+	return nil
+}
+
+func (m *memoryUserStore) GetUser(_ context.Context, id string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+func copyUser(user *User) User {
+	out := *user
+	out.Identities = append([]LinkedIdentity(nil), user.Identities...)
+	return out
+}
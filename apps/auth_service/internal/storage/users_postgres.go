@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// userColumns are the auth_users columns scanned by scanUser
+const userColumns = "id, email, email_verified, display_name, avatar_url, created_at, updated_at"
+
+// PostgresUserStore is a PostgreSQL-backed UserStore. The schema must be
+// created first with MigratePostgres.
+type PostgresUserStore struct {
+	pool    *pgxpool.Pool
+	linking emailLinking
+}
+
+// NewPostgresUserStore creates a UserStore on pool
+func NewPostgresUserStore(pool *pgxpool.Pool, opts ...UserStoreOption) *PostgresUserStore {
+	return &PostgresUserStore{pool: pool, linking: newEmailLinking(opts)}
+}
+
+// LinkIdentity records a login with identity in a single transaction
+func (p *PostgresUserStore) LinkIdentity(ctx context.Context, identity Identity) (User, bool, error) {
+	var user User
+	var created bool
+
+	err := pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		var err error
+		user, created, err = linkIdentity(ctx, tx, p.linking, identity, time.Now())
+		return err
+	})
+	if err != nil {
+		return User{}, false, fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	user.Identities, err = p.identities(ctx, user.ID)
+	if err != nil {
+		return User{}, false, err
+	}
+	return user, created, nil
+}
+
+func linkIdentity(ctx context.Context, tx pgx.Tx, linking emailLinking, identity Identity, now time.Time) (User, bool, error) {
+	// A known identity only refreshes its user's profile and login time
+	var userID string
+	err := tx.QueryRow(ctx,
+		"UPDATE auth_identities SET last_login_at = $3 WHERE provider = $1 AND subject = $2 RETURNING user_id",
+		identity.Provider, identity.Subject, now,
+	).Scan(&userID)
+	switch {
+	case err == nil:
+		user, err := updateProfile(ctx, tx, userID, identity, now)
+		return user, false, err
+	case !errors.Is(err, pgx.ErrNoRows):
+		return User{}, false, err
+	}
+
+	created := false
+	if linking.allows(identity) {
+		// The holder of the verified email, if it logs in through a trusted
+		// issuer too
+		err = tx.QueryRow(ctx, `
+			SELECT id FROM auth_users u
+			WHERE email_verified AND lower(email) = lower($1)
+				AND EXISTS (SELECT 1 FROM auth_identities i WHERE i.user_id = u.id AND i.provider = ANY($2))
+			FOR UPDATE`,
+			identity.Email, linking.issuers,
+		).Scan(&userID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return User{}, false, err
+		}
+	}
+	if userID == "" {
+		userID = uuid.New().String()
+		created = true
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO auth_users (id, created_at, updated_at) VALUES ($1, $2, $2)",
+			userID, now,
+		); err != nil {
+			return User{}, false, err
+		}
+	}
+
+	// Two first logins of the same identity race on the primary key; the
+	// loser's transaction fails and its login can simply be retried
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO auth_identities (provider, subject, user_id, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $4)`,
+		identity.Provider, identity.Subject, userID, now,
+	); err != nil {
+		return User{}, false, err
+	}
+
+	user, err := updateProfile(ctx, tx, userID, identity, now)
+	return user, created, err
+}
+
+// updateProfile stores the profile fields the provider sent. A verified
+// email already held by another user stays unverified, as auth_users_email_idx
+// requires; two users claiming one concurrently fail on the index and the
+// login can be retried.
+func updateProfile(ctx context.Context, tx pgx.Tx, userID string, identity Identity, now time.Time) (User, error) {
+	return scanUser(tx.QueryRow(ctx, `
+		UPDATE auth_users SET
+			email          = CASE WHEN $2 <> '' THEN $2 ELSE email END,
+			email_verified = CASE WHEN $2 <> '' THEN $3 AND NOT EXISTS (
+				SELECT 1 FROM auth_users o WHERE o.id <> $1 AND o.email_verified AND lower(o.email) = lower($2)
+			) ELSE email_verified END,
+			display_name   = CASE WHEN $4 <> '' THEN $4 ELSE display_name END,
+			avatar_url     = CASE WHEN $5 <> '' THEN $5 ELSE avatar_url END,
+			updated_at     = $6
+		WHERE id = $1
+		RETURNING `+userColumns,
+		userID, identity.Email, identity.EmailVerified, identity.DisplayName, identity.AvatarURL, now,
+	))
+}
+
+// GetUser returns a user with its linked identities
+func (p *PostgresUserStore) GetUser(ctx context.Context, id string) (User, error) {
+	user, err := scanUser(p.pool.QueryRow(ctx, "SELECT "+userColumns+" FROM auth_users WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	user.Identities, err = p.identities(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (p *PostgresUserStore) identities(ctx context.Context, userID string) ([]LinkedIdentity, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT provider, subject, created_at, last_login_at FROM auth_identities
+		WHERE user_id = $1
+		ORDER BY created_at, provider, subject`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+
+	identities, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (LinkedIdentity, error) {
+		var identity LinkedIdentity
+		err := row.Scan(&identity.Provider, &identity.Subject, &identity.CreatedAt, &identity.LastLoginAt)
+		return identity, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	return identities, nil
+}
+
+func scanUser(row pgx.Row) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Email, &u.EmailVerified, &u.DisplayName, &u.AvatarURL, &u.CreatedAt, &u.UpdatedAt)
+	return u, err
+}
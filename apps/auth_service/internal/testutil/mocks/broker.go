@@ -0,0 +1,83 @@
+package mocks
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/events"
+)
+
+// ErrBrokerDown is returned by FakeBroker while it is failing publishes
+var ErrBrokerDown = errors.New("fake broker unavailable")
+
+// PublishedMessage is a message a FakeBroker confirmed
+type PublishedMessage struct {
+	Exchange   string
+	RoutingKey string
+	Message    events.Message
+}
+
+// FakeBroker is an in-process events.Broker recording confirmed messages
+type FakeBroker struct {
+	mu        sync.Mutex
+	published []PublishedMessage
+	fail      int
+	attempts  int
+	closed    bool
+}
+
+// NewFakeBroker creates a FakeBroker that confirms every publish
+func NewFakeBroker() *FakeBroker {
+	return &FakeBroker{}
+}
+
+// Publish records msg, or fails while FailPublishes is in effect
+func (b *FakeBroker) Publish(ctx context.Context, exchange, routingKey string, msg events.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.attempts++
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if b.closed {
+		return errors.New("fake broker closed")
+	}
+	if b.fail > 0 {
+		b.fail--
+		return ErrBrokerDown
+	}
+
+	b.published = append(b.published, PublishedMessage{Exchange: exchange, RoutingKey: routingKey, Message: msg})
+	return nil
+}
+
+// Close makes further publishes fail
+func (b *FakeBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+// FailPublishes makes the next n publishes fail
+func (b *FakeBroker) FailPublishes(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fail = n
+}
+
+// Published returns the confirmed messages in publish order
+func (b *FakeBroker) Published() []PublishedMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]PublishedMessage(nil), b.published...)
+}
+
+// Attempts returns how many publishes were attempted, including failed ones
+func (b *FakeBroker) Attempts() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.attempts
+}
@@ -2,15 +2,19 @@
 package mocks
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -23,10 +27,88 @@ type MockOIDCServer struct {
 	ClientID    string
 	RedirectURL string
 	Issuer      string
+
+	// ClientSecret, when set, must be presented by clients at the token endpoint
+	ClientSecret string
+	// ClientPublicKey, when set, requires private_key_jwt client assertions
+	// signed by the matching key under ClientKeyID instead of a secret
+	ClientPublicKey crypto.PublicKey
+	ClientKeyID     string
+	// ClientCertSubject, when set, requires a TLS client certificate with this
+	// common name instead of a secret (tls_client_auth)
+	ClientCertSubject string
+	// ExchangeAudiences, when set, are the only audiences token exchange and
+	// client credentials requests may ask for
+	ExchangeAudiences []string
+	// ACR, AMR and AuthTime, when set, are put in issued ID and access tokens
+	ACR      string
+	AMR      []string
+	AuthTime time.Time
+
+	mu              sync.Mutex
+	revoked         []string
+	failRevocations int
+	logouts         []url.Values
+	failDiscovery   int
+	failTokens      int
+	tokenRequests   int
+	usedAssertions  map[string]bool
+	// deviceCodes maps issued device codes to their user code and status
+	deviceCodes map[string]*mockDeviceCode
+	devicesSeen int
+}
+
+// Device code statuses of the mock device authorization grant
+const (
+	deviceCodePending  = "pending"
+	deviceCodeApproved = "approved"
+	deviceCodeDenied   = "denied"
+)
+
+type mockDeviceCode struct {
+	userCode string
+	status   string
 }
 
 // NewMockOIDCServer creates a new mock OIDC server
 func NewMockOIDCServer() (*MockOIDCServer, error) {
+	mock, err := newMockOIDCServer()
+	if err != nil {
+		return nil, err
+	}
+
+	mock.Server.Start()
+	mock.Issuer = mock.Server.URL
+
+	return mock, nil
+}
+
+// NewMockOIDCServerTLS creates a mock OIDC server serving HTTPS with a
+// certificate issued by ca. With requireClientCert, clients must present a
+// certificate issued by ca (mTLS).
+func NewMockOIDCServerTLS(ca *CertificateAuthority, requireClientCert bool) (*MockOIDCServer, error) {
+	mock, err := newMockOIDCServer()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ca.IssueServer()
+	if err != nil {
+		return nil, err
+	}
+	mock.Server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if requireClientCert {
+		mock.Server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		mock.Server.TLS.ClientCAs = ca.Pool()
+	}
+
+	mock.Server.StartTLS()
+	mock.Issuer = mock.Server.URL
+
+	return mock, nil
+}
+
+func newMockOIDCServer() (*MockOIDCServer, error) {
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
 		return nil, err
@@ -52,8 +134,16 @@ func NewMockOIDCServer() (*MockOIDCServer, error) {
 	// Token endpoint
 	mux.HandleFunc("/token", mock.handleToken)
 
-	mock.Server = httptest.NewServer(mux)
-	mock.Issuer = mock.Server.URL
+	// Revocation endpoint (RFC 7009)
+	mux.HandleFunc("/revoke", mock.handleRevoke)
+
+	// End session endpoint (RP-Initiated Logout)
+	mux.HandleFunc("/logout", mock.handleLogout)
+
+	// Device authorization endpoint (RFC 8628)
+	mux.HandleFunc("/device", mock.handleDeviceAuthorization)
+
+	mock.Server = httptest.NewUnstartedServer(mux)
 
 	return mock, nil
 }
@@ -65,12 +155,25 @@ func (m *MockOIDCServer) Close() {
 
 // handleDiscovery returns the OIDC discovery document
 func (m *MockOIDCServer) handleDiscovery(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	fail := m.failDiscovery > 0
+	if fail {
+		m.failDiscovery--
+	}
+	m.mu.Unlock()
+	if fail {
+		http.Error(w, "temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
 	discovery := map[string]interface{}{
 		"issuer":                 m.Issuer,
 		"authorization_endpoint": m.Issuer + "/authorize",
 		"token_endpoint":         m.Issuer + "/token",
 		"jwks_uri":              m.Issuer + "/jwks",
 		"end_session_endpoint":  m.Issuer + "/logout",
+		"revocation_endpoint":   m.Issuer + "/revoke",
+		"device_authorization_endpoint": m.Issuer + "/device",
 		"response_types_supported": []string{"code"},
 		"subject_types_supported":  []string{"public"},
 		"id_token_signing_alg_values_supported": []string{"RS256"},
@@ -119,11 +222,30 @@ func (m *MockOIDCServer) handleAuthorize(w http.ResponseWriter, r *http.Request)
 
 // handleToken simulates the token endpoint
 func (m *MockOIDCServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.tokenRequests++
+	fail := m.failTokens > 0
+	if fail {
+		m.failTokens--
+	}
+	m.mu.Unlock()
+	if fail {
+		http.Error(w, "temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
 
+	if !m.authenticateClient(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
 	grantType := r.Form.Get("grant_type")
 
 	var accessToken, refreshToken, idToken string
@@ -146,6 +268,25 @@ func (m *MockOIDCServer) handleToken(w http.ResponseWriter, r *http.Request) {
 		}
 		accessToken, refreshToken, idToken, err = m.generateTokens("test-user")
 
+	case "urn:ietf:params:oauth:grant-type:token-exchange":
+		m.handleTokenExchange(w, r)
+		return
+
+	case "client_credentials":
+		if !m.allowedAudience(r.Form.Get("audience")) {
+			writeTokenError(w, "invalid_target")
+			return
+		}
+		m.writeAccessToken(w, "service-account-"+m.ClientID, r.Form.Get("audience"), nil)
+		return
+
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		if errorCode := m.redeemDeviceCode(r.Form.Get("device_code")); errorCode != "" {
+			writeTokenError(w, errorCode)
+			return
+		}
+		accessToken, refreshToken, idToken, err = m.generateTokens("test-user")
+
 	default:
 		http.Error(w, "unsupported grant type", http.StatusBadRequest)
 		return
@@ -168,19 +309,347 @@ func (m *MockOIDCServer) handleToken(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+// handleTokenExchange simulates RFC 8693 token exchange: the subject token must
+// be an access token issued by this server, and the new token keeps its subject
+func (m *MockOIDCServer) handleTokenExchange(w http.ResponseWriter, r *http.Request) {
+	if r.Form.Get("subject_token_type") != "urn:ietf:params:oauth:token-type:access_token" {
+		writeTokenError(w, "invalid_request")
+		return
+	}
+
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(r.Form.Get("subject_token"), &claims, func(*jwt.Token) (interface{}, error) {
+		return &m.PrivateKey.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(m.Issuer))
+	if err != nil {
+		writeTokenError(w, "invalid_grant")
+		return
+	}
+	if !m.allowedAudience(r.Form.Get("audience")) {
+		writeTokenError(w, "invalid_target")
+		return
+	}
+
+	// Impersonation: the new token is the requested user's, acted on by the
+	// subject token's user
+	if requested := r.Form.Get("requested_subject"); requested != "" {
+		m.writeAccessToken(w, requested, r.Form.Get("audience"), jwt.MapClaims{"act": map[string]string{"sub": claims.Subject}})
+		return
+	}
+
+	m.writeAccessToken(w, claims.Subject, r.Form.Get("audience"), nil)
+}
+
+func (m *MockOIDCServer) allowedAudience(audience string) bool {
+	if len(m.ExchangeAudiences) == 0 {
+		return true
+	}
+	for _, allowed := range m.ExchangeAudiences {
+		if allowed == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAccessToken answers a token request with an access token only,
+// carrying extra claims on top of the standard ones
+func (m *MockOIDCServer) writeAccessToken(w http.ResponseWriter, subject, audience string, extra jwt.MapClaims) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": m.Issuer,
+		"sub": subject,
+		"azp": m.ClientID,
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"iat": now.Unix(),
+	}
+	if audience != "" {
+		claims["aud"] = audience
+	}
+	for name, value := range extra {
+		claims[name] = value
+	}
+
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(m.PrivateKey)
+	if err != nil {
+		http.Error(w, "failed to generate tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":      accessToken,
+		"issued_token_type": "urn:ietf:params:oauth:token-type:access_token",
+		"token_type":        "Bearer",
+		"expires_in":        300,
+	})
+}
+
+func writeTokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// handleRevoke simulates the revocation endpoint, recording revoked tokens
+func (m *MockOIDCServer) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("token") == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !m.authenticateClient(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failRevocations > 0 {
+		m.failRevocations--
+		http.Error(w, "temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	m.revoked = append(m.revoked, r.Form.Get("token"))
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// FailDiscovery makes the next n discovery requests fail with 503
+func (m *MockOIDCServer) FailDiscovery(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failDiscovery = n
+}
+
+// FailTokens makes the next n token endpoint requests fail with 503
+func (m *MockOIDCServer) FailTokens(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failTokens = n
+}
+
+// TokenRequests returns how many requests reached the token endpoint
+func (m *MockOIDCServer) TokenRequests() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tokenRequests
+}
+
+// FailRevocations makes the next n revocation requests fail with 503
+func (m *MockOIDCServer) FailRevocations(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failRevocations = n
+}
+
+// RevokedTokens returns the tokens revoked so far, in order
+func (m *MockOIDCServer) RevokedTokens() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.revoked...)
+}
+
+// handleLogout simulates the end session endpoint: it records the request and
+// redirects to post_logout_redirect_uri, echoing state
+func (m *MockOIDCServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != m.ClientID {
+		http.Error(w, "invalid client_id", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	m.logouts = append(m.logouts, query)
+	m.mu.Unlock()
+
+	redirect := query.Get("post_logout_redirect_uri")
+	if redirect == "" {
+		_, _ = w.Write([]byte("logged out"))
+		return
+	}
+
+	target, err := url.Parse(redirect)
+	if err != nil {
+		http.Error(w, "invalid post_logout_redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if state := query.Get("state"); state != "" {
+		params := target.Query()
+		params.Set("state", state)
+		target.RawQuery = params.Encode()
+	}
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// handleDeviceAuthorization simulates the device authorization endpoint,
+// issuing a pending device code
+func (m *MockOIDCServer) handleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !m.authenticateClient(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	m.mu.Lock()
+	if m.deviceCodes == nil {
+		m.deviceCodes = make(map[string]*mockDeviceCode)
+	}
+	m.devicesSeen++
+	deviceCode := fmt.Sprintf("mock-device-code-%d", m.devicesSeen)
+	userCode := fmt.Sprintf("USER-%04d", m.devicesSeen)
+	m.deviceCodes[deviceCode] = &mockDeviceCode{userCode: userCode, status: deviceCodePending}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          m.Issuer + "/device/verify",
+		"verification_uri_complete": m.Issuer + "/device/verify?user_code=" + userCode,
+		"expires_in":                600,
+		"interval":                  5,
+	})
+}
+
+// redeemDeviceCode returns the RFC 8628 error code for polling deviceCode, or
+// "" when it was approved; an approved code can be redeemed once
+func (m *MockOIDCServer) redeemDeviceCode(deviceCode string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	code, ok := m.deviceCodes[deviceCode]
+	switch {
+	case !ok:
+		return "invalid_grant"
+	case code.status == deviceCodeDenied:
+		return "access_denied"
+	case code.status != deviceCodeApproved:
+		return "authorization_pending"
+	}
+	delete(m.deviceCodes, deviceCode)
+	return ""
+}
+
+// ApproveDevice simulates the user approving the device with userCode
+func (m *MockOIDCServer) ApproveDevice(userCode string) bool {
+	return m.setDeviceStatus(userCode, deviceCodeApproved)
+}
+
+// DenyDevice simulates the user declining the device with userCode
+func (m *MockOIDCServer) DenyDevice(userCode string) bool {
+	return m.setDeviceStatus(userCode, deviceCodeDenied)
+}
+
+func (m *MockOIDCServer) setDeviceStatus(userCode, status string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, code := range m.deviceCodes {
+		if code.userCode == userCode {
+			code.status = status
+			return true
+		}
+	}
+	return false
+}
+
+// Logouts returns the query parameters of the logout requests received so far
+func (m *MockOIDCServer) Logouts() []url.Values {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]url.Values(nil), m.logouts...)
+}
+
+// authenticateClient checks the client credentials the server is configured for
+func (m *MockOIDCServer) authenticateClient(r *http.Request) bool {
+	switch {
+	case m.ClientPublicKey != nil:
+		return m.validClientAssertion(r)
+	case m.ClientCertSubject != "":
+		return r.Form.Get("client_id") == m.ClientID && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 &&
+			r.TLS.PeerCertificates[0].Subject.CommonName == m.ClientCertSubject
+	case m.ClientSecret != "":
+		return m.validClientSecret(r)
+	}
+	return true
+}
+
+// validClientAssertion verifies a private_key_jwt assertion (RFC 7523): the
+// signature and kid, iss and sub equal to the client ID, the token endpoint as
+// audience, an expiry, and a jti that was not seen before
+func (m *MockOIDCServer) validClientAssertion(r *http.Request) bool {
+	if r.Form.Get("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" ||
+		r.Form.Get("client_secret") != "" {
+		return false
+	}
+	if clientID := r.Form.Get("client_id"); clientID != "" && clientID != m.ClientID {
+		return false
+	}
+
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(r.Form.Get("client_assertion"), &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != m.ClientKeyID {
+			return nil, fmt.Errorf("unknown key %v", token.Header["kid"])
+		}
+		return m.ClientPublicKey, nil
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "ES384"}),
+		jwt.WithIssuer(m.ClientID),
+		jwt.WithSubject(m.ClientID),
+		jwt.WithAudience(m.Issuer+"/token"),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.ID == "" {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.usedAssertions[claims.ID] {
+		return false
+	}
+	if m.usedAssertions == nil {
+		m.usedAssertions = make(map[string]bool)
+	}
+	m.usedAssertions[claims.ID] = true
+	return true
+}
+
+// validClientSecret checks the client secret sent via HTTP Basic auth or form parameters
+func (m *MockOIDCServer) validClientSecret(r *http.Request) bool {
+	if _, secret, ok := r.BasicAuth(); ok {
+		return secret == m.ClientSecret
+	}
+	return r.Form.Get("client_secret") == m.ClientSecret
+}
+
 // generateTokens generates mock JWT tokens
 func (m *MockOIDCServer) generateTokens(subject string) (string, string, string, error) {
 	now := time.Now()
 
 	// Generate ID token
 	idTokenClaims := jwt.MapClaims{
-		"iss": m.Issuer,
-		"sub": subject,
-		"aud": m.ClientID,
-		"exp": now.Add(time.Hour).Unix(),
-		"iat": now.Unix(),
+		"iss":            m.Issuer,
+		"sub":            subject,
+		"aud":            m.ClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"email":          subject + "@example.com",
+		"email_verified": true,
+		"name":           "Test User",
 	}
 
+	m.addAuthContext(idTokenClaims)
+
 	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, idTokenClaims)
 	idToken.Header["kid"] = "test-key-id"
 
@@ -198,6 +667,8 @@ func (m *MockOIDCServer) generateTokens(subject string) (string, string, string,
 		"iat": now.Unix(),
 	}
 
+	m.addAuthContext(accessTokenClaims)
+
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodRS256, accessTokenClaims)
 	accessTokenString, err := accessToken.SignedString(m.PrivateKey)
 	if err != nil {
@@ -209,3 +680,16 @@ func (m *MockOIDCServer) generateTokens(subject string) (string, string, string,
 
 	return accessTokenString, refreshToken, idTokenString, nil
 }
+
+// addAuthContext adds the configured acr, amr and auth_time claims
+func (m *MockOIDCServer) addAuthContext(claims jwt.MapClaims) {
+	if m.ACR != "" {
+		claims["acr"] = m.ACR
+	}
+	if len(m.AMR) > 0 {
+		claims["amr"] = m.AMR
+	}
+	if !m.AuthTime.IsZero() {
+		claims["auth_time"] = m.AuthTime.Unix()
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/stretchr/testify/mock"
+
+	"github.com/carlosealves2/short-stream/authservice/internal/storage"
 )
 
 // MockStore is a mock implementation of Store interface
@@ -12,33 +14,36 @@ type MockStore struct {
 }
 
 // CreateState mocks the CreateState method
-func (m *MockStore) CreateState(ctx context.Context) (string, error) {
-	args := m.Called(ctx)
+func (m *MockStore) CreateState(ctx context.Context, data storage.StateData) (string, error) {
+	args := m.Called(ctx, data)
 	return args.String(0), args.Error(1)
 }
 
 // ValidateState mocks the ValidateState method
-func (m *MockStore) ValidateState(ctx context.Context, state string) error {
+func (m *MockStore) ValidateState(ctx context.Context, state string) (storage.StateData, error) {
 	args := m.Called(ctx, state)
-	return args.Error(0)
+	data, _ := args.Get(0).(storage.StateData)
+	return data, args.Error(1)
 }
 
 // CreateSession mocks the CreateSession method
-func (m *MockStore) CreateSession(ctx context.Context, refreshToken string) (string, error) {
-	args := m.Called(ctx, refreshToken)
+func (m *MockStore) CreateSession(ctx context.Context, session storage.NewSession) (string, error) {
+	args := m.Called(ctx, session)
 	return args.String(0), args.Error(1)
 }
 
-// GetRefreshToken mocks the GetRefreshToken method
-func (m *MockStore) GetRefreshToken(ctx context.Context, sessionID string) (string, error) {
+// GetSession mocks the GetSession method
+func (m *MockStore) GetSession(ctx context.Context, sessionID string) (storage.Session, error) {
 	args := m.Called(ctx, sessionID)
-	return args.String(0), args.Error(1)
+	session, _ := args.Get(0).(storage.Session)
+	return session, args.Error(1)
 }
 
 // UpdateSession mocks the UpdateSession method
-func (m *MockStore) UpdateSession(ctx context.Context, sessionID, refreshToken string) error {
+func (m *MockStore) UpdateSession(ctx context.Context, sessionID, refreshToken string) (storage.Session, error) {
 	args := m.Called(ctx, sessionID, refreshToken)
-	return args.Error(0)
+	session, _ := args.Get(0).(storage.Session)
+	return session, args.Error(1)
 }
 
 // DeleteSession mocks the DeleteSession method
@@ -46,3 +51,49 @@ func (m *MockStore) DeleteSession(ctx context.Context, sessionID string) error {
 	args := m.Called(ctx, sessionID)
 	return args.Error(0)
 }
+
+// ListUserSessions mocks the ListUserSessions method
+func (m *MockStore) ListUserSessions(ctx context.Context, userID string) ([]storage.Session, error) {
+	args := m.Called(ctx, userID)
+	sessions, _ := args.Get(0).([]storage.Session)
+	return sessions, args.Error(1)
+}
+
+// DeleteUserSessions mocks the DeleteUserSessions method
+func (m *MockStore) DeleteUserSessions(ctx context.Context, userID string) ([]storage.Session, error) {
+	args := m.Called(ctx, userID)
+	sessions, _ := args.Get(0).([]storage.Session)
+	return sessions, args.Error(1)
+}
+
+// CountSessions mocks the CountSessions method
+func (m *MockStore) CountSessions(ctx context.Context) (storage.SessionCounts, error) {
+	args := m.Called(ctx)
+	counts, _ := args.Get(0).(storage.SessionCounts)
+	return counts, args.Error(1)
+}
+
+// CreateDeviceGrant mocks the CreateDeviceGrant method
+func (m *MockStore) CreateDeviceGrant(ctx context.Context, grant storage.DeviceGrant) (string, error) {
+	args := m.Called(ctx, grant)
+	return args.String(0), args.Error(1)
+}
+
+// GetDeviceGrant mocks the GetDeviceGrant method
+func (m *MockStore) GetDeviceGrant(ctx context.Context, id string) (storage.DeviceGrant, error) {
+	args := m.Called(ctx, id)
+	grant, _ := args.Get(0).(storage.DeviceGrant)
+	return grant, args.Error(1)
+}
+
+// UpdateDeviceGrant mocks the UpdateDeviceGrant method
+func (m *MockStore) UpdateDeviceGrant(ctx context.Context, id string, grant storage.DeviceGrant) error {
+	args := m.Called(ctx, id, grant)
+	return args.Error(0)
+}
+
+// DeleteDeviceGrant mocks the DeleteDeviceGrant method
+func (m *MockStore) DeleteDeviceGrant(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
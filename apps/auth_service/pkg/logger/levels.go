@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/phuslu/log"
+)
+
+// Levels holds the default log level and per-component overrides.
+// It is safe for concurrent use, so levels can be changed at runtime.
+type Levels struct {
+	mu           sync.RWMutex
+	defaultLevel log.Level
+	components   map[string]log.Level
+}
+
+// NewLevels creates a level registry with the given default level
+func NewLevels(defaultLevel log.Level) *Levels {
+	return &Levels{
+		defaultLevel: defaultLevel,
+		components:   make(map[string]log.Level),
+	}
+}
+
+// Default returns the level used by components without an override
+func (l *Levels) Default() log.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.defaultLevel
+}
+
+// SetDefault changes the level used by components without an override
+func (l *Levels) SetDefault(level log.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.defaultLevel = level
+}
+
+// SetComponent overrides the level for a single component
+func (l *Levels) SetComponent(component string, level log.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.components[component] = level
+}
+
+// ResetComponent removes the override for a component
+func (l *Levels) ResetComponent(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.components, component)
+}
+
+// Level returns the effective level for a component
+func (l *Levels) Level(component string) log.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if level, ok := l.components[component]; ok {
+		return level
+	}
+	return l.defaultLevel
+}
+
+// Components returns a copy of the per-component overrides
+func (l *Levels) Components() map[string]log.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	components := make(map[string]log.Level, len(l.components))
+	for name, level := range l.components {
+		components[name] = level
+	}
+	return components
+}
+
+// Enabled reports whether an entry of the given level should be written for a component
+func (l *Levels) Enabled(component string, level log.Level) bool {
+	return level >= l.Level(component)
+}
+
+// ParseLevel converts a level name (trace, debug, info, warn, error, fatal) into a log.Level
+func ParseLevel(s string) (log.Level, error) {
+	level := log.ParseLevel(strings.ToLower(strings.TrimSpace(s)))
+	if level < log.TraceLevel || level > log.FatalLevel {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// ParseComponentLevels parses overrides in the form "oidc=debug,storage=warn"
+func ParseComponentLevels(s string) (map[string]log.Level, error) {
+	components := make(map[string]log.Level)
+	if strings.TrimSpace(s) == "" {
+		return components, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid component level %q, expected component=level", pair)
+		}
+
+		level, err := ParseLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("component %q: %w", name, err)
+		}
+		components[name] = level
+	}
+
+	return components, nil
+}
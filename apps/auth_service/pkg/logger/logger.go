@@ -8,6 +8,16 @@ import (
 	"github.com/phuslu/log"
 )
 
+// Format selects how log entries are rendered
+type Format string
+
+// Supported log formats
+const (
+	FormatAuto    Format = "auto"
+	FormatJSON    Format = "json"
+	FormatConsole Format = "console"
+)
+
 // Logger is the interface for structured logging operations
 type Logger interface {
 	Info() *log.Entry
@@ -15,19 +25,32 @@ type Logger interface {
 	Warn() *log.Entry
 	Debug() *log.Entry
 	Fatal() *log.Entry
+
+	// Component returns a logger tagged with the component name whose level
+	// can be overridden independently of the default level
+	Component(name string) Logger
+	// Levels returns the level registry shared by this logger and its components
+	Levels() *Levels
 }
 
 type logger struct {
 	log.Logger
+	levels    *Levels
+	component string
 }
 
 // NewGlobal creates a global logger with automatic terminal detection
 // If running in a terminal, uses pretty colored output
 // Otherwise, uses JSON format
 func NewGlobal(level log.Level) Logger {
-	var logWriter log.Writer
+	return NewGlobalWithLevels(NewLevels(level), FormatAuto)
+}
 
-	if log.IsTerminal(os.Stderr.Fd()) {
+// NewGlobalWithLevels creates a global logger backed by a shared level registry
+// and rendered in the given format
+func NewGlobalWithLevels(levels *Levels, format Format) Logger {
+	var logWriter log.Writer
+	if format == FormatConsole || (format != FormatJSON && log.IsTerminal(os.Stderr.Fd())) {
 		logWriter = &log.ConsoleWriter{
 			ColorOutput:    true,
 			QuoteString:    true,
@@ -39,11 +62,12 @@ func NewGlobal(level log.Level) Logger {
 
 	return &logger{
 		Logger: log.Logger{
-			Level:      level,
+			Level:      log.TraceLevel,
 			TimeFormat: "15:04:05",
 			Caller:     1,
-			Writer:     logWriter,
+			Writer:     &RedactingWriter{Writer: logWriter, Redactor: DefaultRedactor},
 		},
+		levels: levels,
 	}
 }
 
@@ -51,28 +75,55 @@ func NewGlobal(level log.Level) Logger {
 func New(writer io.Writer, level log.Level) Logger {
 	return &logger{
 		Logger: log.Logger{
-			Level:  level,
-			Writer: &log.ConsoleWriter{Writer: writer},
+			Level:  log.TraceLevel,
+			Writer: &RedactingWriter{Writer: &log.ConsoleWriter{Writer: writer}, Redactor: DefaultRedactor},
 		},
+		levels: NewLevels(level),
 	}
 }
 
 func (l *logger) Info() *log.Entry {
+	if !l.levels.Enabled(l.component, log.InfoLevel) {
+		return nil
+	}
 	return l.Logger.Info()
 }
 
 func (l *logger) Error() *log.Entry {
+	if !l.levels.Enabled(l.component, log.ErrorLevel) {
+		return nil
+	}
 	return l.Logger.Error()
 }
 
 func (l *logger) Warn() *log.Entry {
+	if !l.levels.Enabled(l.component, log.WarnLevel) {
+		return nil
+	}
 	return l.Logger.Warn()
 }
 
 func (l *logger) Debug() *log.Entry {
+	if !l.levels.Enabled(l.component, log.DebugLevel) {
+		return nil
+	}
 	return l.Logger.Debug()
 }
 
 func (l *logger) Fatal() *log.Entry {
 	return l.Logger.Fatal()
 }
+
+func (l *logger) Component(name string) Logger {
+	child := &logger{
+		Logger:    l.Logger,
+		levels:    l.levels,
+		component: name,
+	}
+	child.Context = log.NewContext(nil).Str("component", name).Value()
+	return child
+}
+
+func (l *logger) Levels() *Levels {
+	return l.levels
+}
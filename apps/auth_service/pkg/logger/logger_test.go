@@ -133,3 +133,67 @@ func TestLoggerInterface(_ *testing.T) {
 	var _ = New(buf, log.InfoLevel)
 	// Se compilar, o teste passa - verifica que implementa a interface
 }
+
+func TestLoggerComponent(t *testing.T) {
+	buf := &bytes.Buffer{}
+	root := New(buf, log.InfoLevel)
+	oidcLogger := root.Component("oidc")
+
+	t.Run("TagsEntries", func(t *testing.T) {
+		buf.Reset()
+		oidcLogger.Info().Msg("component message")
+
+		assert.Contains(t, buf.String(), "component")
+		assert.Contains(t, buf.String(), "oidc")
+	})
+
+	t.Run("OverrideOnlyAffectsComponent", func(t *testing.T) {
+		root.Levels().SetComponent("oidc", log.DebugLevel)
+		defer root.Levels().ResetComponent("oidc")
+
+		buf.Reset()
+		root.Debug().Msg("root debug")
+		assert.Equal(t, 0, buf.Len())
+
+		oidcLogger.Debug().Msg("oidc debug")
+		assert.Contains(t, buf.String(), "oidc debug")
+	})
+
+	t.Run("RuntimeDefaultChange", func(t *testing.T) {
+		root.Levels().SetDefault(log.ErrorLevel)
+		defer root.Levels().SetDefault(log.InfoLevel)
+
+		buf.Reset()
+		oidcLogger.Info().Msg("suppressed")
+		root.Warn().Msg("suppressed")
+		assert.Equal(t, 0, buf.Len())
+	})
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("DEBUG")
+	require.NoError(t, err)
+	assert.Equal(t, log.DebugLevel, level)
+
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err)
+
+	_, err = ParseLevel("")
+	assert.Error(t, err)
+}
+
+func TestParseComponentLevels(t *testing.T) {
+	components, err := ParseComponentLevels("oidc=debug, http = warn")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]log.Level{"oidc": log.DebugLevel, "http": log.WarnLevel}, components)
+
+	components, err = ParseComponentLevels("")
+	require.NoError(t, err)
+	assert.Empty(t, components)
+
+	_, err = ParseComponentLevels("oidc")
+	assert.Error(t, err)
+
+	_, err = ParseComponentLevels("oidc=loud")
+	assert.Error(t, err)
+}
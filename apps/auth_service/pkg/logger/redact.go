@@ -0,0 +1,160 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/phuslu/log"
+)
+
+// Action defines how a sensitive value is rewritten before it is written
+type Action int
+
+const (
+	// Mask replaces the value with a fixed placeholder
+	Mask Action = iota
+	// Hash replaces the value with a short SHA-256 digest so entries can still be correlated
+	Hash
+)
+
+// redactedPlaceholder replaces masked values
+const redactedPlaceholder = "[REDACTED]"
+
+var (
+	// stringFieldPattern matches a JSON string field and captures its key and raw value
+	stringFieldPattern = regexp.MustCompile(`"([^"\\]+)":"((?:[^"\\]|\\.)*)"`)
+
+	// jwtPattern matches JWT/JWS compact serializations
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+	// queryParamPattern matches sensitive query parameters inside logged URLs
+	queryParamPattern = regexp.MustCompile(`(?i)(?:[?&]|\\u0026)(?:code|state|id_token_hint|access_token|refresh_token|id_token|client_secret|session_state)=([^&"\s\\]+)`)
+
+	// emailPattern matches e-mail addresses anywhere in a value
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+type redactPattern struct {
+	re     *regexp.Regexp
+	action Action
+}
+
+// Redactor rewrites sensitive values in serialized log entries.
+// Values are matched by field name and by registered patterns.
+type Redactor struct {
+	mu       sync.RWMutex
+	keys     map[string]Action
+	patterns []redactPattern
+}
+
+// NewRedactor creates a Redactor preloaded with the fields and patterns the
+// auth service handles: tokens, session IDs, authorization codes, secrets and e-mails
+func NewRedactor() *Redactor {
+	r := &Redactor{keys: make(map[string]Action)}
+
+	for _, key := range []string{
+		"token", "access_token", "refresh_token", "id_token", "id_token_hint",
+		"code", "client_secret", "secret", "password", "authorization", "cookie",
+	} {
+		r.RegisterKey(key, Mask)
+	}
+	for _, key := range []string{"session_id", "email"} {
+		r.RegisterKey(key, Hash)
+	}
+
+	r.RegisterPattern(jwtPattern, Mask)
+	r.RegisterPattern(queryParamPattern, Mask)
+	r.RegisterPattern(emailPattern, Hash)
+
+	return r
+}
+
+// DefaultRedactor is applied by every logger created in this package
+var DefaultRedactor = NewRedactor()
+
+// RegisterKey marks a field name (case-insensitive) as sensitive
+func (r *Redactor) RegisterKey(key string, action Action) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[strings.ToLower(key)] = action
+}
+
+// RegisterPattern marks every match of re as sensitive. When the pattern has a
+// capture group, only the first group is rewritten and the rest of the match is kept.
+func (r *Redactor) RegisterPattern(re *regexp.Regexp, action Action) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.patterns = append(r.patterns, redactPattern{re: re, action: action})
+}
+
+// Redact returns buf with all sensitive values rewritten
+func (r *Redactor) Redact(buf []byte) []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.keys) > 0 {
+		buf = stringFieldPattern.ReplaceAllFunc(buf, func(field []byte) []byte {
+			m := stringFieldPattern.FindSubmatch(field)
+			action, ok := r.keys[strings.ToLower(string(m[1]))]
+			if !ok || len(m[2]) == 0 {
+				return field
+			}
+			out := make([]byte, 0, len(field))
+			out = append(out, '"')
+			out = append(out, m[1]...)
+			out = append(out, `":"`...)
+			out = append(out, apply(action, m[2])...)
+			return append(out, '"')
+		})
+	}
+
+	for _, p := range r.patterns {
+		buf = replacePattern(buf, p)
+	}
+
+	return buf
+}
+
+func replacePattern(buf []byte, p redactPattern) []byte {
+	matches := p.re.FindAllSubmatchIndex(buf, -1)
+	if len(matches) == 0 {
+		return buf
+	}
+
+	out := make([]byte, 0, len(buf))
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		out = append(out, buf[last:start]...)
+		out = append(out, apply(p.action, buf[start:end])...)
+		last = end
+	}
+	return append(out, buf[last:]...)
+}
+
+func apply(action Action, value []byte) []byte {
+	if action == Hash {
+		sum := sha256.Sum256(value)
+		return []byte("sha256:" + hex.EncodeToString(sum[:6]))
+	}
+	return []byte(redactedPlaceholder)
+}
+
+// RedactingWriter is a log.Writer that redacts entries before handing them to Writer
+type RedactingWriter struct {
+	Writer   log.Writer
+	Redactor *Redactor
+}
+
+// WriteEntry implements log.Writer
+func (w *RedactingWriter) WriteEntry(e *log.Entry) (int, error) {
+	redacted := log.NewContext(w.Redactor.Redact(e.Value()))
+	redacted.Level = e.Level
+	return w.Writer.WriteEntry(redacted)
+}
@@ -0,0 +1,210 @@
+// Package authclient is the client backend services use to get tokens from
+// auth_service's internal token API: tokens on behalf of a user (token
+// exchange) and tokens for the service itself (client credentials). Tokens
+// are cached and requested again shortly before they expire.
+package authclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	exchangePath     = "/internal/token/exchange"
+	serviceTokenPath = "/internal/token/service"
+
+	// defaultLeeway is how long before expiry a cached token is replaced
+	defaultLeeway = 30 * time.Second
+	// maxResponseSize bounds the responses read from auth_service
+	maxResponseSize = 1 << 20
+)
+
+// Error is a request auth_service refused
+type Error struct {
+	StatusCode int
+	// Code is the error auth_service answered with, such as invalid_target
+	Code string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("auth service answered %d: %s", e.StatusCode, e.Code)
+}
+
+// Client gets tokens from auth_service and caches them until shortly before
+// they expire. It is safe for concurrent use; concurrent callers asking for
+// the same token share a single request.
+type Client struct {
+	baseURL    string
+	apiToken   string
+	httpClient *http.Client
+	leeway     time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+}
+
+type cacheKey struct {
+	audience string
+	// subject is a hash of the user's token, empty for service tokens
+	subject [sha256.Size]byte
+}
+
+type cacheEntry struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to call auth_service
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithLeeway sets how long before expiry a cached token is replaced
+func WithLeeway(leeway time.Duration) Option {
+	return func(c *Client) {
+		c.leeway = leeway
+	}
+}
+
+// New creates a client of the auth_service at baseURL, authenticating with
+// apiToken, the calling service's own token (auth_service's
+// INTERNAL_SERVICE_<ID>_TOKEN)
+func New(baseURL, apiToken string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiToken:   apiToken,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		leeway:     defaultLeeway,
+		now:        time.Now,
+		entries:    make(map[cacheKey]*cacheEntry),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ServiceToken returns an access token for audience that identifies the
+// calling service itself
+func (c *Client) ServiceToken(ctx context.Context, audience string) (string, error) {
+	return c.token(ctx, cacheKey{audience: audience}, serviceTokenPath, map[string]string{
+		"audience": audience,
+	})
+}
+
+// ExchangeToken trades the user's access token for one meant for audience,
+// to call that service on the user's behalf
+func (c *Client) ExchangeToken(ctx context.Context, subjectToken, audience string) (string, error) {
+	if subjectToken == "" {
+		return "", errors.New("subject token is required")
+	}
+	key := cacheKey{audience: audience, subject: sha256.Sum256([]byte(subjectToken))}
+	return c.token(ctx, key, exchangePath, map[string]string{
+		"subject_token": subjectToken,
+		"audience":      audience,
+	})
+}
+
+// token returns the cached token for key, requesting path when none is
+// cached or the cached one is about to expire
+func (c *Client) token(ctx context.Context, key cacheKey, path string, body map[string]string) (string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		c.sweep()
+		entry = &cacheEntry{}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.token != "" && c.now().Add(c.leeway).Before(entry.expiresAt) {
+		return entry.token, nil
+	}
+
+	token, expiresIn, err := c.request(ctx, path, body)
+	if err != nil {
+		return "", err
+	}
+	entry.token = token
+	entry.expiresAt = c.now().Add(expiresIn)
+	return token, nil
+}
+
+// sweep drops expired and failed entries, so tokens exchanged for users who
+// went away do not pile up. Callers hold c.mu.
+func (c *Client) sweep() {
+	now := c.now()
+	for key, entry := range c.entries {
+		if !entry.mu.TryLock() {
+			continue
+		}
+		if entry.token == "" || !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+		entry.mu.Unlock()
+	}
+}
+
+func (c *Client) request(ctx context.Context, path string, body map[string]string) (string, time.Duration, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to encode token request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.apiToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to request token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(respBody, &failure)
+		return "", 0, &Error{StatusCode: resp.StatusCode, Code: failure.Error}
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(respBody, &token); err != nil {
+		return "", 0, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, errors.New("no access_token in token response")
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
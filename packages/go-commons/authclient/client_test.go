@@ -0,0 +1,194 @@
+package authclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAuthService answers the internal token API with numbered tokens
+type fakeAuthService struct {
+	*httptest.Server
+	requests atomic.Int32
+}
+
+func newFakeAuthService(t *testing.T) *fakeAuthService {
+	t.Helper()
+	f := &fakeAuthService{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer internal-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"unauthorized"}`))
+			return
+		}
+
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if body["audience"] == "billing" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_target"}`))
+			return
+		}
+
+		n := f.requests.Add(1)
+		token := r.URL.Path + ":" + body["audience"] + ":" + body["subject_token"] + ":" + string(rune('0'+n))
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": token, "token_type": "Bearer", "expires_in": 300})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestClient_ServiceToken_Cached(t *testing.T) {
+	service := newFakeAuthService(t)
+	client := New(service.URL+"/", "internal-secret")
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	first, err := client.ServiceToken(ctx, "video-metadata")
+	if err != nil {
+		t.Fatalf("ServiceToken: %v", err)
+	}
+	second, err := client.ServiceToken(ctx, "video-metadata")
+	if err != nil {
+		t.Fatalf("ServiceToken: %v", err)
+	}
+	if first != second || service.requests.Load() != 1 {
+		t.Fatalf("expected the cached token, got %q then %q after %d requests", first, second, service.requests.Load())
+	}
+
+	// Within the leeway of its expiry the token is replaced
+	now = now.Add(300*time.Second - defaultLeeway)
+	third, err := client.ServiceToken(ctx, "video-metadata")
+	if err != nil {
+		t.Fatalf("ServiceToken: %v", err)
+	}
+	if third == first || service.requests.Load() != 2 {
+		t.Fatalf("expected a new token, got %q after %d requests", third, service.requests.Load())
+	}
+}
+
+func TestClient_ExchangeToken_CachedPerSubject(t *testing.T) {
+	service := newFakeAuthService(t)
+	client := New(service.URL, "internal-secret")
+	ctx := context.Background()
+
+	alice, err := client.ExchangeToken(ctx, "alice-token", "video-metadata")
+	if err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+	bob, err := client.ExchangeToken(ctx, "bob-token", "video-metadata")
+	if err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+	if alice == bob {
+		t.Fatal("tokens of different users must not be shared")
+	}
+
+	again, err := client.ExchangeToken(ctx, "alice-token", "video-metadata")
+	if err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+	if again != alice || service.requests.Load() != 2 {
+		t.Fatalf("expected the cached token, got %q after %d requests", again, service.requests.Load())
+	}
+
+	if _, err := client.ExchangeToken(ctx, "", "video-metadata"); err == nil {
+		t.Fatal("expected an error without subject token")
+	}
+}
+
+func TestClient_SweepsExpiredEntries(t *testing.T) {
+	service := newFakeAuthService(t)
+	client := New(service.URL, "internal-secret")
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := client.ExchangeToken(ctx, "alice-token", "video-metadata"); err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := client.ExchangeToken(ctx, "bob-token", "video-metadata"); err != nil {
+		t.Fatalf("ExchangeToken: %v", err)
+	}
+	if len(client.entries) != 1 {
+		t.Fatalf("expected the expired entry to be dropped, have %d entries", len(client.entries))
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	service := newFakeAuthService(t)
+	ctx := context.Background()
+
+	_, err := New(service.URL, "internal-secret").ServiceToken(ctx, "billing")
+	var authErr *Error
+	if !errors.As(err, &authErr) || authErr.StatusCode != http.StatusBadRequest || authErr.Code != "invalid_target" {
+		t.Fatalf("expected invalid_target, got %v", err)
+	}
+
+	_, err = New(service.URL, "wrong").ServiceToken(ctx, "video-metadata")
+	if !errors.As(err, &authErr) || authErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized, got %v", err)
+	}
+}
+
+func TestClient_ConcurrentCallersShareRequest(t *testing.T) {
+	service := newFakeAuthService(t)
+	client := New(service.URL, "internal-secret")
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.ServiceToken(context.Background(), "video-metadata"); err != nil {
+				t.Errorf("ServiceToken: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := service.requests.Load(); n != 1 {
+		t.Fatalf("expected a single request, got %d", n)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	service := newFakeAuthService(t)
+	client := New(service.URL, "internal-secret")
+
+	var authorization string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer backend.Close()
+
+	httpClient := &http.Client{Transport: &Transport{Client: client, Audience: "video-metadata"}}
+	resp, err := httpClient.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	want, _ := client.ServiceToken(context.Background(), "video-metadata")
+	if authorization != "Bearer "+want {
+		t.Fatalf("expected the service token, got %q", authorization)
+	}
+
+	httpClient.Transport = &Transport{Client: client, Audience: "billing"}
+	if _, err := httpClient.Get(backend.URL); err == nil {
+		t.Fatal("expected an error when no token can be had")
+	}
+}
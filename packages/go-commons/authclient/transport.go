@@ -0,0 +1,36 @@
+package authclient
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper that authenticates every request with a
+// service token for Audience
+type Transport struct {
+	Client   *Client
+	Audience string
+	// Base is the transport that sends the requests; http.DefaultTransport
+	// when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip sets the Authorization header of a copy of req and sends it
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.Client.ServiceToken(req.Context(), t.Audience)
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, fmt.Errorf("failed to get service token: %w", err)
+	}
+
+	authenticated := req.Clone(req.Context())
+	authenticated.Header.Set("Authorization", "Bearer "+token)
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(authenticated)
+}
@@ -0,0 +1,225 @@
+// Package stepup enforces how recently and how strongly a user authenticated
+// before sensitive actions. RequireAuthLevel checks the acr, amr and auth_time
+// claims of the request and, when they fall short, answers with a step-up
+// challenge (RFC 9470) the frontend follows by sending the user back through
+// auth_service's /auth/login with the acr_values and max_age it names.
+package stepup
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorInsufficientAuthentication is the error code of the challenge
+const ErrorInsufficientAuthentication = "insufficient_user_authentication"
+
+// authTimeLeeway absorbs clock skew between services and the provider
+const authTimeLeeway = 30 * time.Second
+
+// Claims is how the user authenticated
+type Claims struct {
+	ACR      string
+	AMR      []string
+	AuthTime time.Time
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying claims, for the middleware that
+// verifies the request's token to hand them to RequireAuthLevel
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims stored by NewContext
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(Claims)
+	return claims, ok
+}
+
+// ParseJWT reads the claims from the payload of a JWT. It does not check the
+// signature: use it only on tokens already verified, e.g. by a gateway.
+func ParseJWT(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, errors.New("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, fmt.Errorf("failed to decode token payload: %w", err)
+	}
+
+	var raw struct {
+		ACR      string   `json:"acr"`
+		AMR      []string `json:"amr"`
+		AuthTime float64  `json:"auth_time"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return Claims{}, fmt.Errorf("failed to decode token claims: %w", err)
+	}
+
+	claims := Claims{ACR: raw.ACR, AMR: raw.AMR}
+	if raw.AuthTime > 0 {
+		claims.AuthTime = time.Unix(int64(raw.AuthTime), 0)
+	}
+	return claims, nil
+}
+
+// Option configures RequireAuthLevel
+type Option func(*requirement)
+
+// WithLevels orders acr values from weakest to strongest, so a stronger acr
+// satisfies a weaker requirement. Without levels the acr must match exactly.
+func WithLevels(levels ...string) Option {
+	return func(r *requirement) {
+		r.levels = levels
+	}
+}
+
+// WithAMR also requires every one of methods in the amr claim
+func WithAMR(methods ...string) Option {
+	return func(r *requirement) {
+		r.amr = methods
+	}
+}
+
+// WithLoginURL sets auth_service's login URL, e.g.
+// https://auth.example.com/auth/login. The challenge then includes the URL
+// that steps up, with acr_values and max_age set.
+func WithLoginURL(loginURL string) Option {
+	return func(r *requirement) {
+		r.loginURL = loginURL
+	}
+}
+
+// WithClaimsFunc sets how the claims are read from the request; FromContext
+// by default
+func WithClaimsFunc(claims func(*http.Request) (Claims, bool)) Option {
+	return func(r *requirement) {
+		r.claims = claims
+	}
+}
+
+// WithClock sets the clock auth_time is checked against (time.Now by default)
+func WithClock(now func() time.Time) Option {
+	return func(r *requirement) {
+		r.now = now
+	}
+}
+
+type requirement struct {
+	acr      string
+	maxAge   time.Duration
+	levels   []string
+	amr      []string
+	loginURL string
+	claims   func(*http.Request) (Claims, bool)
+	now      func() time.Time
+}
+
+// RequireAuthLevel returns a middleware that lets a request through only when
+// the user authenticated with acr (empty for any) within maxAge (zero for no
+// limit). Other requests get a 401 step-up challenge.
+func RequireAuthLevel(acr string, maxAge time.Duration, opts ...Option) func(http.Handler) http.Handler {
+	r := &requirement{
+		acr:    acr,
+		maxAge: maxAge,
+		claims: func(req *http.Request) (Claims, bool) { return FromContext(req.Context()) },
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			claims, ok := r.claims(req)
+			if !ok {
+				r.challenge(w, "authentication required")
+				return
+			}
+			if reason := r.check(claims); reason != "" {
+				r.challenge(w, reason)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// check returns why claims fall short of the requirement, or "" when they
+// meet it
+func (r *requirement) check(claims Claims) string {
+	if !r.acrSatisfied(claims.ACR) {
+		return "a stronger authentication is required"
+	}
+	for _, method := range r.amr {
+		if !slices.Contains(claims.AMR, method) {
+			return "a stronger authentication is required"
+		}
+	}
+	if r.maxAge > 0 && (claims.AuthTime.IsZero() || r.now().Sub(claims.AuthTime) > r.maxAge+authTimeLeeway) {
+		return "a more recent authentication is required"
+	}
+	return ""
+}
+
+func (r *requirement) acrSatisfied(acr string) bool {
+	if r.acr == "" || acr == r.acr {
+		return true
+	}
+	required := slices.Index(r.levels, r.acr)
+	actual := slices.Index(r.levels, acr)
+	return required >= 0 && actual >= required
+}
+
+// challenge answers with the step-up challenge, in the WWW-Authenticate
+// header for generic clients and in the body for the frontend
+func (r *requirement) challenge(w http.ResponseWriter, description string) {
+	params := []string{
+		fmt.Sprintf("error=%q", ErrorInsufficientAuthentication),
+		fmt.Sprintf("error_description=%q", description),
+	}
+	body := map[string]any{
+		"error":             ErrorInsufficientAuthentication,
+		"error_description": description,
+	}
+	query := url.Values{}
+
+	if r.acr != "" {
+		params = append(params, fmt.Sprintf("acr_values=%q", r.acr))
+		body["acr_values"] = r.acr
+		query.Set("acr_values", r.acr)
+	}
+	if r.maxAge > 0 {
+		seconds := strconv.Itoa(int(r.maxAge.Seconds()))
+		params = append(params, fmt.Sprintf("max_age=%q", seconds))
+		body["max_age"] = int(r.maxAge.Seconds())
+		query.Set("max_age", seconds)
+	}
+	if r.loginURL != "" {
+		loginURL := r.loginURL
+		if len(query) > 0 {
+			separator := "?"
+			if strings.Contains(loginURL, "?") {
+				separator = "&"
+			}
+			loginURL += separator + query.Encode()
+		}
+		body["login_url"] = loginURL
+	}
+
+	w.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,140 @@
+package stepup
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+})
+
+// serve runs handler on a request carrying claims, or none when claims is nil
+func serve(handler http.Handler, claims *Claims) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("DELETE", "/account", nil)
+	if claims != nil {
+		req = req.WithContext(NewContext(req.Context(), *claims))
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequireAuthLevel(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	tests := []struct {
+		name   string
+		acr    string
+		maxAge time.Duration
+		opts   []Option
+		claims *Claims
+		status int
+	}{
+		{"no claims", "", 0, nil, nil, http.StatusUnauthorized},
+		{"no requirement", "", 0, nil, &Claims{}, http.StatusNoContent},
+		{"matching acr", "mfa", 0, nil, &Claims{ACR: "mfa"}, http.StatusNoContent},
+		{"other acr", "mfa", 0, nil, &Claims{ACR: "pwd"}, http.StatusUnauthorized},
+		{"stronger level", "mfa", 0, []Option{WithLevels("pwd", "mfa", "hwk")}, &Claims{ACR: "hwk"}, http.StatusNoContent},
+		{"weaker level", "mfa", 0, []Option{WithLevels("pwd", "mfa", "hwk")}, &Claims{ACR: "pwd"}, http.StatusUnauthorized},
+		{"unknown level", "mfa", 0, []Option{WithLevels("pwd", "mfa")}, &Claims{ACR: "custom"}, http.StatusUnauthorized},
+		{"required amr", "", 0, []Option{WithAMR("otp")}, &Claims{AMR: []string{"pwd", "otp"}}, http.StatusNoContent},
+		{"missing amr", "", 0, []Option{WithAMR("otp")}, &Claims{AMR: []string{"pwd"}}, http.StatusUnauthorized},
+		{"recent login", "", 5 * time.Minute, nil, &Claims{AuthTime: now.Add(-time.Minute)}, http.StatusNoContent},
+		{"login within leeway", "", 5 * time.Minute, nil, &Claims{AuthTime: now.Add(-5*time.Minute - 10*time.Second)}, http.StatusNoContent},
+		{"stale login", "", 5 * time.Minute, nil, &Claims{AuthTime: now.Add(-time.Hour)}, http.StatusUnauthorized},
+		{"no auth_time", "", 5 * time.Minute, nil, &Claims{ACR: "mfa"}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireAuthLevel(tt.acr, tt.maxAge, append(tt.opts, WithClock(clock))...)(okHandler)
+			if w := serve(handler, tt.claims); w.Code != tt.status {
+				t.Errorf("status = %d, want %d (body %s)", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireAuthLevel_Challenge(t *testing.T) {
+	handler := RequireAuthLevel("mfa", 5*time.Minute, WithLoginURL("https://auth.example.com/auth/login"))(okHandler)
+	w := serve(handler, &Claims{ACR: "pwd"})
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+	header := w.Header().Get("WWW-Authenticate")
+	for _, want := range []string{`Bearer error="insufficient_user_authentication"`, `acr_values="mfa"`, `max_age="300"`} {
+		if !strings.Contains(header, want) {
+			t.Errorf("WWW-Authenticate = %q, want it to contain %q", header, want)
+		}
+	}
+
+	var body struct {
+		Error     string `json:"error"`
+		ACRValues string `json:"acr_values"`
+		MaxAge    int    `json:"max_age"`
+		LoginURL  string `json:"login_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.Error != ErrorInsufficientAuthentication || body.ACRValues != "mfa" || body.MaxAge != 300 {
+		t.Errorf("body = %+v", body)
+	}
+	if want := "https://auth.example.com/auth/login?acr_values=mfa&max_age=300"; body.LoginURL != want {
+		t.Errorf("login_url = %q, want %q", body.LoginURL, want)
+	}
+}
+
+func TestRequireAuthLevel_ClaimsFunc(t *testing.T) {
+	authTime := time.Now().Add(-time.Minute).Unix()
+	payload, _ := json.Marshal(map[string]any{"sub": "user", "acr": "mfa", "amr": []string{"otp"}, "auth_time": authTime})
+	token := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+
+	fromHeader := func(r *http.Request) (Claims, bool) {
+		claims, err := ParseJWT(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		return claims, err == nil
+	}
+	handler := RequireAuthLevel("mfa", 5*time.Minute, WithClaimsFunc(fromHeader))(okHandler)
+
+	req := httptest.NewRequest("DELETE", "/account", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", w.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/account", nil)
+	req.Header.Set("Authorization", "Bearer not-a-jwt")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+}
+
+func TestParseJWT(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"acr":"mfa","amr":["pwd","otp"],"auth_time":1700000000}`))
+	claims, err := ParseJWT("header." + payload + ".signature")
+	if err != nil {
+		t.Fatalf("ParseJWT: %v", err)
+	}
+	if claims.ACR != "mfa" || !slices.Equal(claims.AMR, []string{"pwd", "otp"}) || claims.AuthTime.Unix() != 1700000000 {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	for _, token := range []string{"", "a.b", "a.!!!.c", "a." + base64.RawURLEncoding.EncodeToString([]byte("[]")) + ".c"} {
+		if _, err := ParseJWT(token); err == nil {
+			t.Errorf("ParseJWT(%q) succeeded, want an error", token)
+		}
+	}
+}